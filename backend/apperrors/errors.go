@@ -4,3 +4,6 @@ import "errors"
 
 // ErrNotFound is returned when a requested resource is not found.
 var ErrNotFound = errors.New("resource not found")
+
+// ErrInvalidInput is returned when caller-supplied input fails validation.
+var ErrInvalidInput = errors.New("invalid input")
@@ -0,0 +1,483 @@
+// Package attachment manages files attached to items and locations, such as
+// photos, receipts and manuals. Metadata is kept in Postgres and contents in
+// a storage.BlobStore. Blobs of deleted attachments, including those removed
+// by cascades when an item, location or home is deleted, are queued by a
+// trigger and removed from the blob store in the background.
+package attachment
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/m-cain/mnemo/backend/apperrors"
+	"github.com/m-cain/mnemo/backend/audit"
+	"github.com/m-cain/mnemo/backend/models"
+	"github.com/m-cain/mnemo/backend/storage"
+)
+
+// Owner types of attachments.
+const (
+	OwnerItem     = "item"
+	OwnerLocation = "location"
+)
+
+const (
+	// maxFilenameLength is the longest filename kept; longer names are truncated.
+	maxFilenameLength = 255
+	// sweepBatchSize is how many queued blobs are deleted per transaction.
+	sweepBatchSize = 100
+)
+
+var (
+	// ErrTooLarge is returned for uploads larger than the configured maximum.
+	ErrTooLarge = errors.New("attachment is too large")
+	// ErrUnsupportedType is returned for uploads whose content type is not allowed.
+	ErrUnsupportedType = errors.New("attachment type is not allowed")
+)
+
+// Owner identifies the item or location an attachment belongs to.
+type Owner struct {
+	Type string // OwnerItem or OwnerLocation
+	ID   uuid.UUID
+}
+
+// table returns the table of the owner and the attachments column referencing it.
+func (o Owner) table() (table, column string) {
+	if o.Type == OwnerLocation {
+		return "locations", "location_id"
+	}
+	return "items", "item_id"
+}
+
+const attachmentColumns = `id, home_id, item_id, location_id, filename, content_type, size_bytes, storage_key, thumbnail_key, created_at`
+
+func scanAttachment(row pgx.Row) (*models.Attachment, error) {
+	var a models.Attachment
+	err := row.Scan(&a.ID, &a.HomeID, &a.ItemID, &a.LocationID, &a.Filename, &a.ContentType, &a.Size, &a.StorageKey, &a.ThumbnailKey, &a.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	a.HasThumbnail = a.ThumbnailKey != nil
+	return &a, nil
+}
+
+// Service manages attachments.
+type Service struct {
+	db    *pgxpool.Pool
+	store storage.BlobStore
+	cfg   Config
+
+	wake chan struct{} // Signals the sweeper that blobs were queued for deletion
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// NewService creates a Service that keeps contents in store and starts
+// removing blobs of deleted attachments in the background.
+func NewService(db *pgxpool.Pool, store storage.BlobStore, cfg Config) *Service {
+	s := &Service{
+		db:    db,
+		store: store,
+		cfg:   cfg,
+		wake:  make(chan struct{}, 1),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// MaxSize returns the largest accepted upload in bytes.
+func (s *Service) MaxSize() int64 {
+	return s.cfg.MaxSize
+}
+
+// Upload stores the contents read from r as a new attachment of an owner in a
+// home. The content type is detected from the contents and must be one of the
+// allowed types, and images get a thumbnail. It returns ErrTooLarge,
+// ErrUnsupportedType, or apperrors.ErrNotFound if the owner does not exist in
+// the home.
+func (s *Service) Upload(ctx context.Context, homeID uuid.UUID, owner Owner, filename string, r io.Reader) (*models.Attachment, error) {
+	if err := s.checkOwner(ctx, s.db, homeID, owner, false); err != nil {
+		return nil, err
+	}
+
+	// Spool the upload to disk to learn its size and sniff its type before storing it
+	tmp, err := os.CreateTemp("", "mnemo-upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	size, err := io.Copy(tmp, io.LimitReader(r, s.cfg.MaxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload: %w", err)
+	}
+	if size > s.cfg.MaxSize {
+		return nil, fmt.Errorf("%w: the limit is %d bytes", ErrTooLarge, s.cfg.MaxSize)
+	}
+	if size == 0 {
+		return nil, fmt.Errorf("%w: file is empty", apperrors.ErrInvalidInput)
+	}
+
+	head := make([]byte, 512)
+	n, err := tmp.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read upload: %w", err)
+	}
+	contentType := http.DetectContentType(head[:n])
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if !slices.Contains(s.cfg.AllowedTypes, mediaType) {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedType, mediaType)
+	}
+
+	attachment := &models.Attachment{
+		ID:          uuid.New(),
+		HomeID:      homeID,
+		Filename:    sanitizeFilename(filename),
+		ContentType: contentType,
+		Size:        size,
+	}
+	attachment.StorageKey = fmt.Sprintf("homes/%s/attachments/%s", homeID, attachment.ID)
+
+	var thumbnail []byte
+	if hasThumbnail(mediaType) {
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("failed to read upload: %w", err)
+		}
+		thumbnail, err = makeThumbnail(ctx, tmp, s.cfg.ThumbnailSize)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		if err != nil && !errors.Is(err, errImageTooLarge) {
+			return nil, fmt.Errorf("%w: %v", apperrors.ErrInvalidInput, err)
+		}
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to read upload: %w", err)
+	}
+	if err := s.store.Put(ctx, attachment.StorageKey, tmp, size, contentType); err != nil {
+		return nil, err
+	}
+	stored := []string{attachment.StorageKey}
+	if thumbnail != nil {
+		key := attachment.StorageKey + "-thumbnail"
+		if err := s.store.Put(ctx, key, bytes.NewReader(thumbnail), int64(len(thumbnail)), thumbnailContentType); err != nil {
+			s.deleteBlobs(stored)
+			return nil, err
+		}
+		attachment.ThumbnailKey = &key
+		stored = append(stored, key)
+	}
+
+	created, err := s.insertAttachment(ctx, homeID, owner, attachment)
+	if err != nil {
+		s.deleteBlobs(stored) // The blobs are unreferenced
+		return nil, err
+	}
+	return created, nil
+}
+
+// insertAttachment records a stored attachment of an owner.
+func (s *Service) insertAttachment(ctx context.Context, homeID uuid.UUID, owner Owner, a *models.Attachment) (*models.Attachment, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx) // Rollback if not committed
+
+	// The owner may have been deleted while the contents were stored
+	if err := s.checkOwner(ctx, tx, homeID, owner, true); err != nil {
+		return nil, err
+	}
+
+	_, column := owner.table()
+	query := `
+		INSERT INTO attachments (id, home_id, ` + column + `, filename, content_type, size_bytes, storage_key, thumbnail_key)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING ` + attachmentColumns
+
+	created, err := scanAttachment(tx.QueryRow(ctx, query, a.ID, homeID, owner.ID, a.Filename, a.ContentType, a.Size, a.StorageKey, a.ThumbnailKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert attachment: %w", err)
+	}
+
+	entry := audit.Entry{HomeID: &homeID, Action: audit.ActionCreate, EntityType: audit.EntityAttachment, EntityID: created.ID, After: created}
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return created, nil
+}
+
+// querier is implemented by pgxpool.Pool and pgx.Tx.
+type querier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// checkOwner returns apperrors.ErrNotFound if the owner does not exist in the
+// home. With lock set, the owner is locked against deletion until the
+// transaction of db ends.
+func (s *Service) checkOwner(ctx context.Context, db querier, homeID uuid.UUID, owner Owner, lock bool) error {
+	table, _ := owner.table()
+	query := `SELECT 1 FROM ` + table + ` WHERE id = $1 AND home_id = $2`
+	if lock {
+		query += ` FOR SHARE`
+	}
+
+	var one int
+	if err := db.QueryRow(ctx, query, owner.ID, homeID).Scan(&one); err != nil {
+		if err == pgx.ErrNoRows {
+			return apperrors.ErrNotFound
+		}
+		return fmt.Errorf("failed to check %s: %w", owner.Type, err)
+	}
+	return nil
+}
+
+// List retrieves the attachments of an owner in a home, oldest first. It
+// returns apperrors.ErrNotFound if the owner does not exist in the home.
+func (s *Service) List(ctx context.Context, homeID uuid.UUID, owner Owner) ([]models.Attachment, error) {
+	if err := s.checkOwner(ctx, s.db, homeID, owner, false); err != nil {
+		return nil, err
+	}
+
+	_, column := owner.table()
+	query := `SELECT ` + attachmentColumns + ` FROM attachments WHERE home_id = $1 AND ` + column + ` = $2 ORDER BY created_at, id`
+
+	rows, err := s.db.Query(ctx, query, homeID, owner.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query attachments: %w", err)
+	}
+	defer rows.Close()
+
+	attachments := []models.Attachment{}
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan attachment row: %w", err)
+		}
+		attachments = append(attachments, *a)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after scanning attachment rows: %w", err)
+	}
+
+	return attachments, nil
+}
+
+// Get retrieves an attachment of an owner in a home. It returns
+// apperrors.ErrNotFound if the owner has no such attachment.
+func (s *Service) Get(ctx context.Context, homeID uuid.UUID, owner Owner, id uuid.UUID) (*models.Attachment, error) {
+	_, column := owner.table()
+	query := `SELECT ` + attachmentColumns + ` FROM attachments WHERE id = $1 AND home_id = $2 AND ` + column + ` = $3`
+
+	a, err := scanAttachment(s.db.QueryRow(ctx, query, id, homeID, owner.ID))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, apperrors.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to query attachment: %w", err)
+	}
+	return a, nil
+}
+
+// Open retrieves an attachment of an owner in a home and opens its contents,
+// or its thumbnail if thumbnail is set. The caller must close the contents.
+// It returns apperrors.ErrNotFound if the owner has no such attachment or the
+// attachment has no thumbnail.
+func (s *Service) Open(ctx context.Context, homeID uuid.UUID, owner Owner, id uuid.UUID, thumbnail bool) (*models.Attachment, io.ReadCloser, error) {
+	a, err := s.Get(ctx, homeID, owner, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key := a.StorageKey
+	if thumbnail {
+		if a.ThumbnailKey == nil {
+			return nil, nil, apperrors.ErrNotFound
+		}
+		key = *a.ThumbnailKey
+	}
+
+	contents, err := s.store.Get(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return a, contents, nil
+}
+
+// Delete deletes an attachment of an owner in a home. Its blobs are removed
+// in the background. It returns apperrors.ErrNotFound if the owner has no
+// such attachment.
+func (s *Service) Delete(ctx context.Context, homeID uuid.UUID, owner Owner, id uuid.UUID) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx) // Rollback if not committed
+
+	_, column := owner.table()
+	query := `DELETE FROM attachments WHERE id = $1 AND home_id = $2 AND ` + column + ` = $3 RETURNING ` + attachmentColumns
+
+	before, err := scanAttachment(tx.QueryRow(ctx, query, id, homeID, owner.ID))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return apperrors.ErrNotFound
+		}
+		return fmt.Errorf("failed to delete attachment: %w", err)
+	}
+
+	entry := audit.Entry{HomeID: &homeID, Action: audit.ActionDelete, EntityType: audit.EntityAttachment, EntityID: id, Before: before}
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	// The trigger queued the blobs; have them removed now rather than on the next sweep
+	select {
+	case s.wake <- struct{}{}:
+	default: // A sweep is already pending
+	}
+
+	return nil
+}
+
+// deleteBlobs removes blobs that were stored for an upload that failed.
+func (s *Service) deleteBlobs(keys []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	for _, key := range keys {
+		if err := s.store.Delete(ctx, key); err != nil {
+			log.Printf("Error removing unreferenced blob %s: %v", key, err)
+		}
+	}
+}
+
+// sweep removes the blobs queued for deletion from the blob store and returns
+// how many were removed. Blobs that fail to be removed stay queued.
+func (s *Service) sweep(ctx context.Context) (int, error) {
+	removed := 0
+	for {
+		n, more, err := s.sweepBatch(ctx)
+		removed += n
+		if err != nil || !more {
+			return removed, err
+		}
+	}
+}
+
+// sweepBatch removes one batch of queued blobs. Queue rows are locked so
+// concurrent sweeps of other instances skip them.
+func (s *Service) sweepBatch(ctx context.Context) (removed int, more bool, err error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx) // Rollback if not committed
+
+	query := `SELECT storage_key FROM attachment_blob_deletions ORDER BY created_at LIMIT $1 FOR UPDATE SKIP LOCKED`
+	rows, err := tx.Query(ctx, query, sweepBatchSize)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to query queued blobs: %w", err)
+	}
+	keys, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to scan queued blobs: %w", err)
+	}
+
+	deleted := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if err := s.store.Delete(ctx, key); err != nil {
+			log.Printf("Error removing blob %s: %v", key, err)
+			continue
+		}
+		deleted = append(deleted, key)
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM attachment_blob_deletions WHERE storage_key = ANY($1)`, deleted); err != nil {
+		return 0, false, fmt.Errorf("failed to dequeue blobs: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	// Stop when a batch was short, or when failures would make the next batch the same
+	return len(deleted), len(keys) == sweepBatchSize && len(deleted) == len(keys), nil
+}
+
+func (s *Service) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.cfg.SweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.wake:
+		case <-s.stop:
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), s.cfg.SweepInterval)
+		if n, err := s.sweep(ctx); err != nil {
+			log.Printf("Error removing blobs of deleted attachments: %v", err)
+		} else if n > 0 {
+			log.Printf("Removed %d blobs of deleted attachments", n)
+		}
+		cancel()
+	}
+}
+
+// Close stops removing blobs in the background.
+func (s *Service) Close() {
+	s.once.Do(func() { close(s.stop) })
+	<-s.done
+}
+
+// sanitizeFilename keeps the base name of an uploaded file without control
+// characters, so it is safe to echo in a Content-Disposition header.
+func sanitizeFilename(name string) string {
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == unicode.ReplacementChar {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if len(name) > maxFilenameLength {
+		name = strings.ToValidUTF8(name[:maxFilenameLength], "")
+	}
+	if name == "" || name == "." || name == ".." {
+		return "attachment"
+	}
+	return name
+}
@@ -0,0 +1,79 @@
+package attachment
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config limits what can be uploaded and tunes thumbnails and blob cleanup.
+type Config struct {
+	MaxSize       int64    // Largest accepted upload in bytes
+	AllowedTypes  []string // Accepted content types, as detected from the contents
+	ThumbnailSize int      // Longest side of thumbnails in pixels
+	// SweepInterval is how often blobs of deleted attachments are removed
+	// from the blob store, besides right after an attachment is deleted. It
+	// catches blobs queued by deleting an item or location, or left by a
+	// failed removal.
+	SweepInterval time.Duration
+}
+
+// DefaultConfig returns the configuration used when nothing is configured.
+func DefaultConfig() Config {
+	return Config{
+		MaxSize: 25 << 20,
+		AllowedTypes: []string{
+			"image/jpeg",
+			"image/png",
+			"image/gif",
+			"image/webp",
+			"application/pdf",
+			"text/plain",
+		},
+		ThumbnailSize: 320,
+		SweepInterval: time.Minute,
+	}
+}
+
+// LoadConfigFromEnv starts from DefaultConfig and applies overrides:
+//
+//   - ATTACHMENT_MAX_SIZE: largest accepted upload in bytes.
+//   - ATTACHMENT_ALLOWED_TYPES: comma-separated content types, e.g.
+//     "image/jpeg,image/png,application/pdf".
+//   - ATTACHMENT_THUMBNAIL_SIZE: longest side of thumbnails in pixels.
+//   - ATTACHMENT_SWEEP_INTERVAL: a duration such as "5m".
+func LoadConfigFromEnv() (Config, error) {
+	cfg := DefaultConfig()
+	if v := os.Getenv("ATTACHMENT_MAX_SIZE"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			return Config{}, fmt.Errorf("invalid ATTACHMENT_MAX_SIZE %q", v)
+		}
+		cfg.MaxSize = n
+	}
+	if v := os.Getenv("ATTACHMENT_ALLOWED_TYPES"); v != "" {
+		cfg.AllowedTypes = nil
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+				cfg.AllowedTypes = append(cfg.AllowedTypes, t)
+			}
+		}
+	}
+	if v := os.Getenv("ATTACHMENT_THUMBNAIL_SIZE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return Config{}, fmt.Errorf("invalid ATTACHMENT_THUMBNAIL_SIZE %q", v)
+		}
+		cfg.ThumbnailSize = n
+	}
+	if v := os.Getenv("ATTACHMENT_SWEEP_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return Config{}, fmt.Errorf("invalid ATTACHMENT_SWEEP_INTERVAL %q", v)
+		}
+		cfg.SweepInterval = d
+	}
+	return cfg, nil
+}
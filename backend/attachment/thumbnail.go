@@ -0,0 +1,116 @@
+package attachment
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // Register decoders for image.Decode
+	"image/jpeg"
+	_ "image/png"
+	"io"
+)
+
+const (
+	// thumbnailContentType is the content type of all thumbnails.
+	thumbnailContentType = "image/jpeg"
+	// maxImagePixels bounds the size of images decoded for thumbnails, so a
+	// small, highly compressed upload cannot exhaust memory. A decoded image
+	// takes up to 8 bytes per pixel.
+	maxImagePixels = 16_000_000
+)
+
+// errImageTooLarge is returned for images with too many pixels to thumbnail.
+var errImageTooLarge = errors.New("image is too large to thumbnail")
+
+// thumbnailSlots serialises image decoding, so concurrent uploads hold at most
+// one decoded image in memory at a time.
+var thumbnailSlots = make(chan struct{}, 1)
+
+// hasThumbnail reports whether thumbnails are generated for a content type.
+// These are the image formats the standard library decodes.
+func hasThumbnail(contentType string) bool {
+	switch contentType {
+	case "image/jpeg", "image/png", "image/gif":
+		return true
+	}
+	return false
+}
+
+// makeThumbnail decodes an image and returns a JPEG of it scaled down to fit
+// a square with sides of size pixels. Transparent areas become white. It waits
+// for other thumbnails to finish first, or returns ctx.Err() if ctx ends.
+func makeThumbnail(ctx context.Context, r io.ReadSeeker, size int) ([]byte, error) {
+	cfg, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || int64(cfg.Width)*int64(cfg.Height) > maxImagePixels {
+		return nil, errImageTooLarge
+	}
+
+	select {
+	case thumbnailSlots <- struct{}{}:
+		defer func() { <-thumbnailSlots }()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, scaleDown(img, size), &jpeg.Options{Quality: 80}); err != nil {
+		return nil, fmt.Errorf("failed to encode thumbnail: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// scaleDown returns an opaque copy of src that fits a square with sides of
+// size pixels, keeping the aspect ratio. Each pixel of the copy is the
+// average of the source pixels it covers; images are never scaled up.
+func scaleDown(src image.Image, size int) *image.RGBA {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	tw, th := w, h
+	if w > size || h > size {
+		if w >= h {
+			tw, th = size, max(1, h*size/w)
+		} else {
+			tw, th = max(1, w*size/h), size
+		}
+	}
+
+	// Sums of the premultiplied red, green, blue and alpha of each target pixel
+	sums := make([][4]uint64, tw*th)
+	counts := make([]uint64, tw*th)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := (y - b.Min.Y) * th / h * tw
+		for x := b.Min.X; x < b.Max.X; x++ {
+			i := row + (x-b.Min.X)*tw/w
+			r, g, bl, a := src.At(x, y).RGBA()
+			sums[i][0] += uint64(r)
+			sums[i][1] += uint64(g)
+			sums[i][2] += uint64(bl)
+			sums[i][3] += uint64(a)
+			counts[i]++
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for i, sum := range sums {
+		n := max(counts[i], 1)
+		// Composite onto white: premultiplied color plus white times the transparency
+		white := 0xffff - sum[3]/n
+		dst.Pix[i*4] = uint8((sum[0]/n + white) >> 8)
+		dst.Pix[i*4+1] = uint8((sum[1]/n + white) >> 8)
+		dst.Pix[i*4+2] = uint8((sum[2]/n + white) >> 8)
+		dst.Pix[i*4+3] = 0xff
+	}
+	return dst
+}
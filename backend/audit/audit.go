@@ -0,0 +1,168 @@
+// Package audit records who changed what in a home. Events are written in the
+// same transaction as the change they describe, so the log never disagrees
+// with the data.
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/m-cain/mnemo/backend/contextkey"
+)
+
+// Actions recorded in the audit log.
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// Entity types recorded in the audit log.
+const (
+	EntityHome       = "home"
+	EntityHomeUser   = "home_user"
+	EntityLocation   = "location"
+	EntityItem       = "item"
+	EntityItemType   = "item_type"
+	EntityBarcode    = "barcode"
+	EntityItemLot    = "item_lot"
+	EntityAttachment = "attachment"
+	EntityInvitation = "invitation"
+)
+
+// Entry describes a change to be recorded. Before and After are snapshots of
+// the entity, nil for creates and deletes respectively.
+type Entry struct {
+	HomeID     *uuid.UUID // Nil for entities that do not belong to a home
+	Action     string
+	EntityType string
+	EntityID   uuid.UUID
+	Before     any
+	After      any
+}
+
+// Change is the before and after value of a single field. A value is omitted
+// when the field did not exist on that side of the change.
+type Change struct {
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+}
+
+// ignoredFields are bookkeeping fields that change on every write.
+var ignoredFields = map[string]bool{"created_at": true, "updated_at": true}
+
+// Diff compares the JSON encodings of two snapshots field by field and
+// returns the fields that differ. Either snapshot may be nil.
+func Diff(before, after any) (map[string]Change, error) {
+	b, err := fields(before)
+	if err != nil {
+		return nil, err
+	}
+	a, err := fields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make(map[string]Change)
+	for name, value := range b {
+		if !ignoredFields[name] && !bytes.Equal(value, a[name]) {
+			changes[name] = Change{Before: value, After: a[name]}
+		}
+	}
+	for name, value := range a {
+		if _, seen := b[name]; !seen && !ignoredFields[name] {
+			changes[name] = Change{After: value}
+		}
+	}
+	return changes, nil
+}
+
+// fields returns the top-level JSON fields of v.
+func fields(v any) (map[string]json.RawMessage, error) {
+	if v == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode audit snapshot: %w", err)
+	}
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to decode audit snapshot: %w", err)
+	}
+	return m, nil
+}
+
+// execer is implemented by both *pgxpool.Pool and pgx.Tx.
+type execer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+// Record writes an audit event for e. The actor, client IP and request ID are
+// taken from ctx; they are left empty for changes made outside a request.
+// Pass the transaction making the change so both commit or roll back together.
+func Record(ctx context.Context, db execer, e Entry) error {
+	changes, err := Diff(e.Before, e.After)
+	if err != nil {
+		return err
+	}
+	changesJSON, err := json.Marshal(changes)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit changes: %w", err)
+	}
+
+	query := `
+		INSERT INTO audit_events (home_id, user_id, api_key_id, action, entity_type, entity_id, changes, ip, request_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`
+	userID, apiKeyID := Actor(ctx)
+	_, err = db.Exec(ctx, query,
+		e.HomeID,
+		userID,
+		apiKeyID,
+		e.Action,
+		e.EntityType,
+		e.EntityID,
+		changesJSON,
+		contextString(ctx, contextkey.ClientIPKey),
+		nilIfEmpty(middleware.GetReqID(ctx)),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert audit event: %w", err)
+	}
+	return nil
+}
+
+// Actor returns the user and, if the request used one, the API key that
+// authenticated the request in ctx. Both are nil outside a request.
+func Actor(ctx context.Context) (userID *uuid.UUID, apiKeyID *uuid.UUID) {
+	return contextUUID(ctx, contextkey.UserIDKey), contextUUID(ctx, contextkey.APIKeyIDKey)
+}
+
+// contextUUID returns the UUID stored as a string under key, or nil.
+func contextUUID(ctx context.Context, key contextkey.ContextKey) *uuid.UUID {
+	s, _ := ctx.Value(key).(string)
+	id, err := uuid.Parse(s)
+	if err != nil {
+		return nil
+	}
+	return &id
+}
+
+// contextString returns the string stored under key, or nil.
+func contextString(ctx context.Context, key contextkey.ContextKey) *string {
+	s, _ := ctx.Value(key).(string)
+	return nilIfEmpty(s)
+}
+
+func nilIfEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
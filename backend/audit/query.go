@@ -0,0 +1,142 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/m-cain/mnemo/backend/apperrors"
+	"github.com/m-cain/mnemo/backend/models"
+)
+
+const (
+	// DefaultEventsPerPage is the page size used when a query does not specify one.
+	DefaultEventsPerPage = 50
+	// MaxEventsPerPage caps the page size a client may request.
+	MaxEventsPerPage = 200
+)
+
+// Service reads the audit log.
+type Service struct {
+	db *pgxpool.Pool
+}
+
+// NewService creates a new audit Service.
+func NewService(db *pgxpool.Pool) *Service {
+	return &Service{db: db}
+}
+
+// Query describes the filters and pagination for List. Events are returned
+// newest first.
+type Query struct {
+	UserID     *uuid.UUID
+	APIKeyID   *uuid.UUID
+	Action     string
+	EntityType string
+	EntityID   *uuid.UUID
+	Since      *time.Time // Inclusive
+	Until      *time.Time // Exclusive
+	Page       int
+	PerPage    int
+}
+
+// EventPage is a single page of events returned by List.
+type EventPage struct {
+	Events  []models.AuditEvent
+	Total   int
+	Page    int
+	PerPage int
+}
+
+// normalize applies defaults and validates the query.
+func (q *Query) normalize() error {
+	if q.Page == 0 {
+		q.Page = 1
+	}
+	if q.PerPage == 0 {
+		q.PerPage = DefaultEventsPerPage
+	}
+	if q.Page < 1 {
+		return fmt.Errorf("%w: page must be at least 1", apperrors.ErrInvalidInput)
+	}
+	if q.PerPage < 1 || q.PerPage > MaxEventsPerPage {
+		return fmt.Errorf("%w: per_page must be between 1 and %d", apperrors.ErrInvalidInput, MaxEventsPerPage)
+	}
+	if q.Since != nil && q.Until != nil && !q.Since.Before(*q.Until) {
+		return fmt.Errorf("%w: since must be before until", apperrors.ErrInvalidInput)
+	}
+	return nil
+}
+
+// List returns a page of the audit events of a home matching q.
+func (s *Service) List(ctx context.Context, homeID uuid.UUID, q Query) (*EventPage, error) {
+	if err := q.normalize(); err != nil {
+		return nil, err
+	}
+
+	conds := []string{"home_id = $1"}
+	args := []any{homeID}
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+	if q.UserID != nil {
+		add("user_id = $%d", *q.UserID)
+	}
+	if q.APIKeyID != nil {
+		add("api_key_id = $%d", *q.APIKeyID)
+	}
+	if q.Action != "" {
+		add("action = $%d", q.Action)
+	}
+	if q.EntityType != "" {
+		add("entity_type = $%d", q.EntityType)
+	}
+	if q.EntityID != nil {
+		add("entity_id = $%d", *q.EntityID)
+	}
+	if q.Since != nil {
+		add("occurred_at >= $%d", *q.Since)
+	}
+	if q.Until != nil {
+		add("occurred_at < $%d", *q.Until)
+	}
+	where := "WHERE " + strings.Join(conds, " AND ")
+
+	page := &EventPage{Page: q.Page, PerPage: q.PerPage}
+	if err := s.db.QueryRow(ctx, "SELECT COUNT(*) FROM audit_events "+where, args...).Scan(&page.Total); err != nil {
+		return nil, fmt.Errorf("failed to count audit events: %w", err)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT id, occurred_at, home_id, user_id, api_key_id, action, entity_type, entity_id, changes, ip, request_id
+		FROM audit_events
+		%s
+		ORDER BY occurred_at DESC, id DESC
+		LIMIT $%d OFFSET $%d
+	`, where, len(args)+1, len(args)+2)
+	rows, err := s.db.Query(ctx, query, append(args, q.PerPage, (q.Page-1)*q.PerPage)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit events: %w", err)
+	}
+	defer rows.Close()
+
+	events := []models.AuditEvent{}
+	for rows.Next() {
+		var e models.AuditEvent
+		if err := rows.Scan(&e.ID, &e.OccurredAt, &e.HomeID, &e.UserID, &e.APIKeyID, &e.Action, &e.EntityType, &e.EntityID, &e.Changes, &e.IP, &e.RequestID); err != nil {
+			return nil, fmt.Errorf("failed to scan audit event row: %w", err)
+		}
+		events = append(events, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after scanning audit event rows: %w", err)
+	}
+
+	page.Events = events
+	return page, nil
+}
@@ -0,0 +1,335 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/m-cain/mnemo/backend/apperrors"
+	"github.com/m-cain/mnemo/backend/contextkey"
+	"github.com/m-cain/mnemo/backend/home"
+	"github.com/m-cain/mnemo/backend/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MinPasswordLength is the minimum length of a password.
+const MinPasswordLength = 8
+
+// revokedPasswordChanged is recorded on sessions ended by a password change.
+const revokedPasswordChanged = "password_changed"
+
+var (
+	// ErrEmailTaken is returned when an email address is already used by another account.
+	ErrEmailTaken = errors.New("email is already registered")
+	// ErrInvalidCredentials is returned when a password confirmation does not match.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+type UpdateProfileRequest struct {
+	Email string `json:"email"` // Optional; must be the current address (see EmailChangeService)
+	Name  string `json:"name"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type DeleteAccountRequest struct {
+	Password   string                `json:"password"`
+	OwnedHomes home.OwnedHomesPolicy `json:"owned_homes"` // Defaults to "transfer"
+}
+
+// validatePassword returns apperrors.ErrInvalidInput if a new password is too weak.
+func validatePassword(password string) error {
+	if len(password) < MinPasswordLength {
+		return fmt.Errorf("%w: password must be at least %d characters", apperrors.ErrInvalidInput, MinPasswordLength)
+	}
+	return nil
+}
+
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// isUniqueViolation reports whether err is a PostgreSQL unique constraint violation.
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+// GetUser retrieves a user by ID.
+func (s *AuthService) GetUser(ctx context.Context, userID uuid.UUID) (*models.User, error) {
+	query := `SELECT ` + userColumns + ` FROM users u WHERE u.id = $1`
+	var user models.User
+	if err := s.db.QueryRow(ctx, query, userID).Scan(userScanTargets(&user)...); err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, apperrors.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get user %s: %w", userID, err)
+	}
+	return &user, nil
+}
+
+// UpdateProfile updates a user's display name. The email address can only be
+// changed through EmailChangeService, which confirms the password and the new
+// address first; an email other than the current one is rejected.
+func (s *AuthService) UpdateProfile(ctx context.Context, userID uuid.UUID, email string, name string) (*models.User, error) {
+	query := `
+		UPDATE users u
+		SET name = $1, updated_at = $2
+		WHERE u.id = $3 AND ($4 = '' OR LOWER(u.email) = LOWER($4))
+		RETURNING ` + userColumns
+	var user models.User
+	err := s.db.QueryRow(ctx, query, strings.TrimSpace(name), time.Now(), userID, strings.TrimSpace(email)).Scan(userScanTargets(&user)...)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			if _, err := s.GetUser(ctx, userID); err != nil {
+				return nil, err // apperrors.ErrNotFound if the user is gone
+			}
+			return nil, fmt.Errorf("%w: email changes must be confirmed through /email/change", apperrors.ErrInvalidInput)
+		}
+		return nil, fmt.Errorf("failed to update user %s: %w", userID, err)
+	}
+	return &user, nil
+}
+
+// checkPassword returns ErrInvalidCredentials unless password is the user's current password.
+func checkPassword(ctx context.Context, db querier, userID uuid.UUID, password string) error {
+	var hashedPassword string
+	err := db.QueryRow(ctx, `SELECT password_hash FROM users WHERE id = $1`, userID).Scan(&hashedPassword)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return apperrors.ErrNotFound
+		}
+		return fmt.Errorf("failed to get user %s: %w", userID, err)
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password)) != nil {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
+
+// ChangePassword replaces a user's password after confirming the current one.
+// Every session except keepSessionID is revoked.
+func (s *AuthService) ChangePassword(ctx context.Context, userID uuid.UUID, keepSessionID uuid.UUID, currentPassword string, newPassword string) error {
+	if err := validatePassword(newPassword); err != nil {
+		return err
+	}
+	if err := checkPassword(ctx, s.db, userID, currentPassword); err != nil {
+		return err
+	}
+	hashedPassword, err := hashPassword(newPassword)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx) // Rollback if not committed
+
+	if _, err := tx.Exec(ctx, `UPDATE users SET password_hash = $1, updated_at = $2 WHERE id = $3`, hashedPassword, time.Now(), userID); err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+	if err := revokeSessions(ctx, tx, `user_id = $1 AND id <> $2`, revokedPasswordChanged, userID, keepSessionID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// DeleteAccount deletes a user after confirming their password. Homes they own
+// are transferred or deleted according to policy; their memberships, API keys
+// and sessions are removed with them.
+func (s *AuthService) DeleteAccount(ctx context.Context, userID uuid.UUID, password string, policy home.OwnedHomesPolicy) error {
+	if err := home.ValidateOwnedHomesPolicy(policy); err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx) // Rollback if not committed
+
+	if err := checkPassword(ctx, tx, userID, password); err != nil {
+		return err
+	}
+	if err := home.ReleaseOwnedHomes(ctx, tx, userID, policy); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM users WHERE id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete user %s: %w", userID, err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// sessionFromContext returns the authenticated user and, for requests made
+// with an access token, their session. ok is false for API key requests.
+func sessionFromContext(ctx context.Context) (userID uuid.UUID, sessionID uuid.UUID, ok bool, err error) {
+	userID, err = uuid.Parse(ctx.Value(contextkey.UserIDKey).(string))
+	if err != nil {
+		return uuid.Nil, uuid.Nil, false, err
+	}
+	raw, ok := ctx.Value(contextkey.SessionIDKey).(string)
+	if !ok {
+		return userID, uuid.Nil, false, nil
+	}
+	sessionID, err = uuid.Parse(raw)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, false, err
+	}
+	return userID, sessionID, true, nil
+}
+
+// writeAccountError maps account errors to HTTP responses.
+// It returns false if err is not a known account error.
+func writeAccountError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, apperrors.ErrNotFound):
+		http.Error(w, "User not found", http.StatusNotFound)
+	case errors.Is(err, apperrors.ErrInvalidInput):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, ErrEmailTaken):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrInvalidCredentials):
+		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+	case errors.Is(err, ErrInvalidTwoFactorCode):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	case errors.Is(err, ErrTwoFactorEnabled), errors.Is(err, ErrTwoFactorNotEnabled):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		return false
+	}
+	return true
+}
+
+func (s *AuthService) handleGetMe(w http.ResponseWriter, r *http.Request) {
+	userID, _, _, err := sessionFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.GetUser(r.Context(), userID)
+	if err != nil {
+		if writeAccountError(w, err) {
+			return
+		}
+		http.Error(w, "Failed to get user", http.StatusInternalServerError)
+		log.Printf("Error getting user: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+func (s *AuthService) handleUpdateMe(w http.ResponseWriter, r *http.Request) {
+	if !HasScope(r.Context(), ScopeAdmin) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	userID, _, _, err := sessionFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var req UpdateProfileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.UpdateProfile(r.Context(), userID, req.Email, req.Name)
+	if err != nil {
+		if writeAccountError(w, err) {
+			return
+		}
+		http.Error(w, "Failed to update user", http.StatusInternalServerError)
+		log.Printf("Error updating user: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// handleChangePassword changes the password of the authenticated user. Only
+// user sessions may do this; the calling session stays signed in.
+func (s *AuthService) handleChangePassword(w http.ResponseWriter, r *http.Request) {
+	userID, sessionID, ok, err := sessionFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	if !ok {
+		http.Error(w, "Request is not authenticated with a session", http.StatusForbidden)
+		return
+	}
+
+	var req ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.ChangePassword(r.Context(), userID, sessionID, req.CurrentPassword, req.NewPassword); err != nil {
+		if writeAccountError(w, err) {
+			return
+		}
+		http.Error(w, "Failed to change password", http.StatusInternalServerError)
+		log.Printf("Error changing password: %v", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleDeleteMe deletes the authenticated user's account. Only user sessions may do this.
+func (s *AuthService) handleDeleteMe(w http.ResponseWriter, r *http.Request) {
+	userID, ok := sessionUserID(w, r)
+	if !ok {
+		return
+	}
+
+	var req DeleteAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if req.OwnedHomes == "" {
+		req.OwnedHomes = home.OwnedHomesTransfer
+	}
+
+	if err := s.DeleteAccount(r.Context(), userID, req.Password, req.OwnedHomes); err != nil {
+		if writeAccountError(w, err) {
+			return
+		}
+		http.Error(w, "Failed to delete account", http.StatusInternalServerError)
+		log.Printf("Error deleting account: %v", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
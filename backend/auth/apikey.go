@@ -3,135 +3,326 @@ package auth
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/m-cain/mnemo/backend/apperrors"
 	"github.com/m-cain/mnemo/backend/models"
 	"golang.org/x/crypto/bcrypt"
 )
 
 // APIKeyService handles operations related to API keys.
 type APIKeyService struct {
-	db *pgxpool.Pool
+	db    *pgxpool.Pool
+	usage *usageTracker
 }
 
-// NewAPIKeyService creates a new APIKeyService.
+// NewAPIKeyService creates a new APIKeyService and starts flushing API key
+// usage to the database in the background. Call Close to stop it.
 func NewAPIKeyService(db *pgxpool.Pool) *APIKeyService {
-	return &APIKeyService{db: db}
+	return &APIKeyService{db: db, usage: newUsageTracker(db, DefaultUsageFlushInterval)}
+}
+
+// RecordUsage notes a successful request made with an API key. The update is
+// buffered and written to the database asynchronously.
+func (s *APIKeyService) RecordUsage(apiKeyID uuid.UUID, ip string) {
+	s.usage.record(apiKeyID, ip, time.Now())
+}
+
+// Close flushes buffered usage data and stops the background flusher.
+func (s *APIKeyService) Close() {
+	s.usage.close()
+}
+
+// apiKeyScheme is the leading component of every API key issued by Mnemo.
+const apiKeyScheme = "mnemo"
+
+// hashAPIKeySecret returns the hex-encoded SHA-256 hash under which a key secret is stored.
+// Secrets are 256-bit random values, so a fast hash is sufficient.
+func hashAPIKeySecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// parseAPIKey splits a key of the form mnemo_<prefix>_<secret>.
+func parseAPIKey(rawKey string) (prefix string, secret string, ok bool) {
+	parts := strings.SplitN(rawKey, "_", 3)
+	if len(parts) != 3 || parts[0] != apiKeyScheme || parts[1] == "" || parts[2] == "" {
+		return "", "", false
+	}
+	return parts[1], parts[2], true
+}
+
+// APIKeyOptions restricts what a new API key may do.
+type APIKeyOptions struct {
+	Scopes    []string    // Required; see the Scope constants
+	HomeIDs   []uuid.UUID // Optional; restricts the key to these homes of the user
+	ExpiresAt *time.Time  // Optional; the key stops working after this time
+	// RateLimitPerMinute optionally overrides the default request quota for the key.
+	RateLimitPerMinute *int
+}
+
+const apiKeyColumns = `ak.id, ak.user_id, ak.name, ak.prefix, ak.scopes, ak.home_ids, ak.expires_at, ak.is_active,
+	ak.rate_limit_per_minute, ak.last_used_at, ak.last_used_ip, ak.request_count, ak.replaced_by, ak.created_at, ak.updated_at`
+
+// usableAPIKeyCondition selects keys that are neither revoked nor expired.
+const usableAPIKeyCondition = `ak.is_active = TRUE AND (ak.expires_at IS NULL OR ak.expires_at > CURRENT_TIMESTAMP)`
+
+func apiKeyScanTargets(apiKey *models.APIKey) []any {
+	return []any{
+		&apiKey.ID, &apiKey.UserID, &apiKey.Name, &apiKey.Prefix, &apiKey.Scopes, &apiKey.HomeIDs, &apiKey.ExpiresAt, &apiKey.IsActive,
+		&apiKey.RateLimitPerMinute, &apiKey.LastUsedAt, &apiKey.LastUsedIP, &apiKey.RequestCount, &apiKey.ReplacedBy, &apiKey.CreatedAt, &apiKey.UpdatedAt,
+	}
+}
+
+// userColumns lists the users columns scanned by userScanTargets, for queries aliasing users as u.
+const userColumns = `u.id, u.email, u.name, u.password_hash, u.totp_enabled_at IS NOT NULL, u.email_verified_at IS NOT NULL, u.created_at, u.updated_at`
+
+func userScanTargets(user *models.User) []any {
+	return []any{&user.ID, &user.Email, &user.Name, &user.PasswordHash, &user.TwoFactorEnabled, &user.EmailVerified, &user.CreatedAt, &user.UpdatedAt}
 }
 
 // GenerateAPIKey generates a new API key for a user.
-func (s *APIKeyService) GenerateAPIKey(ctx context.Context, userID string, name string) (*models.APIKey, string, error) {
-	// Generate a random key
-	keyBytes := make([]byte, 32)
-	_, err := rand.Read(keyBytes)
-	if err != nil {
+// The raw key has the form mnemo_<prefix>_<secret> and is only returned here.
+// Every home in opts.HomeIDs must be one the user belongs to. When the request
+// is authenticated by an API key, the new key may not exceed that key's scopes
+// and homes; ErrExceedsGrants is returned otherwise.
+func (s *APIKeyService) GenerateAPIKey(ctx context.Context, userID string, name string, opts APIKeyOptions) (*models.APIKey, string, error) {
+	if err := ValidateScopes(opts.Scopes); err != nil {
+		return nil, "", err
+	}
+	if opts.HomeIDs != nil {
+		opts.HomeIDs = uniqueUUIDs(opts.HomeIDs)
+	}
+	if err := checkWithinGrants(ctx, opts.Scopes, opts.HomeIDs); err != nil {
+		return nil, "", err
+	}
+	if opts.ExpiresAt != nil && !opts.ExpiresAt.After(time.Now()) {
+		return nil, "", fmt.Errorf("%w: expires_at must be in the future", apperrors.ErrInvalidInput)
+	}
+	if opts.RateLimitPerMinute != nil && *opts.RateLimitPerMinute <= 0 {
+		return nil, "", fmt.Errorf("%w: rate_limit_per_minute must be positive", apperrors.ErrInvalidInput)
+	}
+	if opts.HomeIDs != nil {
+		if len(opts.HomeIDs) == 0 {
+			return nil, "", fmt.Errorf("%w: home_ids must not be empty when set", apperrors.ErrInvalidInput)
+		}
+		memberQuery := `SELECT COUNT(*) FROM home_users WHERE user_id = $1 AND home_id = ANY($2)`
+		var count int
+		if err := s.db.QueryRow(ctx, memberQuery, userID, opts.HomeIDs).Scan(&count); err != nil {
+			return nil, "", fmt.Errorf("failed to check home membership: %w", err)
+		}
+		if count != len(opts.HomeIDs) {
+			return nil, "", fmt.Errorf("%w: home_ids must only contain homes you belong to", apperrors.ErrInvalidInput)
+		}
+	}
+
+	return insertAPIKey(ctx, s.db, userID, name, opts)
+}
+
+// querier is implemented by both *pgxpool.Pool and pgx.Tx.
+type querier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// insertAPIKey generates fresh key material and stores a new key with the given options.
+func insertAPIKey(ctx context.Context, db querier, userID string, name string, opts APIKeyOptions) (*models.APIKey, string, error) {
+	// Generate a random public prefix and secret
+	prefixBytes := make([]byte, 6)
+	if _, err := rand.Read(prefixBytes); err != nil {
+		return nil, "", fmt.Errorf("failed to generate key prefix: %w", err)
+	}
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(secretBytes); err != nil {
 		return nil, "", fmt.Errorf("failed to generate random key: %w", err)
 	}
-	rawKey := base64.URLEncoding.EncodeToString(keyBytes)
+	prefix := hex.EncodeToString(prefixBytes)
+	secret := base64.RawURLEncoding.EncodeToString(secretBytes)
+	rawKey := apiKeyScheme + "_" + prefix + "_" + secret
 
-	// Hash the key for storage
-	hashedKey, err := bcrypt.GenerateFromPassword([]byte(rawKey), bcrypt.DefaultCost)
+	// Insert into database
+	query := `
+		INSERT INTO api_keys AS ak (user_id, name, prefix, secret_hash, scopes, home_ids, expires_at, rate_limit_per_minute, is_active, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, TRUE, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		RETURNING ` + apiKeyColumns
+	apiKey := &models.APIKey{}
+	err := db.QueryRow(ctx, query,
+		userID,
+		name,
+		prefix,
+		hashAPIKeySecret(secret),
+		opts.Scopes,
+		opts.HomeIDs,
+		opts.ExpiresAt,
+		opts.RateLimitPerMinute,
+	).Scan(apiKeyScanTargets(apiKey)...)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to hash API key: %w", err)
+		return nil, "", fmt.Errorf("failed to insert API key: %w", err)
 	}
 
-	// Insert into database
-	apiKey := &models.APIKey{
-		UserID:    userID,
-		Name:      name,
-		Key:       string(hashedKey), // Store the hashed key
-		IsActive:  true,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+	// Return the APIKey model and the raw key
+	return apiKey, rawKey, nil
+}
+
+// uniqueUUIDs returns ids without duplicates, preserving their first occurrence.
+func uniqueUUIDs(ids []uuid.UUID) []uuid.UUID {
+	unique := make([]uuid.UUID, 0, len(ids))
+	for _, id := range ids {
+		if !slices.Contains(unique, id) {
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+// ValidateAPIKey validates an API key and returns the user it belongs to along
+// with the key itself. Prefixed keys are looked up by their indexed prefix and
+// compared in constant time. It returns pgx.ErrNoRows if the key is unknown,
+// revoked, expired or does not match.
+func (s *APIKeyService) ValidateAPIKey(ctx context.Context, rawKey string) (*models.User, *models.APIKey, error) {
+	prefix, secret, ok := parseAPIKey(rawKey)
+	if !ok {
+		return s.validateLegacyAPIKey(ctx, rawKey)
 	}
 
 	query := `
-		INSERT INTO api_keys (user_id, name, key, is_active, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6)
-		RETURNING id, user_id, name, key, is_active, created_at, updated_at
-	`
-	err = s.db.QueryRow(ctx, query,
-		apiKey.UserID,
-		apiKey.Name,
-		apiKey.Key,
-		apiKey.IsActive,
-		apiKey.CreatedAt,
-		apiKey.UpdatedAt,
-	).Scan(
-		&apiKey.ID,
-		&apiKey.UserID,
-		&apiKey.Name,
-		&apiKey.Key, // This will scan the hashed key
-		&apiKey.IsActive,
-		&apiKey.CreatedAt,
-		&apiKey.UpdatedAt,
-	)
+		SELECT ak.secret_hash, ` + apiKeyColumns + `, ` + userColumns + `
+		FROM api_keys ak
+		JOIN users u ON ak.user_id = u.id
+		WHERE ak.prefix = $1 AND ` + usableAPIKeyCondition
+	var storedHash string
+	var apiKey models.APIKey
+	var user models.User
+	targets := append([]any{&storedHash}, apiKeyScanTargets(&apiKey)...)
+	err := s.db.QueryRow(ctx, query, prefix).Scan(append(targets, userScanTargets(&user)...)...)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to insert API key: %w", err)
+		if err == pgx.ErrNoRows {
+			return nil, nil, pgx.ErrNoRows
+		}
+		return nil, nil, fmt.Errorf("failed to query API key for validation: %w", err)
 	}
 
-	// Return the APIKey model (with hashed key) and the raw key
-	return apiKey, rawKey, nil
+	if subtle.ConstantTimeCompare([]byte(hashAPIKeySecret(secret)), []byte(storedHash)) != 1 {
+		return nil, nil, pgx.ErrNoRows
+	}
+	return &user, &apiKey, nil
+}
+
+// legacyAPIKeyLength is the length of a key issued before prefixed keys were
+// introduced: 32 random bytes in padded URL-safe base64.
+const legacyAPIKeyLength = 44
+
+// isLegacyAPIKey reports whether rawKey has the format of a legacy key.
+func isLegacyAPIKey(rawKey string) bool {
+	if len(rawKey) != legacyAPIKeyLength {
+		return false
+	}
+	decoded, err := base64.URLEncoding.DecodeString(rawKey)
+	return err == nil && len(decoded) == 32
 }
 
-// ValidateAPIKey validates an API key.
-func (s *APIKeyService) ValidateAPIKey(ctx context.Context, rawKey string) (*models.User, error) {
-	// Retrieve the API key and associated user from the database
+// validateLegacyAPIKey validates a key issued before prefixed keys were introduced.
+// Keys without the legacy format are rejected before any lookup. A legacy key is
+// checked against its bcrypt hash once; on success its SHA-256 hash is recorded
+// so later requests are a single indexed lookup, and the expiry it had before
+// migration 20250624120000 is restored. Legacy keys that were never upgraded
+// expire as set by that migration, after which no bcrypt comparisons are made
+// at all.
+func (s *APIKeyService) validateLegacyAPIKey(ctx context.Context, rawKey string) (*models.User, *models.APIKey, error) {
+	if !isLegacyAPIKey(rawKey) {
+		return nil, nil, pgx.ErrNoRows
+	}
+	secretHash := hashAPIKeySecret(rawKey)
+
+	var apiKey models.APIKey
+	var user models.User
+
+	upgradedQuery := `
+		SELECT ` + apiKeyColumns + `, ` + userColumns + `
+		FROM api_keys ak
+		JOIN users u ON ak.user_id = u.id
+		WHERE ak.prefix IS NULL AND ak.secret_hash = $1 AND ` + usableAPIKeyCondition
+	err := s.db.QueryRow(ctx, upgradedQuery, secretHash).Scan(append(apiKeyScanTargets(&apiKey), userScanTargets(&user)...)...)
+	if err == nil {
+		return &user, &apiKey, nil
+	}
+	if err != pgx.ErrNoRows {
+		return nil, nil, fmt.Errorf("failed to query legacy API key for validation: %w", err)
+	}
+
+	// Fall back to comparing against legacy keys that have not been used since the upgrade
 	query := `
-		SELECT
-			ak.id, ak.user_id, ak.name, ak.key, ak.is_active, ak.created_at, ak.updated_at,
-			u.id, u.email, u.password_hash, u.created_at, u.updated_at
+		SELECT ak.key, ` + apiKeyColumns + `, ` + userColumns + `
 		FROM api_keys ak
 		JOIN users u ON ak.user_id = u.id
-		WHERE ak.is_active = TRUE
-	`
+		WHERE ak.prefix IS NULL AND ak.secret_hash IS NULL AND ` + usableAPIKeyCondition
 	rows, err := s.db.Query(ctx, query)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query API keys for validation: %w", err)
+		return nil, nil, fmt.Errorf("failed to query API keys for validation: %w", err)
 	}
 	defer rows.Close()
 
-	// Iterate through active keys and compare the hash
+	matched := false
 	for rows.Next() {
-		var apiKey models.APIKey
-		var user models.User
-		err := rows.Scan(
-			&apiKey.ID, &apiKey.UserID, &apiKey.Name, &apiKey.Key, &apiKey.IsActive, &apiKey.CreatedAt, &apiKey.UpdatedAt,
-			&user.ID, &user.Email, &user.PasswordHash, &user.CreatedAt, &user.UpdatedAt,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan API key and user during validation: %w", err)
+		var bcryptHash string
+		targets := append([]any{&bcryptHash}, apiKeyScanTargets(&apiKey)...)
+		if err := rows.Scan(append(targets, userScanTargets(&user)...)...); err != nil {
+			return nil, nil, fmt.Errorf("failed to scan API key and user during validation: %w", err)
 		}
 
-		err = bcrypt.CompareHashAndPassword([]byte(apiKey.Key), []byte(rawKey))
+		err = bcrypt.CompareHashAndPassword([]byte(bcryptHash), []byte(rawKey))
 		if err == nil {
-			// Match found, return the user
-			return &user, nil
+			matched = true
+			break
 		} else if err != bcrypt.ErrMismatchedHashAndPassword {
 			// Other bcrypt error
-			return nil, fmt.Errorf("failed to compare API key hash: %w", err)
+			return nil, nil, fmt.Errorf("failed to compare API key hash: %w", err)
 		}
 		// Mismatched hash, continue to the next key
 	}
+	rows.Close()
 
 	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error during API key validation rows iteration: %w", err)
+		return nil, nil, fmt.Errorf("error during API key validation rows iteration: %w", err)
+	}
+
+	if !matched {
+		// No active key matched
+		return nil, nil, pgx.ErrNoRows
+	}
+
+	upgradeQuery := `
+		UPDATE api_keys
+		SET secret_hash = $1,
+			expires_at = CASE WHEN legacy_expiry_forced THEN legacy_expires_at ELSE expires_at END,
+			legacy_expiry_forced = FALSE,
+			legacy_expires_at = NULL,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2
+		RETURNING expires_at
+	`
+	if err := s.db.QueryRow(ctx, upgradeQuery, secretHash, apiKey.ID).Scan(&apiKey.ExpiresAt); err != nil {
+		return nil, nil, fmt.Errorf("failed to upgrade legacy API key %s: %w", apiKey.ID, err)
 	}
 
-	// No active key matched
-	return nil, pgx.ErrNoRows
+	return &user, &apiKey, nil
 }
 
 // ListAPIKeys lists all API keys for a user.
 func (s *APIKeyService) ListAPIKeys(ctx context.Context, userID string) ([]models.APIKey, error) {
 	query := `
-		SELECT id, user_id, name, key, is_active, created_at, updated_at
-		FROM api_keys
-		WHERE user_id = $1
+		SELECT ` + apiKeyColumns + `
+		FROM api_keys ak
+		WHERE ak.user_id = $1
 	`
 	rows, err := s.db.Query(ctx, query, userID)
 	if err != nil {
@@ -142,7 +333,7 @@ func (s *APIKeyService) ListAPIKeys(ctx context.Context, userID string) ([]model
 	var apiKeys []models.APIKey
 	for rows.Next() {
 		var apiKey models.APIKey
-		err := rows.Scan(&apiKey.ID, &apiKey.UserID, &apiKey.Name, &apiKey.Key, &apiKey.IsActive, &apiKey.CreatedAt, &apiKey.UpdatedAt)
+		err := rows.Scan(apiKeyScanTargets(&apiKey)...)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan API key: %w", err)
 		}
@@ -174,3 +365,71 @@ func (s *APIKeyService) RevokeAPIKey(ctx context.Context, apiKeyID string, userI
 
 	return nil
 }
+
+// DefaultRotationGracePeriod is how long a rotated key keeps working when no grace period is given.
+const DefaultRotationGracePeriod = 24 * time.Hour
+
+// MaxRotationGracePeriod caps the grace period of a rotation.
+const MaxRotationGracePeriod = 30 * 24 * time.Hour
+
+// RotateAPIKey issues a replacement for an API key with the same name, scopes,
+// home restriction and expiry. The old key keeps working for the grace period
+// (but never past its own expiry) and is revoked immediately when grace is zero.
+// Like GenerateAPIKey, it returns ErrExceedsGrants if the request is
+// authenticated by an API key with fewer scopes or homes than the rotated key.
+func (s *APIKeyService) RotateAPIKey(ctx context.Context, apiKeyID string, userID string, grace time.Duration) (*models.APIKey, string, error) {
+	if grace < 0 || grace > MaxRotationGracePeriod {
+		return nil, "", fmt.Errorf("%w: grace period must be between 0 and %s", apperrors.ErrInvalidInput, MaxRotationGracePeriod)
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx) // Rollback if not committed
+
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys ak WHERE ak.id = $1 AND ak.user_id = $2 AND ` + usableAPIKeyCondition + ` FOR UPDATE`
+	var old models.APIKey
+	if err := tx.QueryRow(ctx, query, apiKeyID, userID).Scan(apiKeyScanTargets(&old)...); err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, "", pgx.ErrNoRows // API key not found, not usable or doesn't belong to the user
+		}
+		return nil, "", fmt.Errorf("failed to get API key %s: %w", apiKeyID, err)
+	}
+
+	if err := checkWithinGrants(ctx, old.Scopes, old.HomeIDs); err != nil {
+		return nil, "", err
+	}
+
+	// A legacy key whose expiry was brought forward still passes on its original one
+	expiresAt := old.ExpiresAt
+	legacyQuery := `SELECT legacy_expires_at FROM api_keys WHERE id = $1 AND legacy_expiry_forced`
+	if err := tx.QueryRow(ctx, legacyQuery, old.ID).Scan(&expiresAt); err != nil && err != pgx.ErrNoRows {
+		return nil, "", fmt.Errorf("failed to get original expiry of API key %s: %w", old.ID, err)
+	}
+
+	opts := APIKeyOptions{Scopes: old.Scopes, HomeIDs: old.HomeIDs, ExpiresAt: expiresAt, RateLimitPerMinute: old.RateLimitPerMinute}
+	replacement, rawKey, err := insertAPIKey(ctx, tx, userID, old.Name, opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	retireQuery := `
+		UPDATE api_keys
+		SET replaced_by = $1,
+			is_active = $2,
+			expires_at = LEAST(COALESCE(expires_at, 'infinity'::timestamptz), $3),
+			legacy_expiry_forced = FALSE,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE id = $4
+	`
+	if _, err := tx.Exec(ctx, retireQuery, replacement.ID, grace > 0, time.Now().Add(grace), old.ID); err != nil {
+		return nil, "", fmt.Errorf("failed to retire API key %s: %w", old.ID, err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, "", fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return replacement, rawKey, nil
+}
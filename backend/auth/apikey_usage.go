@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// DefaultUsageFlushInterval is how often buffered API key usage is written to the database.
+const DefaultUsageFlushInterval = 10 * time.Second
+
+// keyUsage is the usage of one API key accumulated since the last flush.
+type keyUsage struct {
+	count      int64
+	lastUsedAt time.Time
+	lastUsedIP string
+}
+
+// usageTracker aggregates API key usage in memory and periodically writes it
+// to the database in a single statement, keeping writes off the request path.
+type usageTracker struct {
+	db *pgxpool.Pool
+
+	mu      sync.Mutex
+	pending map[uuid.UUID]*keyUsage
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+func newUsageTracker(db *pgxpool.Pool, interval time.Duration) *usageTracker {
+	t := &usageTracker{
+		db:      db,
+		pending: make(map[uuid.UUID]*keyUsage),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go t.run(interval)
+	return t
+}
+
+// record adds one request to the usage of an API key.
+func (t *usageTracker) record(apiKeyID uuid.UUID, ip string, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	u, ok := t.pending[apiKeyID]
+	if !ok {
+		u = &keyUsage{}
+		t.pending[apiKeyID] = u
+	}
+	u.count++
+	if at.After(u.lastUsedAt) {
+		u.lastUsedAt = at
+		u.lastUsedIP = ip
+	}
+}
+
+func (t *usageTracker) run(interval time.Duration) {
+	defer close(t.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			t.flush()
+		case <-t.stop:
+			t.flush()
+			return
+		}
+	}
+}
+
+// flush writes all pending usage to the database. On failure the usage is
+// merged back so it is retried on the next flush.
+func (t *usageTracker) flush() {
+	t.mu.Lock()
+	batch := t.pending
+	t.pending = make(map[uuid.UUID]*keyUsage)
+	t.mu.Unlock()
+
+	if len(batch) == 0 {
+		return
+	}
+
+	ids := make([]uuid.UUID, 0, len(batch))
+	counts := make([]int64, 0, len(batch))
+	times := make([]time.Time, 0, len(batch))
+	ips := make([]string, 0, len(batch))
+	for id, u := range batch {
+		ids = append(ids, id)
+		counts = append(counts, u.count)
+		times = append(times, u.lastUsedAt)
+		ips = append(ips, u.lastUsedIP)
+	}
+
+	query := `
+		UPDATE api_keys ak
+		SET request_count = ak.request_count + u.count,
+			last_used_at = GREATEST(ak.last_used_at, u.last_used_at),
+			last_used_ip = CASE WHEN ak.last_used_at IS NULL OR u.last_used_at >= ak.last_used_at THEN u.ip ELSE ak.last_used_ip END
+		FROM unnest($1::uuid[], $2::bigint[], $3::timestamptz[], $4::text[]) AS u(id, count, last_used_at, ip)
+		WHERE ak.id = u.id
+	`
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := t.db.Exec(ctx, query, ids, counts, times, ips); err != nil {
+		log.Printf("Error flushing API key usage for %d keys: %v", len(batch), err)
+		t.mu.Lock()
+		for id, u := range batch {
+			if cur, ok := t.pending[id]; ok {
+				cur.count += u.count
+				if u.lastUsedAt.After(cur.lastUsedAt) {
+					cur.lastUsedAt, cur.lastUsedIP = u.lastUsedAt, u.lastUsedIP
+				}
+			} else {
+				t.pending[id] = u
+			}
+		}
+		t.mu.Unlock()
+	}
+}
+
+// close stops the background flusher after a final flush.
+func (t *usageTracker) close() {
+	t.once.Do(func() { close(t.stop) })
+	<-t.done
+}
@@ -2,9 +2,10 @@ package auth
 
 import (
 	"context"
-	"database/sql"
 	"encoding/json"
-	"fmt"
+	"errors"
+	"log"
+	"net"
 	"net/http"
 	"strings"
 	"time"
@@ -12,21 +13,23 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/m-cain/mnemo/backend/contextkey"
+	"github.com/m-cain/mnemo/backend/ratelimit"
 	"golang.org/x/crypto/bcrypt"
 )
 
-var jwtSecret = []byte("your_jwt_secret_key") // TODO: Load from configuration
-
 type RegisterRequest struct {
 	Email    string `json:"email"`
+	Name     string `json:"name"`
 	Password string `json:"password"`
 }
 
 type RegisterResponse struct {
 	ID    uuid.UUID `json:"id"`
 	Email string    `json:"email"`
+	Name  string    `json:"name"`
 }
 
 type LoginRequest struct {
@@ -35,27 +38,75 @@ type LoginRequest struct {
 }
 
 type LoginResponse struct {
-	Token string `json:"token"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"` // Lifetime of the access token in seconds
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// newLoginResponse builds the response body for a newly issued token pair.
+func newLoginResponse(tokens *TokenPair) LoginResponse {
+	return LoginResponse{Token: tokens.AccessToken, RefreshToken: tokens.RefreshToken, TokenType: "Bearer", ExpiresIn: tokens.ExpiresIn}
 }
 
 // AuthService handles user authentication and authorization.
 type AuthService struct {
 	db            *pgxpool.Pool
 	apiKeyService *APIKeyService // Inject APIKeyService
+	tokens        TokenConfig
+	proxyAuth     *ProxyAuthConfig   // Nil unless reverse-proxy header authentication is enabled
+	proxies       TrustedProxies     // Proxies trusted to report client addresses
+	limiter       *ratelimit.Limiter // Nil disables login throttling and API key quotas
 }
 
-// NewAuthService creates a new AuthService.
-func NewAuthService(db *pgxpool.Pool, apiKeyService *APIKeyService) *AuthService {
-	return &AuthService{db: db, apiKeyService: apiKeyService}
+// NewAuthService creates a new AuthService. proxyAuth may be nil to disable
+// reverse-proxy header authentication. Client addresses are resolved through
+// trustedProxies and the proxies of proxyAuth.
+func NewAuthService(db *pgxpool.Pool, apiKeyService *APIKeyService, tokens TokenConfig, proxyAuth *ProxyAuthConfig, trustedProxies TrustedProxies, limiter *ratelimit.Limiter) *AuthService {
+	if tokens.AccessTokenTTL <= 0 {
+		tokens.AccessTokenTTL = DefaultAccessTokenTTL
+	}
+	if tokens.RefreshTokenTTL <= 0 {
+		tokens.RefreshTokenTTL = DefaultRefreshTokenTTL
+	}
+	proxies := append(TrustedProxies{}, trustedProxies...)
+	if proxyAuth != nil {
+		proxies = append(proxies, proxyAuth.TrustedProxies...)
+	}
+	return &AuthService{db: db, apiKeyService: apiKeyService, tokens: tokens, proxyAuth: proxyAuth, proxies: proxies, limiter: limiter}
 }
 
 // RegisterRoutes registers authentication routes.
 func (s *AuthService) RegisterRoutes(r chi.Router) {
 	r.Post("/register", s.handleRegister)
 	r.Post("/login", s.handleLogin)
+	r.Post("/login/2fa", s.handleTwoFactorLogin)
+	r.Post("/refresh", s.handleRefresh)
+	r.With(s.AuthMiddleware).Post("/logout", s.handleLogout)
+	r.With(s.AuthMiddleware).Post("/logout-all", s.handleLogoutAll)
+
+	r.Route("/me", func(r chi.Router) {
+		r.Use(s.AuthMiddleware)
+		r.Get("/", s.handleGetMe)
+		r.Put("/", s.handleUpdateMe)
+		r.Delete("/", s.handleDeleteMe)
+		r.Put("/password", s.handleChangePassword)
+
+		r.Route("/2fa", func(r chi.Router) {
+			r.Post("/enroll", s.handleEnrollTOTP)
+			r.Post("/confirm", s.handleConfirmTOTP)
+			r.Post("/recovery-codes", s.handleRegenerateRecoveryCodes)
+			r.Delete("/", s.handleDisableTOTP)
+		})
+	})
 }
 
-// AuthMiddleware is a middleware to authenticate requests using JWT or API Key.
+// AuthMiddleware is a middleware to authenticate requests using JWT, API Key
+// or, if enabled, headers set by a trusted reverse proxy.
 func (s *AuthService) AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Check for JWT in Authorization header (Bearer token)
@@ -64,22 +115,23 @@ func (s *AuthService) AuthMiddleware(next http.Handler) http.Handler {
 			parts := strings.SplitN(authHeader, " ", 2)
 			if len(parts) == 2 && parts[0] == "Bearer" {
 				tokenString := parts[1]
-				token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-					if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-						return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"]) // Use fmt.Errorf
+				claims := &accessClaims{}
+				token, err := jwt.ParseWithClaims(tokenString, claims, s.tokens.Keys.keyFunc, jwt.WithExpirationRequired())
+
+				if err == nil && token.Valid && claims.Subject != "" && claims.SessionID != "" {
+					// Tokens stop working as soon as their session is revoked
+					active, err := s.isSessionActive(r.Context(), claims.SessionID)
+					if err != nil {
+						log.Printf("Error checking session: %v", err)
+						http.Error(w, "Failed to authenticate", http.StatusInternalServerError)
+						return
 					}
-					return jwtSecret, nil
-				})
-
-				if err == nil && token.Valid {
-					if claims, ok := token.Claims.(jwt.MapClaims); ok {
-						userID, ok := claims["sub"].(string)
-						if ok {
-							// Set userID in context
-							ctx := context.WithValue(r.Context(), contextkey.UserIDKey, userID) // Use contextkey.UserIDKey
-							next.ServeHTTP(w, r.WithContext(ctx))
-							return
-						}
+					if active {
+						// Set userID and session ID in context
+						ctx := context.WithValue(r.Context(), contextkey.UserIDKey, claims.Subject) // Use contextkey.UserIDKey
+						ctx = context.WithValue(ctx, contextkey.SessionIDKey, claims.SessionID)
+						next.ServeHTTP(w, r.WithContext(ctx))
+						return
 					}
 				}
 			}
@@ -88,16 +140,50 @@ func (s *AuthService) AuthMiddleware(next http.Handler) http.Handler {
 		// Check for API Key in X-API-Key header
 		apiKeyHeader := r.Header.Get("X-API-Key")
 		if apiKeyHeader != "" {
-			user, err := s.apiKeyService.ValidateAPIKey(r.Context(), apiKeyHeader)
+			// Clients that keep presenting invalid keys are locked out before
+			// any key is checked, which bounds the cost of legacy bcrypt keys
+			ip := ClientIP(r)
+			if lockedFor := s.apiKeyLockedFor(r.Context(), ip); lockedFor > 0 {
+				ratelimit.Reject(w, lockedFor, "Too many invalid API keys, try again later")
+				return
+			}
+
+			user, apiKey, err := s.apiKeyService.ValidateAPIKey(r.Context(), apiKeyHeader)
+			if err != nil {
+				s.recordAPIKeyFailure(r.Context(), ip)
+			}
 			if err == nil {
-				// Set userID in context
+				if !s.allowAPIKeyRequest(w, r, apiKey) {
+					return
+				}
+				s.apiKeyService.RecordUsage(apiKey.ID, ip)
+
+				// Set userID and the key's grants in context
 				ctx := context.WithValue(r.Context(), contextkey.UserIDKey, user.ID.String()) // Use contextkey.UserIDKey and user.ID
+				ctx = context.WithValue(ctx, contextkey.APIKeyIDKey, apiKey.ID.String())
+				ctx = context.WithValue(ctx, contextkey.ScopesKey, apiKey.Scopes)
+				if apiKey.HomeIDs != nil {
+					ctx = context.WithValue(ctx, contextkey.APIKeyHomeIDsKey, apiKey.HomeIDs)
+				}
 				next.ServeHTTP(w, r.WithContext(ctx))
 				return
 			}
 		}
 
-		// If neither JWT nor API Key is valid, return Unauthorized
+		// Check for a user asserted by a trusted reverse proxy
+		userID, ok, err := s.authenticateProxyRequest(r.Context(), r)
+		if err != nil {
+			log.Printf("Error authenticating proxy user: %v", err)
+			http.Error(w, "Failed to authenticate", http.StatusInternalServerError)
+			return
+		}
+		if ok {
+			ctx := context.WithValue(r.Context(), contextkey.UserIDKey, userID)
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		// If neither JWT, API Key nor proxy headers are valid, return Unauthorized
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 	})
 }
@@ -110,13 +196,18 @@ func (s *AuthService) handleRegister(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Basic validation
+	req.Email = strings.TrimSpace(req.Email)
 	if req.Email == "" || req.Password == "" {
 		http.Error(w, "Email and password are required", http.StatusBadRequest)
 		return
 	}
+	if err := validatePassword(req.Password); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	// Hash the password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	hashedPassword, err := hashPassword(req.Password)
 	if err != nil {
 		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
 		return
@@ -128,16 +219,20 @@ func (s *AuthService) handleRegister(w http.ResponseWriter, r *http.Request) {
 	updatedAt := time.Now()
 
 	query := `
-		INSERT INTO users (id, email, password_hash, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5)
-		RETURNING id, email
+		INSERT INTO users (id, email, name, password_hash, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, email, name
 	`
 	var resp RegisterResponse
 	// Use dbPool.QueryRow
-	err = s.db.QueryRow(r.Context(), query, userID, req.Email, hashedPassword, createdAt, updatedAt).Scan(&resp.ID, &resp.Email)
+	err = s.db.QueryRow(r.Context(), query, userID, req.Email, strings.TrimSpace(req.Name), hashedPassword, createdAt, updatedAt).Scan(&resp.ID, &resp.Email, &resp.Name)
 	if err != nil {
-		// TODO: Handle duplicate email error specifically
+		if isUniqueViolation(err) {
+			http.Error(w, "Email is already registered", http.StatusConflict)
+			return
+		}
 		http.Error(w, "Failed to create user", http.StatusInternalServerError)
+		log.Printf("Error creating user: %v", err)
 		return
 	}
 
@@ -159,14 +254,20 @@ func (s *AuthService) handleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !s.allowLoginAttempt(w, r, req.Email) {
+		return
+	}
+
 	// Retrieve user from database
 	var userID uuid.UUID
 	var hashedPassword string
-	query := `SELECT id, password_hash FROM users WHERE email = $1`
+	var twoFactorEnabled bool
+	query := `SELECT id, password_hash, totp_enabled_at IS NOT NULL FROM users WHERE LOWER(email) = LOWER($1)`
 	// Use dbPool.QueryRow
-	err := s.db.QueryRow(r.Context(), query, req.Email).Scan(&userID, &hashedPassword)
+	err := s.db.QueryRow(r.Context(), query, req.Email).Scan(&userID, &hashedPassword, &twoFactorEnabled)
 	if err != nil {
-		if err == sql.ErrNoRows {
+		if err == pgx.ErrNoRows {
+			s.recordLoginFailure(r.Context(), ClientIP(r), req.Email)
 			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 			return
 		}
@@ -177,22 +278,132 @@ func (s *AuthService) handleLogin(w http.ResponseWriter, r *http.Request) {
 	// Compare passwords
 	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(req.Password))
 	if err != nil {
+		s.recordLoginFailure(r.Context(), ClientIP(r), req.Email)
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
+	s.resetLoginFailures(r.Context(), req.Email)
 
-	// Generate JWT token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"sub": userID.String(),
-		"exp": time.Now().Add(time.Hour * 24).Unix(), // Token expires in 24 hours
-	})
+	// With 2FA enabled, the password only earns a challenge to exchange at /login/2fa
+	if twoFactorEnabled {
+		challenge, err := s.issueChallengeToken(r.Context(), userID)
+		if err != nil {
+			http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+			log.Printf("Error issuing 2FA challenge: %v", err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(TwoFactorChallengeResponse{MFARequired: true, ChallengeToken: challenge, ExpiresIn: int(mfaChallengeTTL.Seconds())})
+		return
+	}
 
-	tokenString, err := token.SignedString(jwtSecret)
+	tokens, err := s.CreateSession(r.Context(), userID, r.UserAgent(), ClientIP(r))
 	if err != nil {
 		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		log.Printf("Error creating session: %v", err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(LoginResponse{Token: tokenString})
+	json.NewEncoder(w).Encode(newLoginResponse(tokens))
+}
+
+// handleRefresh exchanges a refresh token for a new access and refresh token.
+func (s *AuthService) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	var req RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	tokens, err := s.RefreshSession(r.Context(), req.RefreshToken)
+	if err != nil {
+		if errors.Is(err, ErrInvalidRefreshToken) || errors.Is(err, ErrRefreshTokenReused) {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, "Failed to refresh token", http.StatusInternalServerError)
+		log.Printf("Error refreshing session: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newLoginResponse(tokens))
+}
+
+// handleLogout revokes the session the request was authenticated with.
+func (s *AuthService) handleLogout(w http.ResponseWriter, r *http.Request) {
+	sessionID, ok := r.Context().Value(contextkey.SessionIDKey).(string)
+	if !ok {
+		http.Error(w, "Request is not authenticated with a session", http.StatusBadRequest)
+		return
+	}
+	sessionUUID, err := uuid.Parse(sessionID)
+	if err != nil {
+		http.Error(w, "Invalid session ID", http.StatusBadRequest)
+		return
+	}
+	userID, err := uuid.Parse(r.Context().Value(contextkey.UserIDKey).(string))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.RevokeSession(r.Context(), sessionUUID, userID); err != nil {
+		http.Error(w, "Failed to log out", http.StatusInternalServerError)
+		log.Printf("Error revoking session: %v", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleLogoutAll revokes every session of the authenticated user. API keys
+// need the admin scope, since this signs the user out everywhere.
+func (s *AuthService) handleLogoutAll(w http.ResponseWriter, r *http.Request) {
+	if !HasScope(r.Context(), ScopeAdmin) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	userID, err := uuid.Parse(r.Context().Value(contextkey.UserIDKey).(string))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.RevokeAllSessions(r.Context(), userID); err != nil {
+		http.Error(w, "Failed to log out", http.StatusInternalServerError)
+		log.Printf("Error revoking sessions: %v", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ClientIPMiddleware resolves the IP address of the client, looking through
+// trusted proxies (see LoadTrustedProxiesFromEnv), and stores it in the
+// request context for ClientIP and for code that has no access to the
+// request, such as the audit log.
+func (s *AuthService) ClientIPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), contextkey.ClientIPKey, s.proxies.clientIP(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// ClientIP returns the IP address of the client that sent the request, as
+// resolved by ClientIPMiddleware. Without the middleware it is the address of
+// the peer, which may be a proxy.
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(contextkey.ClientIPKey).(string); ok {
+		return ip
+	}
+	return peerIP(r)
+}
+
+// peerIP returns the IP address of the host connected to the server.
+func peerIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
 }
@@ -0,0 +1,230 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/m-cain/mnemo/backend/apperrors"
+	"github.com/m-cain/mnemo/backend/models"
+	"github.com/m-cain/mnemo/backend/notify"
+)
+
+// DefaultEmailChangeTTL is how long an email change confirmation link stays valid.
+const DefaultEmailChangeTTL = 24 * time.Hour
+
+// ErrInvalidEmailChangeToken is returned for unknown, expired or already used email change tokens.
+var ErrInvalidEmailChangeToken = errors.New("invalid or expired email change token")
+
+type ChangeEmailRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewEmail        string `json:"new_email"`
+}
+
+type ConfirmEmailChangeRequest struct {
+	Token string `json:"token"`
+}
+
+// EmailChangeService changes the email address of an account. The new address
+// only takes effect once the user follows a single-use link sent to it.
+type EmailChangeService struct {
+	db         *pgxpool.Pool
+	notifier   notify.Notifier
+	auth       *AuthService
+	confirmURL string // Base URL of the page that confirms email changes; the token is appended as a query parameter
+	ttl        time.Duration
+}
+
+// NewEmailChangeService creates a new EmailChangeService. authService
+// authenticates requests to change an address.
+func NewEmailChangeService(db *pgxpool.Pool, notifier notify.Notifier, authService *AuthService, confirmURL string) *EmailChangeService {
+	return &EmailChangeService{db: db, notifier: notifier, auth: authService, confirmURL: confirmURL, ttl: DefaultEmailChangeTTL}
+}
+
+// RegisterRoutes registers email change routes. Confirming only requires the
+// token, so the link works from any device.
+func (s *EmailChangeService) RegisterRoutes(r chi.Router) {
+	r.With(s.auth.AuthMiddleware).Post("/email/change", s.handleRequestEmailChange)
+	r.Post("/email/confirm", s.handleConfirmEmailChange)
+}
+
+// parseEmail returns the bare address of email, or apperrors.ErrInvalidInput
+// if it is not a single valid address.
+func parseEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return "", fmt.Errorf("%w: invalid email address", apperrors.ErrInvalidInput)
+	}
+	return addr.Address, nil
+}
+
+// RequestEmailChange emails a confirmation link to newEmail after checking the
+// user's current password. The account keeps its address until the link is
+// followed; earlier unused links for the account stop working.
+func (s *EmailChangeService) RequestEmailChange(ctx context.Context, userID uuid.UUID, currentPassword string, newEmail string) error {
+	newEmail, err := parseEmail(newEmail)
+	if err != nil {
+		return err
+	}
+	if err := checkPassword(ctx, s.db, userID, currentPassword); err != nil {
+		return err
+	}
+
+	var taken bool
+	takenQuery := `SELECT EXISTS (SELECT 1 FROM users WHERE LOWER(email) = LOWER($1) AND id <> $2)`
+	if err := s.db.QueryRow(ctx, takenQuery, newEmail, userID).Scan(&taken); err != nil {
+		return fmt.Errorf("failed to check email address: %w", err)
+	}
+	if taken {
+		return ErrEmailTaken
+	}
+
+	token, err := newOpaqueToken()
+	if err != nil {
+		return err
+	}
+	expiresAt := time.Now().Add(s.ttl)
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx) // Rollback if not committed
+
+	if _, err := tx.Exec(ctx, `DELETE FROM email_change_tokens WHERE user_id = $1 AND used_at IS NULL`, userID); err != nil {
+		return fmt.Errorf("failed to remove previous email change tokens: %w", err)
+	}
+	insertQuery := `INSERT INTO email_change_tokens (user_id, new_email, token_hash, expires_at) VALUES ($1, $2, $3, $4)`
+	if _, err := tx.Exec(ctx, insertQuery, userID, newEmail, hashToken(token), expiresAt); err != nil {
+		return fmt.Errorf("failed to insert email change token: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	// A token whose link could not be sent is harmless; asking again replaces it
+	msg := notify.Message{
+		To:      newEmail,
+		Subject: "Confirm your new Mnemo email address",
+		Body: fmt.Sprintf("Someone asked to use this address for a Mnemo account.\n\n"+
+			"Confirm the change here:\n%s?token=%s\n\n"+
+			"This link expires on %s. If you did not ask for this, you can ignore this email.",
+			s.confirmURL, token, expiresAt.Format(time.RFC1123)),
+	}
+	if err := s.notifier.Notify(ctx, msg); err != nil {
+		return fmt.Errorf("failed to send email change confirmation: %w", err)
+	}
+	return nil
+}
+
+// ConfirmEmailChange switches the account to the address a token was sent to,
+// which counts as verified, and consumes the token. It returns ErrEmailTaken
+// if another account took the address in the meantime.
+func (s *EmailChangeService) ConfirmEmailChange(ctx context.Context, token string) (*models.User, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx) // Rollback if not committed
+
+	query := `
+		SELECT id, user_id, new_email, expires_at, used_at
+		FROM email_change_tokens
+		WHERE token_hash = $1
+		FOR UPDATE
+	`
+	var tokenID, userID uuid.UUID
+	var newEmail string
+	var expiresAt time.Time
+	var usedAt *time.Time
+	if err := tx.QueryRow(ctx, query, hashToken(token)).Scan(&tokenID, &userID, &newEmail, &expiresAt, &usedAt); err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrInvalidEmailChangeToken
+		}
+		return nil, fmt.Errorf("failed to get email change token: %w", err)
+	}
+	if usedAt != nil || time.Now().After(expiresAt) {
+		return nil, ErrInvalidEmailChangeToken
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE email_change_tokens SET used_at = CURRENT_TIMESTAMP WHERE id = $1`, tokenID); err != nil {
+		return nil, fmt.Errorf("failed to mark email change token used: %w", err)
+	}
+	updateQuery := `
+		UPDATE users u
+		SET email = $1, email_verified_at = CURRENT_TIMESTAMP, updated_at = $2
+		WHERE u.id = $3
+		RETURNING ` + userColumns
+	var user models.User
+	if err := tx.QueryRow(ctx, updateQuery, newEmail, time.Now(), userID).Scan(userScanTargets(&user)...); err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrEmailTaken
+		}
+		return nil, fmt.Errorf("failed to update email of user %s: %w", userID, err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return &user, nil
+}
+
+func (s *EmailChangeService) handleRequestEmailChange(w http.ResponseWriter, r *http.Request) {
+	userID, ok := sessionUserID(w, r)
+	if !ok {
+		return
+	}
+
+	var req ChangeEmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.RequestEmailChange(r.Context(), userID, req.CurrentPassword, req.NewEmail); err != nil {
+		if writeAccountError(w, err) {
+			return
+		}
+		http.Error(w, "Failed to request email change", http.StatusInternalServerError)
+		log.Printf("Error requesting email change: %v", err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *EmailChangeService) handleConfirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	var req ConfirmEmailChangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.ConfirmEmailChange(r.Context(), req.Token)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidEmailChangeToken):
+			http.Error(w, err.Error(), http.StatusGone)
+		case errors.Is(err, ErrEmailTaken):
+			http.Error(w, err.Error(), http.StatusConflict)
+		default:
+			http.Error(w, "Failed to confirm email change", http.StatusInternalServerError)
+			log.Printf("Error confirming email change: %v", err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// externalPasswordPlaceholder is stored as the password hash of users created
+// from an external identity. It is not a valid bcrypt hash, so password login
+// always fails until the user sets a password via a reset.
+const externalPasswordPlaceholder = "!external"
+
+var (
+	// ErrExternalUserNotFound is returned when no user matches an external
+	// identity and auto-provisioning is disabled.
+	ErrExternalUserNotFound = errors.New("no account is linked to this identity")
+	// ErrIdentityLinkRequired is returned when an identity's email matches an
+	// account whose own address was never verified. The account's owner must
+	// sign in and link the identity explicitly.
+	ErrIdentityLinkRequired = errors.New("sign in to link this identity to your account")
+	// ErrIdentityInUse is returned when linking an identity that is already
+	// linked to another account.
+	ErrIdentityInUse = errors.New("identity is linked to another account")
+)
+
+// externalIdentity is a user identity asserted by a trusted party, such as an
+// OIDC provider or an authenticating reverse proxy.
+type externalIdentity struct {
+	Issuer        string // Who asserted the identity; subjects are unique per issuer
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	// Authoritative issuers may assert any account, so their identities are
+	// linked by email even to accounts whose address is not verified.
+	Authoritative bool
+}
+
+// resolveExternalUser finds the user for an identity: first by a previous link
+// to the subject, then by verified email, and finally by creating a user if
+// autoProvision is set. New links are recorded in user_identities. An email
+// only links to an existing account whose address is verified; otherwise
+// ErrIdentityLinkRequired is returned. Concurrent first logins of the same
+// identity resolve to the same user.
+func resolveExternalUser(ctx context.Context, db *pgxpool.Pool, identity externalIdentity, autoProvision bool) (uuid.UUID, error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx) // Rollback if not committed
+
+	var userID uuid.UUID
+	linkQuery := `
+		UPDATE user_identities SET last_login_at = CURRENT_TIMESTAMP, email = $3
+		WHERE issuer = $1 AND subject = $2
+		RETURNING user_id
+	`
+	err = tx.QueryRow(ctx, linkQuery, identity.Issuer, identity.Subject, identity.Email).Scan(&userID)
+	if err == nil {
+		if err := tx.Commit(ctx); err != nil {
+			return uuid.Nil, fmt.Errorf("failed to commit transaction: %w", err)
+		}
+		return userID, nil
+	}
+	if err != pgx.ErrNoRows {
+		return uuid.Nil, fmt.Errorf("failed to look up identity: %w", err)
+	}
+
+	email := strings.TrimSpace(identity.Email)
+	// Only a verified email proves the identity owns the Mnemo account
+	if email == "" || !identity.EmailVerified {
+		return uuid.Nil, ErrExternalUserNotFound
+	}
+	userID, verified, err := lookupUserByEmail(ctx, tx, email)
+	if err == pgx.ErrNoRows {
+		if !autoProvision {
+			return uuid.Nil, ErrExternalUserNotFound
+		}
+		// The provider vouches for the address, so the new user's email is verified
+		insertUser := `
+			INSERT INTO users (id, email, name, password_hash, email_verified_at, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, NOW(), NOW(), NOW())
+			ON CONFLICT ((LOWER(email))) DO NOTHING
+		`
+		userID = uuid.New()
+		result, err := tx.Exec(ctx, insertUser, userID, email, strings.TrimSpace(identity.Name), externalPasswordPlaceholder)
+		if err != nil {
+			return uuid.Nil, fmt.Errorf("failed to create user: %w", err)
+		}
+		verified = true
+		if result.RowsAffected() == 0 {
+			// Created concurrently, e.g. by another first login; use that user
+			userID, verified, err = lookupUserByEmail(ctx, tx, email)
+		}
+	}
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("failed to look up user by email: %w", err)
+	}
+	if !verified && !identity.Authoritative {
+		return uuid.Nil, ErrIdentityLinkRequired
+	}
+
+	userID, err = linkIdentity(ctx, tx, userID, identity)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return uuid.Nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return userID, nil
+}
+
+// linkExternalIdentity links an identity to a signed-in user, who thereby
+// confirms it is theirs. It returns ErrIdentityInUse if the identity is
+// already linked to another user.
+func linkExternalIdentity(ctx context.Context, db *pgxpool.Pool, userID uuid.UUID, identity externalIdentity) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx) // Rollback if not committed
+
+	linkedTo, err := linkIdentity(ctx, tx, userID, identity)
+	if err != nil {
+		return err
+	}
+	if linkedTo != userID {
+		return ErrIdentityInUse
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// lookupUserByEmail returns the user with an email address and whether the
+// address is verified. It returns pgx.ErrNoRows if there is no such user.
+func lookupUserByEmail(ctx context.Context, tx pgx.Tx, email string) (uuid.UUID, bool, error) {
+	var userID uuid.UUID
+	var verified bool
+	query := `SELECT id, email_verified_at IS NOT NULL FROM users WHERE LOWER(email) = LOWER($1)`
+	err := tx.QueryRow(ctx, query, email).Scan(&userID, &verified)
+	return userID, verified, err
+}
+
+// linkIdentity links an identity to userID unless it is linked already, and
+// returns the user it ends up linked to. If a concurrent transaction links it
+// first, the insert waits for that transaction and its link is returned.
+func linkIdentity(ctx context.Context, tx pgx.Tx, userID uuid.UUID, identity externalIdentity) (uuid.UUID, error) {
+	insertIdentity := `
+		INSERT INTO user_identities (user_id, issuer, subject, email, last_login_at)
+		VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP)
+		ON CONFLICT (issuer, subject) DO NOTHING
+	`
+	if _, err := tx.Exec(ctx, insertIdentity, userID, identity.Issuer, identity.Subject, identity.Email); err != nil {
+		return uuid.Nil, fmt.Errorf("failed to link identity: %w", err)
+	}
+
+	var linkedTo uuid.UUID
+	query := `SELECT user_id FROM user_identities WHERE issuer = $1 AND subject = $2`
+	if err := tx.QueryRow(ctx, query, identity.Issuer, identity.Subject).Scan(&linkedTo); err != nil {
+		return uuid.Nil, fmt.Errorf("failed to get identity link: %w", err)
+	}
+	return linkedTo, nil
+}
@@ -0,0 +1,277 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/m-cain/mnemo/backend/testdb"
+)
+
+// newTestOIDCService returns an OIDCService for the stub provider, backed by the test database.
+func newTestOIDCService(t *testing.T, db *pgxpool.Pool, idp *stubIdP, autoProvision bool) *OIDCService {
+	t.Helper()
+	keys, err := NewHMACKeySet("test", []byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiKeyService := NewAPIKeyService(db)
+	t.Cleanup(apiKeyService.Close)
+	authService := NewAuthService(db, apiKeyService, TokenConfig{Keys: keys}, nil, nil, nil)
+	cfg := OIDCConfig{Issuer: idp.issuer(), ClientID: stubClientID, RedirectURL: stubRedirectURL, Scopes: "openid email", AutoProvision: autoProvision}
+	return NewOIDCService(db, authService, cfg)
+}
+
+// createTestUser inserts a password user with a unique email address.
+func createTestUser(t *testing.T, db *pgxpool.Pool, verified bool) (uuid.UUID, string) {
+	t.Helper()
+	id := uuid.New()
+	email := fmt.Sprintf("%s@example.com", id)
+	query := `
+		INSERT INTO users (id, email, name, password_hash, email_verified_at, created_at, updated_at)
+		VALUES ($1, $2, 'Test', 'x', CASE WHEN $3 THEN NOW() END, NOW(), NOW())
+	`
+	if _, err := db.Exec(context.Background(), query, id, email, verified); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	return id, email
+}
+
+// testBrowser is the browser token of logins started and completed by tests.
+const testBrowser = "test-browser"
+
+// completeLogin runs an authorization code flow for user through the stub
+// provider in the browser that started it.
+func completeLogin(t *testing.T, s *OIDCService, idp *stubIdP, authURL string, user stubUser) error {
+	t.Helper()
+	code, state := idp.authorize(authURL, user)
+	_, err := s.CompleteLogin(context.Background(), state, code, testBrowser, "test", "127.0.0.1")
+	return err
+}
+
+// linkedUser returns the user an identity of the stub provider is linked to.
+func linkedUser(t *testing.T, db *pgxpool.Pool, idp *stubIdP, subject string) uuid.UUID {
+	t.Helper()
+	var userID uuid.UUID
+	query := `SELECT user_id FROM user_identities WHERE issuer = $1 AND subject = $2`
+	if err := db.QueryRow(context.Background(), query, idp.issuer(), subject).Scan(&userID); err != nil {
+		t.Fatalf("failed to get identity link: %v", err)
+	}
+	return userID
+}
+
+func TestOIDCLoginLinksVerifiedAccount(t *testing.T) {
+	db := testdb.Open(t)
+	idp := newStubIdP(t)
+	s := newTestOIDCService(t, db, idp, false)
+	userID, email := createTestUser(t, db, true)
+
+	authURL, err := s.StartLogin(context.Background(), testBrowser)
+	if err != nil {
+		t.Fatalf("StartLogin: %v", err)
+	}
+	if err := completeLogin(t, s, idp, authURL, stubUser{Subject: "verified", Email: email, EmailVerified: true}); err != nil {
+		t.Fatalf("CompleteLogin: %v", err)
+	}
+	if got := linkedUser(t, db, idp, "verified"); got != userID {
+		t.Fatalf("identity linked to %s, want %s", got, userID)
+	}
+}
+
+func TestOIDCLoginRequiresVerifiedProviderEmail(t *testing.T) {
+	db := testdb.Open(t)
+	idp := newStubIdP(t)
+	s := newTestOIDCService(t, db, idp, true)
+	_, email := createTestUser(t, db, true)
+
+	authURL, err := s.StartLogin(context.Background(), testBrowser)
+	if err != nil {
+		t.Fatalf("StartLogin: %v", err)
+	}
+	err = completeLogin(t, s, idp, authURL, stubUser{Subject: "unverified", Email: email, EmailVerified: false})
+	if !errors.Is(err, ErrExternalUserNotFound) {
+		t.Fatalf("CompleteLogin error = %v, want ErrExternalUserNotFound", err)
+	}
+}
+
+func TestOIDCLoginDoesNotLinkUnverifiedAccount(t *testing.T) {
+	db := testdb.Open(t)
+	idp := newStubIdP(t)
+	s := newTestOIDCService(t, db, idp, true)
+	userID, email := createTestUser(t, db, false)
+	user := stubUser{Subject: "needs-link", Email: email, EmailVerified: true}
+
+	authURL, err := s.StartLogin(context.Background(), testBrowser)
+	if err != nil {
+		t.Fatalf("StartLogin: %v", err)
+	}
+	if err := completeLogin(t, s, idp, authURL, user); !errors.Is(err, ErrIdentityLinkRequired) {
+		t.Fatalf("CompleteLogin error = %v, want ErrIdentityLinkRequired", err)
+	}
+
+	// The account's owner links the identity while signed in
+	authURL, err = s.StartLink(context.Background(), userID, testBrowser)
+	if err != nil {
+		t.Fatalf("StartLink: %v", err)
+	}
+	if err := completeLogin(t, s, idp, authURL, user); err != nil {
+		t.Fatalf("CompleteLogin after StartLink: %v", err)
+	}
+	if got := linkedUser(t, db, idp, "needs-link"); got != userID {
+		t.Fatalf("identity linked to %s, want %s", got, userID)
+	}
+
+	// Later logins use the link
+	authURL, err = s.StartLogin(context.Background(), testBrowser)
+	if err != nil {
+		t.Fatalf("StartLogin: %v", err)
+	}
+	if err := completeLogin(t, s, idp, authURL, user); err != nil {
+		t.Fatalf("CompleteLogin with linked identity: %v", err)
+	}
+}
+
+func TestOIDCLinkRejectsIdentityOfAnotherAccount(t *testing.T) {
+	db := testdb.Open(t)
+	idp := newStubIdP(t)
+	s := newTestOIDCService(t, db, idp, false)
+	ownerID, email := createTestUser(t, db, true)
+	otherID, _ := createTestUser(t, db, true)
+	user := stubUser{Subject: "taken", Email: email, EmailVerified: true}
+
+	authURL, err := s.StartLogin(context.Background(), testBrowser)
+	if err != nil {
+		t.Fatalf("StartLogin: %v", err)
+	}
+	if err := completeLogin(t, s, idp, authURL, user); err != nil {
+		t.Fatalf("CompleteLogin: %v", err)
+	}
+
+	authURL, err = s.StartLink(context.Background(), otherID, testBrowser)
+	if err != nil {
+		t.Fatalf("StartLink: %v", err)
+	}
+	if err := completeLogin(t, s, idp, authURL, user); !errors.Is(err, ErrIdentityInUse) {
+		t.Fatalf("CompleteLogin error = %v, want ErrIdentityInUse", err)
+	}
+	if got := linkedUser(t, db, idp, "taken"); got != ownerID {
+		t.Fatalf("identity linked to %s, want %s", got, ownerID)
+	}
+}
+
+func TestResolveExternalUserConcurrentFirstLogins(t *testing.T) {
+	db := testdb.Open(t)
+	identity := externalIdentity{
+		Issuer:        "https://idp.test/" + url.PathEscape(t.Name()),
+		Subject:       uuid.NewString(),
+		Email:         uuid.NewString() + "@example.com",
+		EmailVerified: true,
+		Name:          "Concurrent",
+	}
+
+	const logins = 8
+	var wg sync.WaitGroup
+	userIDs := make([]uuid.UUID, logins)
+	errs := make([]error, logins)
+	for i := range logins {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			userIDs[i], errs[i] = resolveExternalUser(context.Background(), db, identity, true)
+		}()
+	}
+	wg.Wait()
+
+	for i := range logins {
+		if errs[i] != nil {
+			t.Fatalf("login %d failed: %v", i, errs[i])
+		}
+		if userIDs[i] != userIDs[0] {
+			t.Fatalf("login %d resolved to %s, want %s", i, userIDs[i], userIDs[0])
+		}
+	}
+}
+
+// oidcCallback requests the callback for a code and state with the given
+// browser cookie, if any, and returns the fragment of the frontend redirect.
+func oidcCallback(t *testing.T, s *OIDCService, code, state string, cookie *http.Cookie) url.Values {
+	t.Helper()
+	query := url.Values{"code": {code}, "state": {state}}
+	req := httptest.NewRequest(http.MethodGet, stubRedirectURL+"?"+query.Encode(), nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	s.handleCallback(rec, req)
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("invalid redirect: %v", err)
+	}
+	fragment, err := url.ParseQuery(location.Fragment)
+	if err != nil {
+		t.Fatalf("invalid redirect fragment: %v", err)
+	}
+	return fragment
+}
+
+func TestOIDCCallbackRequiresBrowserCookie(t *testing.T) {
+	db := testdb.Open(t)
+	idp := newStubIdP(t)
+	s := newTestOIDCService(t, db, idp, true)
+
+	rec := httptest.NewRecorder()
+	s.handleLogin(rec, httptest.NewRequest(http.MethodGet, "/api/v1/oidc/login", nil))
+	var browserCookie *http.Cookie
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == oidcBrowserCookie {
+			browserCookie = cookie
+		}
+	}
+	if browserCookie == nil || !browserCookie.HttpOnly || browserCookie.SameSite != http.SameSiteLaxMode {
+		t.Fatalf("login set browser cookie %+v, want an HttpOnly SameSite=Lax cookie", browserCookie)
+	}
+	code, state := idp.authorize(rec.Header().Get("Location"), stubUser{Subject: "cookie", Email: uuid.NewString() + "@example.com", EmailVerified: true})
+
+	otherBrowser := &http.Cookie{Name: oidcBrowserCookie, Value: "other-browser"}
+	for name, cookie := range map[string]*http.Cookie{"without a cookie": nil, "in another browser": otherBrowser} {
+		if fragment := oidcCallback(t, s, code, state, cookie); fragment.Get("error") != "login_failed" || fragment.Get("token") != "" {
+			t.Fatalf("callback %s = %v, want login_failed", name, fragment)
+		}
+	}
+	if fragment := oidcCallback(t, s, code, state, browserCookie); fragment.Get("token") == "" {
+		t.Fatalf("callback in the browser that started the login = %v, want tokens", fragment)
+	}
+}
+
+func TestOIDCLinkCannotBeCompletedInAnotherBrowser(t *testing.T) {
+	db := testdb.Open(t)
+	idp := newStubIdP(t)
+	s := newTestOIDCService(t, db, idp, false)
+	attackerID, _ := createTestUser(t, db, true)
+
+	// The attacker starts linking and sends the authorization URL to the victim
+	authURL, err := s.StartLink(context.Background(), attackerID, "attacker-browser")
+	if err != nil {
+		t.Fatalf("StartLink: %v", err)
+	}
+	code, state := idp.authorize(authURL, stubUser{Subject: "victim", Email: uuid.NewString() + "@example.com", EmailVerified: true})
+	if _, err := s.CompleteLogin(context.Background(), state, code, "victim-browser", "test", "127.0.0.1"); err == nil {
+		t.Fatal("CompleteLogin succeeded in another browser")
+	}
+
+	var links int
+	query := `SELECT COUNT(*) FROM user_identities WHERE issuer = $1 AND subject = 'victim'`
+	if err := db.QueryRow(context.Background(), query, idp.issuer()).Scan(&links); err != nil {
+		t.Fatalf("failed to count identity links: %v", err)
+	}
+	if links != 0 {
+		t.Fatalf("victim's identity was linked %d times, want 0", links)
+	}
+}
@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// minHMACSecretLength is the minimum length of an HS256 secret in bytes.
+const minHMACSecretLength = 32
+
+// jwtKey is a key used to sign or verify JWTs, identified by its key ID.
+type jwtKey struct {
+	id        string
+	method    jwt.SigningMethod
+	signKey   any // Nil for keys that are only used for verification
+	verifyKey any
+}
+
+// KeySet holds the key used to sign new JWTs and every key whose tokens are
+// still accepted. Tokens carry the ID of their signing key in the "kid" header,
+// so keys can be rotated by adding a new signing key and keeping the old one
+// for verification until its tokens have expired.
+type KeySet struct {
+	signing *jwtKey
+	keys    map[string]*jwtKey
+}
+
+// NewHMACKeySet creates a KeySet that signs with an HS256 secret.
+func NewHMACKeySet(kid string, secret []byte) (*KeySet, error) {
+	if len(secret) < minHMACSecretLength {
+		return nil, fmt.Errorf("HS256 secret must be at least %d bytes", minHMACSecretLength)
+	}
+	k := &jwtKey{id: kid, method: jwt.SigningMethodHS256, signKey: secret, verifyKey: secret}
+	return &KeySet{signing: k, keys: map[string]*jwtKey{kid: k}}, nil
+}
+
+// NewAsymmetricKeySet creates a KeySet that signs with a PEM-encoded RS256 or EdDSA private key.
+func NewAsymmetricKeySet(kid string, alg string, privateKeyPEM []byte) (*KeySet, error) {
+	k := &jwtKey{id: kid}
+	switch alg {
+	case jwt.SigningMethodRS256.Alg():
+		key, err := jwt.ParseRSAPrivateKeyFromPEM(privateKeyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse RS256 private key: %w", err)
+		}
+		k.method, k.signKey, k.verifyKey = jwt.SigningMethodRS256, key, key.Public()
+	case jwt.SigningMethodEdDSA.Alg():
+		key, err := jwt.ParseEdPrivateKeyFromPEM(privateKeyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse EdDSA private key: %w", err)
+		}
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, errors.New("EdDSA private key does not expose a public key")
+		}
+		k.method, k.signKey, k.verifyKey = jwt.SigningMethodEdDSA, key, signer.Public()
+	default:
+		return nil, fmt.Errorf("unsupported JWT algorithm %q", alg)
+	}
+	return &KeySet{signing: k, keys: map[string]*jwtKey{kid: k}}, nil
+}
+
+// AddVerificationKey accepts tokens signed by a retired key. For HS256 the
+// material is the secret; for RS256 and EdDSA it is a PEM-encoded public key.
+func (ks *KeySet) AddVerificationKey(kid string, alg string, material []byte) error {
+	if _, exists := ks.keys[kid]; exists {
+		return fmt.Errorf("duplicate JWT key ID %q", kid)
+	}
+	k := &jwtKey{id: kid}
+	switch alg {
+	case jwt.SigningMethodHS256.Alg():
+		if len(material) < minHMACSecretLength {
+			return fmt.Errorf("HS256 secret for key %q must be at least %d bytes", kid, minHMACSecretLength)
+		}
+		k.method, k.verifyKey = jwt.SigningMethodHS256, material
+	case jwt.SigningMethodRS256.Alg():
+		key, err := jwt.ParseRSAPublicKeyFromPEM(material)
+		if err != nil {
+			return fmt.Errorf("failed to parse RS256 public key %q: %w", kid, err)
+		}
+		k.method, k.verifyKey = jwt.SigningMethodRS256, key
+	case jwt.SigningMethodEdDSA.Alg():
+		key, err := jwt.ParseEdPublicKeyFromPEM(material)
+		if err != nil {
+			return fmt.Errorf("failed to parse EdDSA public key %q: %w", kid, err)
+		}
+		k.method, k.verifyKey = jwt.SigningMethodEdDSA, key
+	default:
+		return fmt.Errorf("unsupported JWT algorithm %q for key %q", alg, kid)
+	}
+	ks.keys[kid] = k
+	return nil
+}
+
+// sign signs claims with the current signing key.
+func (ks *KeySet) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.signing.method, claims)
+	token.Header["kid"] = ks.signing.id
+	return token.SignedString(ks.signing.signKey)
+}
+
+// keyFunc resolves the verification key for a token from its "kid" header and
+// rejects tokens whose algorithm does not match the key.
+func (ks *KeySet) keyFunc(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	k, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key ID %q", kid)
+	}
+	if token.Method.Alg() != k.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return k.verifyKey, nil
+}
+
+// LoadKeySetFromEnv builds a KeySet from the environment:
+//
+//   - JWT_ALGORITHM: HS256 (default), RS256 or EdDSA.
+//   - JWT_KEY_ID: ID of the signing key (default "default").
+//   - JWT_SECRET: the HS256 secret, at least 32 bytes. If unset, a random
+//     secret is generated and tokens do not survive a restart.
+//   - JWT_PRIVATE_KEY_FILE: path to the PEM private key for RS256 or EdDSA.
+//   - JWT_RETIRED_KEYS: comma-separated kid=ALG:value entries for keys that
+//     may still verify tokens, where value is an HS256 secret or the path to
+//     a PEM public key.
+func LoadKeySetFromEnv() (*KeySet, error) {
+	alg := os.Getenv("JWT_ALGORITHM")
+	if alg == "" {
+		alg = jwt.SigningMethodHS256.Alg()
+	}
+	kid := os.Getenv("JWT_KEY_ID")
+	if kid == "" {
+		kid = "default"
+	}
+
+	var ks *KeySet
+	var err error
+	if alg == jwt.SigningMethodHS256.Alg() {
+		secret := []byte(os.Getenv("JWT_SECRET"))
+		if len(secret) == 0 {
+			log.Println("JWT_SECRET not set; using a random secret, sessions will not survive a restart")
+			secret = make([]byte, minHMACSecretLength)
+			if _, err := rand.Read(secret); err != nil {
+				return nil, fmt.Errorf("failed to generate JWT secret: %w", err)
+			}
+		}
+		ks, err = NewHMACKeySet(kid, secret)
+	} else {
+		path := os.Getenv("JWT_PRIVATE_KEY_FILE")
+		if path == "" {
+			return nil, fmt.Errorf("JWT_PRIVATE_KEY_FILE must be set for %s", alg)
+		}
+		pem, readErr := os.ReadFile(path)
+		if readErr != nil {
+			return nil, fmt.Errorf("failed to read JWT private key: %w", readErr)
+		}
+		ks, err = NewAsymmetricKeySet(kid, alg, pem)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	retired := os.Getenv("JWT_RETIRED_KEYS")
+	if retired == "" {
+		return ks, nil
+	}
+	for _, entry := range strings.Split(retired, ",") {
+		retiredKID, spec, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid JWT_RETIRED_KEYS entry %q", entry)
+		}
+		retiredAlg, value, ok := strings.Cut(spec, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid JWT_RETIRED_KEYS entry %q", entry)
+		}
+		material := []byte(value)
+		if retiredAlg != jwt.SigningMethodHS256.Alg() {
+			if material, err = os.ReadFile(value); err != nil {
+				return nil, fmt.Errorf("failed to read JWT public key for %q: %w", retiredKID, err)
+			}
+		}
+		if err := ks.AddVerificationKey(retiredKID, retiredAlg, material); err != nil {
+			return nil, err
+		}
+	}
+	return ks, nil
+}
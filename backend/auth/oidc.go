@@ -0,0 +1,327 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// oidcStateTTL is how long a user has to complete login at the identity provider.
+const oidcStateTTL = 10 * time.Minute
+
+// oidcBrowserCookie holds the token that ties a pending login to the browser
+// that started it, so the callback cannot be completed in another browser.
+const oidcBrowserCookie = "mnemo_oidc_browser"
+
+// OIDCConfig configures login through an OpenID Connect provider such as
+// Authelia, Authentik or Keycloak.
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string // Optional; public clients rely on PKCE alone
+	RedirectURL  string // Mnemo's callback URL, registered with the provider
+	Scopes       string
+	// PostLoginURL is the frontend page that receives the tokens in the URL fragment.
+	PostLoginURL string
+	// AutoProvision creates a user on first login when no user can be linked.
+	AutoProvision bool
+}
+
+// LoadOIDCConfigFromEnv reads the OIDC configuration. It returns nil if
+// OIDC_ISSUER is not set, in which case OIDC login is disabled.
+//
+//   - OIDC_ISSUER, OIDC_CLIENT_ID: required to enable OIDC.
+//   - OIDC_CLIENT_SECRET: optional client secret.
+//   - OIDC_REDIRECT_URL: defaults to <apiBaseURL>/api/v1/oidc/callback.
+//   - OIDC_SCOPES: defaults to "openid email profile".
+//   - OIDC_POST_LOGIN_URL: defaults to <appBaseURL>/oidc/callback.
+//   - OIDC_AUTO_PROVISION: "true" to create users on first login.
+func LoadOIDCConfigFromEnv(apiBaseURL, appBaseURL string) (*OIDCConfig, error) {
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		return nil, nil
+	}
+	cfg := &OIDCConfig{
+		Issuer:       issuer,
+		ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+		Scopes:       os.Getenv("OIDC_SCOPES"),
+		PostLoginURL: os.Getenv("OIDC_POST_LOGIN_URL"),
+	}
+	if cfg.ClientID == "" {
+		return nil, errors.New("OIDC_CLIENT_ID must be set when OIDC_ISSUER is set")
+	}
+	if cfg.RedirectURL == "" {
+		cfg.RedirectURL = apiBaseURL + "/api/v1/oidc/callback"
+	}
+	if cfg.Scopes == "" {
+		cfg.Scopes = "openid email profile"
+	}
+	if cfg.PostLoginURL == "" {
+		cfg.PostLoginURL = appBaseURL + "/oidc/callback"
+	}
+	if v := os.Getenv("OIDC_AUTO_PROVISION"); v != "" {
+		autoProvision, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OIDC_AUTO_PROVISION: %w", err)
+		}
+		cfg.AutoProvision = autoProvision
+	}
+	return cfg, nil
+}
+
+// OIDCService signs users in through an OpenID Connect provider using the
+// authorization code flow with PKCE, then issues Mnemo's own session tokens.
+type OIDCService struct {
+	db          *pgxpool.Pool
+	authService *AuthService
+	provider    *oidcProvider
+	cfg         OIDCConfig
+}
+
+// NewOIDCService creates a new OIDCService.
+func NewOIDCService(db *pgxpool.Pool, authService *AuthService, cfg OIDCConfig) *OIDCService {
+	return &OIDCService{
+		db:          db,
+		authService: authService,
+		provider:    newOIDCProvider(cfg.Issuer, cfg.ClientID, cfg.ClientSecret),
+		cfg:         cfg,
+	}
+}
+
+// RegisterRoutes registers OIDC login routes.
+func (s *OIDCService) RegisterRoutes(r chi.Router) {
+	r.Get("/oidc/login", s.handleLogin)
+	r.With(s.authService.AuthMiddleware).Post("/oidc/link", s.handleLink)
+	r.Get("/oidc/callback", s.handleCallback)
+}
+
+// StartLogin records a pending login and returns the provider URL to redirect
+// the user to. browser is the token of the browser's oidcBrowserCookie, which
+// CompleteLogin requires again.
+func (s *OIDCService) StartLogin(ctx context.Context, browser string) (string, error) {
+	return s.startAuthorization(ctx, browser, nil)
+}
+
+// StartLink is StartLogin for a signed-in user who links their provider
+// account. The identity returned to the callback is linked to the user even
+// if their email address is not verified, since they asked for it.
+func (s *OIDCService) StartLink(ctx context.Context, userID uuid.UUID, browser string) (string, error) {
+	return s.startAuthorization(ctx, browser, &userID)
+}
+
+// startAuthorization records a pending login of a browser, to be linked to
+// linkUserID if set, and returns the provider URL to redirect the user to.
+func (s *OIDCService) startAuthorization(ctx context.Context, browser string, linkUserID *uuid.UUID) (string, error) {
+	if browser == "" {
+		return "", errors.New("missing OIDC browser token")
+	}
+	state, err := newOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	nonce, err := newOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	verifier, err := newOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+
+	// Drop abandoned logins while we are here
+	if _, err := s.db.Exec(ctx, `DELETE FROM oidc_login_states WHERE expires_at < CURRENT_TIMESTAMP`); err != nil {
+		return "", fmt.Errorf("failed to delete expired OIDC states: %w", err)
+	}
+	query := `
+		INSERT INTO oidc_login_states (state_hash, browser_hash, code_verifier, nonce, link_user_id, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+	if _, err := s.db.Exec(ctx, query, hashToken(state), hashToken(browser), verifier, nonce, linkUserID, time.Now().Add(oidcStateTTL)); err != nil {
+		return "", fmt.Errorf("failed to store OIDC state: %w", err)
+	}
+
+	return s.provider.authCodeURL(ctx, s.cfg.RedirectURL, s.cfg.Scopes, state, nonce, base64.RawURLEncoding.EncodeToString(challenge[:]))
+}
+
+// CompleteLogin redeems the authorization code returned to the callback,
+// resolves the Mnemo user for the identity, or links it to the user who
+// started the login with StartLink, and starts a session. browser must be the
+// token the login was started with; a login started in another browser is
+// rejected and left pending.
+func (s *OIDCService) CompleteLogin(ctx context.Context, state, code, browser, userAgent, ip string) (*TokenPair, error) {
+	if browser == "" {
+		return nil, errors.New("missing OIDC browser cookie")
+	}
+	var verifier, nonce string
+	var linkUserID *uuid.UUID
+	query := `
+		DELETE FROM oidc_login_states
+		WHERE state_hash = $1 AND browser_hash = $2 AND expires_at > CURRENT_TIMESTAMP
+		RETURNING code_verifier, nonce, link_user_id
+	`
+	if err := s.db.QueryRow(ctx, query, hashToken(state), hashToken(browser)).Scan(&verifier, &nonce, &linkUserID); err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, errors.New("unknown or expired OIDC state, or login started in another browser")
+		}
+		return nil, fmt.Errorf("failed to get OIDC state: %w", err)
+	}
+
+	rawIDToken, err := s.provider.exchangeCode(ctx, s.cfg.RedirectURL, code, verifier)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := s.provider.verifyIDToken(ctx, rawIDToken, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	name := claims.Name
+	if name == "" {
+		name = claims.PreferredUsername
+	}
+	identity := externalIdentity{
+		Issuer:        s.cfg.Issuer,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: bool(claims.EmailVerified),
+		Name:          name,
+	}
+	if linkUserID != nil {
+		if err := linkExternalIdentity(ctx, s.db, *linkUserID, identity); err != nil {
+			return nil, err
+		}
+		return s.authService.CreateSession(ctx, *linkUserID, userAgent, ip)
+	}
+	userID, err := resolveExternalUser(ctx, s.db, identity, s.cfg.AutoProvision)
+	if err != nil {
+		return nil, err
+	}
+	return s.authService.CreateSession(ctx, userID, userAgent, ip)
+}
+
+// bindBrowser returns the token of the browser's oidcBrowserCookie, setting a
+// new cookie if it has none. The cookie is only sent to the callback, and with
+// SameSite=Lax still on the provider's redirect back to it. Logins started
+// together in one browser share its token.
+func (s *OIDCService) bindBrowser(w http.ResponseWriter, r *http.Request) (string, error) {
+	if cookie, err := r.Cookie(oidcBrowserCookie); err == nil && cookie.Value != "" {
+		return cookie.Value, nil
+	}
+	browser, err := newOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	callbackURL, err := url.Parse(s.cfg.RedirectURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid OIDC redirect URL: %w", err)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcBrowserCookie,
+		Value:    browser,
+		Path:     callbackURL.Path,
+		MaxAge:   int(oidcStateTTL.Seconds()),
+		Secure:   callbackURL.Scheme == "https",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return browser, nil
+}
+
+func (s *OIDCService) handleLogin(w http.ResponseWriter, r *http.Request) {
+	browser, err := s.bindBrowser(w, r)
+	if err != nil {
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		log.Printf("Error starting OIDC login: %v", err)
+		return
+	}
+	authURL, err := s.StartLogin(r.Context(), browser)
+	if err != nil {
+		http.Error(w, "Failed to start login", http.StatusBadGateway)
+		log.Printf("Error starting OIDC login: %v", err)
+		return
+	}
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// handleLink starts linking a provider account to the signed-in user. The
+// frontend sends the browser to the returned URL.
+func (s *OIDCService) handleLink(w http.ResponseWriter, r *http.Request) {
+	userID, ok := sessionUserID(w, r)
+	if !ok {
+		return
+	}
+	browser, err := s.bindBrowser(w, r)
+	if err != nil {
+		http.Error(w, "Failed to start linking", http.StatusInternalServerError)
+		log.Printf("Error starting OIDC link: %v", err)
+		return
+	}
+	authURL, err := s.StartLink(r.Context(), userID, browser)
+	if err != nil {
+		http.Error(w, "Failed to start linking", http.StatusBadGateway)
+		log.Printf("Error starting OIDC link: %v", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"authorization_url": authURL})
+}
+
+// handleCallback completes the login and sends the browser to the frontend.
+// Tokens and errors travel in the URL fragment, which browsers never send to servers.
+// Only the browser that started the login, holding its oidcBrowserCookie, can
+// complete it.
+func (s *OIDCService) handleCallback(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	fragment := url.Values{}
+	var browser string
+	if cookie, err := r.Cookie(oidcBrowserCookie); err == nil {
+		browser = cookie.Value
+	}
+
+	switch {
+	case q.Get("error") != "":
+		fragment.Set("error", q.Get("error"))
+	case q.Get("state") == "" || q.Get("code") == "":
+		fragment.Set("error", "invalid_request")
+	default:
+		tokens, err := s.CompleteLogin(r.Context(), q.Get("state"), q.Get("code"), browser, r.UserAgent(), ClientIP(r))
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrExternalUserNotFound):
+				fragment.Set("error", "account_not_linked")
+			case errors.Is(err, ErrIdentityLinkRequired):
+				fragment.Set("error", "link_required")
+			case errors.Is(err, ErrIdentityInUse):
+				fragment.Set("error", "identity_in_use")
+			default:
+				fragment.Set("error", "login_failed")
+				log.Printf("Error completing OIDC login: %v", err)
+			}
+			break
+		}
+		fragment.Set("token", tokens.AccessToken)
+		fragment.Set("refresh_token", tokens.RefreshToken)
+		fragment.Set("token_type", "Bearer")
+		fragment.Set("expires_in", strconv.Itoa(tokens.ExpiresIn))
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, s.cfg.PostLoginURL+"#"+fragment.Encode(), http.StatusFound)
+}
@@ -0,0 +1,351 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// maxOIDCResponseSize bounds the documents read from the identity provider.
+	maxOIDCResponseSize = 1 << 20
+	// jwksRefreshInterval is the minimum time between JWKS downloads triggered by unknown key IDs.
+	jwksRefreshInterval = time.Minute
+)
+
+// idTokenSigningMethods are the ID token algorithms accepted from identity providers.
+var idTokenSigningMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// oidcDiscovery is the subset of the provider's discovery document that Mnemo uses.
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// idTokenClaims are the ID token claims used to find or create the Mnemo user.
+type idTokenClaims struct {
+	Nonce             string       `json:"nonce"`
+	Email             string       `json:"email"`
+	EmailVerified     flexibleBool `json:"email_verified"`
+	Name              string       `json:"name"`
+	PreferredUsername string       `json:"preferred_username"`
+	jwt.RegisteredClaims
+}
+
+// flexibleBool accepts both JSON booleans and the strings "true"/"false",
+// which some providers send for email_verified.
+type flexibleBool bool
+
+func (b *flexibleBool) UnmarshalJSON(data []byte) error {
+	switch strings.Trim(string(data), `"`) {
+	case "true":
+		*b = true
+	case "false", "null", "":
+		*b = false
+	default:
+		return fmt.Errorf("invalid boolean %s", data)
+	}
+	return nil
+}
+
+// oidcProvider talks to an OpenID Connect provider. The discovery document and
+// signing keys are fetched lazily, so Mnemo starts even if the provider is down.
+type oidcProvider struct {
+	issuer       string
+	clientID     string
+	clientSecret string // Empty for public clients, which rely on PKCE alone
+	client       *http.Client
+
+	mu          sync.Mutex
+	discovery   *oidcDiscovery
+	keys        map[string]any
+	keysFetched time.Time
+}
+
+func newOIDCProvider(issuer, clientID, clientSecret string) *oidcProvider {
+	return &oidcProvider{
+		issuer:       issuer,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		client:       &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// getJSON fetches a JSON document from the provider.
+func (p *oidcProvider) getJSON(ctx context.Context, endpoint string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: unexpected status %s", endpoint, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxOIDCResponseSize)).Decode(v)
+}
+
+// getDiscovery returns the provider's discovery document, fetching it on first use.
+func (p *oidcProvider) getDiscovery(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	var d oidcDiscovery
+	if err := p.getJSON(ctx, strings.TrimSuffix(p.issuer, "/")+"/.well-known/openid-configuration", &d); err != nil {
+		return nil, fmt.Errorf("failed to fetch OIDC discovery document: %w", err)
+	}
+	if d.Issuer != p.issuer {
+		return nil, fmt.Errorf("OIDC discovery document is for issuer %q, expected %q", d.Issuer, p.issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, errors.New("OIDC discovery document is missing required endpoints")
+	}
+	p.discovery = &d
+	return p.discovery, nil
+}
+
+// authCodeURL returns the URL that starts an authorization code flow with PKCE.
+func (p *oidcProvider) authCodeURL(ctx context.Context, redirectURL, scopes, state, nonce, codeChallenge string) (string, error) {
+	d, err := p.getDiscovery(ctx)
+	if err != nil {
+		return "", err
+	}
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.clientID)
+	v.Set("redirect_uri", redirectURL)
+	v.Set("scope", scopes)
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", codeChallenge)
+	v.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return d.AuthorizationEndpoint + sep + v.Encode(), nil
+}
+
+// exchangeCode redeems an authorization code and returns the raw ID token.
+func (p *oidcProvider) exchangeCode(ctx context.Context, redirectURL, code, codeVerifier string) (string, error) {
+	d, err := p.getDiscovery(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURL)
+	form.Set("client_id", p.clientID)
+	form.Set("code_verifier", codeVerifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.clientID), url.QueryEscape(p.clientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to exchange authorization code: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxOIDCResponseSize)).Decode(&body); err != nil {
+		return "", fmt.Errorf("failed to decode token response (status %s): %w", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK || body.Error != "" {
+		return "", fmt.Errorf("token endpoint returned %s: %s %s", resp.Status, body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return "", errors.New("token response has no id_token")
+	}
+	return body.IDToken, nil
+}
+
+// verifyIDToken checks an ID token's signature, issuer, audience, expiry and nonce.
+func (p *oidcProvider) verifyIDToken(ctx context.Context, rawIDToken string, nonce string) (*idTokenClaims, error) {
+	claims := &idTokenClaims{}
+	_, err := jwt.ParseWithClaims(rawIDToken, claims,
+		func(token *jwt.Token) (any, error) {
+			kid, _ := token.Header["kid"].(string)
+			return p.signingKey(ctx, kid)
+		},
+		jwt.WithValidMethods(idTokenSigningMethods),
+		jwt.WithIssuer(p.issuer),
+		jwt.WithAudience(p.clientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+	if claims.Nonce == "" || claims.Nonce != nonce {
+		return nil, errors.New("invalid ID token: nonce mismatch")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("invalid ID token: missing subject")
+	}
+	return claims, nil
+}
+
+// signingKey returns the provider key with the given ID. The key set is
+// downloaded again when the ID is unknown, since providers rotate keys.
+func (p *oidcProvider) signingKey(ctx context.Context, kid string) (any, error) {
+	p.mu.Lock()
+	key, ok := p.lookupKey(kid)
+	stale := time.Since(p.keysFetched) >= jwksRefreshInterval
+	p.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	if !stale {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	d, err := p.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := p.getJSON(ctx, d.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	keys := make(map[string]any, len(set.Keys))
+	for _, raw := range set.Keys {
+		id, key, err := parseJWK(raw)
+		if err != nil {
+			continue // Skip keys we cannot use, e.g. encryption keys
+		}
+		keys[id] = key
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.keys, p.keysFetched = keys, time.Now()
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// lookupKey finds a cached key. Tokens without a key ID are accepted only if
+// the provider publishes a single key. p.mu must be held.
+func (p *oidcProvider) lookupKey(kid string) (any, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+// parseJWK converts a JSON Web Key used for signatures into a crypto public key.
+func parseJWK(raw json.RawMessage) (string, any, error) {
+	var k struct {
+		Kid string `json:"kid"`
+		Kty string `json:"kty"`
+		Use string `json:"use"`
+		Crv string `json:"crv"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	}
+	if err := json.Unmarshal(raw, &k); err != nil {
+		return "", nil, err
+	}
+	if k.Use != "" && k.Use != "sig" {
+		return "", nil, fmt.Errorf("key %q is not a signing key", k.Kid)
+	}
+	b64 := base64.RawURLEncoding
+
+	switch k.Kty {
+	case "RSA":
+		n, err := b64.DecodeString(k.N)
+		if err != nil {
+			return "", nil, err
+		}
+		e, err := b64.DecodeString(k.E)
+		if err != nil {
+			return "", nil, err
+		}
+		exp := new(big.Int).SetBytes(e)
+		if !exp.IsInt64() || exp.Int64() > 1<<31-1 {
+			return "", nil, fmt.Errorf("key %q has an invalid exponent", k.Kid)
+		}
+		return k.Kid, &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return "", nil, fmt.Errorf("key %q uses unsupported curve %q", k.Kid, k.Crv)
+		}
+		x, err := b64.DecodeString(k.X)
+		if err != nil {
+			return "", nil, err
+		}
+		y, err := b64.DecodeString(k.Y)
+		if err != nil {
+			return "", nil, err
+		}
+		return k.Kid, &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return "", nil, fmt.Errorf("key %q uses unsupported curve %q", k.Kid, k.Crv)
+		}
+		x, err := b64.DecodeString(k.X)
+		if err != nil {
+			return "", nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return "", nil, fmt.Errorf("key %q has an invalid Ed25519 public key", k.Kid)
+		}
+		return k.Kid, ed25519.PublicKey(x), nil
+	}
+	return "", nil, fmt.Errorf("key %q has unsupported type %q", k.Kid, k.Kty)
+}
@@ -0,0 +1,246 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	stubClientID    = "mnemo"
+	stubRedirectURL = "http://mnemo.test/api/v1/oidc/callback"
+	stubKeyID       = "stub-key"
+)
+
+// stubUser is the account a stub provider signs in at its authorization endpoint.
+type stubUser struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+// stubGrant is an authorization code issued by a stub provider.
+type stubGrant struct {
+	user        stubUser
+	nonce       string
+	challenge   string
+	redirectURI string
+}
+
+// stubIdP is a minimal OpenID Connect provider serving discovery, JWKS and
+// token endpoints. Tests play the user's browser by calling authorize.
+type stubIdP struct {
+	t        *testing.T
+	server   *httptest.Server
+	key      *rsa.PrivateKey
+	audience string // Audience of issued ID tokens; defaults to stubClientID
+
+	mu     sync.Mutex
+	grants map[string]stubGrant
+}
+
+func newStubIdP(t *testing.T) *stubIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	idp := &stubIdP{t: t, key: key, audience: stubClientID, grants: make(map[string]stubGrant)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:                idp.issuer(),
+			AuthorizationEndpoint: idp.issuer() + "/authorize",
+			TokenEndpoint:         idp.issuer() + "/token",
+			JWKSURI:               idp.issuer() + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		b64 := base64.RawURLEncoding
+		jwk := map[string]string{
+			"kty": "RSA",
+			"kid": stubKeyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   b64.EncodeToString(key.N.Bytes()),
+			"e":   b64.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}
+		json.NewEncoder(w).Encode(map[string]any{"keys": []any{jwk}})
+	})
+	mux.HandleFunc("POST /token", idp.handleToken)
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+func (idp *stubIdP) issuer() string {
+	return idp.server.URL
+}
+
+// authorize signs user in at an authorization URL and returns the code and
+// state the provider would send back to the redirect URI.
+func (idp *stubIdP) authorize(authURL string, user stubUser) (code string, state string) {
+	idp.t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		idp.t.Fatalf("invalid authorization URL: %v", err)
+	}
+	q := u.Query()
+	if q.Get("response_type") != "code" || q.Get("client_id") != stubClientID || q.Get("code_challenge_method") != "S256" {
+		idp.t.Fatalf("unexpected authorization request: %s", authURL)
+	}
+
+	code = base64.RawURLEncoding.EncodeToString([]byte(user.Subject + q.Get("state")))
+	idp.mu.Lock()
+	idp.grants[code] = stubGrant{user: user, nonce: q.Get("nonce"), challenge: q.Get("code_challenge"), redirectURI: q.Get("redirect_uri")}
+	idp.mu.Unlock()
+	return code, q.Get("state")
+}
+
+// handleToken redeems a code once, checking the PKCE verifier, and returns a signed ID token.
+func (idp *stubIdP) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	idp.mu.Lock()
+	grant, ok := idp.grants[r.PostForm.Get("code")]
+	delete(idp.grants, r.PostForm.Get("code"))
+	idp.mu.Unlock()
+
+	challenge := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || r.PostForm.Get("grant_type") != "authorization_code" ||
+		r.PostForm.Get("redirect_uri") != grant.redirectURI ||
+		base64.RawURLEncoding.EncodeToString(challenge[:]) != grant.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	claims := idTokenClaims{
+		Nonce:         grant.nonce,
+		Email:         grant.user.Email,
+		EmailVerified: flexibleBool(grant.user.EmailVerified),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    idp.issuer(),
+			Subject:   grant.user.Subject,
+			Audience:  jwt.ClaimStrings{idp.audience},
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = stubKeyID
+	signed, err := token.SignedString(idp.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": signed, "token_type": "Bearer"})
+}
+
+// pkce returns a code verifier and its S256 challenge.
+func pkce(t *testing.T) (verifier string, challenge string) {
+	t.Helper()
+	verifier, err := newOpaqueToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	return verifier, base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func TestOIDCProviderCodeFlow(t *testing.T) {
+	ctx := context.Background()
+	idp := newStubIdP(t)
+	provider := newOIDCProvider(idp.issuer(), stubClientID, "")
+	verifier, challenge := pkce(t)
+
+	authURL, err := provider.authCodeURL(ctx, stubRedirectURL, "openid email", "state-1", "nonce-1", challenge)
+	if err != nil {
+		t.Fatalf("authCodeURL: %v", err)
+	}
+	if !strings.HasPrefix(authURL, idp.issuer()+"/authorize?") {
+		t.Fatalf("authorization URL %q does not use the discovered endpoint", authURL)
+	}
+	code, state := idp.authorize(authURL, stubUser{Subject: "alice", Email: "alice@example.com", EmailVerified: true})
+	if state != "state-1" {
+		t.Fatalf("state = %q, want state-1", state)
+	}
+
+	rawIDToken, err := provider.exchangeCode(ctx, stubRedirectURL, code, verifier)
+	if err != nil {
+		t.Fatalf("exchangeCode: %v", err)
+	}
+	claims, err := provider.verifyIDToken(ctx, rawIDToken, "nonce-1")
+	if err != nil {
+		t.Fatalf("verifyIDToken: %v", err)
+	}
+	if claims.Subject != "alice" || claims.Email != "alice@example.com" || !bool(claims.EmailVerified) {
+		t.Fatalf("unexpected claims: %+v", claims)
+	}
+}
+
+func TestOIDCProviderRejectsWrongCodeVerifier(t *testing.T) {
+	ctx := context.Background()
+	idp := newStubIdP(t)
+	provider := newOIDCProvider(idp.issuer(), stubClientID, "")
+	_, challenge := pkce(t)
+	otherVerifier, _ := pkce(t)
+
+	authURL, err := provider.authCodeURL(ctx, stubRedirectURL, "openid", "state", "nonce", challenge)
+	if err != nil {
+		t.Fatalf("authCodeURL: %v", err)
+	}
+	code, _ := idp.authorize(authURL, stubUser{Subject: "alice"})
+	if _, err := provider.exchangeCode(ctx, stubRedirectURL, code, otherVerifier); err == nil {
+		t.Fatal("exchangeCode succeeded with the wrong code verifier")
+	}
+}
+
+func TestOIDCProviderRejectsInvalidIDTokens(t *testing.T) {
+	tests := []struct {
+		name     string
+		audience string
+		nonce    string
+	}{
+		{name: "nonce mismatch", audience: stubClientID, nonce: "other-nonce"},
+		{name: "wrong audience", audience: "another-client", nonce: "nonce"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			idp := newStubIdP(t)
+			idp.audience = tt.audience
+			provider := newOIDCProvider(idp.issuer(), stubClientID, "")
+			verifier, challenge := pkce(t)
+
+			authURL, err := provider.authCodeURL(ctx, stubRedirectURL, "openid", "state", "nonce", challenge)
+			if err != nil {
+				t.Fatalf("authCodeURL: %v", err)
+			}
+			code, _ := idp.authorize(authURL, stubUser{Subject: "alice"})
+			rawIDToken, err := provider.exchangeCode(ctx, stubRedirectURL, code, verifier)
+			if err != nil {
+				t.Fatalf("exchangeCode: %v", err)
+			}
+			if _, err := provider.verifyIDToken(ctx, rawIDToken, tt.nonce); err == nil {
+				t.Fatal("verifyIDToken accepted an invalid token")
+			}
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/m-cain/mnemo/backend/apperrors"
+	"github.com/m-cain/mnemo/backend/notify"
+	"github.com/m-cain/mnemo/backend/ratelimit"
+)
+
+// DefaultPasswordResetTTL is how long a password reset link stays valid.
+const DefaultPasswordResetTTL = time.Hour
+
+// passwordResetRequestTimeout bounds the work done for a reset request after
+// the response has been sent.
+const passwordResetRequestTimeout = time.Minute
+
+// revokedPasswordReset is recorded on sessions ended by a password reset.
+const revokedPasswordReset = "password_reset"
+
+// ErrInvalidResetToken is returned for unknown, expired or already used reset tokens.
+var ErrInvalidResetToken = errors.New("invalid or expired reset token")
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+	KeepAPIKeys bool   `json:"keep_api_keys"` // API keys are revoked unless set
+}
+
+// PasswordResetService lets users who forgot their password set a new one
+// through a single-use link sent by email.
+type PasswordResetService struct {
+	db       *pgxpool.Pool
+	notifier notify.Notifier
+	limiter  *ratelimit.Limiter // Nil disables the per-account limit on reset emails
+	resetURL string             // Base URL of the page that resets passwords; the token is appended as a query parameter
+	ttl      time.Duration
+	pending  sync.WaitGroup // Reset requests still being processed
+}
+
+// NewPasswordResetService creates a new PasswordResetService. limiter may be
+// nil to disable the per-account limit on reset emails. Call Close to wait for
+// requests still being processed.
+func NewPasswordResetService(db *pgxpool.Pool, notifier notify.Notifier, resetURL string, limiter *ratelimit.Limiter) *PasswordResetService {
+	return &PasswordResetService{db: db, notifier: notifier, limiter: limiter, resetURL: resetURL, ttl: DefaultPasswordResetTTL}
+}
+
+// Close waits for reset requests that are still being processed.
+func (s *PasswordResetService) Close() {
+	s.pending.Wait()
+}
+
+// RegisterRoutes registers password reset routes.
+func (s *PasswordResetService) RegisterRoutes(r chi.Router) {
+	r.Post("/password/forgot", s.handleForgotPassword)
+	r.Post("/password/reset", s.handleResetPassword)
+}
+
+// RequestReset emails a reset link to the account with the given email, if
+// there is one. Unknown addresses are ignored so callers cannot probe which
+// addresses are registered. Earlier unused links for the account stop working.
+// Addresses that asked for too many links recently are ignored as well.
+func (s *PasswordResetService) RequestReset(ctx context.Context, email string) error {
+	if !s.allowReset(ctx, email) {
+		return nil
+	}
+
+	var userID uuid.UUID
+	var address string
+	err := s.db.QueryRow(ctx, `SELECT id, email FROM users WHERE LOWER(email) = LOWER($1)`, strings.TrimSpace(email)).Scan(&userID, &address)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil
+		}
+		return fmt.Errorf("failed to look up user: %w", err)
+	}
+
+	token, err := newOpaqueToken()
+	if err != nil {
+		return err
+	}
+	expiresAt := time.Now().Add(s.ttl)
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx) // Rollback if not committed
+
+	if _, err := tx.Exec(ctx, `DELETE FROM password_reset_tokens WHERE user_id = $1 AND used_at IS NULL`, userID); err != nil {
+		return fmt.Errorf("failed to remove previous reset tokens: %w", err)
+	}
+	insertQuery := `INSERT INTO password_reset_tokens (user_id, token_hash, expires_at) VALUES ($1, $2, $3)`
+	if _, err := tx.Exec(ctx, insertQuery, userID, hashToken(token), expiresAt); err != nil {
+		return fmt.Errorf("failed to insert reset token: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	// A token whose link could not be sent is harmless; asking again replaces it
+	msg := notify.Message{
+		To:      address,
+		Subject: "Reset your Mnemo password",
+		Body: fmt.Sprintf("Someone asked to reset the password of your Mnemo account.\n\n"+
+			"Choose a new password here:\n%s?token=%s\n\n"+
+			"This link expires on %s. If you did not ask for this, you can ignore this email.",
+			s.resetURL, token, expiresAt.Format(time.RFC1123)),
+	}
+	if err := s.notifier.Notify(ctx, msg); err != nil {
+		return fmt.Errorf("failed to send reset email: %w", err)
+	}
+	return nil
+}
+
+// allowReset takes a token from the reset quota of an address, whether or not
+// it is registered. Limiter errors fail open.
+func (s *PasswordResetService) allowReset(ctx context.Context, email string) bool {
+	if s.limiter == nil {
+		return true
+	}
+	res, err := s.limiter.Store.Take(ctx, passwordResetAccountKey(email), s.limiter.Config.PasswordReset)
+	if err != nil {
+		log.Printf("Error taking password reset rate limit token: %v", err)
+		return true
+	}
+	return res.Allowed
+}
+
+// ResetPassword sets a new password using a reset token and consumes the token.
+// All of the user's sessions are revoked, as are their API keys unless keepAPIKeys is set.
+func (s *PasswordResetService) ResetPassword(ctx context.Context, token string, newPassword string, keepAPIKeys bool) error {
+	if err := validatePassword(newPassword); err != nil {
+		return err
+	}
+	hashedPassword, err := hashPassword(newPassword)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx) // Rollback if not committed
+
+	query := `
+		SELECT id, user_id, expires_at, used_at
+		FROM password_reset_tokens
+		WHERE token_hash = $1
+		FOR UPDATE
+	`
+	var tokenID, userID uuid.UUID
+	var expiresAt time.Time
+	var usedAt *time.Time
+	if err := tx.QueryRow(ctx, query, hashToken(token)).Scan(&tokenID, &userID, &expiresAt, &usedAt); err != nil {
+		if err == pgx.ErrNoRows {
+			return ErrInvalidResetToken
+		}
+		return fmt.Errorf("failed to get reset token: %w", err)
+	}
+	if usedAt != nil || time.Now().After(expiresAt) {
+		return ErrInvalidResetToken
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE password_reset_tokens SET used_at = CURRENT_TIMESTAMP WHERE id = $1`, tokenID); err != nil {
+		return fmt.Errorf("failed to mark reset token used: %w", err)
+	}
+	// The link was mailed to the account's address, which proves the user receives mail there
+	updateQuery := `
+		UPDATE users
+		SET password_hash = $1, email_verified_at = COALESCE(email_verified_at, CURRENT_TIMESTAMP), updated_at = $2
+		WHERE id = $3
+	`
+	if _, err := tx.Exec(ctx, updateQuery, hashedPassword, time.Now(), userID); err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+	if err := revokeSessions(ctx, tx, `user_id = $1`, revokedPasswordReset, userID); err != nil {
+		return err
+	}
+	if !keepAPIKeys {
+		revokeQuery := `UPDATE api_keys SET is_active = FALSE, updated_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND is_active = TRUE`
+		if _, err := tx.Exec(ctx, revokeQuery, userID); err != nil {
+			return fmt.Errorf("failed to revoke API keys: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (s *PasswordResetService) handleForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req ForgotPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Email) == "" {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	// Respond before doing any work, so the response is the same and takes as
+	// long whether or not the address is registered
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), passwordResetRequestTimeout)
+	s.pending.Add(1)
+	go func() {
+		defer s.pending.Done()
+		defer cancel()
+		if err := s.RequestReset(ctx, req.Email); err != nil {
+			log.Printf("Error requesting password reset: %v", err)
+		}
+	}()
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *PasswordResetService) handleResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.ResetPassword(r.Context(), req.Token, req.NewPassword, req.KeepAPIKeys); err != nil {
+		switch {
+		case errors.Is(err, apperrors.ErrInvalidInput):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, ErrInvalidResetToken):
+			http.Error(w, err.Error(), http.StatusGone)
+		default:
+			http.Error(w, "Failed to reset password", http.StatusInternalServerError)
+			log.Printf("Error resetting password: %v", err)
+		}
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
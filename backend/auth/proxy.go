@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// proxyIdentityIssuer is the issuer recorded for identities asserted by the reverse proxy.
+const proxyIdentityIssuer = "proxy-header"
+
+// TrustedProxies are the networks of reverse proxies whose X-Forwarded-For
+// header is trusted to name the client of a request.
+type TrustedProxies []*net.IPNet
+
+// LoadTrustedProxiesFromEnv reads the reverse proxies trusted to report client
+// addresses from TRUSTED_PROXY_CIDRS, comma-separated CIDRs such as
+// "172.18.0.0/16". It returns nil if the variable is not set, in which case
+// the client address is the peer address. Trusting a proxy for client
+// addresses does not enable proxy authentication.
+func LoadTrustedProxiesFromEnv() (TrustedProxies, error) {
+	return parseTrustedProxies("TRUSTED_PROXY_CIDRS")
+}
+
+// parseTrustedProxies parses the comma-separated CIDRs in an environment variable.
+func parseTrustedProxies(key string) (TrustedProxies, error) {
+	cidrs := os.Getenv(key)
+	if cidrs == "" {
+		return nil, nil
+	}
+	var proxies TrustedProxies
+	for _, cidr := range strings.Split(cidrs, ",") {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s entry %q: %w", key, cidr, err)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+// ProxyAuthConfig configures authentication by a trusted reverse proxy (for
+// example Authelia or Authentik in forward-auth mode) that sets headers
+// naming the signed-in user. The headers are only trusted on requests whose
+// peer address is in TrustedProxies. These proxies are also trusted to report
+// client addresses, as are those in TRUSTED_PROXY_CIDRS.
+type ProxyAuthConfig struct {
+	TrustedProxies TrustedProxies
+	UserHeader     string
+	EmailHeader    string
+	NameHeader     string
+	// AutoProvision creates a user the first time an unknown identity is seen.
+	AutoProvision bool
+}
+
+// LoadProxyAuthConfigFromEnv reads the proxy authentication configuration.
+// It returns nil if PROXY_AUTH_TRUSTED_CIDRS is not set, in which case proxy
+// headers are ignored.
+//
+//   - PROXY_AUTH_TRUSTED_CIDRS: comma-separated CIDRs of the proxies, e.g. "172.18.0.0/16".
+//   - PROXY_AUTH_USER_HEADER: defaults to "Remote-User".
+//   - PROXY_AUTH_EMAIL_HEADER: defaults to "Remote-Email".
+//   - PROXY_AUTH_NAME_HEADER: defaults to "Remote-Name".
+//   - PROXY_AUTH_AUTO_PROVISION: "false" to only accept users that already exist.
+func LoadProxyAuthConfigFromEnv() (*ProxyAuthConfig, error) {
+	proxies, err := parseTrustedProxies("PROXY_AUTH_TRUSTED_CIDRS")
+	if err != nil || proxies == nil {
+		return nil, err
+	}
+	cfg := &ProxyAuthConfig{
+		TrustedProxies: proxies,
+		UserHeader:     envOrDefault("PROXY_AUTH_USER_HEADER", "Remote-User"),
+		EmailHeader:    envOrDefault("PROXY_AUTH_EMAIL_HEADER", "Remote-Email"),
+		NameHeader:     envOrDefault("PROXY_AUTH_NAME_HEADER", "Remote-Name"),
+		AutoProvision:  true,
+	}
+	if v := os.Getenv("PROXY_AUTH_AUTO_PROVISION"); v != "" {
+		autoProvision, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PROXY_AUTH_AUTO_PROVISION: %w", err)
+		}
+		cfg.AutoProvision = autoProvision
+	}
+	return cfg, nil
+}
+
+func envOrDefault(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// isTrustedProxy reports whether the request came directly from a trusted proxy.
+func (c *ProxyAuthConfig) isTrustedProxy(r *http.Request) bool {
+	return c.TrustedProxies.trusts(net.ParseIP(peerIP(r)))
+}
+
+// clientIP returns the address of the client that sent a request. For
+// requests relayed by trusted proxies it is the right-most X-Forwarded-For
+// hop that is not a trusted proxy itself; hops left of it were supplied by the
+// client and may be forged. Without trusted proxies it is the peer address.
+func (c TrustedProxies) clientIP(r *http.Request) string {
+	client := peerIP(r)
+	if !c.trusts(net.ParseIP(client)) {
+		return client
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			break // Malformed, so nothing left of it can be trusted
+		}
+		client = ip.String()
+		if !c.trusts(ip) {
+			break
+		}
+	}
+	return client
+}
+
+// trusts reports whether ip belongs to a trusted proxy.
+func (c TrustedProxies) trusts(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range c {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticateProxyRequest returns the user named by the proxy headers. It
+// returns ok=false if proxy authentication does not apply to the request:
+// it is disabled, the peer is not a trusted proxy, or the headers are absent.
+func (s *AuthService) authenticateProxyRequest(ctx context.Context, r *http.Request) (userID string, ok bool, err error) {
+	cfg := s.proxyAuth
+	if cfg == nil || !cfg.isTrustedProxy(r) {
+		return "", false, nil
+	}
+	subject := strings.TrimSpace(r.Header.Get(cfg.UserHeader))
+	if subject == "" {
+		return "", false, nil
+	}
+
+	// The proxy vouches for the email address, so it counts as verified, and
+	// it authenticates users for Mnemo, so it may assert any account
+	email := strings.TrimSpace(r.Header.Get(cfg.EmailHeader))
+	identity := externalIdentity{
+		Issuer:        proxyIdentityIssuer,
+		Subject:       subject,
+		Email:         email,
+		EmailVerified: email != "",
+		Name:          r.Header.Get(cfg.NameHeader),
+		Authoritative: true,
+	}
+	id, err := resolveExternalUser(ctx, s.db, identity, cfg.AutoProvision)
+	if err != nil {
+		if errors.Is(err, ErrExternalUserNotFound) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	return id.String(), true, nil
+}
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIPMiddleware(t *testing.T) {
+	_, proxies, err := net.ParseCIDR("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	trusting := NewAuthService(nil, nil, TokenConfig{}, nil, TrustedProxies{proxies}, nil)
+	proxyAuth := NewAuthService(nil, nil, TokenConfig{}, &ProxyAuthConfig{TrustedProxies: TrustedProxies{proxies}}, nil, nil)
+
+	tests := []struct {
+		name         string
+		service      *AuthService
+		remoteAddr   string
+		forwardedFor []string
+		want         string
+	}{
+		{name: "no proxy configured", service: &AuthService{}, remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"203.0.113.7"}, want: "10.0.0.1"},
+		{name: "untrusted peer", service: trusting, remoteAddr: "198.51.100.1:1234", forwardedFor: []string{"203.0.113.7"}, want: "198.51.100.1"},
+		{name: "trusted peer without header", service: trusting, remoteAddr: "10.0.0.1:1234", want: "10.0.0.1"},
+		{name: "single hop", service: trusting, remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"203.0.113.7"}, want: "203.0.113.7"},
+		{name: "forged hops are ignored", service: trusting, remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"1.2.3.4, 203.0.113.7"}, want: "203.0.113.7"},
+		{name: "chained trusted proxies", service: trusting, remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"1.2.3.4, 203.0.113.7, 10.0.0.2"}, want: "203.0.113.7"},
+		{name: "repeated headers", service: trusting, remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"1.2.3.4", "203.0.113.7, 10.0.0.2"}, want: "203.0.113.7"},
+		{name: "only trusted hops", service: trusting, remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"10.0.0.3, 10.0.0.2"}, want: "10.0.0.3"},
+		{name: "malformed hop", service: trusting, remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"203.0.113.7, garbage, 10.0.0.2"}, want: "10.0.0.2"},
+		{name: "IPv6 hop", service: trusting, remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"2001:db8::1"}, want: "2001:db8::1"},
+		{name: "proxy authentication proxies", service: proxyAuth, remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"203.0.113.7"}, want: "203.0.113.7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.forwardedFor {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+
+			var got string
+			handler := tt.service.ClientIPMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = ClientIP(r)
+			}))
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+			if got != tt.want {
+				t.Errorf("ClientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadTrustedProxiesFromEnv(t *testing.T) {
+	t.Setenv("PROXY_AUTH_TRUSTED_CIDRS", "")
+	t.Setenv("TRUSTED_PROXY_CIDRS", "10.0.0.0/8, 2001:db8::/32")
+
+	proxies, err := LoadTrustedProxiesFromEnv()
+	if err != nil {
+		t.Fatalf("LoadTrustedProxiesFromEnv: %v", err)
+	}
+	if len(proxies) != 2 || !proxies.trusts(net.ParseIP("10.1.2.3")) || !proxies.trusts(net.ParseIP("2001:db8::1")) {
+		t.Fatalf("LoadTrustedProxiesFromEnv = %v, want 10.0.0.0/8 and 2001:db8::/32", proxies)
+	}
+
+	// Resolving client addresses through a proxy does not let it authenticate users
+	proxyAuth, err := LoadProxyAuthConfigFromEnv()
+	if err != nil {
+		t.Fatalf("LoadProxyAuthConfigFromEnv: %v", err)
+	}
+	if proxyAuth != nil {
+		t.Fatalf("LoadProxyAuthConfigFromEnv = %+v, want proxy authentication disabled", proxyAuth)
+	}
+	s := NewAuthService(nil, nil, TokenConfig{}, proxyAuth, proxies, nil)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("Remote-User", "admin")
+	if _, ok, err := s.authenticateProxyRequest(r.Context(), r); ok || err != nil {
+		t.Fatalf("authenticateProxyRequest = %v, %v; want the header ignored", ok, err)
+	}
+
+	t.Setenv("TRUSTED_PROXY_CIDRS", "10.0.0.0/8,not-a-cidr")
+	if _, err := LoadTrustedProxiesFromEnv(); err == nil {
+		t.Fatal("LoadTrustedProxiesFromEnv accepted an invalid CIDR")
+	}
+}
@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/google/uuid"
+	"github.com/m-cain/mnemo/backend/apperrors"
+	"github.com/m-cain/mnemo/backend/contextkey"
+)
+
+// API key scopes. ScopeAdmin grants everything, including managing API keys.
+const (
+	ScopeItemsRead      = "items:read"
+	ScopeItemsWrite     = "items:write"
+	ScopeLocationsRead  = "locations:read"
+	ScopeLocationsWrite = "locations:write"
+	ScopeHomesRead      = "homes:read"
+	ScopeHomesWrite     = "homes:write"
+	ScopeMembersRead    = "members:read"
+	ScopeMembersWrite   = "members:write"
+	ScopeAuditRead      = "audit:read"
+	ScopeAdmin          = "admin"
+)
+
+// ErrExceedsGrants is returned when a request authenticated by an API key
+// tries to issue a key with scopes or homes the authenticating key lacks.
+var ErrExceedsGrants = errors.New("API key cannot grant scopes or homes beyond its own")
+
+// knownScopes lists every scope an API key may be granted.
+var knownScopes = []string{
+	ScopeItemsRead, ScopeItemsWrite,
+	ScopeLocationsRead, ScopeLocationsWrite,
+	ScopeHomesRead, ScopeHomesWrite,
+	ScopeMembersRead, ScopeMembersWrite,
+	ScopeAuditRead,
+	ScopeAdmin,
+}
+
+// ValidateScopes returns apperrors.ErrInvalidInput if scopes is empty or contains an unknown scope.
+func ValidateScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("%w: at least one scope is required", apperrors.ErrInvalidInput)
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(knownScopes, scope) {
+			return fmt.Errorf("%w: unknown scope %q", apperrors.ErrInvalidInput, scope)
+		}
+	}
+	return nil
+}
+
+// HasScope reports whether the request context grants scope. Requests
+// authenticated as a user session are not limited by scopes.
+func HasScope(ctx context.Context, scope string) bool {
+	scopes, ok := ctx.Value(contextkey.ScopesKey).([]string)
+	if !ok {
+		return true
+	}
+	return slices.Contains(scopes, ScopeAdmin) || slices.Contains(scopes, scope)
+}
+
+// CanAccessHome reports whether the request context may access the given home.
+// Only API keys restricted to specific homes are limited.
+func CanAccessHome(ctx context.Context, homeID string) bool {
+	homeIDs, ok := ctx.Value(contextkey.APIKeyHomeIDsKey).([]uuid.UUID)
+	if !ok {
+		return true
+	}
+	id, err := uuid.Parse(homeID)
+	if err != nil {
+		return false
+	}
+	return slices.Contains(homeIDs, id)
+}
+
+// checkWithinGrants returns ErrExceedsGrants unless every scope and home of a
+// new key is granted to the request context. A nil homeIDs means all homes.
+func checkWithinGrants(ctx context.Context, scopes []string, homeIDs []uuid.UUID) error {
+	for _, scope := range scopes {
+		if !HasScope(ctx, scope) {
+			return fmt.Errorf("%w: scope %q", ErrExceedsGrants, scope)
+		}
+	}
+	if _, restricted := ctx.Value(contextkey.APIKeyHomeIDsKey).([]uuid.UUID); restricted && homeIDs == nil {
+		return fmt.Errorf("%w: home_ids must be set", ErrExceedsGrants)
+	}
+	for _, id := range homeIDs {
+		if !CanAccessHome(ctx, id.String()) {
+			return fmt.Errorf("%w: home %s", ErrExceedsGrants, id)
+		}
+	}
+	return nil
+}
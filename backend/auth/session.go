@@ -0,0 +1,264 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+const (
+	// DefaultAccessTokenTTL is the lifetime of an access token.
+	DefaultAccessTokenTTL = 15 * time.Minute
+	// DefaultRefreshTokenTTL is how long a refresh token can be used; each use issues a new one.
+	DefaultRefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+// Reasons recorded when a session is revoked.
+const (
+	revokedLogout       = "logout"
+	revokedLogoutAll    = "logout_all"
+	revokedRefreshReuse = "refresh_token_reuse"
+)
+
+var (
+	// ErrInvalidRefreshToken is returned for unknown, expired or revoked refresh tokens.
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	// ErrRefreshTokenReused is returned when an already used refresh token is presented.
+	// The session it belongs to is revoked, since the token has likely been stolen.
+	ErrRefreshTokenReused = errors.New("refresh token reuse detected")
+)
+
+// TokenConfig configures how sessions and their tokens are issued.
+type TokenConfig struct {
+	Keys            *KeySet
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+}
+
+// LoadTokenConfigFromEnv builds a TokenConfig from the environment. Keys are
+// loaded by LoadKeySetFromEnv; JWT_ACCESS_TTL and JWT_REFRESH_TTL optionally
+// override the token lifetimes using Go duration syntax (e.g. "10m", "720h").
+func LoadTokenConfigFromEnv() (TokenConfig, error) {
+	keys, err := LoadKeySetFromEnv()
+	if err != nil {
+		return TokenConfig{}, err
+	}
+	cfg := TokenConfig{Keys: keys, AccessTokenTTL: DefaultAccessTokenTTL, RefreshTokenTTL: DefaultRefreshTokenTTL}
+	if v := os.Getenv("JWT_ACCESS_TTL"); v != "" {
+		if cfg.AccessTokenTTL, err = time.ParseDuration(v); err != nil {
+			return TokenConfig{}, fmt.Errorf("invalid JWT_ACCESS_TTL: %w", err)
+		}
+	}
+	if v := os.Getenv("JWT_REFRESH_TTL"); v != "" {
+		if cfg.RefreshTokenTTL, err = time.ParseDuration(v); err != nil {
+			return TokenConfig{}, fmt.Errorf("invalid JWT_REFRESH_TTL: %w", err)
+		}
+	}
+	return cfg, nil
+}
+
+// accessClaims are the claims of an access token. SessionID ties the token to
+// a server-side session so it stops working once the session is revoked.
+type accessClaims struct {
+	SessionID string `json:"sid"`
+	jwt.RegisteredClaims
+}
+
+// TokenPair is the set of tokens issued when a session is created or refreshed.
+type TokenPair struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresIn    int // Lifetime of the access token in seconds
+}
+
+// hashToken returns the hex-encoded SHA-256 hash under which an opaque token is stored.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newOpaqueToken returns a random URL-safe token.
+func newOpaqueToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// issueAccessToken signs a short-lived access token for a session.
+func (s *AuthService) issueAccessToken(userID uuid.UUID, sessionID uuid.UUID) (string, error) {
+	now := time.Now()
+	claims := accessClaims{
+		SessionID: sessionID.String(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   userID.String(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.tokens.AccessTokenTTL)),
+		},
+	}
+	token, err := s.tokens.Keys.sign(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign access token: %w", err)
+	}
+	return token, nil
+}
+
+// insertRefreshToken stores a new refresh token for a session and returns it.
+func (s *AuthService) insertRefreshToken(ctx context.Context, tx pgx.Tx, sessionID uuid.UUID) (string, error) {
+	token, err := newOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+	query := `INSERT INTO refresh_tokens (session_id, token_hash, expires_at) VALUES ($1, $2, $3)`
+	if _, err := tx.Exec(ctx, query, sessionID, hashToken(token), time.Now().Add(s.tokens.RefreshTokenTTL)); err != nil {
+		return "", fmt.Errorf("failed to insert refresh token: %w", err)
+	}
+	return token, nil
+}
+
+// CreateSession starts a new session for a user who has just authenticated and
+// returns its access and refresh tokens.
+func (s *AuthService) CreateSession(ctx context.Context, userID uuid.UUID, userAgent string, ip string) (*TokenPair, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx) // Rollback if not committed
+
+	var sessionID uuid.UUID
+	query := `INSERT INTO sessions (user_id, user_agent, ip) VALUES ($1, $2, $3) RETURNING id`
+	if err := tx.QueryRow(ctx, query, userID, userAgent, ip).Scan(&sessionID); err != nil {
+		return nil, fmt.Errorf("failed to insert session: %w", err)
+	}
+
+	refreshToken, err := s.insertRefreshToken(ctx, tx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	accessToken, err := s.issueAccessToken(userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return &TokenPair{AccessToken: accessToken, RefreshToken: refreshToken, ExpiresIn: int(s.tokens.AccessTokenTTL.Seconds())}, nil
+}
+
+// RefreshSession exchanges a refresh token for a new token pair. Each refresh
+// token is single-use: presenting one that was already used revokes the whole
+// session and returns ErrRefreshTokenReused.
+func (s *AuthService) RefreshSession(ctx context.Context, refreshToken string) (*TokenPair, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx) // Rollback if not committed
+
+	query := `
+		SELECT rt.id, rt.expires_at, rt.used_at, s.id, s.user_id, s.revoked_at
+		FROM refresh_tokens rt
+		JOIN sessions s ON rt.session_id = s.id
+		WHERE rt.token_hash = $1
+		FOR UPDATE
+	`
+	var tokenID, sessionID, userID uuid.UUID
+	var expiresAt time.Time
+	var usedAt, revokedAt *time.Time
+	err = tx.QueryRow(ctx, query, hashToken(refreshToken)).Scan(&tokenID, &expiresAt, &usedAt, &sessionID, &userID, &revokedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, fmt.Errorf("failed to get refresh token: %w", err)
+	}
+	if revokedAt != nil || time.Now().After(expiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	if usedAt != nil {
+		if err := revokeSessions(ctx, tx, `id = $1`, revokedRefreshReuse, sessionID); err != nil {
+			return nil, err
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return nil, fmt.Errorf("failed to commit transaction: %w", err)
+		}
+		log.Printf("Refresh token reuse detected for session %s of user %s; session revoked", sessionID, userID)
+		return nil, ErrRefreshTokenReused
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE refresh_tokens SET used_at = CURRENT_TIMESTAMP WHERE id = $1`, tokenID); err != nil {
+		return nil, fmt.Errorf("failed to mark refresh token used: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `UPDATE sessions SET last_refreshed_at = CURRENT_TIMESTAMP WHERE id = $1`, sessionID); err != nil {
+		return nil, fmt.Errorf("failed to update session: %w", err)
+	}
+
+	newRefreshToken, err := s.insertRefreshToken(ctx, tx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	accessToken, err := s.issueAccessToken(userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return &TokenPair{AccessToken: accessToken, RefreshToken: newRefreshToken, ExpiresIn: int(s.tokens.AccessTokenTTL.Seconds())}, nil
+}
+
+// RevokeSession revokes one session of a user.
+func (s *AuthService) RevokeSession(ctx context.Context, sessionID uuid.UUID, userID uuid.UUID) error {
+	return revokeSessions(ctx, s.db, `id = $1 AND user_id = $2`, revokedLogout, sessionID, userID)
+}
+
+// RevokeAllSessions revokes every active session of a user.
+func (s *AuthService) RevokeAllSessions(ctx context.Context, userID uuid.UUID) error {
+	return revokeSessions(ctx, s.db, `user_id = $1`, revokedLogoutAll, userID)
+}
+
+// execer is implemented by both *pgxpool.Pool and pgx.Tx.
+type execer interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+// revokeSessions marks the active sessions matching cond as revoked.
+// The reason is bound as the last parameter after args.
+func revokeSessions(ctx context.Context, db execer, cond string, reason string, args ...any) error {
+	query := fmt.Sprintf(`UPDATE sessions SET revoked_at = CURRENT_TIMESTAMP, revoked_reason = $%d WHERE revoked_at IS NULL AND %s`, len(args)+1, cond)
+	if _, err := db.Exec(ctx, query, append(args, reason)...); err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	return nil
+}
+
+// isSessionActive reports whether a session exists and has not been revoked.
+func (s *AuthService) isSessionActive(ctx context.Context, sessionID string) (bool, error) {
+	var active bool
+	err := s.db.QueryRow(ctx, `SELECT revoked_at IS NULL FROM sessions WHERE id = $1`, sessionID).Scan(&active)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to check session: %w", err)
+	}
+	return active, nil
+}
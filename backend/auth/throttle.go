@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/m-cain/mnemo/backend/models"
+	"github.com/m-cain/mnemo/backend/ratelimit"
+)
+
+// Keys under which authentication attempts are throttled.
+func loginIPKey(ip string) string { return "login:ip:" + ip }
+func loginAccountKey(email string) string {
+	return "login:account:" + strings.ToLower(strings.TrimSpace(email))
+}
+func passwordResetAccountKey(email string) string {
+	return "reset:account:" + strings.ToLower(strings.TrimSpace(email))
+}
+func loginTwoFactorKey(userID uuid.UUID) string { return "login:2fa:" + userID.String() }
+func apiKeyFailureKey(ip string) string         { return "apikey:ip:" + ip }
+func apiKeyQuotaKey(id string) string           { return "apikey:" + id }
+
+// allowLoginAttempt enforces the login quota per client IP and per account and
+// any lockout from earlier failures. It writes a 429 response and returns false
+// if the attempt must be rejected.
+func (s *AuthService) allowLoginAttempt(w http.ResponseWriter, r *http.Request, email string) bool {
+	return s.allowAttempt(w, r, loginIPKey(ClientIP(r)), loginAccountKey(email))
+}
+
+// allowTwoFactorAttempt is allowLoginAttempt for the second step of a login,
+// throttled per client IP and per user. userID is uuid.Nil if the challenge
+// token is invalid, so that only the client IP is known.
+func (s *AuthService) allowTwoFactorAttempt(w http.ResponseWriter, r *http.Request, userID uuid.UUID) bool {
+	if userID == uuid.Nil {
+		return s.allowAttempt(w, r, loginIPKey(ClientIP(r)))
+	}
+	return s.allowAttempt(w, r, loginIPKey(ClientIP(r)), loginTwoFactorKey(userID))
+}
+
+// allowAttempt checks the lockout of each key and takes a login token from it.
+// Limiter errors fail open.
+func (s *AuthService) allowAttempt(w http.ResponseWriter, r *http.Request, keys ...string) bool {
+	if s.limiter == nil {
+		return true
+	}
+	ctx := r.Context()
+	for _, key := range keys {
+		lockedFor, err := s.limiter.Store.LockedFor(ctx, key)
+		if err != nil {
+			log.Printf("Error checking lockout for %s: %v", key, err)
+			continue
+		}
+		if lockedFor > 0 {
+			ratelimit.Reject(w, lockedFor, "Too many failed attempts, try again later")
+			return false
+		}
+
+		res, err := s.limiter.Store.Take(ctx, key, s.limiter.Config.Login)
+		if err != nil {
+			log.Printf("Error taking rate limit token for %s: %v", key, err)
+			continue
+		}
+		if !res.Allowed {
+			ratelimit.Reject(w, res.RetryAfter, "Too many login attempts, try again later")
+			return false
+		}
+	}
+	return true
+}
+
+// recordLoginFailure counts a failed login against the client IP and the account.
+func (s *AuthService) recordLoginFailure(ctx context.Context, ip string, email string) {
+	s.recordFailures(ctx, loginIPKey(ip), loginAccountKey(email))
+}
+
+// recordFailures records a failure for each key under the lockout policy.
+func (s *AuthService) recordFailures(ctx context.Context, keys ...string) {
+	if s.limiter == nil {
+		return
+	}
+	for _, key := range keys {
+		if _, err := s.limiter.Store.Fail(ctx, key, s.limiter.Config.Lockout); err != nil {
+			log.Printf("Error recording failure for %s: %v", key, err)
+		}
+	}
+}
+
+// resetLoginFailures clears an account's failures after a successful login.
+// Failures from the client IP are kept, so one known password cannot be used
+// to keep guessing others.
+func (s *AuthService) resetLoginFailures(ctx context.Context, email string) {
+	if s.limiter == nil {
+		return
+	}
+	if err := s.limiter.Store.Reset(ctx, loginAccountKey(email)); err != nil {
+		log.Printf("Error resetting login failures: %v", err)
+	}
+}
+
+// resetTwoFactorFailures clears a user's failed codes after a successful
+// second step of a login.
+func (s *AuthService) resetTwoFactorFailures(ctx context.Context, userID uuid.UUID) {
+	if s.limiter == nil {
+		return
+	}
+	if err := s.limiter.Store.Reset(ctx, loginTwoFactorKey(userID)); err != nil {
+		log.Printf("Error resetting two-factor failures: %v", err)
+	}
+}
+
+// apiKeyLockedFor returns how long the client IP is locked out of API key
+// authentication after repeated invalid keys.
+func (s *AuthService) apiKeyLockedFor(ctx context.Context, ip string) time.Duration {
+	if s.limiter == nil {
+		return 0
+	}
+	lockedFor, err := s.limiter.Store.LockedFor(ctx, apiKeyFailureKey(ip))
+	if err != nil {
+		log.Printf("Error checking API key lockout: %v", err)
+		return 0
+	}
+	return lockedFor
+}
+
+// recordAPIKeyFailure counts an invalid API key against the client IP.
+func (s *AuthService) recordAPIKeyFailure(ctx context.Context, ip string) {
+	s.recordFailures(ctx, apiKeyFailureKey(ip))
+}
+
+// allowAPIKeyRequest enforces an API key's quota. It writes a 429 response and
+// returns false if the request must be rejected.
+func (s *AuthService) allowAPIKeyRequest(w http.ResponseWriter, r *http.Request, apiKey *models.APIKey) bool {
+	if s.limiter == nil {
+		return true
+	}
+	limit := s.limiter.Config.APIKey
+	if apiKey.RateLimitPerMinute != nil {
+		limit = ratelimit.PerMinute(*apiKey.RateLimitPerMinute)
+	}
+	res, err := s.limiter.Store.Take(r.Context(), apiKeyQuotaKey(apiKey.ID.String()), limit)
+	if err != nil {
+		log.Printf("Error taking API key rate limit token: %v", err)
+		return true
+	}
+	ratelimit.SetHeaders(w, limit, res)
+	if !res.Allowed {
+		ratelimit.Reject(w, res.RetryAfter, "API key quota exceeded")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,518 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	// mfaChallengeTTL is how long a user has to enter their code after their password.
+	mfaChallengeTTL = 5 * time.Minute
+	// mfaChallengeAudience marks challenge tokens so they are never accepted as access tokens.
+	mfaChallengeAudience = "mnemo-2fa-challenge"
+	// mfaChallengeMaxAttempts is how many wrong codes a challenge accepts
+	// before it is discarded and the user must enter their password again.
+	mfaChallengeMaxAttempts = 5
+	// recoveryCodeCount is the number of recovery codes issued at a time.
+	recoveryCodeCount = 10
+)
+
+var (
+	// ErrTwoFactorEnabled is returned when enrolling a user who already has 2FA enabled.
+	ErrTwoFactorEnabled = errors.New("two-factor authentication is already enabled")
+	// ErrTwoFactorNotEnabled is returned when 2FA must be enrolled or enabled first.
+	ErrTwoFactorNotEnabled = errors.New("two-factor authentication is not enabled")
+	// ErrInvalidTwoFactorCode is returned for wrong, reused or expired codes and challenges.
+	ErrInvalidTwoFactorCode = errors.New("invalid two-factor code")
+)
+
+type TwoFactorChallengeResponse struct {
+	MFARequired    bool   `json:"mfa_required"`
+	ChallengeToken string `json:"challenge_token"`
+	ExpiresIn      int    `json:"expires_in"` // Lifetime of the challenge token in seconds
+}
+
+type TwoFactorLoginRequest struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"` // A TOTP code or a recovery code
+}
+
+type TwoFactorEnrollResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"` // Render as a QR code for authenticator apps
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type DisableTwoFactorRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// normalizeRecoveryCode makes recovery codes comparable regardless of case and separators.
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+// replaceRecoveryCodes discards a user's recovery codes and stores a new set.
+// The codes are only returned here; the database keeps their hashes.
+func replaceRecoveryCodes(ctx context.Context, tx pgx.Tx, userID uuid.UUID) ([]string, error) {
+	if _, err := tx.Exec(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return nil, fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	enc := base32.StdEncoding.WithPadding(base32.NoPadding)
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+		raw := strings.ToLower(enc.EncodeToString(b)) // 16 characters, shown in groups of four
+		codes[i] = strings.Join([]string{raw[0:4], raw[4:8], raw[8:12], raw[12:16]}, "-")
+		hashes[i] = hashToken(raw)
+	}
+
+	query := `INSERT INTO recovery_codes (user_id, code_hash) SELECT $1, unnest($2::text[])`
+	if _, err := tx.Exec(ctx, query, userID, hashes); err != nil {
+		return nil, fmt.Errorf("failed to insert recovery codes: %w", err)
+	}
+	return codes, nil
+}
+
+// verifySecondFactor checks a TOTP code or consumes a recovery code for a user
+// with 2FA enabled. It returns ErrInvalidTwoFactorCode if neither matches.
+func verifySecondFactor(ctx context.Context, tx pgx.Tx, userID uuid.UUID, code string) error {
+	var secret string
+	var lastStep *int64
+	query := `SELECT totp_secret, totp_last_used_step FROM users WHERE id = $1 AND totp_enabled_at IS NOT NULL FOR UPDATE`
+	if err := tx.QueryRow(ctx, query, userID).Scan(&secret, &lastStep); err != nil {
+		if err == pgx.ErrNoRows {
+			return ErrTwoFactorNotEnabled
+		}
+		return fmt.Errorf("failed to get TOTP secret: %w", err)
+	}
+
+	last := int64(-1)
+	if lastStep != nil {
+		last = *lastStep
+	}
+	if step, ok := validateTOTP(secret, code, time.Now(), last); ok {
+		if _, err := tx.Exec(ctx, `UPDATE users SET totp_last_used_step = $1 WHERE id = $2`, step, userID); err != nil {
+			return fmt.Errorf("failed to record TOTP use: %w", err)
+		}
+		return nil
+	}
+
+	recoveryQuery := `
+		UPDATE recovery_codes SET used_at = CURRENT_TIMESTAMP
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL
+	`
+	result, err := tx.Exec(ctx, recoveryQuery, userID, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return fmt.Errorf("failed to use recovery code: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrInvalidTwoFactorCode
+	}
+	return nil
+}
+
+// EnrollTOTP generates a new TOTP secret for a user. 2FA is not enforced until
+// the user proves their authenticator works with ConfirmTOTP.
+func (s *AuthService) EnrollTOTP(ctx context.Context, userID uuid.UUID) (*TwoFactorEnrollResponse, error) {
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		UPDATE users SET totp_secret = $1, totp_last_used_step = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2 AND totp_enabled_at IS NULL
+		RETURNING email
+	`
+	var email string
+	if err := s.db.QueryRow(ctx, query, secret, userID).Scan(&email); err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrTwoFactorEnabled
+		}
+		return nil, fmt.Errorf("failed to store TOTP secret: %w", err)
+	}
+
+	return &TwoFactorEnrollResponse{Secret: secret, OTPAuthURI: totpURI(email, secret)}, nil
+}
+
+// ConfirmTOTP enables 2FA once the user enters a valid code for their enrolled
+// secret, and returns their recovery codes.
+func (s *AuthService) ConfirmTOTP(ctx context.Context, userID uuid.UUID, code string) ([]string, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx) // Rollback if not committed
+
+	var secret *string
+	var enabledAt *time.Time
+	query := `SELECT totp_secret, totp_enabled_at FROM users WHERE id = $1 FOR UPDATE`
+	if err := tx.QueryRow(ctx, query, userID).Scan(&secret, &enabledAt); err != nil {
+		return nil, fmt.Errorf("failed to get TOTP secret: %w", err)
+	}
+	if enabledAt != nil {
+		return nil, ErrTwoFactorEnabled
+	}
+	if secret == nil {
+		return nil, ErrTwoFactorNotEnabled
+	}
+
+	step, ok := validateTOTP(*secret, code, time.Now(), -1)
+	if !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+	enableQuery := `
+		UPDATE users SET totp_enabled_at = CURRENT_TIMESTAMP, totp_last_used_step = $1, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $2
+	`
+	if _, err := tx.Exec(ctx, enableQuery, step, userID); err != nil {
+		return nil, fmt.Errorf("failed to enable TOTP: %w", err)
+	}
+	codes, err := replaceRecoveryCodes(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return codes, nil
+}
+
+// RegenerateRecoveryCodes replaces a user's recovery codes after verifying a second factor.
+func (s *AuthService) RegenerateRecoveryCodes(ctx context.Context, userID uuid.UUID, code string) ([]string, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx) // Rollback if not committed
+
+	if err := verifySecondFactor(ctx, tx, userID, code); err != nil {
+		return nil, err
+	}
+	codes, err := replaceRecoveryCodes(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return codes, nil
+}
+
+// DisableTOTP turns 2FA off after verifying both the password and a second factor.
+func (s *AuthService) DisableTOTP(ctx context.Context, userID uuid.UUID, password string, code string) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx) // Rollback if not committed
+
+	if err := checkPassword(ctx, tx, userID, password); err != nil {
+		return err
+	}
+	if err := verifySecondFactor(ctx, tx, userID, code); err != nil {
+		return err
+	}
+	disableQuery := `
+		UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_used_step = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`
+	if _, err := tx.Exec(ctx, disableQuery, userID); err != nil {
+		return fmt.Errorf("failed to disable TOTP: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// issueChallengeToken signs a token proving that userID passed the password step of login.
+// The challenge is recorded so that it can only be used once, for a limited
+// number of codes.
+func (s *AuthService) issueChallengeToken(ctx context.Context, userID uuid.UUID) (string, error) {
+	if _, err := s.db.Exec(ctx, `DELETE FROM mfa_challenges WHERE expires_at < CURRENT_TIMESTAMP`); err != nil {
+		return "", fmt.Errorf("failed to delete expired challenges: %w", err)
+	}
+
+	now := time.Now()
+	challengeID := uuid.New()
+	query := `INSERT INTO mfa_challenges (id, user_id, expires_at) VALUES ($1, $2, $3)`
+	if _, err := s.db.Exec(ctx, query, challengeID, userID, now.Add(mfaChallengeTTL)); err != nil {
+		return "", fmt.Errorf("failed to store challenge: %w", err)
+	}
+
+	claims := jwt.RegisteredClaims{
+		ID:        challengeID.String(),
+		Subject:   userID.String(),
+		Audience:  jwt.ClaimStrings{mfaChallengeAudience},
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(mfaChallengeTTL)),
+	}
+	token, err := s.tokens.Keys.sign(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign challenge token: %w", err)
+	}
+	return token, nil
+}
+
+// parseChallengeToken returns the challenge and user of a challenge token.
+// It returns ErrInvalidTwoFactorCode if the token is invalid or expired.
+func (s *AuthService) parseChallengeToken(challengeToken string) (uuid.UUID, uuid.UUID, error) {
+	claims := &jwt.RegisteredClaims{}
+	token, err := jwt.ParseWithClaims(challengeToken, claims, s.tokens.Keys.keyFunc,
+		jwt.WithAudience(mfaChallengeAudience), jwt.WithExpirationRequired())
+	if err != nil || !token.Valid {
+		return uuid.Nil, uuid.Nil, ErrInvalidTwoFactorCode
+	}
+	challengeID, err := uuid.Parse(claims.ID)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, ErrInvalidTwoFactorCode
+	}
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return uuid.Nil, uuid.Nil, ErrInvalidTwoFactorCode
+	}
+	return challengeID, userID, nil
+}
+
+// CompleteTwoFactorLogin exchanges a challenge token and a TOTP or recovery
+// code for a new session. A challenge is used up by a valid code or by
+// mfaChallengeMaxAttempts wrong ones.
+func (s *AuthService) CompleteTwoFactorLogin(ctx context.Context, challengeToken string, code string, userAgent string, ip string) (*TokenPair, error) {
+	challengeID, userID, err := s.parseChallengeToken(challengeToken)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx) // Rollback if not committed
+
+	var failedAttempts int
+	challengeQuery := `
+		SELECT failed_attempts FROM mfa_challenges
+		WHERE id = $1 AND user_id = $2 AND expires_at > CURRENT_TIMESTAMP
+		FOR UPDATE
+	`
+	if err := tx.QueryRow(ctx, challengeQuery, challengeID, userID).Scan(&failedAttempts); err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrInvalidTwoFactorCode // Already used or discarded
+		}
+		return nil, fmt.Errorf("failed to get challenge: %w", err)
+	}
+
+	err = verifySecondFactor(ctx, tx, userID, code)
+	if errors.Is(err, ErrInvalidTwoFactorCode) {
+		if err := recordChallengeFailure(ctx, tx, challengeID, failedAttempts+1); err != nil {
+			return nil, err
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return nil, fmt.Errorf("failed to commit transaction: %w", err)
+		}
+		return nil, ErrInvalidTwoFactorCode
+	}
+	if err != nil {
+		if errors.Is(err, ErrTwoFactorNotEnabled) {
+			return nil, ErrInvalidTwoFactorCode // 2FA was disabled after the challenge was issued
+		}
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM mfa_challenges WHERE id = $1`, challengeID); err != nil {
+		return nil, fmt.Errorf("failed to use challenge: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return s.CreateSession(ctx, userID, userAgent, ip)
+}
+
+// recordChallengeFailure counts a wrong code against a challenge, discarding
+// it once it reaches mfaChallengeMaxAttempts.
+func recordChallengeFailure(ctx context.Context, tx pgx.Tx, challengeID uuid.UUID, failedAttempts int) error {
+	if failedAttempts >= mfaChallengeMaxAttempts {
+		if _, err := tx.Exec(ctx, `DELETE FROM mfa_challenges WHERE id = $1`, challengeID); err != nil {
+			return fmt.Errorf("failed to discard challenge: %w", err)
+		}
+		return nil
+	}
+	if _, err := tx.Exec(ctx, `UPDATE mfa_challenges SET failed_attempts = $1 WHERE id = $2`, failedAttempts, challengeID); err != nil {
+		return fmt.Errorf("failed to record wrong code: %w", err)
+	}
+	return nil
+}
+
+func (s *AuthService) handleTwoFactorLogin(w http.ResponseWriter, r *http.Request) {
+	var req TwoFactorLoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ChallengeToken == "" || req.Code == "" {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	_, userID, err := s.parseChallengeToken(req.ChallengeToken)
+	if !s.allowTwoFactorAttempt(w, r, userID) {
+		return
+	}
+	if err != nil {
+		s.recordFailures(r.Context(), loginIPKey(ClientIP(r)))
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	tokens, err := s.CompleteTwoFactorLogin(r.Context(), req.ChallengeToken, req.Code, r.UserAgent(), ClientIP(r))
+	if err != nil {
+		if errors.Is(err, ErrInvalidTwoFactorCode) {
+			// Wrong codes count against the user too, wherever they come from
+			s.recordFailures(r.Context(), loginIPKey(ClientIP(r)), loginTwoFactorKey(userID))
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, "Failed to log in", http.StatusInternalServerError)
+		log.Printf("Error completing two-factor login: %v", err)
+		return
+	}
+	s.resetTwoFactorFailures(r.Context(), userID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newLoginResponse(tokens))
+}
+
+// sessionUserID returns the authenticated user for 2FA management, which only
+// user sessions may perform. It writes an error response and returns false otherwise.
+func sessionUserID(w http.ResponseWriter, r *http.Request) (uuid.UUID, bool) {
+	userID, _, ok, err := sessionFromContext(r.Context())
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return uuid.Nil, false
+	}
+	if !ok {
+		http.Error(w, "Request is not authenticated with a session", http.StatusForbidden)
+		return uuid.Nil, false
+	}
+	return userID, true
+}
+
+func (s *AuthService) handleEnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	userID, ok := sessionUserID(w, r)
+	if !ok {
+		return
+	}
+
+	resp, err := s.EnrollTOTP(r.Context(), userID)
+	if err != nil {
+		if writeAccountError(w, err) {
+			return
+		}
+		http.Error(w, "Failed to enroll two-factor authentication", http.StatusInternalServerError)
+		log.Printf("Error enrolling TOTP: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *AuthService) handleConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	userID, ok := sessionUserID(w, r)
+	if !ok {
+		return
+	}
+	var req TwoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	codes, err := s.ConfirmTOTP(r.Context(), userID, req.Code)
+	if err != nil {
+		if writeAccountError(w, err) {
+			return
+		}
+		http.Error(w, "Failed to enable two-factor authentication", http.StatusInternalServerError)
+		log.Printf("Error confirming TOTP: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+func (s *AuthService) handleRegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	userID, ok := sessionUserID(w, r)
+	if !ok {
+		return
+	}
+	var req TwoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	codes, err := s.RegenerateRecoveryCodes(r.Context(), userID, req.Code)
+	if err != nil {
+		if writeAccountError(w, err) {
+			return
+		}
+		http.Error(w, "Failed to regenerate recovery codes", http.StatusInternalServerError)
+		log.Printf("Error regenerating recovery codes: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+func (s *AuthService) handleDisableTOTP(w http.ResponseWriter, r *http.Request) {
+	userID, ok := sessionUserID(w, r)
+	if !ok {
+		return
+	}
+	var req DisableTwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.DisableTOTP(r.Context(), userID, req.Password, req.Code); err != nil {
+		if writeAccountError(w, err) {
+			return
+		}
+		http.Error(w, "Failed to disable two-factor authentication", http.StatusInternalServerError)
+		log.Printf("Error disabling TOTP: %v", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
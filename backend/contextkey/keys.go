@@ -10,4 +10,15 @@ const (
 	HomeIDKey ContextKey = "homeID"
 	// UserRoleKey is the key for the user role in the context.
 	UserRoleKey ContextKey = "userRole"
+	// APIKeyIDKey is the key for the ID of the API key that authenticated the request, if any.
+	APIKeyIDKey ContextKey = "apiKeyID"
+	// ScopesKey is the key for the scopes granted to the request's API key.
+	// It is absent for requests authenticated as a user session.
+	ScopesKey ContextKey = "scopes"
+	// SessionIDKey is the key for the ID of the session an access token belongs to, if any.
+	SessionIDKey ContextKey = "sessionID"
+	// APIKeyHomeIDsKey is the key for the homes an API key is restricted to, if any.
+	APIKeyHomeIDsKey ContextKey = "apiKeyHomeIDs"
+	// ClientIPKey is the key for the IP address of the client making the request.
+	ClientIPKey ContextKey = "clientIP"
 )
@@ -0,0 +1,239 @@
+// Package decimal implements the exact decimal numbers used for quantities.
+// A Decimal is a fixed-point number with six fractional digits, enough for a
+// milligram in kilograms, and up to twelve integer digits, matching the
+// NUMERIC(18, 6) columns it is stored in.
+package decimal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	// Places is the number of fractional digits of a Decimal.
+	Places = 6
+	// scale is the value of one in the internal representation.
+	scale = 1_000_000
+	// maxMicros bounds the internal representation to twelve integer digits.
+	maxMicros = 1_000_000_000_000*scale - 1
+)
+
+// ErrOutOfRange is returned for numbers with more than twelve integer digits.
+var ErrOutOfRange = errors.New("number is out of range")
+
+// Decimal is an exact decimal number. The zero value is zero.
+type Decimal struct {
+	micros int64 // The value times 10^Places
+}
+
+// Zero is the Decimal zero.
+var Zero Decimal
+
+// FromInt returns n as a Decimal.
+func FromInt(n int64) Decimal {
+	return Decimal{micros: n * scale}
+}
+
+// Parse parses a decimal number such as "12", "-0.5" or "1.250". Exponents
+// are not accepted, and at most Places fractional digits.
+func Parse(s string) (Decimal, error) {
+	orig := s
+	neg := false
+	if s != "" && (s[0] == '-' || s[0] == '+') {
+		neg = s[0] == '-'
+		s = s[1:]
+	}
+	intPart, fracPart, _ := strings.Cut(s, ".")
+	if (intPart == "" && fracPart == "") || !isDigits(intPart) || !isDigits(fracPart) {
+		return Zero, fmt.Errorf("invalid decimal %q", orig)
+	}
+	fracPart = strings.TrimRight(fracPart, "0")
+	if len(fracPart) > Places {
+		return Zero, fmt.Errorf("decimal %q has more than %d fractional digits", orig, Places)
+	}
+	intPart = strings.TrimLeft(intPart, "0")
+	if len(intPart) > 12 {
+		return Zero, fmt.Errorf("decimal %q: %w", orig, ErrOutOfRange)
+	}
+
+	var micros int64
+	if intPart != "" {
+		n, err := strconv.ParseInt(intPart, 10, 64)
+		if err != nil {
+			return Zero, fmt.Errorf("invalid decimal %q", orig)
+		}
+		micros = n * scale
+	}
+	if fracPart != "" {
+		n, err := strconv.ParseInt(fracPart+strings.Repeat("0", Places-len(fracPart)), 10, 64)
+		if err != nil {
+			return Zero, fmt.Errorf("invalid decimal %q", orig)
+		}
+		micros += n
+	}
+	if neg {
+		micros = -micros
+	}
+	return Decimal{micros: micros}, nil
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// FromRat returns r rounded half away from zero to Places fractional digits.
+func FromRat(r *big.Rat) (Decimal, error) {
+	scaled := new(big.Rat).Mul(r, big.NewRat(scale, 1))
+	quo, rem := new(big.Int).QuoRem(scaled.Num(), scaled.Denom(), new(big.Int))
+	// Round away from zero if the remainder is at least half the denominator
+	if new(big.Int).Mul(new(big.Int).Abs(rem), big.NewInt(2)).Cmp(scaled.Denom()) >= 0 {
+		quo.Add(quo, big.NewInt(int64(scaled.Num().Sign())))
+	}
+	if !quo.IsInt64() {
+		return Zero, ErrOutOfRange
+	}
+	return fromMicros(quo.Int64())
+}
+
+// Rat returns d as a big.Rat.
+func (d Decimal) Rat() *big.Rat {
+	return big.NewRat(d.micros, scale)
+}
+
+// Add returns d + e. It returns ErrOutOfRange if the sum has more than
+// twelve integer digits.
+func (d Decimal) Add(e Decimal) (Decimal, error) {
+	return fromMicros(d.micros + e.micros)
+}
+
+// Sub returns d - e. It returns ErrOutOfRange if the difference has more than
+// twelve integer digits.
+func (d Decimal) Sub(e Decimal) (Decimal, error) {
+	return fromMicros(d.micros - e.micros)
+}
+
+// fromMicros returns the Decimal of an internal representation, or
+// ErrOutOfRange if it has more than twelve integer digits.
+func fromMicros(micros int64) (Decimal, error) {
+	if micros > maxMicros || micros < -maxMicros {
+		return Zero, ErrOutOfRange
+	}
+	return Decimal{micros: micros}, nil
+}
+
+// Neg returns -d.
+func (d Decimal) Neg() Decimal {
+	return Decimal{micros: -d.micros}
+}
+
+// Min returns the smaller of d and e.
+func (d Decimal) Min(e Decimal) Decimal {
+	if e.micros < d.micros {
+		return e
+	}
+	return d
+}
+
+// Cmp returns -1, 0 or +1 as d is less than, equal to or greater than e.
+func (d Decimal) Cmp(e Decimal) int {
+	switch {
+	case d.micros < e.micros:
+		return -1
+	case d.micros > e.micros:
+		return 1
+	}
+	return 0
+}
+
+// Sign returns -1, 0 or +1 as d is negative, zero or positive.
+func (d Decimal) Sign() int {
+	return d.Cmp(Zero)
+}
+
+// IsZero reports whether d is zero.
+func (d Decimal) IsZero() bool {
+	return d.micros == 0
+}
+
+// String formats d without trailing fractional zeros, e.g. "1.5" or "3".
+func (d Decimal) String() string {
+	micros := d.micros
+	sign := ""
+	if micros < 0 {
+		sign, micros = "-", -micros
+	}
+	s := sign + strconv.FormatInt(micros/scale, 10)
+	if frac := micros % scale; frac != 0 {
+		s += "." + strings.TrimRight(fmt.Sprintf("%0*d", Places, frac), "0")
+	}
+	return s
+}
+
+// MarshalJSON implements json.Marshaler. A Decimal is encoded as a JSON
+// number with its exact digits.
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler. Both JSON numbers and strings
+// holding a number are accepted; the digits are parsed exactly.
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if strings.HasPrefix(s, `"`) {
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+	}
+	v, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	*d = v
+	return nil
+}
+
+// ScanNumeric implements pgtype.NumericScanner.
+func (d *Decimal) ScanNumeric(v pgtype.Numeric) error {
+	if !v.Valid {
+		return fmt.Errorf("cannot scan NULL into decimal.Decimal")
+	}
+	if v.NaN || v.InfinityModifier != pgtype.Finite {
+		return fmt.Errorf("cannot scan %v into decimal.Decimal", v.InfinityModifier)
+	}
+	r := new(big.Rat).SetInt(v.Int)
+	exp := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs(v.Exp))), nil))
+	if v.Exp >= 0 {
+		r.Mul(r, exp)
+	} else {
+		r.Quo(r, exp)
+	}
+	scanned, err := FromRat(r)
+	if err != nil {
+		return err
+	}
+	*d = scanned
+	return nil
+}
+
+func abs(n int32) int32 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// NumericValue implements pgtype.NumericValuer.
+func (d Decimal) NumericValue() (pgtype.Numeric, error) {
+	return pgtype.Numeric{Int: big.NewInt(d.micros), Exp: -Places, Valid: true}, nil
+}
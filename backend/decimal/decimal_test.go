@@ -0,0 +1,161 @@
+package decimal
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+func mustParse(t *testing.T, s string) Decimal {
+	t.Helper()
+	d, err := Parse(s)
+	if err != nil {
+		t.Fatalf("Parse(%q): %v", s, err)
+	}
+	return d
+}
+
+func TestParseStringRoundTrip(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{in: "12", want: "12"},
+		{in: "-0.5", want: "-0.5"},
+		{in: "+3", want: "3"},
+		{in: "1.250", want: "1.25"},
+		{in: "007", want: "7"},
+		{in: ".5", want: "0.5"},
+		{in: "5.", want: "5"},
+		{in: "-0", want: "0"},
+		{in: "0.000001", want: "0.000001"},
+		{in: "1.0000000", want: "1"},
+		{in: "999999999999.999999", want: "999999999999.999999"},
+		{in: "-999999999999.999999", want: "-999999999999.999999"},
+	}
+	for _, tt := range tests {
+		d := mustParse(t, tt.in)
+		if got := d.String(); got != tt.want {
+			t.Errorf("Parse(%q).String() = %q, want %q", tt.in, got, tt.want)
+		}
+		if again := mustParse(t, d.String()); again != d {
+			t.Errorf("Parse(%q) = %v after a round trip, want %v", d.String(), again, d)
+		}
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	tests := []struct {
+		in         string
+		outOfRange bool
+	}{
+		{in: ""},
+		{in: "-"},
+		{in: "."},
+		{in: "1e3"},
+		{in: "1E-2"},
+		{in: "0x10"},
+		{in: "1.2.3"},
+		{in: " 1"},
+		{in: "1,5"},
+		{in: "0.0000001"},
+		{in: "1.2345678"},
+		{in: "1000000000000", outOfRange: true},
+		{in: "-1000000000000.5", outOfRange: true},
+	}
+	for _, tt := range tests {
+		d, err := Parse(tt.in)
+		if err == nil {
+			t.Errorf("Parse(%q) = %v, want an error", tt.in, d)
+			continue
+		}
+		if errors.Is(err, ErrOutOfRange) != tt.outOfRange {
+			t.Errorf("Parse(%q) error = %v, want ErrOutOfRange: %t", tt.in, err, tt.outOfRange)
+		}
+	}
+}
+
+func TestFromRatRoundsHalfAwayFromZero(t *testing.T) {
+	tests := []struct {
+		r    *big.Rat
+		want string
+	}{
+		{r: big.NewRat(1, 2_000_000), want: "0.000001"},
+		{r: big.NewRat(-1, 2_000_000), want: "-0.000001"},
+		{r: big.NewRat(49, 100_000_000), want: "0"},
+		{r: big.NewRat(-49, 100_000_000), want: "0"},
+		{r: big.NewRat(2, 3), want: "0.666667"},
+		{r: big.NewRat(-2, 3), want: "-0.666667"},
+		{r: big.NewRat(1, 3), want: "0.333333"},
+		{r: big.NewRat(5, 2), want: "2.5"},
+	}
+	for _, tt := range tests {
+		d, err := FromRat(tt.r)
+		if err != nil {
+			t.Fatalf("FromRat(%v): %v", tt.r, err)
+		}
+		if got := d.String(); got != tt.want {
+			t.Errorf("FromRat(%v) = %s, want %s", tt.r, got, tt.want)
+		}
+	}
+
+	if _, err := FromRat(big.NewRat(1_000_000_000_000, 1)); !errors.Is(err, ErrOutOfRange) {
+		t.Errorf("FromRat(10^12) error = %v, want ErrOutOfRange", err)
+	}
+}
+
+func TestScanNumeric(t *testing.T) {
+	tests := []struct {
+		v    pgtype.Numeric
+		want string
+	}{
+		{v: pgtype.Numeric{Int: big.NewInt(15), Exp: -1, Valid: true}, want: "1.5"},
+		{v: pgtype.Numeric{Int: big.NewInt(-1234567), Exp: -7, Valid: true}, want: "-0.123457"},
+		{v: pgtype.Numeric{Int: big.NewInt(12), Exp: 3, Valid: true}, want: "12000"},
+		{v: pgtype.Numeric{Int: big.NewInt(-7), Exp: 0, Valid: true}, want: "-7"},
+	}
+	for _, tt := range tests {
+		var d Decimal
+		if err := d.ScanNumeric(tt.v); err != nil {
+			t.Fatalf("ScanNumeric(%v): %v", tt.v, err)
+		}
+		if got := d.String(); got != tt.want {
+			t.Errorf("ScanNumeric(%v) = %s, want %s", tt.v, got, tt.want)
+		}
+	}
+
+	invalid := []pgtype.Numeric{
+		{Int: big.NewInt(1), Exp: 12, Valid: true},
+		{NaN: true, Valid: true},
+		{InfinityModifier: pgtype.Infinity, Valid: true},
+		{},
+	}
+	for _, v := range invalid {
+		var d Decimal
+		if err := d.ScanNumeric(v); err == nil {
+			t.Errorf("ScanNumeric(%v) = %v, want an error", v, d)
+		}
+	}
+}
+
+func TestAddSubRange(t *testing.T) {
+	largest := mustParse(t, "999999999999.999999")
+	step := mustParse(t, "0.000001")
+
+	if sum, err := mustParse(t, "1.5").Add(mustParse(t, "2.25")); err != nil || sum.String() != "3.75" {
+		t.Errorf("1.5 + 2.25 = %v, %v; want 3.75", sum, err)
+	}
+	if diff, err := mustParse(t, "1.5").Sub(mustParse(t, "2.25")); err != nil || diff.String() != "-0.75" {
+		t.Errorf("1.5 - 2.25 = %v, %v; want -0.75", diff, err)
+	}
+	if _, err := largest.Add(step); !errors.Is(err, ErrOutOfRange) {
+		t.Errorf("max + 0.000001 error = %v, want ErrOutOfRange", err)
+	}
+	if _, err := largest.Neg().Sub(step); !errors.Is(err, ErrOutOfRange) {
+		t.Errorf("-max - 0.000001 error = %v, want ErrOutOfRange", err)
+	}
+	if _, err := largest.Sub(largest.Neg()); !errors.Is(err, ErrOutOfRange) {
+		t.Errorf("max - -max error = %v, want ErrOutOfRange", err)
+	}
+}
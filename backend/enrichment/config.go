@@ -0,0 +1,127 @@
+package enrichment
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Config selects and configures the enrichment providers.
+type Config struct {
+	// Providers lists provider names in lookup order; empty disables enrichment.
+	Providers         []string
+	OpenLibraryURL    string
+	GoogleBooksURL    string
+	GoogleBooksAPIKey string
+	OpenFoodFactsURL  string
+	OFFDumpFile       string // Local Open Food Facts dump for the offdump provider
+	// CacheTTL is how long found products are cached; NegativeCacheTTL is how
+	// long a provider's miss is remembered.
+	CacheTTL         time.Duration
+	NegativeCacheTTL time.Duration
+	Timeout          time.Duration // Per request to an online provider
+}
+
+// DefaultConfig returns the configuration used when nothing is configured.
+func DefaultConfig() Config {
+	return Config{
+		Providers:        []string{"openlibrary", "googlebooks", "openfoodfacts"},
+		OpenLibraryURL:   DefaultOpenLibraryURL,
+		GoogleBooksURL:   DefaultGoogleBooksURL,
+		OpenFoodFactsURL: DefaultOpenFoodFactsURL,
+		CacheTTL:         30 * 24 * time.Hour,
+		NegativeCacheTTL: 24 * time.Hour,
+		Timeout:          10 * time.Second,
+	}
+}
+
+// LoadConfigFromEnv starts from DefaultConfig and applies overrides:
+//
+//   - ENRICHMENT_PROVIDERS: comma-separated provider names in lookup order,
+//     from openlibrary, googlebooks, openfoodfacts and offdump, or "none".
+//   - OPENLIBRARY_BASE_URL, GOOGLE_BOOKS_BASE_URL, OPENFOODFACTS_BASE_URL:
+//     alternative API locations, e.g. local stand-ins in tests.
+//   - GOOGLE_BOOKS_API_KEY: optional Google Books API key.
+//   - OFF_DUMP_FILE: path to an Open Food Facts JSONL dump. Unless
+//     ENRICHMENT_PROVIDERS is set, offdump is then consulted first.
+//   - ENRICHMENT_CACHE_TTL, ENRICHMENT_NEGATIVE_CACHE_TTL, ENRICHMENT_TIMEOUT:
+//     durations such as "720h".
+func LoadConfigFromEnv() (Config, error) {
+	cfg := DefaultConfig()
+	for key, target := range map[string]*string{
+		"OPENLIBRARY_BASE_URL":   &cfg.OpenLibraryURL,
+		"GOOGLE_BOOKS_BASE_URL":  &cfg.GoogleBooksURL,
+		"GOOGLE_BOOKS_API_KEY":   &cfg.GoogleBooksAPIKey,
+		"OPENFOODFACTS_BASE_URL": &cfg.OpenFoodFactsURL,
+		"OFF_DUMP_FILE":          &cfg.OFFDumpFile,
+	} {
+		if v := os.Getenv(key); v != "" {
+			*target = v
+		}
+	}
+	for key, target := range map[string]*time.Duration{
+		"ENRICHMENT_CACHE_TTL":          &cfg.CacheTTL,
+		"ENRICHMENT_NEGATIVE_CACHE_TTL": &cfg.NegativeCacheTTL,
+		"ENRICHMENT_TIMEOUT":            &cfg.Timeout,
+	} {
+		v := os.Getenv(key)
+		if v == "" {
+			continue
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid %s: %w", key, err)
+		}
+		*target = d
+	}
+
+	switch v := os.Getenv("ENRICHMENT_PROVIDERS"); v {
+	case "":
+		if cfg.OFFDumpFile != "" {
+			cfg.Providers = append([]string{"offdump"}, cfg.Providers...)
+		}
+	case "none":
+		cfg.Providers = nil
+	default:
+		cfg.Providers = nil
+		for _, name := range strings.Split(v, ",") {
+			cfg.Providers = append(cfg.Providers, strings.TrimSpace(name))
+		}
+	}
+	return cfg, nil
+}
+
+// NewRegistryFromConfig creates the configured providers in lookup order.
+func NewRegistryFromConfig(cfg Config) (*Registry, error) {
+	client := &http.Client{Timeout: cfg.Timeout}
+	registry := &Registry{}
+	for _, name := range cfg.Providers {
+		var p Provider
+		switch name {
+		case "openlibrary":
+			p = NewOpenLibraryProvider(cfg.OpenLibraryURL, client)
+		case "googlebooks":
+			p = NewGoogleBooksProvider(cfg.GoogleBooksURL, cfg.GoogleBooksAPIKey, client)
+		case "openfoodfacts":
+			p = NewOpenFoodFactsProvider(cfg.OpenFoodFactsURL, client)
+		case "offdump":
+			if cfg.OFFDumpFile == "" {
+				return nil, errors.New("OFF_DUMP_FILE must be set for the offdump provider")
+			}
+			dump, err := NewOFFDumpProvider(cfg.OFFDumpFile)
+			if err != nil {
+				return nil, err
+			}
+			p = dump
+		default:
+			return nil, fmt.Errorf("unknown enrichment provider %q", name)
+		}
+		if err := registry.Register(p); err != nil {
+			return nil, err
+		}
+	}
+	return registry, nil
+}
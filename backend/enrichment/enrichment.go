@@ -0,0 +1,144 @@
+// Package enrichment looks up product data such as names, brands and cover
+// images for barcodes and ISBNs. Lookups go through an ordered registry of
+// providers and their responses are cached in Postgres.
+package enrichment
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/m-cain/mnemo/backend/apperrors"
+)
+
+// Kinds of codes a product can be looked up by.
+const (
+	KindBarcode = "barcode" // GTIN: EAN-8, UPC-A, EAN-13 or GTIN-14
+	KindISBN    = "isbn"    // Always normalized to ISBN-13
+)
+
+// Product is the data a provider knows about a code. Fields a provider does
+// not know are left empty.
+type Product struct {
+	Name              string `json:"name"`
+	Description       string `json:"description,omitempty"`
+	Brand             string `json:"brand,omitempty"` // Manufacturer, or publisher for books
+	ImageURL          string `json:"image_url,omitempty"`
+	SuggestedItemType string `json:"suggested_item_type,omitempty"` // Name of an item type, e.g. "Books"
+	Provider          string `json:"provider"`
+}
+
+// Query is a normalized code to look up; see ParseQuery.
+type Query struct {
+	Code string `json:"code"`
+	Kind string `json:"kind"`
+}
+
+// Provider looks up products in one source of product data.
+type Provider interface {
+	// Name identifies the provider in configuration and in the cache.
+	Name() string
+	// Supports reports whether the provider can look up codes of a kind.
+	Supports(kind string) bool
+	// Lookup returns the product for a code, or apperrors.ErrNotFound if the
+	// provider has no match. Other errors are treated as temporary.
+	Lookup(ctx context.Context, q Query) (*Product, error)
+}
+
+// ParseQuery validates and normalizes a code. If kind is empty it is
+// detected: valid ISBN-10s and 978/979 EAN-13s are ISBNs, other codes are
+// barcodes. ISBN-10s are converted to ISBN-13 and UPC-A codes to EAN-13, so
+// equivalent codes share cache entries.
+func ParseQuery(code, kind string) (Query, error) {
+	code = strings.ToUpper(strings.NewReplacer(" ", "", "-", "").Replace(code))
+	if kind == "" {
+		kind = KindBarcode
+		if isISBN10(code) || isISBN13(code) {
+			kind = KindISBN
+		}
+	}
+
+	switch kind {
+	case KindISBN:
+		if isISBN10(code) {
+			code = isbn10To13(code)
+		}
+		if !isISBN13(code) {
+			return Query{}, fmt.Errorf("%w: %q is not a valid ISBN", apperrors.ErrInvalidInput, code)
+		}
+	case KindBarcode:
+		switch len(code) {
+		case 8, 12, 13, 14:
+		default:
+			return Query{}, fmt.Errorf("%w: barcode must have 8, 12, 13 or 14 digits", apperrors.ErrInvalidInput)
+		}
+		if !isDigits(code) || !validCheckDigit(code) {
+			return Query{}, fmt.Errorf("%w: %q is not a valid barcode", apperrors.ErrInvalidInput, code)
+		}
+		if len(code) == 12 {
+			code = "0" + code // UPC-A is EAN-13 with a leading zero
+		}
+	default:
+		return Query{}, fmt.Errorf("%w: unknown code kind %q", apperrors.ErrInvalidInput, kind)
+	}
+	return Query{Code: code, Kind: kind}, nil
+}
+
+// isISBN10 reports whether s is an ISBN-10 with a valid check character.
+func isISBN10(s string) bool {
+	if len(s) != 10 || !isDigits(s[:9]) {
+		return false
+	}
+	sum := 0
+	for i := 0; i < 9; i++ {
+		sum += (10 - i) * int(s[i]-'0')
+	}
+	switch c := s[9]; {
+	case c == 'X':
+		sum += 10
+	case c >= '0' && c <= '9':
+		sum += int(c - '0')
+	default:
+		return false
+	}
+	return sum%11 == 0
+}
+
+// isISBN13 reports whether s is a valid 978 or 979 prefixed EAN-13.
+func isISBN13(s string) bool {
+	return len(s) == 13 && isDigits(s) && (strings.HasPrefix(s, "978") || strings.HasPrefix(s, "979")) && validCheckDigit(s)
+}
+
+// isbn10To13 converts a valid ISBN-10 to its ISBN-13.
+func isbn10To13(s string) string {
+	code := "978" + s[:9]
+	return code + string(rune('0'+gtinCheckDigit(code)))
+}
+
+// gtinCheckDigit computes the check digit for a GTIN without one. Counting
+// from the right, digits are weighted 3, 1, 3, ...
+func gtinCheckDigit(s string) int {
+	sum := 0
+	for i := len(s) - 1; i >= 0; i-- {
+		d := int(s[i] - '0')
+		if (len(s)-1-i)%2 == 0 {
+			d *= 3
+		}
+		sum += d
+	}
+	return (10 - sum%10) % 10
+}
+
+// validCheckDigit reports whether the last digit of a GTIN is its check digit.
+func validCheckDigit(s string) bool {
+	return gtinCheckDigit(s[:len(s)-1]) == int(s[len(s)-1]-'0')
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
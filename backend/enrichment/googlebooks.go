@@ -0,0 +1,81 @@
+package enrichment
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/m-cain/mnemo/backend/apperrors"
+)
+
+// DefaultGoogleBooksURL is the base URL of the public Google Books API.
+const DefaultGoogleBooksURL = "https://www.googleapis.com"
+
+// GoogleBooksProvider looks up books by ISBN in Google Books.
+type GoogleBooksProvider struct {
+	baseURL string
+	apiKey  string // Optional; raises the anonymous quota
+	client  *http.Client
+}
+
+// NewGoogleBooksProvider creates a Google Books provider for the API at baseURL.
+func NewGoogleBooksProvider(baseURL, apiKey string, client *http.Client) *GoogleBooksProvider {
+	return &GoogleBooksProvider{baseURL: strings.TrimSuffix(baseURL, "/"), apiKey: apiKey, client: client}
+}
+
+// Name implements Provider.
+func (p *GoogleBooksProvider) Name() string { return "googlebooks" }
+
+// Supports implements Provider.
+func (p *GoogleBooksProvider) Supports(kind string) bool { return kind == KindISBN }
+
+// Lookup implements Provider.
+func (p *GoogleBooksProvider) Lookup(ctx context.Context, q Query) (*Product, error) {
+	params := url.Values{"q": {"isbn:" + q.Code}}
+	if p.apiKey != "" {
+		params.Set("key", p.apiKey)
+	}
+	endpoint := p.baseURL + "/books/v1/volumes?" + params.Encode()
+
+	var result struct {
+		Items []struct {
+			VolumeInfo struct {
+				Title       string   `json:"title"`
+				Subtitle    string   `json:"subtitle"`
+				Authors     []string `json:"authors"`
+				Publisher   string   `json:"publisher"`
+				Description string   `json:"description"`
+				ImageLinks  struct {
+					Thumbnail      string `json:"thumbnail"`
+					SmallThumbnail string `json:"smallThumbnail"`
+				} `json:"imageLinks"`
+			} `json:"volumeInfo"`
+		} `json:"items"`
+	}
+	if err := getJSON(ctx, p.client, endpoint, &result); err != nil {
+		return nil, err
+	}
+	if len(result.Items) == 0 || result.Items[0].VolumeInfo.Title == "" {
+		return nil, apperrors.ErrNotFound
+	}
+
+	info := result.Items[0].VolumeInfo
+	product := &Product{
+		Name:              info.Title,
+		Description:       info.Description,
+		Brand:             info.Publisher,
+		ImageURL:          info.ImageLinks.Thumbnail,
+		SuggestedItemType: bookItemType,
+	}
+	if info.Subtitle != "" {
+		product.Name += ": " + info.Subtitle
+	}
+	if product.Description == "" {
+		product.Description = byAuthors(info.Authors)
+	}
+	if product.ImageURL == "" {
+		product.ImageURL = info.ImageLinks.SmallThumbnail
+	}
+	return product, nil
+}
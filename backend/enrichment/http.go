@@ -0,0 +1,41 @@
+package enrichment
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/m-cain/mnemo/backend/apperrors"
+)
+
+const (
+	// maxResponseSize limits how much of a provider response is read.
+	maxResponseSize = 2 << 20
+	// userAgent identifies Mnemo to providers; Open Food Facts asks clients to set one.
+	userAgent = "Mnemo/1.0 (+https://github.com/m-cain/mnemo)"
+)
+
+// getJSON fetches a JSON document from a provider. A 404 response returns
+// apperrors.ErrNotFound.
+func getJSON(ctx context.Context, client *http.Client, endpoint string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return apperrors.ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: unexpected status %s", endpoint, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(v)
+}
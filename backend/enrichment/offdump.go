@@ -0,0 +1,81 @@
+package enrichment
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/m-cain/mnemo/backend/apperrors"
+)
+
+// OFFDumpProvider looks up food products by barcode in a local Open Food
+// Facts JSONL dump, for installations without internet access. The dump is
+// indexed in memory when the provider is created, so a dump filtered to the
+// relevant countries keeps startup fast and memory use low.
+type OFFDumpProvider struct {
+	products map[string]Product
+}
+
+// NewOFFDumpProvider loads the dump at path, which may be gzip-compressed if
+// its name ends in ".gz". Products without a name are skipped.
+func NewOFFDumpProvider(path string) (*OFFDumpProvider, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open Open Food Facts dump: %w", err)
+	}
+	defer f.Close()
+
+	var r io.Reader = bufio.NewReader(f)
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read Open Food Facts dump: %w", err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	p := &OFFDumpProvider{products: make(map[string]Product)}
+	dec := json.NewDecoder(r)
+	for {
+		var off offProduct
+		if err := dec.Decode(&off); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to parse Open Food Facts dump: %w", err)
+		}
+		q, err := ParseQuery(off.Code, KindBarcode)
+		if err != nil {
+			continue // Internal or malformed codes cannot be scanned
+		}
+		if product := off.toProduct(); product != nil {
+			p.products[q.Code] = *product
+		}
+	}
+	return p, nil
+}
+
+// Name implements Provider.
+func (p *OFFDumpProvider) Name() string { return "offdump" }
+
+// Supports implements Provider.
+func (p *OFFDumpProvider) Supports(kind string) bool { return kind == KindBarcode }
+
+// Lookup implements Provider.
+func (p *OFFDumpProvider) Lookup(ctx context.Context, q Query) (*Product, error) {
+	product, ok := p.products[q.Code]
+	if !ok {
+		return nil, apperrors.ErrNotFound
+	}
+	return &product, nil
+}
+
+// Len returns the number of products in the dump.
+func (p *OFFDumpProvider) Len() int {
+	return len(p.products)
+}
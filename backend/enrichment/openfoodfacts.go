@@ -0,0 +1,90 @@
+package enrichment
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/m-cain/mnemo/backend/apperrors"
+)
+
+// DefaultOpenFoodFactsURL is the base URL of the public Open Food Facts API.
+const DefaultOpenFoodFactsURL = "https://world.openfoodfacts.org"
+
+// offProduct holds the Open Food Facts product fields Mnemo uses. The API and
+// the data dumps share these field names.
+type offProduct struct {
+	Code           string   `json:"code"`
+	ProductName    string   `json:"product_name"`
+	GenericName    string   `json:"generic_name"`
+	Brands         string   `json:"brands"`
+	ImageFrontURL  string   `json:"image_front_url"`
+	ImageURL       string   `json:"image_url"`
+	CategoriesTags []string `json:"categories_tags"`
+}
+
+// offFields lists offProduct's fields for the API's fields parameter.
+const offFields = "code,product_name,generic_name,brands,image_front_url,image_url,categories_tags"
+
+// toProduct converts an Open Food Facts product, or returns nil if it has no name.
+func (p offProduct) toProduct() *Product {
+	if p.ProductName == "" {
+		return nil
+	}
+	product := &Product{
+		Name:              p.ProductName,
+		Description:       p.GenericName,
+		ImageURL:          p.ImageFrontURL,
+		SuggestedItemType: "Food",
+	}
+	// Brands are a comma-separated list, most relevant first
+	product.Brand, _, _ = strings.Cut(p.Brands, ",")
+	product.Brand = strings.TrimSpace(product.Brand)
+	if product.ImageURL == "" {
+		product.ImageURL = p.ImageURL
+	}
+	if slices.Contains(p.CategoriesTags, "en:beverages") {
+		product.SuggestedItemType = "Beverages"
+	}
+	return product
+}
+
+// OpenFoodFactsProvider looks up food products by barcode in Open Food Facts.
+type OpenFoodFactsProvider struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewOpenFoodFactsProvider creates an Open Food Facts provider for the API at baseURL.
+func NewOpenFoodFactsProvider(baseURL string, client *http.Client) *OpenFoodFactsProvider {
+	return &OpenFoodFactsProvider{baseURL: strings.TrimSuffix(baseURL, "/"), client: client}
+}
+
+// Name implements Provider.
+func (p *OpenFoodFactsProvider) Name() string { return "openfoodfacts" }
+
+// Supports implements Provider.
+func (p *OpenFoodFactsProvider) Supports(kind string) bool { return kind == KindBarcode }
+
+// Lookup implements Provider.
+func (p *OpenFoodFactsProvider) Lookup(ctx context.Context, q Query) (*Product, error) {
+	endpoint := p.baseURL + "/api/v2/product/" + url.PathEscape(q.Code) + ".json?" + url.Values{"fields": {offFields}}.Encode()
+
+	var result struct {
+		Status  int        `json:"status"` // 1 if the product was found
+		Product offProduct `json:"product"`
+	}
+	if err := getJSON(ctx, p.client, endpoint, &result); err != nil {
+		return nil, err
+	}
+	if result.Status != 1 {
+		return nil, apperrors.ErrNotFound
+	}
+	product := result.Product.toProduct()
+	if product == nil {
+		return nil, apperrors.ErrNotFound
+	}
+	return product, nil
+}
@@ -0,0 +1,89 @@
+package enrichment
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/m-cain/mnemo/backend/apperrors"
+)
+
+// DefaultOpenLibraryURL is the base URL of the public Open Library API.
+const DefaultOpenLibraryURL = "https://openlibrary.org"
+
+// bookItemType is the item type suggested for books.
+const bookItemType = "Books"
+
+// OpenLibraryProvider looks up books by ISBN in Open Library.
+type OpenLibraryProvider struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewOpenLibraryProvider creates an Open Library provider for the API at baseURL.
+func NewOpenLibraryProvider(baseURL string, client *http.Client) *OpenLibraryProvider {
+	return &OpenLibraryProvider{baseURL: strings.TrimSuffix(baseURL, "/"), client: client}
+}
+
+// Name implements Provider.
+func (p *OpenLibraryProvider) Name() string { return "openlibrary" }
+
+// Supports implements Provider.
+func (p *OpenLibraryProvider) Supports(kind string) bool { return kind == KindISBN }
+
+// Lookup implements Provider.
+func (p *OpenLibraryProvider) Lookup(ctx context.Context, q Query) (*Product, error) {
+	key := "ISBN:" + q.Code
+	endpoint := p.baseURL + "/api/books?" + url.Values{
+		"bibkeys": {key},
+		"format":  {"json"},
+		"jscmd":   {"data"},
+	}.Encode()
+
+	type named struct {
+		Name string `json:"name"`
+	}
+	var books map[string]struct {
+		Title      string  `json:"title"`
+		Subtitle   string  `json:"subtitle"`
+		Authors    []named `json:"authors"`
+		Publishers []named `json:"publishers"`
+		Cover      struct {
+			Medium string `json:"medium"`
+			Large  string `json:"large"`
+		} `json:"cover"`
+	}
+	if err := getJSON(ctx, p.client, endpoint, &books); err != nil {
+		return nil, err
+	}
+	book, ok := books[key]
+	if !ok || book.Title == "" {
+		return nil, apperrors.ErrNotFound
+	}
+
+	product := &Product{Name: book.Title, ImageURL: book.Cover.Large, SuggestedItemType: bookItemType}
+	if book.Subtitle != "" {
+		product.Name += ": " + book.Subtitle
+	}
+	if product.ImageURL == "" {
+		product.ImageURL = book.Cover.Medium
+	}
+	if len(book.Publishers) > 0 {
+		product.Brand = book.Publishers[0].Name
+	}
+	authors := make([]string, 0, len(book.Authors))
+	for _, a := range book.Authors {
+		authors = append(authors, a.Name)
+	}
+	product.Description = byAuthors(authors)
+	return product, nil
+}
+
+// byAuthors describes a book by its authors, or returns "" if there are none.
+func byAuthors(authors []string) string {
+	if len(authors) == 0 {
+		return ""
+	}
+	return "By " + strings.Join(authors, ", ")
+}
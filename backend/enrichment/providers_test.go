@@ -0,0 +1,241 @@
+package enrichment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/m-cain/mnemo/backend/apperrors"
+)
+
+// fakeUpstream serves canned Open Food Facts, Open Library and Google Books
+// responses, counting the requests it receives.
+type fakeUpstream struct {
+	server   *httptest.Server
+	requests atomic.Int32
+	status   atomic.Int32 // If set, every request fails with this status
+}
+
+func newFakeUpstream(t *testing.T) *fakeUpstream {
+	t.Helper()
+	u := &fakeUpstream{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v2/product/{file}", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("fields") != offFields {
+			t.Errorf("Open Food Facts request without the fields parameter: %s", r.URL)
+		}
+		switch r.PathValue("file") {
+		case "3017620422003.json":
+			writeJSON(w, map[string]any{"status": 1, "product": map[string]any{
+				"code":            "3017620422003",
+				"product_name":    "Nutella",
+				"generic_name":    "Hazelnut spread",
+				"brands":          "Ferrero, Nutella",
+				"image_url":       "https://images.example/nutella.jpg",
+				"categories_tags": []string{"en:spreads"},
+			}})
+		case "5449000000996.json":
+			writeJSON(w, map[string]any{"status": 1, "product": map[string]any{
+				"product_name":    "Coca-Cola",
+				"image_front_url": "https://images.example/coke-front.jpg",
+				"image_url":       "https://images.example/coke.jpg",
+				"categories_tags": []string{"en:beverages", "en:sodas"},
+			}})
+		case "4006381333931.json":
+			writeJSON(w, map[string]any{"status": 1, "product": map[string]any{"product_name": ""}})
+		default:
+			writeJSON(w, map[string]any{"status": 0, "status_verbose": "product not found"})
+		}
+	})
+	mux.HandleFunc("GET /api/books", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("format") != "json" || q.Get("jscmd") != "data" {
+			t.Errorf("unexpected Open Library request: %s", r.URL)
+		}
+		books := map[string]any{}
+		if q.Get("bibkeys") == "ISBN:9780306406157" {
+			books["ISBN:9780306406157"] = map[string]any{
+				"title":      "Pattern Recognition",
+				"subtitle":   "A Novel",
+				"authors":    []map[string]string{{"name": "William Gibson"}, {"name": "Someone Else"}},
+				"publishers": []map[string]string{{"name": "Putnam"}},
+				"cover":      map[string]string{"medium": "https://covers.example/m.jpg"},
+			}
+		}
+		writeJSON(w, books)
+	})
+	mux.HandleFunc("GET /books/v1/volumes", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("key") != "test-key" {
+			t.Errorf("Google Books request without the API key: %s", r.URL)
+		}
+		if r.URL.Query().Get("q") != "isbn:9780140449136" {
+			writeJSON(w, map[string]any{"totalItems": 0})
+			return
+		}
+		writeJSON(w, map[string]any{"items": []any{map[string]any{"volumeInfo": map[string]any{
+			"title":      "The Odyssey",
+			"authors":    []string{"Homer"},
+			"publisher":  "Penguin",
+			"imageLinks": map[string]string{"smallThumbnail": "https://books.example/small.jpg"},
+		}}}})
+	})
+
+	u.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u.requests.Add(1)
+		if r.Header.Get("User-Agent") != userAgent {
+			t.Errorf("request without the Mnemo user agent: %s", r.URL)
+		}
+		if status := u.status.Load(); status != 0 {
+			w.WriteHeader(int(status))
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(u.server.Close)
+	return u
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// mustParseQuery parses a code that is known to be valid.
+func mustParseQuery(t *testing.T, code string) Query {
+	t.Helper()
+	q, err := ParseQuery(code, "")
+	if err != nil {
+		t.Fatalf("ParseQuery(%q): %v", code, err)
+	}
+	return q
+}
+
+func TestProviderParsing(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	client := upstream.server.Client()
+	off := NewOpenFoodFactsProvider(upstream.server.URL+"/", client)
+	openLibrary := NewOpenLibraryProvider(upstream.server.URL, client)
+	googleBooks := NewGoogleBooksProvider(upstream.server.URL, "test-key", client)
+
+	tests := []struct {
+		name     string
+		provider Provider
+		code     string
+		want     *Product // Nil if the provider has no match
+	}{
+		{
+			name:     "Open Food Facts product",
+			provider: off,
+			code:     "3017620422003",
+			want: &Product{
+				Name:              "Nutella",
+				Description:       "Hazelnut spread",
+				Brand:             "Ferrero",
+				ImageURL:          "https://images.example/nutella.jpg",
+				SuggestedItemType: "Food",
+			},
+		},
+		{
+			name:     "Open Food Facts beverage",
+			provider: off,
+			code:     "5449000000996",
+			want:     &Product{Name: "Coca-Cola", ImageURL: "https://images.example/coke-front.jpg", SuggestedItemType: "Beverages"},
+		},
+		{name: "Open Food Facts product without a name", provider: off, code: "4006381333931"},
+		{name: "Open Food Facts unknown product", provider: off, code: "96385074"},
+		{
+			name:     "Open Library book",
+			provider: openLibrary,
+			code:     "0-306-40615-2",
+			want: &Product{
+				Name:              "Pattern Recognition: A Novel",
+				Description:       "By William Gibson, Someone Else",
+				Brand:             "Putnam",
+				ImageURL:          "https://covers.example/m.jpg",
+				SuggestedItemType: "Books",
+			},
+		},
+		{name: "Open Library unknown book", provider: openLibrary, code: "9780140449136"},
+		{
+			name:     "Google Books volume",
+			provider: googleBooks,
+			code:     "9780140449136",
+			want: &Product{
+				Name:              "The Odyssey",
+				Description:       "By Homer",
+				Brand:             "Penguin",
+				ImageURL:          "https://books.example/small.jpg",
+				SuggestedItemType: "Books",
+			},
+		},
+		{name: "Google Books unknown volume", provider: googleBooks, code: "9780306406157"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			product, err := tt.provider.Lookup(context.Background(), mustParseQuery(t, tt.code))
+			if tt.want == nil {
+				if !errors.Is(err, apperrors.ErrNotFound) {
+					t.Fatalf("Lookup = %+v, %v; want ErrNotFound", product, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Lookup: %v", err)
+			}
+			if !reflect.DeepEqual(product, tt.want) {
+				t.Fatalf("Lookup = %+v, want %+v", product, tt.want)
+			}
+		})
+	}
+}
+
+func TestProviderUpstreamErrors(t *testing.T) {
+	upstream := newFakeUpstream(t)
+	provider := NewOpenFoodFactsProvider(upstream.server.URL, upstream.server.Client())
+	q := mustParseQuery(t, "3017620422003")
+
+	upstream.status.Store(http.StatusNotFound)
+	if _, err := provider.Lookup(context.Background(), q); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Fatalf("Lookup with a 404 response error = %v, want ErrNotFound", err)
+	}
+	upstream.status.Store(http.StatusServiceUnavailable)
+	if _, err := provider.Lookup(context.Background(), q); err == nil || errors.Is(err, apperrors.ErrNotFound) {
+		t.Fatalf("Lookup with a 503 response error = %v, want a temporary error", err)
+	}
+}
+
+func TestProviderTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	t.Cleanup(server.Close)
+	t.Cleanup(func() { close(release) }) // Runs first, so Close need not wait for the handler
+
+	cfg := DefaultConfig()
+	cfg.Providers = []string{"openfoodfacts"}
+	cfg.OpenFoodFactsURL = server.URL
+	cfg.Timeout = 50 * time.Millisecond
+	registry, err := NewRegistryFromConfig(cfg)
+	if err != nil {
+		t.Fatalf("NewRegistryFromConfig: %v", err)
+	}
+
+	start := time.Now()
+	_, err = registry.Providers(KindBarcode)[0].Lookup(context.Background(), mustParseQuery(t, "3017620422003"))
+	if err == nil || errors.Is(err, apperrors.ErrNotFound) {
+		t.Fatalf("Lookup error = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Lookup took %s despite a %s timeout", elapsed, cfg.Timeout)
+	}
+}
@@ -0,0 +1,60 @@
+package enrichment
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Registry holds the providers used for lookups. Providers are consulted in
+// the order they were registered, so sources that are cheaper or more
+// trusted should be registered first.
+type Registry struct {
+	mu        sync.RWMutex
+	providers []Provider
+}
+
+// NewRegistry creates a registry with the given providers, in lookup order.
+func NewRegistry(providers ...Provider) (*Registry, error) {
+	r := &Registry{}
+	for _, p := range providers {
+		if err := r.Register(p); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Register adds a provider after those already registered.
+func (r *Registry) Register(p Provider) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.providers {
+		if existing.Name() == p.Name() {
+			return fmt.Errorf("enrichment provider %q is already registered", p.Name())
+		}
+	}
+	r.providers = append(r.providers, p)
+	return nil
+}
+
+// Providers returns the providers that support a kind of code, in lookup order.
+func (r *Registry) Providers(kind string) []Provider {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var providers []Provider
+	for _, p := range r.providers {
+		if p.Supports(kind) {
+			providers = append(providers, p)
+		}
+	}
+	return providers
+}
+
+// Len returns the number of registered providers.
+func (r *Registry) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.providers)
+}
@@ -0,0 +1,119 @@
+package enrichment
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/m-cain/mnemo/backend/apperrors"
+)
+
+// ErrProvidersUnavailable is returned when no provider had a match and at
+// least one of them failed, so the code may still be known.
+var ErrProvidersUnavailable = errors.New("product data providers are unavailable")
+
+// Service looks up products through a registry of providers, caching each
+// provider's answers in Postgres.
+type Service struct {
+	db       *pgxpool.Pool
+	registry *Registry
+	cfg      Config
+}
+
+// NewService creates a new Service.
+func NewService(db *pgxpool.Pool, registry *Registry, cfg Config) *Service {
+	return &Service{db: db, registry: registry, cfg: cfg}
+}
+
+// Lookup returns the first product found for a query, asking each provider
+// that supports the kind of code in registry order. Cached answers, including
+// cached misses, are used while they are fresh. It returns
+// apperrors.ErrNotFound if no provider knows the code.
+func (s *Service) Lookup(ctx context.Context, q Query) (*Product, error) {
+	failed := false
+	for _, p := range s.registry.Providers(q.Kind) {
+		product, hit, err := s.cached(ctx, p.Name(), q)
+		if err != nil {
+			return nil, err
+		}
+		if !hit {
+			product, err = p.Lookup(ctx, q)
+			switch {
+			case errors.Is(err, apperrors.ErrNotFound):
+				product = nil
+			case err != nil:
+				// Temporary failures are not cached, so the provider is asked again next time
+				log.Printf("Error looking up %s %s with %s: %v", q.Kind, q.Code, p.Name(), err)
+				failed = true
+				continue
+			default:
+				product.Provider = p.Name()
+			}
+			if err := s.store(ctx, p.Name(), q, product); err != nil {
+				return nil, err
+			}
+		}
+		if product != nil {
+			return product, nil
+		}
+	}
+	if failed {
+		return nil, ErrProvidersUnavailable
+	}
+	return nil, apperrors.ErrNotFound
+}
+
+// cached returns a provider's fresh cached answer for a query. hit is false
+// if there is none; a hit with a nil product is a cached miss.
+func (s *Service) cached(ctx context.Context, provider string, q Query) (product *Product, hit bool, err error) {
+	var data []byte
+	var fetchedAt time.Time
+	query := `SELECT product, fetched_at FROM enrichment_cache WHERE provider = $1 AND kind = $2 AND code = $3`
+	if err := s.db.QueryRow(ctx, query, provider, q.Kind, q.Code).Scan(&data, &fetchedAt); err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("failed to read enrichment cache: %w", err)
+	}
+
+	ttl := s.cfg.CacheTTL
+	if data == nil {
+		ttl = s.cfg.NegativeCacheTTL
+	}
+	if time.Since(fetchedAt) > ttl {
+		return nil, false, nil
+	}
+	if data == nil {
+		return nil, true, nil
+	}
+	if err := json.Unmarshal(data, &product); err != nil {
+		return nil, false, fmt.Errorf("failed to decode cached product: %w", err)
+	}
+	return product, true, nil
+}
+
+// store caches a provider's answer for a query; a nil product records a miss.
+func (s *Service) store(ctx context.Context, provider string, q Query, product *Product) error {
+	var data []byte
+	if product != nil {
+		var err error
+		if data, err = json.Marshal(product); err != nil {
+			return fmt.Errorf("failed to encode product: %w", err)
+		}
+	}
+
+	query := `
+		INSERT INTO enrichment_cache (provider, kind, code, product, fetched_at)
+		VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP)
+		ON CONFLICT (provider, kind, code) DO UPDATE SET product = EXCLUDED.product, fetched_at = EXCLUDED.fetched_at
+	`
+	if _, err := s.db.Exec(ctx, query, provider, q.Kind, q.Code, data); err != nil {
+		return fmt.Errorf("failed to write enrichment cache: %w", err)
+	}
+	return nil
+}
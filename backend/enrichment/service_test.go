@@ -0,0 +1,176 @@
+package enrichment
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/m-cain/mnemo/backend/apperrors"
+	"github.com/m-cain/mnemo/backend/testdb"
+)
+
+// renamedProvider gives a provider a unique name, so tests sharing the
+// database never see each other's cache entries.
+type renamedProvider struct {
+	Provider
+	name string
+}
+
+func (p renamedProvider) Name() string { return p.name }
+
+// newTestService returns a Service asking the fake upstream's Open Food Facts
+// API, and the name its answers are cached under.
+func newTestService(t *testing.T, db *pgxpool.Pool, upstream *fakeUpstream) (*Service, string) {
+	t.Helper()
+	name := "off-" + uuid.NewString()[:8]
+	provider := renamedProvider{NewOpenFoodFactsProvider(upstream.server.URL, upstream.server.Client()), name}
+	registry, err := NewRegistry(provider)
+	if err != nil {
+		t.Fatalf("NewRegistry: %v", err)
+	}
+	return NewService(db, registry, DefaultConfig()), name
+}
+
+// ageCacheEntry moves the time a cached answer was fetched back by age.
+func ageCacheEntry(t *testing.T, db *pgxpool.Pool, provider string, q Query, age time.Duration) {
+	t.Helper()
+	query := `UPDATE enrichment_cache SET fetched_at = fetched_at - make_interval(secs => $4) WHERE provider = $1 AND kind = $2 AND code = $3`
+	if _, err := db.Exec(context.Background(), query, provider, q.Kind, q.Code, age.Seconds()); err != nil {
+		t.Fatalf("failed to age cache entry: %v", err)
+	}
+}
+
+func TestServiceCachesProducts(t *testing.T) {
+	db := testdb.Open(t)
+	upstream := newFakeUpstream(t)
+	s, name := newTestService(t, db, upstream)
+	q := mustParseQuery(t, "3017620422003")
+
+	for i := range 2 {
+		product, err := s.Lookup(context.Background(), q)
+		if err != nil {
+			t.Fatalf("Lookup %d: %v", i, err)
+		}
+		if product.Name != "Nutella" || product.Provider != name {
+			t.Fatalf("Lookup %d = %+v", i, product)
+		}
+	}
+	if n := upstream.requests.Load(); n != 1 {
+		t.Fatalf("upstream received %d requests, want 1", n)
+	}
+
+	ageCacheEntry(t, db, name, q, s.cfg.CacheTTL+time.Minute)
+	if _, err := s.Lookup(context.Background(), q); err != nil {
+		t.Fatalf("Lookup after expiry: %v", err)
+	}
+	if n := upstream.requests.Load(); n != 2 {
+		t.Fatalf("upstream received %d requests after expiry, want 2", n)
+	}
+}
+
+func TestServiceCachesMisses(t *testing.T) {
+	db := testdb.Open(t)
+	upstream := newFakeUpstream(t)
+	s, name := newTestService(t, db, upstream)
+	q := mustParseQuery(t, "96385074")
+
+	for i := range 2 {
+		if _, err := s.Lookup(context.Background(), q); !errors.Is(err, apperrors.ErrNotFound) {
+			t.Fatalf("Lookup %d error = %v, want ErrNotFound", i, err)
+		}
+	}
+	if n := upstream.requests.Load(); n != 1 {
+		t.Fatalf("upstream received %d requests, want 1", n)
+	}
+
+	// Misses are remembered for NegativeCacheTTL, not CacheTTL
+	ageCacheEntry(t, db, name, q, s.cfg.NegativeCacheTTL+time.Minute)
+	if _, err := s.Lookup(context.Background(), q); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Fatalf("Lookup after expiry error = %v, want ErrNotFound", err)
+	}
+	if n := upstream.requests.Load(); n != 2 {
+		t.Fatalf("upstream received %d requests after expiry, want 2", n)
+	}
+}
+
+func TestServiceDoesNotCacheFailures(t *testing.T) {
+	db := testdb.Open(t)
+	upstream := newFakeUpstream(t)
+	s, _ := newTestService(t, db, upstream)
+	q := mustParseQuery(t, "3017620422003")
+
+	upstream.status.Store(http.StatusBadGateway)
+	if _, err := s.Lookup(context.Background(), q); !errors.Is(err, ErrProvidersUnavailable) {
+		t.Fatalf("Lookup error = %v, want ErrProvidersUnavailable", err)
+	}
+	upstream.status.Store(0)
+	product, err := s.Lookup(context.Background(), q)
+	if err != nil {
+		t.Fatalf("Lookup after recovery: %v", err)
+	}
+	if product.Name != "Nutella" {
+		t.Fatalf("Lookup after recovery = %+v", product)
+	}
+}
+
+func TestServiceFallsBackToNextProvider(t *testing.T) {
+	db := testdb.Open(t)
+	upstream := newFakeUpstream(t)
+	client := upstream.server.Client()
+	suffix := uuid.NewString()[:8]
+	registry, err := NewRegistry(
+		renamedProvider{NewOpenLibraryProvider(upstream.server.URL, client), "ol-" + suffix},
+		renamedProvider{NewGoogleBooksProvider(upstream.server.URL, "test-key", client), "gb-" + suffix},
+	)
+	if err != nil {
+		t.Fatalf("NewRegistry: %v", err)
+	}
+	s := NewService(db, registry, DefaultConfig())
+
+	product, err := s.Lookup(context.Background(), mustParseQuery(t, "9780140449136"))
+	if err != nil {
+		t.Fatalf("Lookup: %v", err)
+	}
+	if product.Name != "The Odyssey" || product.Provider != "gb-"+suffix {
+		t.Fatalf("Lookup = %+v, want The Odyssey from Google Books", product)
+	}
+}
+
+func TestServiceTimeout(t *testing.T) {
+	db := testdb.Open(t)
+	release := make(chan struct{})
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	t.Cleanup(server.Close)
+	t.Cleanup(func() { close(release) }) // Runs first, so Close need not wait for the handler
+
+	client := &http.Client{Timeout: 50 * time.Millisecond}
+	registry, err := NewRegistry(renamedProvider{NewOpenFoodFactsProvider(server.URL, client), "slow-" + uuid.NewString()[:8]})
+	if err != nil {
+		t.Fatalf("NewRegistry: %v", err)
+	}
+	s := NewService(db, registry, DefaultConfig())
+	q := mustParseQuery(t, "3017620422003")
+
+	// A timeout is temporary, so it is reported and the provider asked again
+	for i := range 2 {
+		if _, err := s.Lookup(context.Background(), q); !errors.Is(err, ErrProvidersUnavailable) {
+			t.Fatalf("Lookup %d error = %v, want ErrProvidersUnavailable", i, err)
+		}
+	}
+	if n := requests.Load(); n != 2 {
+		t.Fatalf("upstream received %d requests, want 2", n)
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/m-cain/mnemo/backend/apperrors"
+	"github.com/m-cain/mnemo/backend/audit"
 	"github.com/m-cain/mnemo/backend/models"
 )
 
@@ -56,11 +58,16 @@ func (s *HomeService) CreateHome(ctx context.Context, name string, ownerID strin
 		INSERT INTO home_users (home_id, user_id, role, joined_at)
 		VALUES ($1, $2, $3, $4)
 	`
-	_, err = tx.Exec(ctx, homeUserQuery, homeID, ownerUUID, "owner", time.Now()) // Assign 'owner' role
+	_, err = tx.Exec(ctx, homeUserQuery, homeID, ownerUUID, RoleOwner, time.Now()) // Assign 'owner' role
 	if err != nil {
 		return nil, fmt.Errorf("failed to insert home owner user: %w", err)
 	}
 
+	entry := audit.Entry{HomeID: &home.ID, Action: audit.ActionCreate, EntityType: audit.EntityHome, EntityID: home.ID, After: home}
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return nil, err
+	}
+
 	err = tx.Commit(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("failed to commit transaction: %w", err)
@@ -139,6 +146,20 @@ func (s *HomeService) UpdateHome(ctx context.Context, homeID string, name string
 	}
 	updatedAt := time.Now()
 
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx) // Rollback if not committed
+
+	before, err := lockHome(ctx, tx, homeUUID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil // Home not found
+		}
+		return nil, err
+	}
+
 	query := `
 		UPDATE homes
 		SET name = $1, updated_at = $2
@@ -146,16 +167,23 @@ func (s *HomeService) UpdateHome(ctx context.Context, homeID string, name string
 		RETURNING id, name, owner_id, created_at, updated_at
 	`
 	home := &models.Home{}
-	err = s.db.QueryRow(ctx, query, name, updatedAt, homeUUID).Scan(
+	err = tx.QueryRow(ctx, query, name, updatedAt, homeUUID).Scan(
 		&home.ID, &home.Name, &home.OwnerID, &home.CreatedAt, &home.UpdatedAt,
 	)
 	if err != nil {
-		if err == pgx.ErrNoRows {
-			return nil, nil // Home not found
-		}
 		return nil, fmt.Errorf("failed to update home %s: %w", homeID, err)
 	}
 
+	entry := audit.Entry{HomeID: &home.ID, Action: audit.ActionUpdate, EntityType: audit.EntityHome, EntityID: home.ID, Before: before, After: home}
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
 	return home, nil
 }
 
@@ -166,26 +194,62 @@ func (s *HomeService) DeleteHome(ctx context.Context, homeID string) error {
 		return fmt.Errorf("invalid home ID: %w", err)
 	}
 
-	// Deleting a home should also delete associated home_users, locations, and items
-	// Due to foreign key constraints with ON DELETE CASCADE, deleting the home
-	// should automatically handle the related records.
-
-	query := `
-		DELETE FROM homes
-		WHERE id = $1
-	`
-	result, err := s.db.Exec(ctx, query, homeUUID)
+	tx, err := s.db.Begin(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to delete home %s: %w", homeID, err)
+		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
+	defer tx.Rollback(ctx) // Rollback if not committed
 
-	if result.RowsAffected() == 0 {
-		return pgx.ErrNoRows // Home not found
+	if err := deleteHome(ctx, tx, homeUUID); err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
 	return nil
 }
 
+// deleteHome deletes a home and everything in it. Memberships, invitations,
+// locations and items are removed by ON DELETE CASCADE.
+func deleteHome(ctx context.Context, tx pgx.Tx, homeID uuid.UUID) error {
+	before, err := lockHome(ctx, tx, homeID)
+	if err != nil {
+		return err // pgx.ErrNoRows if the home is not found
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM homes WHERE id = $1`, homeID); err != nil {
+		return fmt.Errorf("failed to delete home %s: %w", homeID, err)
+	}
+
+	entry := audit.Entry{HomeID: &homeID, Action: audit.ActionDelete, EntityType: audit.EntityHome, EntityID: homeID, Before: before}
+	return audit.Record(ctx, tx, entry)
+}
+
+// lockHome returns a home, locking it until tx ends.
+// It returns pgx.ErrNoRows if the home does not exist.
+func lockHome(ctx context.Context, tx pgx.Tx, homeID uuid.UUID) (*models.Home, error) {
+	query := `
+		SELECT id, name, owner_id, created_at, updated_at
+		FROM homes
+		WHERE id = $1
+		FOR UPDATE
+	`
+	home := &models.Home{}
+	err := tx.QueryRow(ctx, query, homeID).Scan(
+		&home.ID, &home.Name, &home.OwnerID, &home.CreatedAt, &home.UpdatedAt,
+	)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to lock home %s: %w", homeID, err)
+	}
+	return home, nil
+}
+
 // ListHomeUsers lists all users associated with a home.
 func (s *HomeService) ListHomeUsers(ctx context.Context, homeID string) ([]models.HomeUser, error) {
 	homeUUID, err := uuid.Parse(homeID)
@@ -221,52 +285,40 @@ func (s *HomeService) ListHomeUsers(ctx context.Context, homeID string) ([]model
 	return homeUsers, nil
 }
 
-// InviteUserToHome invites a user to a home with a specific role.
-// This is a simplified version; a real implementation would involve invitations,
-// email notifications, and acceptance flows.
-func (s *HomeService) InviteUserToHome(ctx context.Context, homeID string, userID string, role string) error {
+// UpdateHomeUserRole updates the role of a user in a home on behalf of a
+// member with actorRole, who must be allowed to grant the role and to manage
+// the user as checked by CanGrantRole and CanManageMember. The user's current
+// role is checked under the same lock as the update. It returns ErrInvalidRole
+// for unknown roles and ErrOwnerRole when granting the owner role or changing
+// the role of the home's owner.
+func (s *HomeService) UpdateHomeUserRole(ctx context.Context, homeID string, userID string, role string, actorRole string) error {
+	if err := CanGrantRole(actorRole, role); err != nil {
+		return err
+	}
 	homeUUID, err := uuid.Parse(homeID)
 	if err != nil {
 		return fmt.Errorf("invalid home ID: %w", err)
 	}
 	userUUID, err := uuid.Parse(userID)
 	if err != nil {
-		return fmt.Errorf("invalid user ID: %w", err)
+		return fmt.Errorf("%w: invalid user ID", apperrors.ErrInvalidInput)
 	}
 
-	// Check if user is already a member
-	checkQuery := `SELECT COUNT(*) FROM home_users WHERE home_id = $1 AND user_id = $2`
-	var count int
-	err = s.db.QueryRow(ctx, checkQuery, homeUUID, userUUID).Scan(&count)
+	tx, err := s.db.Begin(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to check existing home user: %w", err)
-	}
-	if count > 0 {
-		return fmt.Errorf("user %s is already a member of home %s", userID, homeID)
+		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
+	defer tx.Rollback(ctx) // Rollback if not committed
 
-	// Insert home_user entry
-	insertQuery := `
-		INSERT INTO home_users (home_id, user_id, role, joined_at)
-		VALUES ($1, $2, $3, $4)
-	`
-	_, err = s.db.Exec(ctx, insertQuery, homeUUID, userUUID, role, time.Now())
-	if err != nil {
-		return fmt.Errorf("failed to invite user to home: %w", err)
+	if err := checkNotOwner(ctx, tx, homeUUID, userUUID); err != nil {
+		return err // pgx.ErrNoRows if the home is not found
 	}
-
-	return nil
-}
-
-// UpdateHomeUserRole updates the role of a user in a home.
-func (s *HomeService) UpdateHomeUserRole(ctx context.Context, homeID string, userID string, role string) error {
-	homeUUID, err := uuid.Parse(homeID)
+	before, err := lockHomeUser(ctx, tx, homeUUID, userUUID)
 	if err != nil {
-		return fmt.Errorf("invalid home ID: %w", err)
+		return err // pgx.ErrNoRows if the home user is not found
 	}
-	userUUID, err := uuid.Parse(userID)
-	if err != nil {
-		return fmt.Errorf("invalid user ID: %w", err)
+	if err := CanManageMember(actorRole, before.Role); err != nil {
+		return err
 	}
 
 	query := `
@@ -274,45 +326,100 @@ func (s *HomeService) UpdateHomeUserRole(ctx context.Context, homeID string, use
 		SET role = $1
 		WHERE home_id = $2 AND user_id = $3
 	`
-	result, err := s.db.Exec(ctx, query, role, homeUUID, userUUID)
-	if err != nil {
+	if _, err := tx.Exec(ctx, query, role, homeUUID, userUUID); err != nil {
 		return fmt.Errorf("failed to update home user role: %w", err)
 	}
 
-	if result.RowsAffected() == 0 {
-		return pgx.ErrNoRows // Home user not found
+	after := *before
+	after.Role = role
+	entry := audit.Entry{HomeID: &homeUUID, Action: audit.ActionUpdate, EntityType: audit.EntityHomeUser, EntityID: userUUID, Before: before, After: after}
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
 	return nil
 }
 
-// RemoveUserFromHome removes a user from a home.
-func (s *HomeService) RemoveUserFromHome(ctx context.Context, homeID string, userID string) error {
+// RemoveUserFromHome removes a user from a home, either by another member or
+// by the user leaving it. actorRole is the role of the member removing the
+// user, checked with CanManageMember under the same lock as the removal, or
+// empty if the user is leaving. It returns ErrOwnerRole when attempting to
+// remove the home's owner.
+func (s *HomeService) RemoveUserFromHome(ctx context.Context, homeID string, userID string, actorRole string) error {
 	homeUUID, err := uuid.Parse(homeID)
 	if err != nil {
 		return fmt.Errorf("invalid home ID: %w", err)
 	}
 	userUUID, err := uuid.Parse(userID)
 	if err != nil {
-		return fmt.Errorf("invalid user ID: %w", err)
+		return fmt.Errorf("%w: invalid user ID", apperrors.ErrInvalidInput)
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx) // Rollback if not committed
+
+	if err := checkNotOwner(ctx, tx, homeUUID, userUUID); err != nil {
+		return err // pgx.ErrNoRows if the home is not found
+	}
+	before, err := lockHomeUser(ctx, tx, homeUUID, userUUID)
+	if err != nil {
+		return err // pgx.ErrNoRows if the home user is not found
+	}
+	if actorRole != "" {
+		if err := CanManageMember(actorRole, before.Role); err != nil {
+			return err
+		}
 	}
 
 	query := `
 		DELETE FROM home_users
 		WHERE home_id = $1 AND user_id = $2
 	`
-	result, err := s.db.Exec(ctx, query, homeUUID, userUUID)
-	if err != nil {
+	if _, err := tx.Exec(ctx, query, homeUUID, userUUID); err != nil {
 		return fmt.Errorf("failed to remove user from home: %w", err)
 	}
 
-	if result.RowsAffected() == 0 {
-		return pgx.ErrNoRows // Home user not found
+	entry := audit.Entry{HomeID: &homeUUID, Action: audit.ActionDelete, EntityType: audit.EntityHomeUser, EntityID: userUUID, Before: before}
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
 	return nil
 }
 
+// lockHomeUser returns a membership, locking it until tx ends.
+// It returns pgx.ErrNoRows if the user is not a member of the home.
+func lockHomeUser(ctx context.Context, tx pgx.Tx, homeID uuid.UUID, userID uuid.UUID) (*models.HomeUser, error) {
+	query := `
+		SELECT home_id, user_id, role, joined_at
+		FROM home_users
+		WHERE home_id = $1 AND user_id = $2
+		FOR UPDATE
+	`
+	homeUser := &models.HomeUser{}
+	err := tx.QueryRow(ctx, query, homeID, userID).Scan(&homeUser.HomeID, &homeUser.UserID, &homeUser.Role, &homeUser.JoinedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to lock home user: %w", err)
+	}
+	return homeUser, nil
+}
+
 // CheckHomeMembership checks if a user is a member of a home and returns their role.
 func (s *HomeService) CheckHomeMembership(ctx context.Context, homeID string, userID string) (string, error) {
 	homeUUID, err := uuid.Parse(homeID)
@@ -340,3 +447,18 @@ func (s *HomeService) CheckHomeMembership(ctx context.Context, homeID string, us
 
 	return role, nil
 }
+
+// checkNotOwner returns ErrOwnerRole if the user is the owner of the home.
+// The home stays locked until tx ends, so its ownership cannot change before
+// the caller's membership change commits. It returns pgx.ErrNoRows if the
+// home does not exist.
+func checkNotOwner(ctx context.Context, tx pgx.Tx, homeID uuid.UUID, userID uuid.UUID) error {
+	home, err := lockHome(ctx, tx, homeID)
+	if err != nil {
+		return err
+	}
+	if home.OwnerID == userID {
+		return ErrOwnerRole
+	}
+	return nil
+}
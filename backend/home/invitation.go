@@ -0,0 +1,357 @@
+package home
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/m-cain/mnemo/backend/apperrors"
+	"github.com/m-cain/mnemo/backend/audit"
+	"github.com/m-cain/mnemo/backend/models"
+	"github.com/m-cain/mnemo/backend/notify"
+)
+
+// DefaultInvitationTTL is how long an invitation token stays valid.
+const DefaultInvitationTTL = 7 * 24 * time.Hour
+
+// Invitation statuses.
+const (
+	InvitationPending  = "pending"
+	InvitationAccepted = "accepted"
+	InvitationDeclined = "declined"
+	InvitationRevoked  = "revoked"
+	InvitationExpired  = "expired" // Derived from expires_at, never stored
+)
+
+var (
+	// ErrInvitationClosed is returned when an invitation has expired or was already used, declined or revoked.
+	ErrInvitationClosed = errors.New("invitation is no longer valid")
+	// ErrInvitationEmailMismatch is returned when a user accepts an invitation addressed to another email.
+	ErrInvitationEmailMismatch = errors.New("invitation was sent to a different email address")
+	// ErrAlreadyMember is returned when the invited user already belongs to the home.
+	ErrAlreadyMember = errors.New("user is already a member of this home")
+	// ErrInvitationNotSent is returned when an invitation was created but could
+	// not be delivered. The failure is recorded on the invitation.
+	ErrInvitationNotSent = errors.New("invitation was created but could not be sent")
+)
+
+// InvitationService handles invitations for users to join homes.
+type InvitationService struct {
+	db        *pgxpool.Pool
+	notifier  notify.Notifier
+	acceptURL string // Base URL of the page that accepts invitations; the token is appended as a query parameter
+	ttl       time.Duration
+}
+
+// NewInvitationService creates a new InvitationService.
+func NewInvitationService(db *pgxpool.Pool, notifier notify.Notifier, acceptURL string) *InvitationService {
+	return &InvitationService{db: db, notifier: notifier, acceptURL: acceptURL, ttl: DefaultInvitationTTL}
+}
+
+const invitationColumns = `id, home_id, email, role, invited_by, status, expires_at, responded_at, send_error, created_at`
+
+func scanInvitation(row pgx.Row) (*models.Invitation, error) {
+	var inv models.Invitation
+	err := row.Scan(&inv.ID, &inv.HomeID, &inv.Email, &inv.Role, &inv.InvitedBy, &inv.Status, &inv.ExpiresAt, &inv.RespondedAt, &inv.SendError, &inv.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if inv.Status == InvitationPending && time.Now().After(inv.ExpiresAt) {
+		inv.Status = InvitationExpired
+	}
+	return &inv, nil
+}
+
+// hashInvitationToken returns the hex-encoded SHA-256 hash under which a token is stored.
+func hashInvitationToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateInvitation invites an email address to join a home with the given role
+// and notifies the recipient. Any earlier pending invitation for the same address
+// is revoked. The inviter's role caps the roles they may grant (see CanGrantRole).
+// The recipient is notified once the invitation is committed; if that fails, the
+// failure is recorded on the invitation, which is returned with
+// ErrInvitationNotSent. Inviting the address again resends it.
+func (s *InvitationService) CreateInvitation(ctx context.Context, homeID uuid.UUID, inviterID uuid.UUID, inviterRole string, email string, role string) (*models.Invitation, error) {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != strings.TrimSpace(email) {
+		return nil, fmt.Errorf("%w: invalid email address", apperrors.ErrInvalidInput)
+	}
+	email = addr.Address
+	if err := CanGrantRole(inviterRole, role); err != nil {
+		return nil, err
+	}
+
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return nil, fmt.Errorf("failed to generate invitation token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(tokenBytes)
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx) // Rollback if not committed
+
+	var homeName string
+	err = tx.QueryRow(ctx, `SELECT name FROM homes WHERE id = $1`, homeID).Scan(&homeName)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, apperrors.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get home %s: %w", homeID, err)
+	}
+
+	memberQuery := `
+		SELECT EXISTS (
+			SELECT 1 FROM home_users hu JOIN users u ON hu.user_id = u.id
+			WHERE hu.home_id = $1 AND LOWER(u.email) = LOWER($2)
+		)
+	`
+	var isMember bool
+	if err := tx.QueryRow(ctx, memberQuery, homeID, email).Scan(&isMember); err != nil {
+		return nil, fmt.Errorf("failed to check existing membership: %w", err)
+	}
+	if isMember {
+		return nil, ErrAlreadyMember
+	}
+
+	previousQuery := `
+		SELECT ` + invitationColumns + ` FROM invitations
+		WHERE home_id = $1 AND LOWER(email) = LOWER($2) AND status = 'pending'
+		FOR UPDATE
+	`
+	rows, err := tx.Query(ctx, previousQuery, homeID, email)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get previous invitations: %w", err)
+	}
+	previous, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*models.Invitation, error) {
+		return scanInvitation(row)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan previous invitation: %w", err)
+	}
+	for _, prev := range previous {
+		prev.Status = InvitationPending // As stored; scanInvitation reports lapsed ones as expired
+		if err := closeInvitation(ctx, tx, prev, InvitationRevoked); err != nil {
+			return nil, err
+		}
+	}
+
+	insertQuery := `
+		INSERT INTO invitations (home_id, email, role, token_hash, invited_by, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING ` + invitationColumns
+	inv, err := scanInvitation(tx.QueryRow(ctx, insertQuery, homeID, email, role, hashInvitationToken(token), inviterID, time.Now().Add(s.ttl)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert invitation: %w", err)
+	}
+
+	entry := audit.Entry{HomeID: &homeID, Action: audit.ActionCreate, EntityType: audit.EntityInvitation, EntityID: inv.ID, After: inv}
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	// Sent after committing, so a slow mail server holds no locks or connections
+	msg := notify.Message{
+		To:      email,
+		Subject: fmt.Sprintf("You have been invited to %s on Mnemo", homeName),
+		Body: fmt.Sprintf("You have been invited to join the home %q as %s.\n\n"+
+			"Accept the invitation here:\n%s?token=%s\n\n"+
+			"This invitation expires on %s.",
+			homeName, role, s.acceptURL, token, inv.ExpiresAt.Format(time.RFC1123)),
+	}
+	if sendErr := s.notifier.Notify(ctx, msg); sendErr != nil {
+		reason := sendErr.Error()
+		query := `UPDATE invitations SET send_error = $1 WHERE id = $2`
+		if _, err := s.db.Exec(ctx, query, reason, inv.ID); err != nil {
+			return nil, fmt.Errorf("failed to record invitation send failure (%v): %w", sendErr, err)
+		}
+		inv.SendError = &reason
+		return inv, fmt.Errorf("%w: %w", ErrInvitationNotSent, sendErr)
+	}
+
+	return inv, nil
+}
+
+// ListInvitations lists all invitations for a home, newest first.
+func (s *InvitationService) ListInvitations(ctx context.Context, homeID uuid.UUID) ([]models.Invitation, error) {
+	query := `SELECT ` + invitationColumns + ` FROM invitations WHERE home_id = $1 ORDER BY created_at DESC`
+	rows, err := s.db.Query(ctx, query, homeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list invitations for home %s: %w", homeID, err)
+	}
+	defer rows.Close()
+
+	var invitations []models.Invitation
+	for rows.Next() {
+		inv, err := scanInvitation(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan invitation: %w", err)
+		}
+		invitations = append(invitations, *inv)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error during list invitations rows iteration: %w", err)
+	}
+
+	return invitations, nil
+}
+
+// RevokeInvitation revokes a pending invitation of a home.
+func (s *InvitationService) RevokeInvitation(ctx context.Context, homeID uuid.UUID, invitationID uuid.UUID) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx) // Rollback if not committed
+
+	query := `
+		SELECT ` + invitationColumns + ` FROM invitations
+		WHERE id = $1 AND home_id = $2 AND status = 'pending'
+		FOR UPDATE
+	`
+	inv, err := scanInvitation(tx.QueryRow(ctx, query, invitationID, homeID))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return apperrors.ErrNotFound // Invitation not found or no longer pending
+		}
+		return fmt.Errorf("failed to get invitation %s: %w", invitationID, err)
+	}
+	inv.Status = InvitationPending // As stored; scanInvitation reports lapsed ones as expired
+	if err := closeInvitation(ctx, tx, inv, InvitationRevoked); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// AcceptInvitation redeems an invitation token for the given user, adding them
+// to the home with the invited role. The user's email must match the invitation.
+func (s *InvitationService) AcceptInvitation(ctx context.Context, token string, userID uuid.UUID) (*models.Invitation, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx) // Rollback if not committed
+
+	inv, err := lockOpenInvitation(ctx, tx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	var email string
+	err = tx.QueryRow(ctx, `SELECT email FROM users WHERE id = $1`, userID).Scan(&email)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user %s: %w", userID, err)
+	}
+	if !strings.EqualFold(email, inv.Email) {
+		return nil, ErrInvitationEmailMismatch
+	}
+
+	insertQuery := `
+		INSERT INTO home_users (home_id, user_id, role, joined_at)
+		VALUES ($1, $2, $3, CURRENT_TIMESTAMP)
+		ON CONFLICT (home_id, user_id) DO NOTHING
+		RETURNING home_id, user_id, role, joined_at
+	`
+	member := &models.HomeUser{}
+	err = tx.QueryRow(ctx, insertQuery, inv.HomeID, userID, inv.Role).Scan(&member.HomeID, &member.UserID, &member.Role, &member.JoinedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrAlreadyMember
+		}
+		return nil, fmt.Errorf("failed to add user to home: %w", err)
+	}
+
+	entry := audit.Entry{HomeID: &inv.HomeID, Action: audit.ActionCreate, EntityType: audit.EntityHomeUser, EntityID: userID, After: member}
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return nil, err
+	}
+
+	if err := closeInvitation(ctx, tx, inv, InvitationAccepted); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return inv, nil
+}
+
+// DeclineInvitation declines an invitation. Holding the token is sufficient;
+// the recipient does not need an account.
+func (s *InvitationService) DeclineInvitation(ctx context.Context, token string) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx) // Rollback if not committed
+
+	inv, err := lockOpenInvitation(ctx, tx, token)
+	if err != nil {
+		return err
+	}
+	if err := closeInvitation(ctx, tx, inv, InvitationDeclined); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// lockOpenInvitation loads the invitation for a token and locks it for the rest of
+// the transaction. It returns apperrors.ErrNotFound for unknown tokens and
+// ErrInvitationClosed for invitations that are no longer pending.
+func lockOpenInvitation(ctx context.Context, tx pgx.Tx, token string) (*models.Invitation, error) {
+	query := `SELECT ` + invitationColumns + ` FROM invitations WHERE token_hash = $1 FOR UPDATE`
+	inv, err := scanInvitation(tx.QueryRow(ctx, query, hashInvitationToken(token)))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, apperrors.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get invitation: %w", err)
+	}
+	if inv.Status != InvitationPending {
+		return nil, ErrInvitationClosed
+	}
+	return inv, nil
+}
+
+// closeInvitation marks a locked pending invitation with its final status and
+// records the change in the audit log.
+func closeInvitation(ctx context.Context, tx pgx.Tx, inv *models.Invitation, status string) error {
+	before := *inv
+	query := `UPDATE invitations SET status = $1, responded_at = CURRENT_TIMESTAMP WHERE id = $2 RETURNING responded_at`
+	if err := tx.QueryRow(ctx, query, status, inv.ID).Scan(&inv.RespondedAt); err != nil {
+		return fmt.Errorf("failed to update invitation %s: %w", inv.ID, err)
+	}
+	inv.Status = status
+
+	entry := audit.Entry{HomeID: &inv.HomeID, Action: audit.ActionUpdate, EntityType: audit.EntityInvitation, EntityID: inv.ID, Before: &before, After: inv}
+	return audit.Record(ctx, tx, entry)
+}
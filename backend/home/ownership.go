@@ -0,0 +1,104 @@
+package home
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/m-cain/mnemo/backend/apperrors"
+	"github.com/m-cain/mnemo/backend/audit"
+)
+
+// OwnedHomesPolicy decides what happens to the homes a user owns when their account is deleted.
+type OwnedHomesPolicy string
+
+const (
+	// OwnedHomesTransfer hands each owned home to its highest-ranked remaining
+	// member, preferring the longest-standing one. Homes without other members
+	// are deleted.
+	OwnedHomesTransfer OwnedHomesPolicy = "transfer"
+	// OwnedHomesDelete deletes every owned home along with its contents.
+	OwnedHomesDelete OwnedHomesPolicy = "delete"
+)
+
+// ValidateOwnedHomesPolicy returns apperrors.ErrInvalidInput if policy is unknown.
+func ValidateOwnedHomesPolicy(policy OwnedHomesPolicy) error {
+	switch policy {
+	case OwnedHomesTransfer, OwnedHomesDelete:
+		return nil
+	}
+	return fmt.Errorf("%w: owned_homes must be %q or %q", apperrors.ErrInvalidInput, OwnedHomesTransfer, OwnedHomesDelete)
+}
+
+// ReleaseOwnedHomes transfers or deletes every home owned by a user, as part
+// of a transaction that deletes the user.
+func ReleaseOwnedHomes(ctx context.Context, tx pgx.Tx, userID uuid.UUID, policy OwnedHomesPolicy) error {
+	if err := ValidateOwnedHomesPolicy(policy); err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(ctx, `SELECT id FROM homes WHERE owner_id = $1 FOR UPDATE`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to list owned homes: %w", err)
+	}
+	homeIDs, err := pgx.CollectRows(rows, pgx.RowTo[uuid.UUID])
+	if err != nil {
+		return fmt.Errorf("failed to scan owned home: %w", err)
+	}
+
+	for _, homeID := range homeIDs {
+		if policy == OwnedHomesTransfer {
+			transferred, err := transferHome(ctx, tx, homeID, userID)
+			if err != nil {
+				return err
+			}
+			if transferred {
+				continue
+			}
+		}
+		if err := deleteHome(ctx, tx, homeID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// transferHome makes the highest-ranked other member of a home its owner.
+// It returns false if the home has no other members.
+func transferHome(ctx context.Context, tx pgx.Tx, homeID uuid.UUID, ownerID uuid.UUID) (bool, error) {
+	query := `
+		SELECT user_id
+		FROM home_users
+		WHERE home_id = $1 AND user_id <> $2
+		ORDER BY CASE role WHEN $3 THEN 1 WHEN $4 THEN 2 ELSE 3 END, joined_at
+		LIMIT 1
+	`
+	var successorID uuid.UUID
+	err := tx.QueryRow(ctx, query, homeID, ownerID, RoleAdmin, RoleEditor).Scan(&successorID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to find new owner for home %s: %w", homeID, err)
+	}
+
+	before, err := lockHome(ctx, tx, homeID)
+	if err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(ctx, `UPDATE homes SET owner_id = $1, updated_at = NOW() WHERE id = $2`, successorID, homeID); err != nil {
+		return false, fmt.Errorf("failed to transfer home %s: %w", homeID, err)
+	}
+	if _, err := tx.Exec(ctx, `UPDATE home_users SET role = $1 WHERE home_id = $2 AND user_id = $3`, RoleOwner, homeID, successorID); err != nil {
+		return false, fmt.Errorf("failed to update new owner's role for home %s: %w", homeID, err)
+	}
+
+	after := *before
+	after.OwnerID = successorID
+	entry := audit.Entry{HomeID: &homeID, Action: audit.ActionUpdate, EntityType: audit.EntityHome, EntityID: homeID, Before: before, After: after}
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return false, err
+	}
+	return true, nil
+}
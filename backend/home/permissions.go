@@ -0,0 +1,127 @@
+package home
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Roles a user can hold within a home, from most to least privileged.
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+// Permission names an action a home member may be allowed to perform.
+type Permission string
+
+// Permissions checked by the HTTP layer for home-scoped routes.
+const (
+	PermHomeRead       Permission = "home:read"
+	PermHomeUpdate     Permission = "home:update"
+	PermHomeDelete     Permission = "home:delete"
+	PermMembersRead    Permission = "members:read"
+	PermMembersInvite  Permission = "members:invite"
+	PermMembersManage  Permission = "members:manage"
+	PermItemsRead      Permission = "items:read"
+	PermItemsWrite     Permission = "items:write"
+	PermLocationsRead  Permission = "locations:read"
+	PermLocationsWrite Permission = "locations:write"
+	PermAuditRead      Permission = "audit:read"
+)
+
+var (
+	// ErrInvalidRole is returned when a role is not one of the known home roles.
+	ErrInvalidRole = errors.New("invalid role")
+	// ErrOwnerRole is returned when attempting to change, grant or remove the owner role.
+	// Ownership is tied to homes.owner_id and is not managed through membership updates.
+	ErrOwnerRole = errors.New("the owner role cannot be granted, changed or removed")
+	// ErrInsufficientRole is returned when the acting member's role does not allow
+	// granting the requested role or managing the target member.
+	ErrInsufficientRole = errors.New("insufficient role")
+)
+
+// roleRanks orders the roles; a higher rank is more privileged.
+var roleRanks = map[string]int{
+	RoleOwner:  4,
+	RoleAdmin:  3,
+	RoleEditor: 2,
+	RoleViewer: 1,
+}
+
+// rolePermissions is the permission matrix for home roles.
+var rolePermissions = map[string][]Permission{
+	RoleOwner: {
+		PermHomeRead, PermHomeUpdate, PermHomeDelete,
+		PermMembersRead, PermMembersInvite, PermMembersManage,
+		PermItemsRead, PermItemsWrite,
+		PermLocationsRead, PermLocationsWrite,
+		PermAuditRead,
+	},
+	RoleAdmin: {
+		PermHomeRead, PermHomeUpdate,
+		PermMembersRead, PermMembersInvite, PermMembersManage,
+		PermItemsRead, PermItemsWrite,
+		PermLocationsRead, PermLocationsWrite,
+		PermAuditRead,
+	},
+	RoleEditor: {
+		PermHomeRead,
+		PermMembersRead,
+		PermItemsRead, PermItemsWrite,
+		PermLocationsRead, PermLocationsWrite,
+	},
+	RoleViewer: {
+		PermHomeRead,
+		PermMembersRead,
+		PermItemsRead,
+		PermLocationsRead,
+	},
+}
+
+// ValidateRole returns ErrInvalidRole if role is not a known home role.
+func ValidateRole(role string) error {
+	if _, ok := roleRanks[role]; !ok {
+		return fmt.Errorf("%w: %q", ErrInvalidRole, role)
+	}
+	return nil
+}
+
+// HasPermission reports whether the given role grants perm. Unknown roles have no permissions.
+func HasPermission(role string, perm Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// CanGrantRole checks whether a member with actorRole may grant role to another member.
+// Nobody can grant the owner role, and members cannot grant roles above their own.
+func CanGrantRole(actorRole, role string) error {
+	if err := ValidateRole(role); err != nil {
+		return err
+	}
+	if role == RoleOwner {
+		return ErrOwnerRole
+	}
+	if roleRanks[actorRole] < roleRanks[role] {
+		return fmt.Errorf("%w: %s cannot grant %s", ErrInsufficientRole, actorRole, role)
+	}
+	return nil
+}
+
+// CanManageMember checks whether a member with actorRole may change the role of,
+// or remove, a member currently holding targetRole. Members can only manage
+// members ranked strictly below themselves, and the owner cannot be managed at all.
+func CanManageMember(actorRole, targetRole string) error {
+	if targetRole == RoleOwner {
+		return ErrOwnerRole
+	}
+	if roleRanks[actorRole] <= roleRanks[targetRole] {
+		return fmt.Errorf("%w: %s cannot manage %s", ErrInsufficientRole, actorRole, targetRole)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package inventory
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/m-cain/mnemo/backend/apperrors"
+	"github.com/m-cain/mnemo/backend/models"
+)
+
+// Attribute types an item type can define.
+const (
+	AttributeString  = "string"
+	AttributeNumber  = "number"
+	AttributeDate    = "date" // Calendar date as YYYY-MM-DD
+	AttributeEnum    = "enum"
+	AttributeBoolean = "boolean"
+)
+
+// attributeNamePattern restricts attribute names so they are safe to use as
+// query parameters and JSON keys.
+var attributeNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+// normalizeAttributeSchema checks that an attribute schema is well formed and
+// returns it as a non-nil slice, so it is stored as an empty JSON array.
+func normalizeAttributeSchema(fields []models.AttributeField) ([]models.AttributeField, error) {
+	if err := validateAttributeSchema(fields); err != nil {
+		return nil, err
+	}
+	if fields == nil {
+		fields = []models.AttributeField{}
+	}
+	return fields, nil
+}
+
+// validateAttributeSchema checks that an attribute schema is well formed.
+func validateAttributeSchema(fields []models.AttributeField) error {
+	seen := make(map[string]bool, len(fields))
+	for _, f := range fields {
+		if !attributeNamePattern.MatchString(f.Name) {
+			return fmt.Errorf("%w: invalid attribute name %q", apperrors.ErrInvalidInput, f.Name)
+		}
+		if seen[f.Name] {
+			return fmt.Errorf("%w: duplicate attribute %q", apperrors.ErrInvalidInput, f.Name)
+		}
+		seen[f.Name] = true
+
+		switch f.Type {
+		case AttributeString, AttributeNumber, AttributeDate, AttributeBoolean:
+			if len(f.Values) > 0 {
+				return fmt.Errorf("%w: attribute %q: values are only allowed for enums", apperrors.ErrInvalidInput, f.Name)
+			}
+		case AttributeEnum:
+			if len(f.Values) == 0 {
+				return fmt.Errorf("%w: enum attribute %q needs at least one value", apperrors.ErrInvalidInput, f.Name)
+			}
+		default:
+			return fmt.Errorf("%w: attribute %q has unknown type %q", apperrors.ErrInvalidInput, f.Name, f.Type)
+		}
+	}
+	return nil
+}
+
+// validateItemAttributes checks an item's attributes against the schema of
+// its type, which may be nil for items without a type.
+func validateItemAttributes(itemType *models.ItemType, attrs map[string]any) (map[string]any, error) {
+	var schema []models.AttributeField
+	if itemType != nil {
+		schema = itemType.AttributeSchema
+	}
+	return validateAttributes(schema, attrs)
+}
+
+// validateAttributes checks an item's attribute values against the schema of
+// its type and returns them without null values. Attributes not defined by
+// the schema are rejected, as are missing required attributes.
+func validateAttributes(schema []models.AttributeField, attrs map[string]any) (map[string]any, error) {
+	valid := make(map[string]any, len(attrs))
+	for name, v := range attrs {
+		if v == nil {
+			continue // A null value clears the attribute
+		}
+		i := slices.IndexFunc(schema, func(f models.AttributeField) bool { return f.Name == name })
+		if i < 0 {
+			return nil, fmt.Errorf("%w: unknown attribute %q", apperrors.ErrInvalidInput, name)
+		}
+		if err := checkAttributeValue(schema[i], v); err != nil {
+			return nil, err
+		}
+		valid[name] = v
+	}
+	for _, f := range schema {
+		if _, ok := valid[f.Name]; f.Required && !ok {
+			return nil, fmt.Errorf("%w: attribute %q is required", apperrors.ErrInvalidInput, f.Name)
+		}
+	}
+	return valid, nil
+}
+
+// checkAttributeValue checks a JSON-decoded value against an attribute field.
+func checkAttributeValue(f models.AttributeField, v any) error {
+	ok := false
+	switch f.Type {
+	case AttributeString:
+		_, ok = v.(string)
+	case AttributeNumber:
+		_, ok = v.(float64)
+	case AttributeBoolean:
+		_, ok = v.(bool)
+	case AttributeDate:
+		if s, isString := v.(string); isString {
+			_, err := time.Parse(time.DateOnly, s)
+			ok = err == nil
+		}
+	case AttributeEnum:
+		if s, isString := v.(string); isString {
+			ok = slices.Contains(f.Values, s)
+		}
+	}
+	if !ok {
+		if f.Type == AttributeEnum {
+			return fmt.Errorf("%w: attribute %q must be one of %v", apperrors.ErrInvalidInput, f.Name, f.Values)
+		}
+		return fmt.Errorf("%w: attribute %q must be a %s", apperrors.ErrInvalidInput, f.Name, f.Type)
+	}
+	return nil
+}
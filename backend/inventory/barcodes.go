@@ -0,0 +1,299 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/m-cain/mnemo/backend/apperrors"
+	"github.com/m-cain/mnemo/backend/audit"
+	"github.com/m-cain/mnemo/backend/models"
+)
+
+// Barcode symbologies that can be attached to items.
+const (
+	SymbologyEAN13   = "ean13"
+	SymbologyUPCA    = "upca"
+	SymbologyCode128 = "code128"
+	SymbologyQR      = "qr"
+)
+
+const (
+	// maxCode128Length is the longest Code 128 payload accepted; longer codes
+	// do not fit on a label a phone camera can read.
+	maxCode128Length = 80
+	// maxQRLength is the capacity of a version 40 QR code in byte mode.
+	maxQRLength = 2953
+)
+
+// ErrDuplicateBarcode is returned when a barcode is already attached to an item of the home.
+var ErrDuplicateBarcode = errors.New("barcode is already assigned to an item in this home")
+
+// NormalizeBarcode validates a scanned code and returns it with its
+// symbology. If symbology is empty it is detected: 13 and 12 digit codes are
+// EAN-13 and UPC-A and must carry a valid check digit, other printable ASCII
+// codes are Code 128 and anything else is a QR code.
+func NormalizeBarcode(code, symbology string) (string, string, error) {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return "", "", fmt.Errorf("%w: barcode is empty", apperrors.ErrInvalidInput)
+	}
+	if symbology == "" {
+		symbology = detectSymbology(code)
+	}
+
+	switch symbology {
+	case SymbologyEAN13, SymbologyUPCA:
+		length := 13
+		if symbology == SymbologyUPCA {
+			length = 12
+		}
+		if len(code) != length || !isDigits(code) {
+			return "", "", fmt.Errorf("%w: %s barcode must have %d digits", apperrors.ErrInvalidInput, symbology, length)
+		}
+		if !validGTINCheckDigit(code) {
+			return "", "", fmt.Errorf("%w: %s barcode %s has an invalid check digit", apperrors.ErrInvalidInput, symbology, code)
+		}
+	case SymbologyCode128:
+		if len(code) > maxCode128Length || !isPrintableASCII(code) {
+			return "", "", fmt.Errorf("%w: Code 128 barcode must be at most %d printable ASCII characters", apperrors.ErrInvalidInput, maxCode128Length)
+		}
+	case SymbologyQR:
+		if len(code) > maxQRLength {
+			return "", "", fmt.Errorf("%w: QR code must be at most %d bytes", apperrors.ErrInvalidInput, maxQRLength)
+		}
+	default:
+		return "", "", fmt.Errorf("%w: unknown barcode symbology %q", apperrors.ErrInvalidInput, symbology)
+	}
+	return code, symbology, nil
+}
+
+// detectSymbology guesses the symbology of a code without one.
+func detectSymbology(code string) string {
+	switch {
+	case isDigits(code) && len(code) == 13:
+		return SymbologyEAN13
+	case isDigits(code) && len(code) == 12:
+		return SymbologyUPCA
+	case len(code) <= maxCode128Length && isPrintableASCII(code):
+		return SymbologyCode128
+	default:
+		return SymbologyQR
+	}
+}
+
+// validGTINCheckDigit reports whether the last digit of an EAN-13 or UPC-A
+// code is its check digit. Counting from the right, digits before the check
+// digit are weighted 3, 1, 3, ...
+func validGTINCheckDigit(code string) bool {
+	sum := 0
+	for i := len(code) - 2; i >= 0; i-- {
+		d := int(code[i] - '0')
+		if (len(code)-2-i)%2 == 0 {
+			d *= 3
+		}
+		sum += d
+	}
+	return (10-sum%10)%10 == int(code[len(code)-1]-'0')
+}
+
+// barcodeLookupCode returns the form of a code that barcodes are stored and
+// matched by. A UPC-A code is the EAN-13 code with a leading zero, and
+// scanners report either form, so UPC-A codes are padded to EAN-13.
+func barcodeLookupCode(code string) string {
+	if isDigits(code) && len(code) == 12 {
+		return "0" + code
+	}
+	return code
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func isPrintableASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x20 || s[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// ListItemBarcodes retrieves the barcodes of an item of a home. It returns
+// apperrors.ErrNotFound if the item does not exist in the home.
+func (s *InventoryService) ListItemBarcodes(ctx context.Context, homeID uuid.UUID, itemID uuid.UUID) ([]models.Barcode, error) {
+	var exists bool
+	if err := s.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM items WHERE id = $1 AND home_id = $2)`, itemID, homeID).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("failed to check item: %w", err)
+	}
+	if !exists {
+		return nil, apperrors.ErrNotFound
+	}
+	return s.itemBarcodes(ctx, itemID)
+}
+
+// itemBarcodes retrieves the barcodes of an item, oldest first.
+func (s *InventoryService) itemBarcodes(ctx context.Context, itemID uuid.UUID) ([]models.Barcode, error) {
+	query := `SELECT id, item_id, code, symbology, created_at FROM item_barcodes WHERE item_id = $1 ORDER BY created_at, id`
+
+	rows, err := s.db.Query(ctx, query, itemID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query barcodes: %w", err)
+	}
+	defer rows.Close()
+
+	barcodes := []models.Barcode{}
+	for rows.Next() {
+		var barcode models.Barcode
+		if err := rows.Scan(&barcode.ID, &barcode.ItemID, &barcode.Code, &barcode.Symbology, &barcode.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan barcode row: %w", err)
+		}
+		barcodes = append(barcodes, barcode)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after scanning barcode rows: %w", err)
+	}
+
+	return barcodes, nil
+}
+
+// AddItemBarcode attaches a barcode to an item of a home. The symbology is
+// detected if empty; see NormalizeBarcode. It returns ErrDuplicateBarcode if
+// the code is already attached to an item of the home.
+func (s *InventoryService) AddItemBarcode(ctx context.Context, homeID uuid.UUID, itemID uuid.UUID, code, symbology string) (*models.Barcode, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx) // Rollback if not committed
+
+	if _, err := lockItem(ctx, tx, homeID, itemID); err != nil {
+		return nil, err
+	}
+
+	barcode, err := insertBarcode(ctx, tx, homeID, itemID, code, symbology)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return barcode, nil
+}
+
+// insertBarcode validates a barcode and attaches it to an item of a home.
+// The unique index on the lookup code rejects UPC-A and EAN-13 forms of a
+// code already attached in the home.
+func insertBarcode(ctx context.Context, tx pgx.Tx, homeID uuid.UUID, itemID uuid.UUID, code, symbology string) (*models.Barcode, error) {
+	code, symbology, err := NormalizeBarcode(code, symbology)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `INSERT INTO item_barcodes (item_id, home_id, code, lookup_code, symbology) VALUES ($1, $2, $3, $4, $5) RETURNING id, item_id, code, symbology, created_at`
+
+	var barcode models.Barcode
+	err = tx.QueryRow(ctx, query, itemID, homeID, code, barcodeLookupCode(code), symbology).Scan(&barcode.ID, &barcode.ItemID, &barcode.Code, &barcode.Symbology, &barcode.CreatedAt)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrDuplicateBarcode
+		}
+		return nil, fmt.Errorf("failed to insert barcode: %w", err)
+	}
+
+	entry := audit.Entry{HomeID: &homeID, Action: audit.ActionCreate, EntityType: audit.EntityBarcode, EntityID: barcode.ID, After: barcode}
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return nil, err
+	}
+
+	return &barcode, nil
+}
+
+// DeleteItemBarcode removes a barcode from an item of a home.
+// It returns apperrors.ErrNotFound if the item has no such barcode.
+func (s *InventoryService) DeleteItemBarcode(ctx context.Context, homeID uuid.UUID, itemID uuid.UUID, barcodeID uuid.UUID) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx) // Rollback if not committed
+
+	query := `DELETE FROM item_barcodes WHERE id = $1 AND item_id = $2 AND home_id = $3 RETURNING id, item_id, code, symbology, created_at`
+
+	var before models.Barcode
+	err = tx.QueryRow(ctx, query, barcodeID, itemID, homeID).Scan(&before.ID, &before.ItemID, &before.Code, &before.Symbology, &before.CreatedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return apperrors.ErrNotFound
+		}
+		return fmt.Errorf("failed to delete barcode: %w", err)
+	}
+
+	entry := audit.Entry{HomeID: &homeID, Action: audit.ActionDelete, EntityType: audit.EntityBarcode, EntityID: barcodeID, Before: before}
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// FindItemByBarcode retrieves the item of a home a barcode is attached to,
+// including its barcodes. UPC-A and EAN-13 forms of a code match each other.
+// It returns apperrors.ErrNotFound if no item has the barcode.
+func (s *InventoryService) FindItemByBarcode(ctx context.Context, homeID uuid.UUID, code string) (*models.Item, error) {
+	query := `
+		SELECT ` + itemColumns + `
+		FROM items i
+		JOIN item_barcodes b ON b.item_id = i.id
+		WHERE b.home_id = $1 AND b.lookup_code = $2
+	`
+
+	var item models.Item
+	err := s.db.QueryRow(ctx, query, homeID, barcodeLookupCode(strings.TrimSpace(code))).Scan(itemScanTargets(&item)...)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, apperrors.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to query item by barcode: %w", err)
+	}
+
+	if item.Barcodes, err = s.itemBarcodes(ctx, item.ID); err != nil {
+		return nil, err
+	}
+
+	return &item, nil
+}
+
+// ScanBarcode changes the quantity of the item of a home a barcode is
+// attached to, so a scanner can restock or consume an item in one call. It
+// returns apperrors.ErrNotFound if no item has the barcode.
+func (s *InventoryService) ScanBarcode(ctx context.Context, homeID uuid.UUID, code string, change QuantityChange) (*models.Item, error) {
+	item, err := s.FindItemByBarcode(ctx, homeID, code)
+	if err != nil {
+		return nil, err
+	}
+
+	updated, err := s.UpdateItemQuantity(ctx, homeID, item.ID, change)
+	if err != nil {
+		return nil, err
+	}
+	updated.Barcodes = item.Barcodes
+
+	return updated, nil
+}
@@ -0,0 +1,53 @@
+package inventory
+
+import (
+	"context"
+	"testing"
+
+	"github.com/m-cain/mnemo/backend/models"
+	"github.com/m-cain/mnemo/backend/testdb"
+)
+
+func TestBarcodeUPCAMatchesEAN13(t *testing.T) {
+	ctx := context.Background()
+	db := testdb.Open(t)
+	s := NewInventoryService(db)
+	home := seedHome(t, db, s)
+
+	if _, err := s.AddItemBarcode(ctx, home.ID, home.ItemID, "036000291452", ""); err != nil {
+		t.Fatalf("AddItemBarcode: %v", err)
+	}
+	found, err := s.FindItemByBarcode(ctx, home.ID, "0036000291452")
+	if err != nil {
+		t.Fatalf("FindItemByBarcode EAN-13 form: %v", err)
+	}
+	if found.ID != home.ItemID {
+		t.Fatalf("FindItemByBarcode EAN-13 form found item %s, want %s", found.ID, home.ItemID)
+	}
+
+	other, err := s.CreateItem(ctx, home.ID, models.Item{Name: "Sugar"})
+	if err != nil {
+		t.Fatalf("CreateItem: %v", err)
+	}
+	if _, err := s.AddItemBarcode(ctx, home.ID, other.ID, "0036000291452", ""); err != ErrDuplicateBarcode {
+		t.Fatalf("AddItemBarcode EAN-13 form error = %v, want ErrDuplicateBarcode", err)
+	}
+}
+
+func TestBarcodeLookupCode(t *testing.T) {
+	tests := []struct {
+		code string
+		want string
+	}{
+		{code: "036000291452", want: "0036000291452"},
+		{code: "0036000291452", want: "0036000291452"},
+		{code: "4006381333931", want: "4006381333931"},
+		{code: "LOT-1.5", want: "LOT-1.5"},
+		{code: "https://example.com/a/b", want: "https://example.com/a/b"},
+	}
+	for _, tt := range tests {
+		if got := barcodeLookupCode(tt.code); got != tt.want {
+			t.Errorf("barcodeLookupCode(%q) = %q, want %q", tt.code, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/m-cain/mnemo/backend/models"
+)
+
+// LoadBuiltinItemTypesFromEnv reads the built-in item types offered to every
+// home from the JSON file named by BUILTIN_ITEM_TYPES_FILE, an array of
+// objects with name, description, default_unit, canonical_unit and
+// attribute_schema. It returns nil if the variable is not set.
+func LoadBuiltinItemTypesFromEnv() ([]models.ItemType, error) {
+	path := os.Getenv("BUILTIN_ITEM_TYPES_FILE")
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read built-in item types: %w", err)
+	}
+	var itemTypes []models.ItemType
+	if err := json.Unmarshal(data, &itemTypes); err != nil {
+		return nil, fmt.Errorf("failed to parse built-in item types: %w", err)
+	}
+	for i, itemType := range itemTypes {
+		if itemType.Name == "" {
+			return nil, fmt.Errorf("built-in item type without a name in %s", path)
+		}
+		if err := validateAttributeSchema(itemType.AttributeSchema); err != nil {
+			return nil, fmt.Errorf("built-in item type %q: %w", itemType.Name, err)
+		}
+		if err := normalizeItemTypeUnits(&itemTypes[i]); err != nil {
+			return nil, fmt.Errorf("built-in item type %q: %w", itemType.Name, err)
+		}
+	}
+	return itemTypes, nil
+}
+
+// SyncBuiltinItemTypes creates the given built-in item types, or updates the
+// description, units and attribute schema of those that already exist by
+// name. Built-in types that are no longer listed are kept, since items may
+// still use them.
+func (s *InventoryService) SyncBuiltinItemTypes(ctx context.Context, itemTypes []models.ItemType) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx) // Rollback if not committed
+
+	query := `
+		INSERT INTO item_types (name, description, default_unit, canonical_unit, attribute_schema) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (name) WHERE home_id IS NULL
+		DO UPDATE SET description = EXCLUDED.description, default_unit = EXCLUDED.default_unit,
+			canonical_unit = EXCLUDED.canonical_unit, attribute_schema = EXCLUDED.attribute_schema, updated_at = CURRENT_TIMESTAMP
+	`
+	for _, itemType := range itemTypes {
+		schema, err := normalizeAttributeSchema(itemType.AttributeSchema)
+		if err != nil {
+			return fmt.Errorf("built-in item type %q: %w", itemType.Name, err)
+		}
+		if _, err := tx.Exec(ctx, query, itemType.Name, itemType.Description, itemType.DefaultUnit, itemType.CanonicalUnit, schema); err != nil {
+			return fmt.Errorf("failed to sync built-in item type %q: %w", itemType.Name, err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
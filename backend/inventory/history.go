@@ -0,0 +1,187 @@
+package inventory
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/m-cain/mnemo/backend/apperrors"
+	"github.com/m-cain/mnemo/backend/audit"
+	"github.com/m-cain/mnemo/backend/decimal"
+	"github.com/m-cain/mnemo/backend/units"
+)
+
+// Reasons recorded with each change in the item quantity ledger.
+const (
+	ReasonPurchase   = "purchase"
+	ReasonConsumed   = "consumed"
+	ReasonAdjustment = "adjustment"
+	ReasonMoved      = "moved"
+	ReasonExpired    = "expired"
+)
+
+// quantityReasons lists every reason a quantity change may be recorded with.
+var quantityReasons = []string{ReasonPurchase, ReasonConsumed, ReasonAdjustment, ReasonMoved, ReasonExpired}
+
+// QuantityChange sets an item's quantity to an absolute value or changes it
+// by a relative delta. Exactly one of Quantity and Delta must be set.
+type QuantityChange struct {
+	Quantity      *decimal.Decimal
+	Delta         *decimal.Decimal
+	Unit          string // Unit of Quantity or Delta if not the item's own, e.g. g for an item in kg
+	Reason        string // Defaults to ReasonAdjustment
+	AllowNegative bool   // Permit a resulting quantity below zero
+}
+
+// normalize applies defaults and validates the change.
+func (c *QuantityChange) normalize() error {
+	if (c.Quantity == nil) == (c.Delta == nil) {
+		return fmt.Errorf("%w: exactly one of quantity and delta is required", apperrors.ErrInvalidInput)
+	}
+	if c.Reason == "" {
+		c.Reason = ReasonAdjustment
+	}
+	if !slices.Contains(quantityReasons, c.Reason) {
+		return fmt.Errorf("%w: unknown reason %q", apperrors.ErrInvalidInput, c.Reason)
+	}
+	return nil
+}
+
+// apply returns the quantity that results from applying the change to
+// current. It returns apperrors.ErrInvalidInput if the quantity is out of the
+// range of a decimal.Decimal.
+func (c QuantityChange) apply(current decimal.Decimal) (decimal.Decimal, error) {
+	if c.Delta == nil {
+		return *c.Quantity, nil
+	}
+	quantity, err := current.Add(*c.Delta)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("%w: quantity %w", apperrors.ErrInvalidInput, err)
+	}
+	return quantity, nil
+}
+
+// quantityDelta returns the change from one quantity of an item to another.
+// It returns apperrors.ErrInvalidInput if the change is out of the range of a
+// decimal.Decimal.
+func quantityDelta(from, to decimal.Decimal) (decimal.Decimal, error) {
+	delta, err := to.Sub(from)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("%w: quantity change %w", apperrors.ErrInvalidInput, err)
+	}
+	return delta, nil
+}
+
+// recordQuantityEvent appends a change of an item's quantity to the ledger,
+// attributed to the actor in ctx. Changes with a zero delta are not recorded.
+func recordQuantityEvent(ctx context.Context, tx pgx.Tx, itemID uuid.UUID, delta decimal.Decimal, quantityAfter decimal.Decimal, reason string) error {
+	if delta.IsZero() {
+		return nil
+	}
+	userID, apiKeyID := audit.Actor(ctx)
+	query := `
+		INSERT INTO item_quantity_events (item_id, delta, quantity_after, reason, user_id, api_key_id)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+	if _, err := tx.Exec(ctx, query, itemID, delta, quantityAfter, reason, userID, apiKeyID); err != nil {
+		return fmt.Errorf("failed to record quantity change: %w", err)
+	}
+	return nil
+}
+
+// historyBuckets lists the bucket sizes accepted by ItemHistory.
+var historyBuckets = []string{"hour", "day", "week", "month"}
+
+// HistoryQuery describes the time range and bucket size for ItemHistory.
+type HistoryQuery struct {
+	Bucket string     // One of hour, day, week or month; defaults to day
+	Since  *time.Time // Inclusive
+	Until  *time.Time // Exclusive
+	Unit   string     // Unit to report quantities in, or CanonicalUnit; defaults to the item's unit
+}
+
+// QuantityBucket aggregates the quantity changes of an item within one bucket.
+type QuantityBucket struct {
+	Start    time.Time       `json:"start"`
+	Unit     string          `json:"unit"`
+	Added    decimal.Decimal `json:"added"`   // Sum of the increases
+	Removed  decimal.Decimal `json:"removed"` // Sum of the decreases, as a positive number
+	Net      decimal.Decimal `json:"net"`
+	Quantity decimal.Decimal `json:"quantity"` // Quantity after the last change in the bucket
+	Events   int             `json:"events"`
+}
+
+// ItemHistory aggregates the quantity ledger of an item of a home into time
+// buckets, in the unit of the item or the unit asked for by q. Buckets without
+// changes are omitted. It returns apperrors.ErrNotFound if the item does not
+// exist in the home, and apperrors.ErrInvalidInput if its quantities cannot be
+// converted to the unit asked for.
+func (s *InventoryService) ItemHistory(ctx context.Context, homeID uuid.UUID, itemID uuid.UUID, q HistoryQuery) ([]QuantityBucket, error) {
+	if q.Bucket == "" {
+		q.Bucket = "day"
+	}
+	if !slices.Contains(historyBuckets, q.Bucket) {
+		return nil, fmt.Errorf("%w: bucket must be one of hour, day, week or month", apperrors.ErrInvalidInput)
+	}
+
+	to, itemUnit, err := s.historyUnit(ctx, homeID, itemID, q.Unit)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT date_trunc($2, occurred_at) AS bucket,
+			COALESCE(SUM(delta) FILTER (WHERE delta > 0), 0),
+			COALESCE(-SUM(delta) FILTER (WHERE delta < 0), 0),
+			SUM(delta),
+			(array_agg(quantity_after ORDER BY occurred_at DESC, id DESC))[1],
+			COUNT(*)
+		FROM item_quantity_events
+		WHERE item_id = $1
+			AND ($3::timestamptz IS NULL OR occurred_at >= $3)
+			AND ($4::timestamptz IS NULL OR occurred_at < $4)
+		GROUP BY bucket
+		ORDER BY bucket
+	`
+	rows, err := s.db.Query(ctx, query, itemID, q.Bucket, q.Since, q.Until)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query item history: %w", err)
+	}
+	defer rows.Close()
+
+	buckets := []QuantityBucket{}
+	for rows.Next() {
+		b := QuantityBucket{Unit: itemUnit}
+		if err := rows.Scan(&b.Start, &b.Added, &b.Removed, &b.Net, &b.Quantity, &b.Events); err != nil {
+			return nil, fmt.Errorf("failed to scan item history row: %w", err)
+		}
+		if q.Unit != "" {
+			if err := b.convert(itemUnit, to); err != nil {
+				return nil, err
+			}
+		}
+		buckets = append(buckets, b)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after scanning item history rows: %w", err)
+	}
+
+	return buckets, nil
+}
+
+// convert converts the quantities of a bucket from unit to the unit to.
+func (b *QuantityBucket) convert(unit string, to units.Unit) error {
+	for _, q := range []*decimal.Decimal{&b.Added, &b.Removed, &b.Net, &b.Quantity} {
+		converted, err := units.ConvertNamed(*q, unit, to.Symbol)
+		if err != nil {
+			return fmt.Errorf("%w: %w", apperrors.ErrInvalidInput, err)
+		}
+		*q = converted
+	}
+	b.Unit = to.Symbol
+	return nil
+}
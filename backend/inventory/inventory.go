@@ -2,13 +2,36 @@ package inventory
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"maps"
+	"slices"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/m-cain/mnemo/backend/apperrors"
+	"github.com/m-cain/mnemo/backend/audit"
 	"github.com/m-cain/mnemo/backend/models"
+	"github.com/m-cain/mnemo/backend/units"
+)
+
+var (
+	// ErrNegativeQuantity is returned when a quantity change would leave an
+	// item below zero without explicitly allowing it.
+	ErrNegativeQuantity = errors.New("quantity cannot go below zero")
+	// ErrVersionMismatch is returned when an item has been modified since the
+	// version a conditional update was based on.
+	ErrVersionMismatch = errors.New("item has been modified")
+	// ErrLocationHasChildren is returned when deleting a location that has child locations.
+	ErrLocationHasChildren = errors.New("location has child locations and cannot be deleted")
+	// ErrLocationNotEmpty is returned when deleting a location that contains items.
+	ErrLocationNotEmpty = errors.New("location contains items and cannot be deleted")
+	// ErrBuiltinItemType is returned when changing a built-in item type through a home.
+	ErrBuiltinItemType = errors.New("built-in item types cannot be changed")
+	// ErrDuplicateItemType is returned when an item type name is already used in a home.
+	ErrDuplicateItemType = errors.New("an item type with this name already exists")
 )
 
 // InventoryService handles operations related to inventory items and types.
@@ -21,11 +44,20 @@ func NewInventoryService(db *pgxpool.Pool) *InventoryService {
 	return &InventoryService{db: db}
 }
 
-// ListItemTypes retrieves all item types from the database.
-func (s *InventoryService) ListItemTypes(ctx context.Context) ([]models.ItemType, error) {
-	query := `SELECT id, name, created_at, updated_at FROM item_types`
+// itemTypeColumns are the columns of an item type read by itemTypeScanTargets.
+const itemTypeColumns = `id, home_id, name, description, default_unit, canonical_unit, attribute_schema, created_at, updated_at`
+
+// itemTypeScanTargets returns the scan destinations for itemTypeColumns.
+func itemTypeScanTargets(itemType *models.ItemType) []any {
+	return []any{&itemType.ID, &itemType.HomeID, &itemType.Name, &itemType.Description, &itemType.DefaultUnit, &itemType.CanonicalUnit, &itemType.AttributeSchema, &itemType.CreatedAt, &itemType.UpdatedAt}
+}
+
+// ListItemTypes retrieves the item types available to a home: its own types
+// and the built-in types shared by every home.
+func (s *InventoryService) ListItemTypes(ctx context.Context, homeID uuid.UUID) ([]models.ItemType, error) {
+	query := `SELECT ` + itemTypeColumns + ` FROM item_types WHERE home_id = $1 OR home_id IS NULL ORDER BY name, home_id NULLS LAST`
 
-	rows, err := s.db.Query(ctx, query)
+	rows, err := s.db.Query(ctx, query, homeID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query item types: %w", err)
 	}
@@ -34,7 +66,7 @@ func (s *InventoryService) ListItemTypes(ctx context.Context) ([]models.ItemType
 	var itemTypes []models.ItemType
 	for rows.Next() {
 		var itemType models.ItemType
-		if err := rows.Scan(&itemType.ID, &itemType.Name, &itemType.CreatedAt, &itemType.UpdatedAt); err != nil {
+		if err := rows.Scan(itemTypeScanTargets(&itemType)...); err != nil {
 			return nil, fmt.Errorf("failed to scan item type row: %w", err)
 		}
 		itemTypes = append(itemTypes, itemType)
@@ -47,22 +79,80 @@ func (s *InventoryService) ListItemTypes(ctx context.Context) ([]models.ItemType
 	return itemTypes, nil
 }
 
-// ListItems retrieves all items from the database for a given home.
-func (s *InventoryService) ListItems(ctx context.Context, homeID uuid.UUID) ([]models.Item, error) {
-	// This query needs to be refined to join with locations and filter by home_id
-	// For now, a basic query is used.
-	query := `SELECT id, name, quantity, unit, location_id, item_type_id, created_at, updated_at FROM items`
+// ListItems retrieves a page of items belonging to a home, applying the
+// filters, ordering and pagination described by q.
+func (s *InventoryService) ListItems(ctx context.Context, homeID uuid.UUID, q ItemQuery) (*ItemPage, error) {
+	if err := q.normalize(); err != nil {
+		return nil, err
+	}
+
+	b := &queryBuilder{}
+	b.where("i.home_id = " + b.arg(homeID))
+	if q.Name != "" {
+		b.where("i.name ILIKE '%' || " + b.arg(escapeLike(q.Name)) + "::text || '%'")
+	}
+	if q.ItemTypeID != nil {
+		b.where("i.item_type_id = " + b.arg(*q.ItemTypeID))
+	}
+	if q.LocationID != nil {
+		b.where(`i.location_id IN (
+			WITH RECURSIVE subtree AS (
+				SELECT id FROM locations WHERE id = ` + b.arg(*q.LocationID) + `
+				UNION ALL
+				SELECT c.id FROM locations c JOIN subtree st ON c.parent_location_id = st.id
+			)
+			SELECT id FROM subtree
+		)`)
+	}
+	if q.MinQuantity != nil {
+		b.where("i.quantity >= " + b.arg(*q.MinQuantity))
+	}
+	if q.MaxQuantity != nil {
+		b.where("i.quantity <= " + b.arg(*q.MaxQuantity))
+	}
+	if q.UpdatedSince != nil {
+		b.where("i.updated_at >= " + b.arg(*q.UpdatedSince))
+	}
+	for _, name := range slices.Sorted(maps.Keys(q.Attributes)) {
+		b.where(fmt.Sprintf("i.attributes ->> %s = %s", b.arg(name), b.arg(q.Attributes[name])))
+	}
 
-	rows, err := s.db.Query(ctx, query)
+	const from = `FROM items i`
+
+	// The total reflects the filters only, not the cursor position.
+	page := &ItemPage{PerPage: q.PerPage}
+	countQuery := "SELECT COUNT(*) " + from + " " + b.whereClause()
+	if err := s.db.QueryRow(ctx, countQuery, b.args...).Scan(&page.Total); err != nil {
+		return nil, fmt.Errorf("failed to count items: %w", err)
+	}
+
+	var offset int
+	if q.Cursor != "" {
+		cursor, err := decodeItemCursor(q.Sort, q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		b.where(keysetCondition(b, q.Sort, cursor))
+	} else {
+		page.Page = q.Page
+		offset = (q.Page - 1) * q.PerPage
+	}
+
+	// Fetch one extra row to learn whether another page follows.
+	query := `SELECT ` + itemColumns + ` ` +
+		from + " " + b.whereClause() + " " + orderByClause(q.Sort) +
+		" LIMIT " + b.arg(q.PerPage+1) + " OFFSET " + b.arg(offset)
+
+	rows, err := s.db.Query(ctx, query, b.args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query items: %w", err)
 	}
 	defer rows.Close()
 
-	var items []models.Item
+	items := []models.Item{}
 	for rows.Next() {
 		var item models.Item
-		if err := rows.Scan(&item.ID, &item.Name, &item.Quantity, &item.Unit, &item.LocationID, &item.ItemTypeID, &item.CreatedAt, &item.UpdatedAt); err != nil {
+		if err := rows.Scan(itemScanTargets(&item)...); err != nil {
 			return nil, fmt.Errorf("failed to scan item row: %w", err)
 		}
 		items = append(items, item)
@@ -72,31 +162,71 @@ func (s *InventoryService) ListItems(ctx context.Context, homeID uuid.UUID) ([]m
 		return nil, fmt.Errorf("error after scanning item rows: %w", err)
 	}
 
-	return items, nil
+	if len(items) > q.PerPage {
+		items = items[:q.PerPage]
+		page.NextCursor, err = encodeItemCursor(q.Sort, items[len(items)-1])
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := s.NormalizeQuantities(ctx, homeID, q.Unit, items); err != nil {
+		return nil, err
+	}
+	page.Items = items
+
+	return page, nil
 }
 
-// CreateItemType creates a new item type in the database.
-func (s *InventoryService) CreateItemType(ctx context.Context, name string) (*models.ItemType, error) {
-	query := `INSERT INTO item_types (name) VALUES ($1) RETURNING id, name, created_at, updated_at`
+// CreateItemType creates a new item type in a home. Names are unique within
+// a home; a duplicate name returns ErrDuplicateItemType. Its units are
+// validated; see normalizeItemTypeUnits.
+func (s *InventoryService) CreateItemType(ctx context.Context, homeID uuid.UUID, itemType models.ItemType) (*models.ItemType, error) {
+	schema, err := normalizeAttributeSchema(itemType.AttributeSchema)
+	if err != nil {
+		return nil, err
+	}
+	if err := normalizeItemTypeUnits(&itemType); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx) // Rollback if not committed
 
-	var itemType models.ItemType
-	err := s.db.QueryRow(ctx, query, name).Scan(&itemType.ID, &itemType.Name, &itemType.CreatedAt, &itemType.UpdatedAt)
+	query := `INSERT INTO item_types (home_id, name, description, default_unit, canonical_unit, attribute_schema) VALUES ($1, $2, $3, $4, $5, $6) RETURNING ` + itemTypeColumns
+
+	var createdItemType models.ItemType
+	err = tx.QueryRow(ctx, query, homeID, itemType.Name, itemType.Description, itemType.DefaultUnit, itemType.CanonicalUnit, schema).Scan(itemTypeScanTargets(&createdItemType)...)
 	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrDuplicateItemType
+		}
 		return nil, fmt.Errorf("failed to insert item type: %w", err)
 	}
 
-	return &itemType, nil
+	entry := audit.Entry{HomeID: &homeID, Action: audit.ActionCreate, EntityType: audit.EntityItemType, EntityID: createdItemType.ID, After: createdItemType}
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return &createdItemType, nil
 }
 
-// GetItemTypeByID retrieves an item type by its ID from the database.
-func (s *InventoryService) GetItemTypeByID(ctx context.Context, id uuid.UUID) (*models.ItemType, error) {
-	query := `SELECT id, name, created_at, updated_at FROM item_types WHERE id = $1`
+// GetItemTypeByID retrieves an item type available to a home by its ID.
+func (s *InventoryService) GetItemTypeByID(ctx context.Context, homeID uuid.UUID, id uuid.UUID) (*models.ItemType, error) {
+	query := `SELECT ` + itemTypeColumns + ` FROM item_types WHERE id = $1 AND (home_id = $2 OR home_id IS NULL)`
 
 	var itemType models.ItemType
-	err := s.db.QueryRow(ctx, query, id).Scan(&itemType.ID, &itemType.Name, &itemType.CreatedAt, &itemType.UpdatedAt)
+	err := s.db.QueryRow(ctx, query, id, homeID).Scan(itemTypeScanTargets(&itemType)...)
 	if err != nil {
 		if err == pgx.ErrNoRows {
-			return nil, nil // Item type not found
+			return nil, apperrors.ErrNotFound // Item type not found
 		}
 		return nil, fmt.Errorf("failed to query item type by ID: %w", err)
 	}
@@ -104,38 +234,130 @@ func (s *InventoryService) GetItemTypeByID(ctx context.Context, id uuid.UUID) (*
 	return &itemType, nil
 }
 
-// UpdateItemType updates an existing item type in the database.
-func (s *InventoryService) UpdateItemType(ctx context.Context, id uuid.UUID, name string) (*models.ItemType, error) {
-	query := `UPDATE item_types SET name = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2 RETURNING id, name, created_at, updated_at`
+// UpdateItemType updates an item type of a home. Built-in item types cannot
+// be changed through a home and return ErrBuiltinItemType. Existing items are
+// checked against a changed attribute schema or canonical unit the next time
+// they are updated.
+func (s *InventoryService) UpdateItemType(ctx context.Context, homeID uuid.UUID, id uuid.UUID, itemType models.ItemType) (*models.ItemType, error) {
+	schema, err := normalizeAttributeSchema(itemType.AttributeSchema)
+	if err != nil {
+		return nil, err
+	}
+	if err := normalizeItemTypeUnits(&itemType); err != nil {
+		return nil, err
+	}
 
-	var itemType models.ItemType
-	err := s.db.QueryRow(ctx, query, name, id).Scan(&itemType.ID, &itemType.Name, &itemType.CreatedAt, &itemType.UpdatedAt)
+	tx, err := s.db.Begin(ctx)
 	if err != nil {
-		if err == pgx.ErrNoRows {
-			return nil, nil // Item type not found
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx) // Rollback if not committed
+
+	before, err := lockItemType(ctx, tx, homeID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `UPDATE item_types SET name = $1, description = $2, default_unit = $3, canonical_unit = $4, attribute_schema = $5, updated_at = CURRENT_TIMESTAMP WHERE id = $6 RETURNING ` + itemTypeColumns
+
+	var updatedItemType models.ItemType
+	err = tx.QueryRow(ctx, query, itemType.Name, itemType.Description, itemType.DefaultUnit, itemType.CanonicalUnit, schema, id).Scan(itemTypeScanTargets(&updatedItemType)...)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrDuplicateItemType
 		}
 		return nil, fmt.Errorf("failed to update item type: %w", err)
 	}
 
-	return &itemType, nil
+	entry := audit.Entry{HomeID: &homeID, Action: audit.ActionUpdate, EntityType: audit.EntityItemType, EntityID: id, Before: before, After: updatedItemType}
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return &updatedItemType, nil
 }
 
-// DeleteItemType deletes an item type by its ID from the database.
-func (s *InventoryService) DeleteItemType(ctx context.Context, id uuid.UUID) error {
-	query := `DELETE FROM item_types WHERE id = $1`
+// DeleteItemType deletes an item type of a home by its ID. Items of that type
+// are kept without a type. Built-in item types return ErrBuiltinItemType.
+func (s *InventoryService) DeleteItemType(ctx context.Context, homeID uuid.UUID, id uuid.UUID) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx) // Rollback if not committed
 
-	result, err := s.db.Exec(ctx, query, id)
+	before, err := lockItemType(ctx, tx, homeID, id)
 	if err != nil {
+		return err
+	}
+
+	query := `DELETE FROM item_types WHERE id = $1`
+	if _, err := tx.Exec(ctx, query, id); err != nil {
 		return fmt.Errorf("failed to delete item type: %w", err)
 	}
 
-	if result.RowsAffected() == 0 {
-		return pgx.ErrNoRows // Item type not found
+	entry := audit.Entry{HomeID: &homeID, Action: audit.ActionDelete, EntityType: audit.EntityItemType, EntityID: id, Before: before}
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
 	return nil
 }
 
+// lockItemType returns an item type of a home, locking it until tx ends.
+// It returns apperrors.ErrNotFound if the item type is not available to the
+// home and
+// ErrBuiltinItemType if it is a built-in type.
+func lockItemType(ctx context.Context, tx pgx.Tx, homeID uuid.UUID, id uuid.UUID) (*models.ItemType, error) {
+	query := `SELECT ` + itemTypeColumns + ` FROM item_types WHERE id = $1 AND (home_id = $2 OR home_id IS NULL) FOR UPDATE`
+
+	var itemType models.ItemType
+	err := tx.QueryRow(ctx, query, id, homeID).Scan(itemTypeScanTargets(&itemType)...)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, apperrors.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to lock item type: %w", err)
+	}
+	if itemType.HomeID == nil {
+		return nil, ErrBuiltinItemType
+	}
+
+	return &itemType, nil
+}
+
+// lookupItemType returns the item type of an item, or nil if itemTypeID is
+// nil. It returns apperrors.ErrInvalidInput if the type is not available to
+// the home.
+func lookupItemType(ctx context.Context, tx pgx.Tx, homeID uuid.UUID, itemTypeID *uuid.UUID) (*models.ItemType, error) {
+	if itemTypeID == nil {
+		return nil, nil
+	}
+	var itemType models.ItemType
+	query := `SELECT ` + itemTypeColumns + ` FROM item_types WHERE id = $1 AND (home_id = $2 OR home_id IS NULL)`
+	if err := tx.QueryRow(ctx, query, *itemTypeID, homeID).Scan(itemTypeScanTargets(&itemType)...); err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("%w: item type %s not found in this home", apperrors.ErrInvalidInput, *itemTypeID)
+		}
+		return nil, fmt.Errorf("failed to check item type: %w", err)
+	}
+	return &itemType, nil
+}
+
+// isUniqueViolation reports whether err is a PostgreSQL unique constraint violation.
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
 // ListLocationsByHome retrieves all top-level locations for a given home.
 func (s *InventoryService) ListLocationsByHome(ctx context.Context, homeID uuid.UUID) ([]models.Location, error) {
 	query := `SELECT id, name, parent_location_id, home_id, created_at, updated_at FROM locations WHERE home_id = $1 AND parent_location_id IS NULL`
@@ -162,11 +384,11 @@ func (s *InventoryService) ListLocationsByHome(ctx context.Context, homeID uuid.
 	return locations, nil
 }
 
-// ListLocationsByParent retrieves direct child locations for a given parent location.
-func (s *InventoryService) ListLocationsByParent(ctx context.Context, parentLocationID uuid.UUID) ([]models.Location, error) {
-	query := `SELECT id, name, parent_location_id, home_id, created_at, updated_at FROM locations WHERE parent_location_id = $1`
+// ListLocationsByParent retrieves direct child locations for a given parent location in a home.
+func (s *InventoryService) ListLocationsByParent(ctx context.Context, homeID uuid.UUID, parentLocationID uuid.UUID) ([]models.Location, error) {
+	query := `SELECT id, name, parent_location_id, home_id, created_at, updated_at FROM locations WHERE home_id = $1 AND parent_location_id = $2`
 
-	rows, err := s.db.Query(ctx, query, parentLocationID)
+	rows, err := s.db.Query(ctx, query, homeID, parentLocationID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query child locations: %w", err)
 	}
@@ -188,79 +410,189 @@ func (s *InventoryService) ListLocationsByParent(ctx context.Context, parentLoca
 	return locations, nil
 }
 
-// CreateLocation creates a new location in the database.
-func (s *InventoryService) CreateLocation(ctx context.Context, name string, parentLocationID *uuid.UUID, homeID uuid.UUID) (*models.Location, error) {
+// CreateLocation creates a new location in a home. The parent location, if
+// any, must belong to the same home.
+func (s *InventoryService) CreateLocation(ctx context.Context, homeID uuid.UUID, name string, parentLocationID *uuid.UUID) (*models.Location, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx) // Rollback if not committed
+
+	if err := checkLocationInHome(ctx, tx, homeID, parentLocationID); err != nil {
+		return nil, err
+	}
+
 	query := `INSERT INTO locations (name, parent_location_id, home_id) VALUES ($1, $2, $3) RETURNING id, name, parent_location_id, home_id, created_at, updated_at`
 
 	var location models.Location
-	err := s.db.QueryRow(ctx, query, name, parentLocationID, homeID).Scan(&location.ID, &location.Name, &location.ParentLocationID, &location.HomeID, &location.CreatedAt, &location.UpdatedAt)
+	err = tx.QueryRow(ctx, query, name, parentLocationID, homeID).Scan(&location.ID, &location.Name, &location.ParentLocationID, &location.HomeID, &location.CreatedAt, &location.UpdatedAt)
 	if err != nil {
 		return nil, fmt.Errorf("failed to insert location: %w", err)
 	}
 
+	entry := audit.Entry{HomeID: &homeID, Action: audit.ActionCreate, EntityType: audit.EntityLocation, EntityID: location.ID, After: location}
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
 	return &location, nil
 }
 
-// DeleteLocation deletes a location by its ID from the database.
-// It will return an error if the location has child locations.
-func (s *InventoryService) DeleteLocation(ctx context.Context, id uuid.UUID) error {
+// DeleteLocation deletes a location of a home by its ID. It returns
+// ErrLocationHasChildren or ErrLocationNotEmpty if the location still has
+// child locations, items or item lots.
+func (s *InventoryService) DeleteLocation(ctx context.Context, homeID uuid.UUID, id uuid.UUID) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx) // Rollback if not committed
+
+	before, err := lockLocation(ctx, tx, homeID, id)
+	if err != nil {
+		return err
+	}
+
 	// Check if the location has any child locations
 	countQuery := `SELECT COUNT(*) FROM locations WHERE parent_location_id = $1`
 	var count int
-	err := s.db.QueryRow(ctx, countQuery, id).Scan(&count)
+	err = tx.QueryRow(ctx, countQuery, id).Scan(&count)
 	if err != nil {
 		return fmt.Errorf("failed to check for child locations: %w", err)
 	}
 	if count > 0 {
-		return fmt.Errorf("location has child locations and cannot be deleted")
+		return ErrLocationHasChildren
 	}
 
-	// Check if the location has any items
-	itemCountQuery := `SELECT COUNT(*) FROM items WHERE location_id = $1`
+	// Check if the location has any items or lots of items kept elsewhere
+	itemCountQuery := `SELECT (SELECT COUNT(*) FROM items WHERE location_id = $1) + (SELECT COUNT(*) FROM item_lots WHERE location_id = $1)`
 	var itemCount int
-	err = s.db.QueryRow(ctx, itemCountQuery, id).Scan(&itemCount)
+	err = tx.QueryRow(ctx, itemCountQuery, id).Scan(&itemCount)
 	if err != nil {
 		return fmt.Errorf("failed to check for items in location: %w", err)
 	}
 	if itemCount > 0 {
-		return fmt.Errorf("location contains items and cannot be deleted")
+		return ErrLocationNotEmpty
 	}
 
 	query := `DELETE FROM locations WHERE id = $1`
-	result, err := s.db.Exec(ctx, query, id)
-	if err != nil {
+	if _, err := tx.Exec(ctx, query, id); err != nil {
 		return fmt.Errorf("failed to delete location: %w", err)
 	}
 
-	if result.RowsAffected() == 0 {
-		return pgx.ErrNoRows // Location not found
+	entry := audit.Entry{HomeID: &homeID, Action: audit.ActionDelete, EntityType: audit.EntityLocation, EntityID: id, Before: before}
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
 	return nil
 }
 
-// UpdateLocation updates an existing location in the database.
-func (s *InventoryService) UpdateLocation(ctx context.Context, id uuid.UUID, name string, parentLocationID *uuid.UUID) (*models.Location, error) {
+// UpdateLocation updates an existing location of a home. The new parent, if
+// any, must belong to the same home and must not be the location itself or
+// one of its descendants.
+func (s *InventoryService) UpdateLocation(ctx context.Context, homeID uuid.UUID, id uuid.UUID, name string, parentLocationID *uuid.UUID) (*models.Location, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx) // Rollback if not committed
+
+	before, err := lockLocation(ctx, tx, homeID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if parentLocationID != nil {
+		if err := checkLocationInHome(ctx, tx, homeID, parentLocationID); err != nil {
+			return nil, err
+		}
+		cycleQuery := `
+			WITH RECURSIVE subtree AS (
+				SELECT id FROM locations WHERE id = $1
+				UNION ALL
+				SELECT c.id FROM locations c JOIN subtree st ON c.parent_location_id = st.id
+			)
+			SELECT EXISTS (SELECT 1 FROM subtree WHERE id = $2)
+		`
+		var cycle bool
+		if err := tx.QueryRow(ctx, cycleQuery, id, *parentLocationID).Scan(&cycle); err != nil {
+			return nil, fmt.Errorf("failed to check location hierarchy: %w", err)
+		}
+		if cycle {
+			return nil, fmt.Errorf("%w: a location cannot be moved into itself or one of its descendants", apperrors.ErrInvalidInput)
+		}
+	}
+
 	query := `UPDATE locations SET name = $1, parent_location_id = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $3 RETURNING id, name, parent_location_id, home_id, created_at, updated_at`
 
 	var location models.Location
-	err := s.db.QueryRow(ctx, query, name, parentLocationID, id).Scan(&location.ID, &location.Name, &location.ParentLocationID, &location.HomeID, &location.CreatedAt, &location.UpdatedAt)
+	err = tx.QueryRow(ctx, query, name, parentLocationID, id).Scan(&location.ID, &location.Name, &location.ParentLocationID, &location.HomeID, &location.CreatedAt, &location.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update location: %w", err)
+	}
+
+	entry := audit.Entry{HomeID: &homeID, Action: audit.ActionUpdate, EntityType: audit.EntityLocation, EntityID: id, Before: before, After: location}
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return &location, nil
+}
+
+// lockLocation returns a location of a home, locking it until tx ends.
+// It returns apperrors.ErrNotFound if the location does not exist in the home.
+func lockLocation(ctx context.Context, tx pgx.Tx, homeID uuid.UUID, id uuid.UUID) (*models.Location, error) {
+	query := `SELECT id, name, parent_location_id, home_id, created_at, updated_at FROM locations WHERE id = $1 AND home_id = $2 FOR UPDATE`
+
+	var location models.Location
+	err := tx.QueryRow(ctx, query, id, homeID).Scan(&location.ID, &location.Name, &location.ParentLocationID, &location.HomeID, &location.CreatedAt, &location.UpdatedAt)
 	if err != nil {
 		if err == pgx.ErrNoRows {
-			return nil, apperrors.ErrNotFound // Location not found
+			return nil, apperrors.ErrNotFound
 		}
-		return nil, fmt.Errorf("failed to update location: %w", err)
+		return nil, fmt.Errorf("failed to lock location: %w", err)
 	}
 
 	return &location, nil
 }
 
-// GetLocationByID retrieves a location by its ID from the database.
-func (s *InventoryService) GetLocationByID(ctx context.Context, id uuid.UUID) (*models.Location, error) {
-	query := `SELECT id, name, parent_location_id, home_id, created_at, updated_at FROM locations WHERE id = $1`
+// checkLocationInHome returns apperrors.ErrInvalidInput unless locationID is
+// nil or refers to a location of the home.
+func checkLocationInHome(ctx context.Context, tx pgx.Tx, homeID uuid.UUID, locationID *uuid.UUID) error {
+	if locationID == nil {
+		return nil
+	}
+	var exists bool
+	query := `SELECT EXISTS (SELECT 1 FROM locations WHERE id = $1 AND home_id = $2)`
+	if err := tx.QueryRow(ctx, query, *locationID, homeID).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to check location: %w", err)
+	}
+	if !exists {
+		return fmt.Errorf("%w: location %s not found in this home", apperrors.ErrInvalidInput, *locationID)
+	}
+	return nil
+}
+
+// GetLocationByID retrieves a location of a home by its ID.
+func (s *InventoryService) GetLocationByID(ctx context.Context, homeID uuid.UUID, id uuid.UUID) (*models.Location, error) {
+	query := `SELECT id, name, parent_location_id, home_id, created_at, updated_at FROM locations WHERE id = $1 AND home_id = $2`
 
 	var location models.Location
-	err := s.db.QueryRow(ctx, query, id).Scan(&location.ID, &location.Name, &location.ParentLocationID, &location.HomeID, &location.CreatedAt, &location.UpdatedAt)
+	err := s.db.QueryRow(ctx, query, id, homeID).Scan(&location.ID, &location.Name, &location.ParentLocationID, &location.HomeID, &location.CreatedAt, &location.UpdatedAt)
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			return nil, apperrors.ErrNotFound // Location not found
@@ -271,58 +603,158 @@ func (s *InventoryService) GetLocationByID(ctx context.Context, id uuid.UUID) (*
 	return &location, nil
 }
 
-// UpdateItemQuantity updates the quantity of an existing item in the database.
-func (s *InventoryService) UpdateItemQuantity(ctx context.Context, id uuid.UUID, quantity int) error {
-	query := `UPDATE items SET quantity = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2`
+// UpdateItemQuantity sets or adjusts the quantity of an existing item and
+// records the change in the item's quantity ledger. The change is applied to
+// the stored quantity in SQL, so concurrent adjustments never overwrite each
+// other. A decrease draws down the item's lots, soonest-expiring first, before
+// its stock in no lot. A change in another unit than the item's is converted
+// to the item's unit. It returns ErrNegativeQuantity if the quantity would
+// drop below zero and the change does not allow it, and
+// apperrors.ErrInvalidInput if it would be out of range.
+func (s *InventoryService) UpdateItemQuantity(ctx context.Context, homeID uuid.UUID, id uuid.UUID, change QuantityChange) (*models.Item, error) {
+	if err := change.normalize(); err != nil {
+		return nil, err
+	}
 
-	result, err := s.db.Exec(ctx, query, quantity, id)
+	tx, err := s.db.Begin(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to update item quantity: %w", err)
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
 	}
+	defer tx.Rollback(ctx) // Rollback if not committed
 
-	if result.RowsAffected() == 0 {
-		return pgx.ErrNoRows // Item not found
+	before, err := lockItem(ctx, tx, homeID, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := change.inUnit(before.Unit); err != nil {
+		return nil, err
+	}
+	after, err := change.apply(before.Quantity)
+	if err != nil {
+		return nil, err
+	}
+	delta, err := quantityDelta(before.Quantity, after)
+	if err != nil {
+		return nil, err
+	}
+	if after.Sign() < 0 && !change.AllowNegative {
+		return nil, ErrNegativeQuantity
+	}
+	if err := drawDownLots(ctx, tx, id, delta.Neg()); err != nil {
+		return nil, err
 	}
 
-	return nil
+	query := `UPDATE items i SET quantity = i.quantity + $1, version = i.version + 1, updated_at = CURRENT_TIMESTAMP WHERE i.id = $2 RETURNING ` + itemColumns
+
+	var item models.Item
+	if err := tx.QueryRow(ctx, query, delta, id).Scan(itemScanTargets(&item)...); err != nil {
+		return nil, fmt.Errorf("failed to update item quantity: %w", err)
+	}
+
+	if err := recordQuantityEvent(ctx, tx, id, delta, item.Quantity, change.Reason); err != nil {
+		return nil, err
+	}
+	entry := audit.Entry{HomeID: &homeID, Action: audit.ActionUpdate, EntityType: audit.EntityItem, EntityID: id, Before: before, After: item}
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return &item, nil
 }
 
-// CreateItem creates a new item in the database.
-func (s *InventoryService) CreateItem(ctx context.Context, item models.Item) (*models.Item, error) {
-	query := `INSERT INTO items (name, quantity, unit, location_id, item_type_id, created_at, updated_at)
-			  VALUES ($1, $2, $3, $4, $5, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
-			  RETURNING id, name, quantity, unit, location_id, item_type_id, created_at, updated_at`
+// CreateItem creates a new item in a home. The item's location and type, if
+// any, must be available to the same home, and its attributes must match the
+// schema of its type. Its quantity must not be negative. Its unit is
+// validated against its type; see normalizeItemUnit. Purchase details are
+// validated; see normalizePurchaseDetails.
+// Barcodes given with the item are attached to it; see AddItemBarcode.
+func (s *InventoryService) CreateItem(ctx context.Context, homeID uuid.UUID, item models.Item) (*models.Item, error) {
+	if item.Quantity.Sign() < 0 {
+		return nil, fmt.Errorf("%w: quantity must not be negative", apperrors.ErrInvalidInput)
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx) // Rollback if not committed
+
+	if err := checkLocationInHome(ctx, tx, homeID, item.LocationID); err != nil {
+		return nil, err
+	}
+	itemType, err := lookupItemType(ctx, tx, homeID, item.ItemTypeID)
+	if err != nil {
+		return nil, err
+	}
+	if err := normalizeItemUnit(&item, itemType); err != nil {
+		return nil, err
+	}
+	if item.Attributes, err = validateItemAttributes(itemType, item.Attributes); err != nil {
+		return nil, err
+	}
+	if err := normalizePurchaseDetails(&item); err != nil {
+		return nil, err
+	}
+
+	query := `INSERT INTO items AS i (home_id, name, quantity, unit, location_id, item_type_id, attributes,
+			      purchase_date, purchase_price, currency, vendor, serial_number, warranty_expiry, created_at, updated_at)
+			  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+			  RETURNING ` + itemColumns
 
 	var createdItem models.Item
-	err := s.db.QueryRow(ctx, query,
+	err = tx.QueryRow(ctx, query,
+		homeID,
 		item.Name,
 		item.Quantity,
 		item.Unit,
 		item.LocationID,
 		item.ItemTypeID,
-	).Scan(
-		&createdItem.ID,
-		&createdItem.Name,
-		&createdItem.Quantity,
-		&createdItem.Unit,
-		&createdItem.LocationID,
-		&createdItem.ItemTypeID,
-		&createdItem.CreatedAt,
-		&createdItem.UpdatedAt,
-	)
+		item.Attributes,
+		item.PurchaseDate,
+		item.PurchasePrice,
+		item.Currency,
+		item.Vendor,
+		item.SerialNumber,
+		item.WarrantyExpiry,
+	).Scan(itemScanTargets(&createdItem)...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to insert item: %w", err)
 	}
 
+	// The initial stock of a new item is recorded as a purchase
+	if err := recordQuantityEvent(ctx, tx, createdItem.ID, createdItem.Quantity, createdItem.Quantity, ReasonPurchase); err != nil {
+		return nil, err
+	}
+	for _, b := range item.Barcodes {
+		barcode, err := insertBarcode(ctx, tx, homeID, createdItem.ID, b.Code, b.Symbology)
+		if err != nil {
+			return nil, err
+		}
+		createdItem.Barcodes = append(createdItem.Barcodes, *barcode)
+	}
+	entry := audit.Entry{HomeID: &homeID, Action: audit.ActionCreate, EntityType: audit.EntityItem, EntityID: createdItem.ID, After: createdItem}
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
 	return &createdItem, nil
 }
 
-// GetItemByID retrieves an item by its ID from the database.
-func (s *InventoryService) GetItemByID(ctx context.Context, id uuid.UUID) (*models.Item, error) {
-	query := `SELECT id, name, quantity, unit, location_id, item_type_id, created_at, updated_at FROM items WHERE id = $1`
+// GetItemByID retrieves an item of a home by its ID, including its barcodes
+// and lots.
+func (s *InventoryService) GetItemByID(ctx context.Context, homeID uuid.UUID, id uuid.UUID) (*models.Item, error) {
+	query := `SELECT ` + itemColumns + ` FROM items i WHERE i.id = $1 AND i.home_id = $2`
 
 	var item models.Item
-	err := s.db.QueryRow(ctx, query, id).Scan(&item.ID, &item.Name, &item.Quantity, &item.Unit, &item.LocationID, &item.ItemTypeID, &item.CreatedAt, &item.UpdatedAt)
+	err := s.db.QueryRow(ctx, query, id, homeID).Scan(itemScanTargets(&item)...)
 	if err != nil {
 		if err == pgx.ErrNoRows {
 			return nil, apperrors.ErrNotFound // Item not found
@@ -330,53 +762,177 @@ func (s *InventoryService) GetItemByID(ctx context.Context, id uuid.UUID) (*mode
 		return nil, fmt.Errorf("failed to query item by ID: %w", err)
 	}
 
+	if item.Barcodes, err = s.itemBarcodes(ctx, id); err != nil {
+		return nil, err
+	}
+	if item.Lots, err = s.itemLots(ctx, id); err != nil {
+		return nil, err
+	}
+
 	return &item, nil
 }
 
-// UpdateItem updates an existing item in the database.
-func (s *InventoryService) UpdateItem(ctx context.Context, id uuid.UUID, item models.Item) (*models.Item, error) {
-	query := `UPDATE items SET name = $1, quantity = $2, unit = $3, location_id = $4, item_type_id = $5, updated_at = CURRENT_TIMESTAMP WHERE id = $6 RETURNING id, name, quantity, unit, location_id, item_type_id, created_at, updated_at`
+// UpdateItem updates an existing item of a home. Its quantity, unit,
+// attributes and purchase details are replaced and validated as in
+// CreateItem, and a lower quantity draws down its lots as in
+// UpdateItemQuantity. A change to another unit of the same dimension converts
+// its lots and quantity history to the new unit, and its purchase price if it
+// is unchanged, since that is the price of one unit. If ifVersion is set, the
+// update only applies if the item is still at that version; otherwise
+// ErrVersionMismatch is returned.
+func (s *InventoryService) UpdateItem(ctx context.Context, homeID uuid.UUID, id uuid.UUID, item models.Item, ifVersion *int) (*models.Item, error) {
+	if item.Quantity.Sign() < 0 {
+		return nil, fmt.Errorf("%w: quantity must not be negative", apperrors.ErrInvalidInput)
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx) // Rollback if not committed
+
+	before, err := lockItem(ctx, tx, homeID, id)
+	if err != nil {
+		return nil, err
+	}
+	if ifVersion != nil && *ifVersion != before.Version {
+		return nil, ErrVersionMismatch
+	}
+	if err := checkLocationInHome(ctx, tx, homeID, item.LocationID); err != nil {
+		return nil, err
+	}
+	itemType, err := lookupItemType(ctx, tx, homeID, item.ItemTypeID)
+	if err != nil {
+		return nil, err
+	}
+	if err := normalizeItemUnit(&item, itemType); err != nil {
+		return nil, err
+	}
+	if item.Attributes, err = validateItemAttributes(itemType, item.Attributes); err != nil {
+		return nil, err
+	}
+	if err := normalizePurchaseDetails(&item); err != nil {
+		return nil, err
+	}
+	if item.PurchasePrice != nil && before.PurchasePrice != nil && *item.PurchasePrice == *before.PurchasePrice {
+		price, err := rescalePurchasePrice(*item.PurchasePrice, before.Unit, item.Unit)
+		if err != nil {
+			return nil, err
+		}
+		item.PurchasePrice = &price
+	}
+
+	query := `UPDATE items i SET name = $1, quantity = $2, unit = $3, location_id = $4, item_type_id = $5, attributes = $6,
+			      purchase_date = $7, purchase_price = $8, currency = $9, vendor = $10, serial_number = $11, warranty_expiry = $12,
+			      version = i.version + 1, updated_at = CURRENT_TIMESTAMP
+			  WHERE i.id = $13 RETURNING ` + itemColumns
 
 	var updatedItem models.Item
-	err := s.db.QueryRow(ctx, query,
+	err = tx.QueryRow(ctx, query,
 		item.Name,
 		item.Quantity,
 		item.Unit,
 		item.LocationID,
 		item.ItemTypeID,
+		item.Attributes,
+		item.PurchaseDate,
+		item.PurchasePrice,
+		item.Currency,
+		item.Vendor,
+		item.SerialNumber,
+		item.WarrantyExpiry,
 		id,
-	).Scan(
-		&updatedItem.ID,
-		&updatedItem.Name,
-		&updatedItem.Quantity,
-		&updatedItem.Unit,
-		&updatedItem.LocationID,
-		&updatedItem.ItemTypeID,
-		&updatedItem.CreatedAt,
-		&updatedItem.UpdatedAt,
-	)
+	).Scan(itemScanTargets(&updatedItem)...)
 	if err != nil {
-		if err == pgx.ErrNoRows {
-			return nil, nil // Item not found
-		}
 		return nil, fmt.Errorf("failed to update item: %w", err)
 	}
 
+	// The previous quantity is compared in the new unit
+	previous := before.Quantity
+	if updatedItem.Unit != before.Unit {
+		if err := rescaleItemQuantities(ctx, tx, id, before.Unit, updatedItem.Unit); err != nil {
+			return nil, err
+		}
+		if converted, err := units.ConvertNamed(previous, before.Unit, updatedItem.Unit); err == nil {
+			previous = converted
+		}
+	}
+	delta, err := quantityDelta(previous, updatedItem.Quantity)
+	if err != nil {
+		return nil, err
+	}
+	if err := drawDownLots(ctx, tx, id, delta.Neg()); err != nil {
+		return nil, err
+	}
+	if err := recordQuantityEvent(ctx, tx, id, delta, updatedItem.Quantity, ReasonAdjustment); err != nil {
+		return nil, err
+	}
+	entry := audit.Entry{HomeID: &homeID, Action: audit.ActionUpdate, EntityType: audit.EntityItem, EntityID: id, Before: before, After: updatedItem}
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
 	return &updatedItem, nil
 }
 
-// DeleteItem deletes an item by its ID from the database.
-func (s *InventoryService) DeleteItem(ctx context.Context, id uuid.UUID) error {
-	query := `DELETE FROM items WHERE id = $1`
+// DeleteItem deletes an item of a home by its ID.
+func (s *InventoryService) DeleteItem(ctx context.Context, homeID uuid.UUID, id uuid.UUID) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx) // Rollback if not committed
 
-	result, err := s.db.Exec(ctx, query, id)
+	before, err := lockItem(ctx, tx, homeID, id)
 	if err != nil {
+		return err
+	}
+
+	query := `DELETE FROM items WHERE id = $1`
+	if _, err := tx.Exec(ctx, query, id); err != nil {
 		return fmt.Errorf("failed to delete item: %w", err)
 	}
 
-	if result.RowsAffected() == 0 {
-		return pgx.ErrNoRows // Item not found
+	entry := audit.Entry{HomeID: &homeID, Action: audit.ActionDelete, EntityType: audit.EntityItem, EntityID: id, Before: before}
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
 	return nil
 }
+
+// itemColumns are the columns of an item read by itemScanTargets, qualified
+// with the alias i.
+const itemColumns = `i.id, i.home_id, i.name, i.quantity, i.unit, i.location_id, i.item_type_id, i.attributes, ` +
+	`i.purchase_date, i.purchase_price, i.currency, i.vendor, i.serial_number, i.warranty_expiry, i.version, i.created_at, i.updated_at`
+
+// itemScanTargets returns the scan destinations for itemColumns.
+func itemScanTargets(item *models.Item) []any {
+	return []any{&item.ID, &item.HomeID, &item.Name, &item.Quantity, &item.Unit, &item.LocationID, &item.ItemTypeID, &item.Attributes,
+		&item.PurchaseDate, &item.PurchasePrice, &item.Currency, &item.Vendor, &item.SerialNumber, &item.WarrantyExpiry, &item.Version, &item.CreatedAt, &item.UpdatedAt}
+}
+
+// lockItem returns an item of a home, locking it until tx ends.
+// It returns apperrors.ErrNotFound if the item does not exist in the home.
+func lockItem(ctx context.Context, tx pgx.Tx, homeID uuid.UUID, id uuid.UUID) (*models.Item, error) {
+	query := `SELECT ` + itemColumns + ` FROM items i WHERE i.id = $1 AND i.home_id = $2 FOR UPDATE`
+
+	var item models.Item
+	err := tx.QueryRow(ctx, query, id, homeID).Scan(itemScanTargets(&item)...)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, apperrors.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to lock item: %w", err)
+	}
+
+	return &item, nil
+}
@@ -0,0 +1,201 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/m-cain/mnemo/backend/apperrors"
+	"github.com/m-cain/mnemo/backend/decimal"
+	"github.com/m-cain/mnemo/backend/models"
+	"github.com/m-cain/mnemo/backend/testdb"
+)
+
+// testHome is a home seeded with a location, an item type and an item of
+// that type in that location.
+type testHome struct {
+	ID         uuid.UUID
+	LocationID uuid.UUID
+	ItemTypeID uuid.UUID
+	ItemID     uuid.UUID
+}
+
+// seedHome creates a user owning a new home and seeds the home's inventory.
+func seedHome(t *testing.T, db *pgxpool.Pool, s *InventoryService) testHome {
+	t.Helper()
+	ctx := context.Background()
+	userID, homeID := uuid.New(), uuid.New()
+	if _, err := db.Exec(ctx, `INSERT INTO users (id, email, name, password_hash) VALUES ($1, $2, 'Test', 'x')`,
+		userID, fmt.Sprintf("%s@example.com", userID)); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	if _, err := db.Exec(ctx, `INSERT INTO homes (id, name, owner_id) VALUES ($1, 'Test', $2)`, homeID, userID); err != nil {
+		t.Fatalf("failed to create home: %v", err)
+	}
+
+	location, err := s.CreateLocation(ctx, homeID, "Pantry", nil)
+	if err != nil {
+		t.Fatalf("CreateLocation: %v", err)
+	}
+	itemType, err := s.CreateItemType(ctx, homeID, models.ItemType{Name: "Food"})
+	if err != nil {
+		t.Fatalf("CreateItemType: %v", err)
+	}
+	item, err := s.CreateItem(ctx, homeID, models.Item{Name: "Rice", Quantity: decimal.FromInt(2), LocationID: &location.ID, ItemTypeID: &itemType.ID})
+	if err != nil {
+		t.Fatalf("CreateItem: %v", err)
+	}
+	return testHome{ID: homeID, LocationID: location.ID, ItemTypeID: itemType.ID, ItemID: item.ID}
+}
+
+// seedHomes returns a service and two homes seeded by seedHome.
+func seedHomes(t *testing.T) (*InventoryService, testHome, testHome) {
+	t.Helper()
+	db := testdb.Open(t)
+	s := NewInventoryService(db)
+	return s, seedHome(t, db, s), seedHome(t, db, s)
+}
+
+func TestItemsAreIsolatedBetweenHomes(t *testing.T) {
+	ctx := context.Background()
+	s, home, other := seedHomes(t)
+
+	if _, err := s.GetItemByID(ctx, home.ID, other.ItemID); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Errorf("GetItemByID error = %v, want ErrNotFound", err)
+	}
+
+	queries := map[string]ItemQuery{
+		"all":                  {},
+		"other home location":  {LocationID: &other.LocationID},
+		"other home item type": {ItemTypeID: &other.ItemTypeID},
+	}
+	for name, q := range queries {
+		page, err := s.ListItems(ctx, home.ID, q)
+		if err != nil {
+			t.Fatalf("ListItems %s: %v", name, err)
+		}
+		for _, item := range page.Items {
+			if item.HomeID != home.ID {
+				t.Errorf("ListItems %s returned item %s of home %s", name, item.ID, item.HomeID)
+			}
+		}
+	}
+
+	if _, err := s.UpdateItem(ctx, home.ID, other.ItemID, models.Item{Name: "Stolen", Quantity: decimal.FromInt(1)}, nil); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Errorf("UpdateItem error = %v, want ErrNotFound", err)
+	}
+	quantity := decimal.FromInt(0)
+	if _, err := s.UpdateItemQuantity(ctx, home.ID, other.ItemID, QuantityChange{Quantity: &quantity}); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Errorf("UpdateItemQuantity error = %v, want ErrNotFound", err)
+	}
+	if err := s.DeleteItem(ctx, home.ID, other.ItemID); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Errorf("DeleteItem error = %v, want ErrNotFound", err)
+	}
+
+	item, err := s.GetItemByID(ctx, other.ID, other.ItemID)
+	if err != nil {
+		t.Fatalf("GetItemByID in its own home: %v", err)
+	}
+	if item.Name != "Rice" || item.Quantity.Cmp(decimal.FromInt(2)) != 0 {
+		t.Errorf("item of the other home was changed: %+v", item)
+	}
+}
+
+func TestItemsCannotReferenceAnotherHome(t *testing.T) {
+	ctx := context.Background()
+	s, home, other := seedHomes(t)
+
+	tests := []struct {
+		name string
+		item models.Item
+	}{
+		{name: "location", item: models.Item{Name: "Flour", LocationID: &other.LocationID}},
+		{name: "item type", item: models.Item{Name: "Flour", ItemTypeID: &other.ItemTypeID}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := s.CreateItem(ctx, home.ID, tt.item); !errors.Is(err, apperrors.ErrInvalidInput) {
+				t.Errorf("CreateItem error = %v, want ErrInvalidInput", err)
+			}
+			if _, err := s.UpdateItem(ctx, home.ID, home.ItemID, tt.item, nil); !errors.Is(err, apperrors.ErrInvalidInput) {
+				t.Errorf("UpdateItem error = %v, want ErrInvalidInput", err)
+			}
+		})
+	}
+}
+
+func TestLocationsAreIsolatedBetweenHomes(t *testing.T) {
+	ctx := context.Background()
+	s, home, other := seedHomes(t)
+
+	if _, err := s.GetLocationByID(ctx, home.ID, other.LocationID); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Errorf("GetLocationByID error = %v, want ErrNotFound", err)
+	}
+	locations, err := s.ListLocationsByHome(ctx, home.ID)
+	if err != nil {
+		t.Fatalf("ListLocationsByHome: %v", err)
+	}
+	for _, location := range locations {
+		if location.HomeID != home.ID {
+			t.Errorf("ListLocationsByHome returned location %s of home %s", location.ID, location.HomeID)
+		}
+	}
+	if _, err := s.CreateLocation(ctx, other.ID, "Shelf", &other.LocationID); err != nil {
+		t.Fatalf("CreateLocation: %v", err)
+	}
+	children, err := s.ListLocationsByParent(ctx, home.ID, other.LocationID)
+	if err != nil {
+		t.Fatalf("ListLocationsByParent: %v", err)
+	}
+	if len(children) != 0 {
+		t.Errorf("ListLocationsByParent returned %d locations of another home", len(children))
+	}
+
+	if _, err := s.UpdateLocation(ctx, home.ID, other.LocationID, "Stolen", nil); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Errorf("UpdateLocation error = %v, want ErrNotFound", err)
+	}
+	if _, err := s.UpdateLocation(ctx, home.ID, home.LocationID, "Pantry", &other.LocationID); !errors.Is(err, apperrors.ErrInvalidInput) {
+		t.Errorf("UpdateLocation into another home's location error = %v, want ErrInvalidInput", err)
+	}
+	if _, err := s.CreateLocation(ctx, home.ID, "Shelf", &other.LocationID); !errors.Is(err, apperrors.ErrInvalidInput) {
+		t.Errorf("CreateLocation in another home's location error = %v, want ErrInvalidInput", err)
+	}
+	if err := s.DeleteLocation(ctx, home.ID, other.LocationID); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Errorf("DeleteLocation error = %v, want ErrNotFound", err)
+	}
+
+	location, err := s.GetLocationByID(ctx, other.ID, other.LocationID)
+	if err != nil {
+		t.Fatalf("GetLocationByID in its own home: %v", err)
+	}
+	if location.Name != "Pantry" {
+		t.Errorf("location of the other home was changed: %+v", location)
+	}
+}
+
+func TestItemTypesAreIsolatedBetweenHomes(t *testing.T) {
+	ctx := context.Background()
+	s, home, other := seedHomes(t)
+
+	if _, err := s.GetItemTypeByID(ctx, home.ID, other.ItemTypeID); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Errorf("GetItemTypeByID error = %v, want ErrNotFound", err)
+	}
+	itemTypes, err := s.ListItemTypes(ctx, home.ID)
+	if err != nil {
+		t.Fatalf("ListItemTypes: %v", err)
+	}
+	for _, itemType := range itemTypes {
+		if itemType.ID == other.ItemTypeID {
+			t.Errorf("ListItemTypes returned item type %s of another home", itemType.ID)
+		}
+	}
+	if _, err := s.UpdateItemType(ctx, home.ID, other.ItemTypeID, models.ItemType{Name: "Stolen"}); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Errorf("UpdateItemType error = %v, want ErrNotFound", err)
+	}
+	if err := s.DeleteItemType(ctx, home.ID, other.ItemTypeID); !errors.Is(err, apperrors.ErrNotFound) {
+		t.Errorf("DeleteItemType error = %v, want ErrNotFound", err)
+	}
+}
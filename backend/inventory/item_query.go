@@ -0,0 +1,249 @@
+package inventory
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/m-cain/mnemo/backend/apperrors"
+	"github.com/m-cain/mnemo/backend/decimal"
+	"github.com/m-cain/mnemo/backend/models"
+)
+
+const (
+	// DefaultItemsPerPage is the page size used when a query does not specify one.
+	DefaultItemsPerPage = 50
+	// MaxItemsPerPage caps the page size a client may request.
+	MaxItemsPerPage = 200
+)
+
+// itemSortColumns maps the sort keys accepted from clients to their SQL columns.
+var itemSortColumns = map[string]string{
+	"name":       "i.name",
+	"quantity":   "i.quantity",
+	"created_at": "i.created_at",
+	"updated_at": "i.updated_at",
+}
+
+// ItemSort is a single sort key for an item listing.
+type ItemSort struct {
+	Field string
+	Desc  bool
+}
+
+// ItemQuery describes the filters, ordering and pagination for ListItems.
+// Cursor and Page are mutually exclusive; when Cursor is set, Page is ignored.
+type ItemQuery struct {
+	Name         string           // Case-insensitive substring match on the item name
+	ItemTypeID   *uuid.UUID       // Only items of this type
+	LocationID   *uuid.UUID       // Only items in this location or any of its descendants
+	MinQuantity  *decimal.Decimal // Compared with the quantity in the item's own unit
+	MaxQuantity  *decimal.Decimal
+	UpdatedSince *time.Time
+	Attributes   map[string]string // Only items whose attributes have these values, compared as text
+	Unit         string            // Normalize quantities to this unit or CanonicalUnit; see NormalizeQuantities
+	Sort         []ItemSort
+	Page         int
+	PerPage      int
+	Cursor       string
+}
+
+// ItemPage is a single page of items returned by ListItems.
+type ItemPage struct {
+	Items      []models.Item
+	Total      int
+	Page       int
+	PerPage    int
+	NextCursor string
+}
+
+// ParseItemSort parses a comma-separated list of sort keys such as "name,-updated_at".
+// A leading "-" sorts that key in descending order.
+func ParseItemSort(s string) ([]ItemSort, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var sorts []ItemSort
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		desc := strings.HasPrefix(part, "-")
+		field := strings.TrimPrefix(part, "-")
+		if _, ok := itemSortColumns[field]; !ok {
+			return nil, fmt.Errorf("%w: unknown sort key %q", apperrors.ErrInvalidInput, field)
+		}
+		sorts = append(sorts, ItemSort{Field: field, Desc: desc})
+	}
+	return sorts, nil
+}
+
+// normalize applies defaults and validates the query.
+func (q *ItemQuery) normalize() error {
+	if q.PerPage <= 0 {
+		q.PerPage = DefaultItemsPerPage
+	}
+	if q.PerPage > MaxItemsPerPage {
+		q.PerPage = MaxItemsPerPage
+	}
+	if q.Page <= 0 {
+		q.Page = 1
+	}
+	if q.MinQuantity != nil && q.MaxQuantity != nil && q.MinQuantity.Cmp(*q.MaxQuantity) > 0 {
+		return fmt.Errorf("%w: min_quantity is greater than max_quantity", apperrors.ErrInvalidInput)
+	}
+	for name := range q.Attributes {
+		if !attributeNamePattern.MatchString(name) {
+			return fmt.Errorf("%w: invalid attribute name %q", apperrors.ErrInvalidInput, name)
+		}
+	}
+	for _, s := range q.Sort {
+		if _, ok := itemSortColumns[s.Field]; !ok {
+			return fmt.Errorf("%w: unknown sort key %q", apperrors.ErrInvalidInput, s.Field)
+		}
+	}
+	if len(q.Sort) == 0 {
+		q.Sort = []ItemSort{{Field: "name"}}
+	}
+	return nil
+}
+
+// queryBuilder accumulates SQL conditions and their positional arguments.
+type queryBuilder struct {
+	conds []string
+	args  []any
+}
+
+// arg registers a positional argument and returns its placeholder.
+func (b *queryBuilder) arg(v any) string {
+	b.args = append(b.args, v)
+	return fmt.Sprintf("$%d", len(b.args))
+}
+
+func (b *queryBuilder) where(cond string) {
+	b.conds = append(b.conds, cond)
+}
+
+func (b *queryBuilder) whereClause() string {
+	if len(b.conds) == 0 {
+		return ""
+	}
+	return "WHERE " + strings.Join(b.conds, " AND ")
+}
+
+// itemCursor is the decoded form of an opaque pagination cursor. It holds the
+// sort key values and ID of the last item on the previous page.
+type itemCursor struct {
+	Values []any     `json:"v"`
+	ID     uuid.UUID `json:"id"`
+}
+
+func encodeItemCursor(sorts []ItemSort, item models.Item) (string, error) {
+	c := itemCursor{ID: item.ID}
+	for _, s := range sorts {
+		switch s.Field {
+		case "name":
+			c.Values = append(c.Values, item.Name)
+		case "quantity":
+			c.Values = append(c.Values, item.Quantity)
+		case "created_at":
+			c.Values = append(c.Values, item.CreatedAt)
+		case "updated_at":
+			c.Values = append(c.Values, item.UpdatedAt)
+		}
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode cursor: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// decodeItemCursor decodes a cursor and converts its values back into the
+// Go types of the corresponding sort columns.
+func decodeItemCursor(sorts []ItemSort, s string) (*itemCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", apperrors.ErrInvalidInput)
+	}
+	var raw struct {
+		Values []json.RawMessage `json:"v"`
+		ID     uuid.UUID         `json:"id"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil || len(raw.Values) != len(sorts) {
+		return nil, fmt.Errorf("%w: malformed cursor", apperrors.ErrInvalidInput)
+	}
+
+	c := &itemCursor{ID: raw.ID}
+	for i, s := range sorts {
+		var v any
+		switch s.Field {
+		case "name":
+			var name string
+			err = json.Unmarshal(raw.Values[i], &name)
+			v = name
+		case "quantity":
+			var quantity decimal.Decimal
+			err = json.Unmarshal(raw.Values[i], &quantity)
+			v = quantity
+		case "created_at", "updated_at":
+			var ts time.Time
+			err = json.Unmarshal(raw.Values[i], &ts)
+			v = ts
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: malformed cursor", apperrors.ErrInvalidInput)
+		}
+		c.Values = append(c.Values, v)
+	}
+	return c, nil
+}
+
+// keysetCondition builds the condition selecting rows strictly after the
+// cursor position for the given (possibly mixed-direction) sort order.
+func keysetCondition(b *queryBuilder, sorts []ItemSort, c *itemCursor) string {
+	type key struct {
+		column string
+		desc   bool
+		value  any
+	}
+	keys := make([]key, 0, len(sorts)+1)
+	for i, s := range sorts {
+		keys = append(keys, key{column: itemSortColumns[s.Field], desc: s.Desc, value: c.Values[i]})
+	}
+	keys = append(keys, key{column: "i.id", value: c.ID})
+
+	var ors []string
+	for i, k := range keys {
+		var ands []string
+		for _, prev := range keys[:i] {
+			ands = append(ands, fmt.Sprintf("%s = %s", prev.column, b.arg(prev.value)))
+		}
+		op := ">"
+		if k.desc {
+			op = "<"
+		}
+		ands = append(ands, fmt.Sprintf("%s %s %s", k.column, op, b.arg(k.value)))
+		ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+	}
+	return "(" + strings.Join(ors, " OR ") + ")"
+}
+
+func orderByClause(sorts []ItemSort) string {
+	parts := make([]string, 0, len(sorts)+1)
+	for _, s := range sorts {
+		dir := "ASC"
+		if s.Desc {
+			dir = "DESC"
+		}
+		parts = append(parts, itemSortColumns[s.Field]+" "+dir)
+	}
+	parts = append(parts, "i.id ASC")
+	return "ORDER BY " + strings.Join(parts, ", ")
+}
+
+// escapeLike escapes the LIKE wildcard characters in s so it matches literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,361 @@
+package inventory
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/m-cain/mnemo/backend/apperrors"
+	"github.com/m-cain/mnemo/backend/audit"
+	"github.com/m-cain/mnemo/backend/decimal"
+	"github.com/m-cain/mnemo/backend/models"
+)
+
+// lotColumns are the columns of a lot read by lotScanTargets.
+const lotColumns = `id, item_id, quantity, expiry_date, opened_date, location_id, created_at, updated_at`
+
+// lotScanTargets returns the scan destinations for lotColumns.
+func lotScanTargets(lot *models.ItemLot) []any {
+	return []any{&lot.ID, &lot.ItemID, &lot.Quantity, &lot.ExpiryDate, &lot.OpenedDate, &lot.LocationID, &lot.CreatedAt, &lot.UpdatedAt}
+}
+
+// lotOrder orders lots in the order they are consumed: soonest-expiring
+// first, lots without an expiry date last, and oldest first among equals.
+const lotOrder = `expiry_date NULLS LAST, created_at, id`
+
+// ListItemLots retrieves the lots of an item of a home in the order they are
+// consumed. It returns apperrors.ErrNotFound if the item does not exist in the
+// home.
+func (s *InventoryService) ListItemLots(ctx context.Context, homeID uuid.UUID, itemID uuid.UUID) ([]models.ItemLot, error) {
+	var exists bool
+	if err := s.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM items WHERE id = $1 AND home_id = $2)`, itemID, homeID).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("failed to check item: %w", err)
+	}
+	if !exists {
+		return nil, apperrors.ErrNotFound
+	}
+	return s.itemLots(ctx, itemID)
+}
+
+// itemLots retrieves the lots of an item in the order they are consumed.
+func (s *InventoryService) itemLots(ctx context.Context, itemID uuid.UUID) ([]models.ItemLot, error) {
+	query := `SELECT ` + lotColumns + ` FROM item_lots WHERE item_id = $1 ORDER BY ` + lotOrder
+
+	rows, err := s.db.Query(ctx, query, itemID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query item lots: %w", err)
+	}
+	defer rows.Close()
+
+	lots := []models.ItemLot{}
+	for rows.Next() {
+		var lot models.ItemLot
+		if err := rows.Scan(lotScanTargets(&lot)...); err != nil {
+			return nil, fmt.Errorf("failed to scan item lot row: %w", err)
+		}
+		lots = append(lots, lot)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after scanning item lot rows: %w", err)
+	}
+
+	return lots, nil
+}
+
+// CreateItemLot adds a lot to an item of a home. The lot's quantity, which
+// must be positive, is added to the item's quantity as a purchase. It returns
+// apperrors.ErrNotFound if the item does not exist in the home.
+func (s *InventoryService) CreateItemLot(ctx context.Context, homeID uuid.UUID, itemID uuid.UUID, lot models.ItemLot) (*models.ItemLot, error) {
+	if lot.Quantity.Sign() <= 0 {
+		return nil, fmt.Errorf("%w: lot quantity must be positive", apperrors.ErrInvalidInput)
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx) // Rollback if not committed
+
+	item, err := lockItem(ctx, tx, homeID, itemID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkLocationInHome(ctx, tx, homeID, lot.LocationID); err != nil {
+		return nil, err
+	}
+
+	query := `
+		INSERT INTO item_lots (item_id, home_id, quantity, expiry_date, opened_date, location_id)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING ` + lotColumns
+
+	var created models.ItemLot
+	err = tx.QueryRow(ctx, query, itemID, homeID, lot.Quantity, lot.ExpiryDate, lot.OpenedDate, lot.LocationID).Scan(lotScanTargets(&created)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert item lot: %w", err)
+	}
+
+	entry := audit.Entry{HomeID: &homeID, Action: audit.ActionCreate, EntityType: audit.EntityItemLot, EntityID: created.ID, After: created}
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return nil, err
+	}
+	if err := changeItemQuantity(ctx, tx, homeID, item, created.Quantity, ReasonPurchase); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return &created, nil
+}
+
+// UpdateItemLot replaces the quantity, dates and location of a lot of an item
+// of a home. A change of the lot's quantity, which must stay positive, changes
+// the item's quantity by as much. It returns apperrors.ErrNotFound if the item
+// has no such lot.
+func (s *InventoryService) UpdateItemLot(ctx context.Context, homeID uuid.UUID, itemID uuid.UUID, lotID uuid.UUID, lot models.ItemLot) (*models.ItemLot, error) {
+	if lot.Quantity.Sign() <= 0 {
+		return nil, fmt.Errorf("%w: lot quantity must be positive", apperrors.ErrInvalidInput)
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx) // Rollback if not committed
+
+	item, err := lockItem(ctx, tx, homeID, itemID)
+	if err != nil {
+		return nil, err
+	}
+	before, err := lockLot(ctx, tx, itemID, lotID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkLocationInHome(ctx, tx, homeID, lot.LocationID); err != nil {
+		return nil, err
+	}
+
+	query := `
+		UPDATE item_lots
+		SET quantity = $1, expiry_date = $2, opened_date = $3, location_id = $4, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $5
+		RETURNING ` + lotColumns
+
+	var updated models.ItemLot
+	err = tx.QueryRow(ctx, query, lot.Quantity, lot.ExpiryDate, lot.OpenedDate, lot.LocationID, lotID).Scan(lotScanTargets(&updated)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update item lot: %w", err)
+	}
+
+	entry := audit.Entry{HomeID: &homeID, Action: audit.ActionUpdate, EntityType: audit.EntityItemLot, EntityID: lotID, Before: before, After: updated}
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return nil, err
+	}
+	delta, err := quantityDelta(before.Quantity, updated.Quantity)
+	if err != nil {
+		return nil, err
+	}
+	if err := changeItemQuantity(ctx, tx, homeID, item, delta, ReasonAdjustment); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return &updated, nil
+}
+
+// DeleteItemLot removes a lot of an item of a home, e.g. when it was thrown
+// away, and takes its quantity off the item's quantity. The change is
+// recorded with reason, ReasonAdjustment if empty. It returns
+// apperrors.ErrNotFound if the item has no such lot.
+func (s *InventoryService) DeleteItemLot(ctx context.Context, homeID uuid.UUID, itemID uuid.UUID, lotID uuid.UUID, reason string) error {
+	if reason == "" {
+		reason = ReasonAdjustment
+	}
+	if !slices.Contains(quantityReasons, reason) {
+		return fmt.Errorf("%w: unknown reason %q", apperrors.ErrInvalidInput, reason)
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx) // Rollback if not committed
+
+	item, err := lockItem(ctx, tx, homeID, itemID)
+	if err != nil {
+		return err
+	}
+	before, err := lockLot(ctx, tx, itemID, lotID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM item_lots WHERE id = $1`, lotID); err != nil {
+		return fmt.Errorf("failed to delete item lot: %w", err)
+	}
+
+	entry := audit.Entry{HomeID: &homeID, Action: audit.ActionDelete, EntityType: audit.EntityItemLot, EntityID: lotID, Before: before}
+	if err := audit.Record(ctx, tx, entry); err != nil {
+		return err
+	}
+	if err := changeItemQuantity(ctx, tx, homeID, item, before.Quantity.Neg(), reason); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// lockLot returns a lot of an item, locking it until tx ends.
+// It returns apperrors.ErrNotFound if the item has no such lot.
+func lockLot(ctx context.Context, tx pgx.Tx, itemID uuid.UUID, lotID uuid.UUID) (*models.ItemLot, error) {
+	query := `SELECT ` + lotColumns + ` FROM item_lots WHERE id = $1 AND item_id = $2 FOR UPDATE`
+
+	var lot models.ItemLot
+	if err := tx.QueryRow(ctx, query, lotID, itemID).Scan(lotScanTargets(&lot)...); err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, apperrors.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to lock item lot: %w", err)
+	}
+
+	return &lot, nil
+}
+
+// changeItemQuantity changes the quantity of a locked item by delta after a
+// change to its lots, and records the change in the ledger and audit log. It
+// returns apperrors.ErrInvalidInput if the quantity would be out of range.
+func changeItemQuantity(ctx context.Context, tx pgx.Tx, homeID uuid.UUID, before *models.Item, delta decimal.Decimal, reason string) error {
+	if delta.IsZero() {
+		return nil
+	}
+	// The item is locked, so the stored quantity is still before.Quantity
+	if _, err := (QuantityChange{Delta: &delta}).apply(before.Quantity); err != nil {
+		return err
+	}
+
+	query := `UPDATE items i SET quantity = i.quantity + $1, version = i.version + 1, updated_at = CURRENT_TIMESTAMP WHERE i.id = $2 RETURNING ` + itemColumns
+
+	var item models.Item
+	if err := tx.QueryRow(ctx, query, delta, before.ID).Scan(itemScanTargets(&item)...); err != nil {
+		return fmt.Errorf("failed to update item quantity: %w", err)
+	}
+
+	if err := recordQuantityEvent(ctx, tx, item.ID, delta, item.Quantity, reason); err != nil {
+		return err
+	}
+	entry := audit.Entry{HomeID: &homeID, Action: audit.ActionUpdate, EntityType: audit.EntityItem, EntityID: item.ID, Before: before, After: item}
+	return audit.Record(ctx, tx, entry)
+}
+
+// drawDownLots takes amount off the lots of a locked item in the order they
+// are consumed, deleting lots that run out. Any amount beyond the lots'
+// total comes out of the stock in no lot.
+func drawDownLots(ctx context.Context, tx pgx.Tx, itemID uuid.UUID, amount decimal.Decimal) error {
+	if amount.Sign() <= 0 {
+		return nil
+	}
+
+	query := `SELECT id, quantity FROM item_lots WHERE item_id = $1 ORDER BY ` + lotOrder + ` FOR UPDATE`
+	rows, err := tx.Query(ctx, query, itemID)
+	if err != nil {
+		return fmt.Errorf("failed to query item lots: %w", err)
+	}
+	type lotQuantity struct {
+		ID       uuid.UUID
+		Quantity decimal.Decimal
+	}
+	lots, err := pgx.CollectRows(rows, pgx.RowToStructByPos[lotQuantity])
+	if err != nil {
+		return fmt.Errorf("failed to scan item lots: %w", err)
+	}
+
+	for _, lot := range lots {
+		if amount.IsZero() {
+			break
+		}
+		if lot.Quantity.Cmp(amount) <= 0 {
+			if _, err := tx.Exec(ctx, `DELETE FROM item_lots WHERE id = $1`, lot.ID); err != nil {
+				return fmt.Errorf("failed to delete item lot: %w", err)
+			}
+			amount, _ = amount.Sub(lot.Quantity) // In range, since the lot is no larger
+			continue
+		}
+		query := `UPDATE item_lots SET quantity = quantity - $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2`
+		if _, err := tx.Exec(ctx, query, amount, lot.ID); err != nil {
+			return fmt.Errorf("failed to update item lot: %w", err)
+		}
+		amount = decimal.Zero
+	}
+
+	return nil
+}
+
+// ExpiringLot is a lot listed by ListExpiringLots, with the name and unit of
+// its item.
+type ExpiringLot struct {
+	models.ItemLot
+	ItemName   string          `json:"item_name"`
+	Unit       string          `json:"unit"`
+	Normalized *models.Measure `json:"normalized,omitempty"` // Quantity in the unit the listing was asked for
+	Expired    bool            `json:"expired"`              // The expiry date has passed
+}
+
+// ListExpiringLots retrieves the lots of the items of a home that expire
+// within the given number of days, soonest first. Lots that have already
+// expired are included, since they are likely still on the shelf. Their
+// quantities are normalized to unit as by NormalizeQuantities.
+func (s *InventoryService) ListExpiringLots(ctx context.Context, homeID uuid.UUID, withinDays int, unit string) ([]ExpiringLot, error) {
+	if withinDays < 0 {
+		return nil, fmt.Errorf("%w: expiry window must not be negative", apperrors.ErrInvalidInput)
+	}
+	n, err := s.newQuantityNormalizer(ctx, homeID, unit)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT l.id, l.item_id, l.quantity, l.expiry_date, l.opened_date, l.location_id, l.created_at, l.updated_at,
+			i.name, i.unit, i.item_type_id, l.expiry_date < CURRENT_DATE
+		FROM item_lots l
+		JOIN items i ON i.id = l.item_id
+		WHERE l.home_id = $1 AND l.expiry_date <= CURRENT_DATE + $2::int
+		ORDER BY l.expiry_date, i.name, l.created_at, l.id
+	`
+
+	rows, err := s.db.Query(ctx, query, homeID, withinDays)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query expiring lots: %w", err)
+	}
+	defer rows.Close()
+
+	lots := []ExpiringLot{}
+	for rows.Next() {
+		var lot ExpiringLot
+		var itemTypeID *uuid.UUID
+		if err := rows.Scan(append(lotScanTargets(&lot.ItemLot), &lot.ItemName, &lot.Unit, &itemTypeID, &lot.Expired)...); err != nil {
+			return nil, fmt.Errorf("failed to scan expiring lot row: %w", err)
+		}
+		if n != nil {
+			lot.Normalized = n.normalize(lot.Quantity, lot.Unit, itemTypeID)
+		}
+		lots = append(lots, lot)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after scanning expiring lot rows: %w", err)
+	}
+
+	return lots, nil
+}
@@ -0,0 +1,367 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/m-cain/mnemo/backend/apperrors"
+	"github.com/m-cain/mnemo/backend/decimal"
+	"github.com/m-cain/mnemo/backend/models"
+	"github.com/m-cain/mnemo/backend/units"
+)
+
+// CanonicalUnit may be given instead of a unit to normalize quantities to the
+// canonical unit of each item's type.
+const CanonicalUnit = "canonical"
+
+// normalizeItemTypeUnits validates the units of an item type. Known units are
+// replaced by their symbol. The canonical unit must be a known unit, and the
+// default unit, if any, must be convertible to it.
+func normalizeItemTypeUnits(itemType *models.ItemType) error {
+	itemType.DefaultUnit = trimmedOrNil(itemType.DefaultUnit)
+	if itemType.DefaultUnit != nil {
+		unit := units.Normalize(*itemType.DefaultUnit)
+		itemType.DefaultUnit = &unit
+	}
+	itemType.CanonicalUnit = trimmedOrNil(itemType.CanonicalUnit)
+	if itemType.CanonicalUnit == nil {
+		return nil
+	}
+	canonical, ok := units.Lookup(*itemType.CanonicalUnit)
+	if !ok {
+		return fmt.Errorf("%w: unknown canonical unit %q", apperrors.ErrInvalidInput, *itemType.CanonicalUnit)
+	}
+	itemType.CanonicalUnit = &canonical.Symbol
+	if itemType.DefaultUnit != nil {
+		if err := checkConvertible(*itemType.DefaultUnit, canonical); err != nil {
+			return fmt.Errorf("%w: default unit: %w", apperrors.ErrInvalidInput, err)
+		}
+	}
+	return nil
+}
+
+// normalizeItemUnit validates the unit of an item of the given type, which
+// may be nil. An item without a unit takes the default unit of its type, or
+// else its canonical unit. Known units are replaced by their symbol, and an
+// item whose type has a canonical unit must be in a unit convertible to it.
+func normalizeItemUnit(item *models.Item, itemType *models.ItemType) error {
+	item.Unit = units.Normalize(item.Unit)
+	if item.Unit == "" && itemType != nil {
+		switch {
+		case itemType.DefaultUnit != nil:
+			item.Unit = *itemType.DefaultUnit
+		case itemType.CanonicalUnit != nil:
+			item.Unit = *itemType.CanonicalUnit
+		}
+	}
+	if itemType == nil || itemType.CanonicalUnit == nil {
+		return nil
+	}
+	canonical, ok := units.Lookup(*itemType.CanonicalUnit)
+	if !ok {
+		return nil // Not validated before canonical units were
+	}
+	if err := checkConvertible(item.Unit, canonical); err != nil {
+		return fmt.Errorf("%w: %w", apperrors.ErrInvalidInput, err)
+	}
+	return nil
+}
+
+// checkConvertible returns an error unless quantities in unit can be
+// converted to the unit to.
+func checkConvertible(unit string, to units.Unit) error {
+	from, ok := units.Lookup(unit)
+	if !ok {
+		return fmt.Errorf("unit %q cannot be converted to %s", unit, to.Symbol)
+	}
+	if from.Dimension != to.Dimension {
+		return fmt.Errorf("unit %s measures %s, not %s like %s", from.Symbol, from.Dimension, to.Dimension, to.Symbol)
+	}
+	return nil
+}
+
+// inUnit converts the quantity or delta of a change given in c.Unit to unit,
+// the unit of the item it applies to.
+func (c *QuantityChange) inUnit(unit string) error {
+	if c.Unit == "" {
+		return nil
+	}
+	for _, q := range []*decimal.Decimal{c.Quantity, c.Delta} {
+		if q == nil {
+			continue
+		}
+		converted, err := units.ConvertNamed(*q, c.Unit, unit)
+		if err != nil {
+			return fmt.Errorf("%w: %w", apperrors.ErrInvalidInput, err)
+		}
+		*q = converted
+	}
+	return nil
+}
+
+// rescaleItemQuantities converts the lots and quantity ledger of a locked item
+// whose unit changes from one known unit to another of the same dimension, so
+// they stay in the unit of the item. Other unit changes leave them as they are.
+func rescaleItemQuantities(ctx context.Context, tx pgx.Tx, itemID uuid.UUID, fromUnit, toUnit string) error {
+	from, fromOK := units.Lookup(fromUnit)
+	to, toOK := units.Lookup(toUnit)
+	if !fromOK || !toOK || from.Dimension != to.Dimension || from.Symbol == to.Symbol {
+		return nil
+	}
+
+	// Lots are kept positive even if a tiny lot rounds to nothing
+	query := `
+		UPDATE item_lots
+		SET quantity = GREATEST(ROUND(quantity * $2::numeric / $3::numeric, 6), 0.000001), updated_at = CURRENT_TIMESTAMP
+		WHERE item_id = $1
+	`
+	if _, err := tx.Exec(ctx, query, itemID, from.Factor(), to.Factor()); err != nil {
+		return fmt.Errorf("failed to convert item lots: %w", err)
+	}
+	query = `
+		UPDATE item_quantity_events
+		SET delta = ROUND(delta * $2::numeric / $3::numeric, 6), quantity_after = ROUND(quantity_after * $2::numeric / $3::numeric, 6)
+		WHERE item_id = $1
+	`
+	if _, err := tx.Exec(ctx, query, itemID, from.Factor(), to.Factor()); err != nil {
+		return fmt.Errorf("failed to convert item quantity history: %w", err)
+	}
+	return nil
+}
+
+// rescalePurchasePrice converts the price of one fromUnit to the price of one
+// toUnit, rounded half away from zero to minor units, e.g. a price of 1500 per
+// kg to 2 per g. Like rescaleItemQuantities, it leaves the price as it is
+// unless both units are known and of the same dimension.
+func rescalePurchasePrice(price int64, fromUnit, toUnit string) (int64, error) {
+	from, fromOK := units.Lookup(fromUnit)
+	to, toOK := units.Lookup(toUnit)
+	if !fromOK || !toOK || from.Dimension != to.Dimension || from.Symbol == to.Symbol {
+		return price, nil
+	}
+
+	r, _ := new(big.Rat).SetString(to.Factor())
+	f, _ := new(big.Rat).SetString(from.Factor())
+	r.Mul(r, new(big.Rat).SetInt64(price)).Quo(r, f)
+	quo, rem := new(big.Int).QuoRem(r.Num(), r.Denom(), new(big.Int))
+	if new(big.Int).Mul(new(big.Int).Abs(rem), big.NewInt(2)).Cmp(r.Denom()) >= 0 {
+		quo.Add(quo, big.NewInt(int64(r.Num().Sign())))
+	}
+	if !quo.IsInt64() {
+		return 0, fmt.Errorf("%w: purchase price is out of range in %s", apperrors.ErrInvalidInput, to.Symbol)
+	}
+	return quo.Int64(), nil
+}
+
+// quantityNormalizer converts quantities to the unit a listing was asked for:
+// a single unit, or the canonical unit of each item's type.
+type quantityNormalizer struct {
+	unit      *units.Unit
+	canonical map[uuid.UUID]units.Unit // Canonical units by item type ID
+}
+
+// newQuantityNormalizer returns a normalizer to unit, a unit or
+// CanonicalUnit, for the items of a home. It returns nil if unit is empty.
+func (s *InventoryService) newQuantityNormalizer(ctx context.Context, homeID uuid.UUID, unit string) (*quantityNormalizer, error) {
+	if unit == "" {
+		return nil, nil
+	}
+	if unit != CanonicalUnit {
+		u, ok := units.Lookup(unit)
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown unit %q", apperrors.ErrInvalidInput, unit)
+		}
+		return &quantityNormalizer{unit: &u}, nil
+	}
+
+	query := `SELECT id, canonical_unit FROM item_types WHERE (home_id = $1 OR home_id IS NULL) AND canonical_unit IS NOT NULL`
+	rows, err := s.db.Query(ctx, query, homeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query canonical units: %w", err)
+	}
+	defer rows.Close()
+
+	n := &quantityNormalizer{canonical: make(map[uuid.UUID]units.Unit)}
+	for rows.Next() {
+		var id uuid.UUID
+		var symbol string
+		if err := rows.Scan(&id, &symbol); err != nil {
+			return nil, fmt.Errorf("failed to scan canonical unit row: %w", err)
+		}
+		if u, ok := units.Lookup(symbol); ok {
+			n.canonical[id] = u
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after scanning canonical unit rows: %w", err)
+	}
+
+	return n, nil
+}
+
+// target returns the unit quantities of items of the given type are
+// normalized to, if any.
+func (n *quantityNormalizer) target(itemTypeID *uuid.UUID) (units.Unit, bool) {
+	if n.unit != nil {
+		return *n.unit, true
+	}
+	if itemTypeID == nil {
+		return units.Unit{}, false
+	}
+	u, ok := n.canonical[*itemTypeID]
+	return u, ok
+}
+
+// normalize converts a quantity in unit of an item of the given type. It
+// returns nil if the quantity cannot be converted.
+func (n *quantityNormalizer) normalize(quantity decimal.Decimal, unit string, itemTypeID *uuid.UUID) *models.Measure {
+	to, ok := n.target(itemTypeID)
+	if !ok {
+		return nil
+	}
+	converted, err := units.ConvertNamed(quantity, unit, to.Symbol)
+	if err != nil {
+		return nil
+	}
+	return &models.Measure{Quantity: converted, Unit: to.Symbol}
+}
+
+// NormalizeQuantities sets the normalized quantity of items of a home to
+// their quantity in unit, a unit or CanonicalUnit. Items whose quantity
+// cannot be converted are left without one. An empty unit does nothing.
+func (s *InventoryService) NormalizeQuantities(ctx context.Context, homeID uuid.UUID, unit string, items []models.Item) error {
+	n, err := s.newQuantityNormalizer(ctx, homeID, unit)
+	if err != nil || n == nil {
+		return err
+	}
+	for i := range items {
+		items[i].Normalized = n.normalize(items[i].Quantity, items[i].Unit, items[i].ItemTypeID)
+	}
+	return nil
+}
+
+// QuantityTotal is the total quantity of the items of one type, or of the
+// items without a type when ItemTypeID is nil. Totals has one measure per
+// unit; with a unit to total in, every convertible quantity is in that unit.
+type QuantityTotal struct {
+	ItemTypeID   *uuid.UUID       `json:"item_type_id"`
+	ItemTypeName *string          `json:"item_type_name"`
+	ItemCount    int              `json:"item_count"`
+	Totals       []models.Measure `json:"totals"`
+}
+
+// QuantityTotals totals the quantities of the items of a home by item type,
+// converted to unit, a unit or CanonicalUnit. With an empty unit, quantities
+// are totalled per unit as they are. Items with a negative quantity count as
+// none.
+func (s *InventoryService) QuantityTotals(ctx context.Context, homeID uuid.UUID, unit string) ([]QuantityTotal, error) {
+	n, err := s.newQuantityNormalizer(ctx, homeID, unit)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT i.item_type_id, t.name, i.unit, SUM(GREATEST(i.quantity, 0)), COUNT(*)
+		FROM items i
+		LEFT JOIN item_types t ON t.id = i.item_type_id
+		WHERE i.home_id = $1
+		GROUP BY i.item_type_id, t.name, i.unit
+		ORDER BY t.name NULLS LAST, i.item_type_id, i.unit
+	`
+
+	rows, err := s.db.Query(ctx, query, homeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query quantity totals: %w", err)
+	}
+	defer rows.Close()
+
+	totals := []QuantityTotal{}
+	for rows.Next() {
+		var itemTypeID *uuid.UUID
+		var itemTypeName *string
+		var measure models.Measure
+		var count int
+		if err := rows.Scan(&itemTypeID, &itemTypeName, &measure.Unit, &measure.Quantity, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan quantity total row: %w", err)
+		}
+		if n != nil {
+			if normalized := n.normalize(measure.Quantity, measure.Unit, itemTypeID); normalized != nil {
+				measure = *normalized
+			}
+		}
+
+		// Rows are ordered by type, so a type's rows follow each other
+		if len(totals) == 0 || !sameItemType(totals[len(totals)-1].ItemTypeID, itemTypeID) {
+			totals = append(totals, QuantityTotal{ItemTypeID: itemTypeID, ItemTypeName: itemTypeName, Totals: []models.Measure{}})
+		}
+		total := &totals[len(totals)-1]
+		total.ItemCount += count
+		if total.Totals, err = addMeasure(total.Totals, measure); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after scanning quantity total rows: %w", err)
+	}
+
+	return totals, nil
+}
+
+// sameItemType reports whether two nullable item type IDs are equal.
+func sameItemType(a, b *uuid.UUID) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// addMeasure adds m to the measure of the same unit in measures, or appends it.
+func addMeasure(measures []models.Measure, m models.Measure) ([]models.Measure, error) {
+	for i := range measures {
+		if measures[i].Unit == m.Unit {
+			total, err := measures[i].Quantity.Add(m.Quantity)
+			if err != nil {
+				return nil, fmt.Errorf("failed to total quantities in %s: %w", m.Unit, err)
+			}
+			measures[i].Quantity = total
+			return measures, nil
+		}
+	}
+	return append(measures, m), nil
+}
+
+// historyUnit returns the unit the history of an item of a home is reported
+// in for unit, a unit or CanonicalUnit, and the item's own unit. It returns
+// apperrors.ErrNotFound if the item does not exist in the home.
+func (s *InventoryService) historyUnit(ctx context.Context, homeID uuid.UUID, itemID uuid.UUID, unit string) (to units.Unit, itemUnit string, err error) {
+	var itemTypeID *uuid.UUID
+	query := `SELECT unit, item_type_id FROM items WHERE id = $1 AND home_id = $2`
+	if err := s.db.QueryRow(ctx, query, itemID, homeID).Scan(&itemUnit, &itemTypeID); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return to, "", apperrors.ErrNotFound
+		}
+		return to, "", fmt.Errorf("failed to check item: %w", err)
+	}
+	if unit == "" {
+		return to, itemUnit, nil
+	}
+
+	n, err := s.newQuantityNormalizer(ctx, homeID, unit)
+	if err != nil {
+		return to, "", err
+	}
+	to, ok := n.target(itemTypeID)
+	if !ok {
+		return to, "", fmt.Errorf("%w: the item's type has no canonical unit", apperrors.ErrInvalidInput)
+	}
+	if err := checkConvertible(itemUnit, to); err != nil {
+		return to, "", fmt.Errorf("%w: %w", apperrors.ErrInvalidInput, err)
+	}
+	return to, itemUnit, nil
+}
@@ -0,0 +1,40 @@
+package inventory
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/m-cain/mnemo/backend/apperrors"
+)
+
+func TestRescalePurchasePrice(t *testing.T) {
+	tests := []struct {
+		price    int64
+		from, to string
+		want     int64
+	}{
+		{price: 1500, from: "kg", to: "g", want: 2},
+		{price: 1499, from: "kg", to: "g", want: 1},
+		{price: 2, from: "g", to: "kg", want: 2000},
+		{price: 1000, from: "kg", to: "lb", want: 454},
+		{price: 250, from: "l", to: "ml", want: 0},
+		{price: 300, from: "dozen", to: "pcs", want: 25},
+		{price: 1500, from: "kg", to: "kg", want: 1500},
+		{price: 1500, from: "kg", to: "ml", want: 1500},
+		{price: 1500, from: "box", to: "kg", want: 1500},
+	}
+	for _, tt := range tests {
+		got, err := rescalePurchasePrice(tt.price, tt.from, tt.to)
+		if err != nil {
+			t.Fatalf("rescalePurchasePrice(%d, %s, %s): %v", tt.price, tt.from, tt.to, err)
+		}
+		if got != tt.want {
+			t.Errorf("rescalePurchasePrice(%d, %s, %s) = %d, want %d", tt.price, tt.from, tt.to, got, tt.want)
+		}
+	}
+
+	if _, err := rescalePurchasePrice(math.MaxInt64, "g", "kg"); !errors.Is(err, apperrors.ErrInvalidInput) {
+		t.Errorf("rescalePurchasePrice overflow error = %v, want ErrInvalidInput", err)
+	}
+}
@@ -0,0 +1,175 @@
+package inventory
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/m-cain/mnemo/backend/apperrors"
+	"github.com/m-cain/mnemo/backend/models"
+)
+
+// currencyPattern matches ISO 4217 currency codes.
+var currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// normalizePurchaseDetails validates the purchase and warranty details of an
+// item. Currency codes are upper-cased and blank text fields are cleared. A
+// price requires a currency, and a warranty cannot expire before the purchase.
+func normalizePurchaseDetails(item *models.Item) error {
+	if item.PurchasePrice != nil && *item.PurchasePrice < 0 {
+		return fmt.Errorf("%w: purchase price must not be negative", apperrors.ErrInvalidInput)
+	}
+	item.Currency = trimmedOrNil(item.Currency)
+	if item.Currency != nil {
+		currency := strings.ToUpper(*item.Currency)
+		if !currencyPattern.MatchString(currency) {
+			return fmt.Errorf("%w: currency must be a three-letter ISO 4217 code", apperrors.ErrInvalidInput)
+		}
+		item.Currency = &currency
+	}
+	if item.PurchasePrice != nil && item.Currency == nil {
+		return fmt.Errorf("%w: currency is required with a purchase price", apperrors.ErrInvalidInput)
+	}
+	item.Vendor = trimmedOrNil(item.Vendor)
+	item.SerialNumber = trimmedOrNil(item.SerialNumber)
+	if item.PurchaseDate != nil && item.WarrantyExpiry != nil && item.WarrantyExpiry.Before(item.PurchaseDate.Time) {
+		return fmt.Errorf("%w: warranty cannot expire before the purchase date", apperrors.ErrInvalidInput)
+	}
+	return nil
+}
+
+// trimmedOrNil returns s without surrounding whitespace, or nil if s is nil or blank.
+func trimmedOrNil(s *string) *string {
+	if s == nil {
+		return nil
+	}
+	trimmed := strings.TrimSpace(*s)
+	if trimmed == "" {
+		return nil
+	}
+	return &trimmed
+}
+
+// ListExpiringWarranties retrieves the items of a home whose warranty expires
+// today or within the given number of days, soonest first.
+func (s *InventoryService) ListExpiringWarranties(ctx context.Context, homeID uuid.UUID, withinDays int) ([]models.Item, error) {
+	if withinDays < 0 {
+		return nil, fmt.Errorf("%w: expiry window must not be negative", apperrors.ErrInvalidInput)
+	}
+
+	query := `
+		SELECT ` + itemColumns + `
+		FROM items i
+		WHERE i.home_id = $1 AND i.warranty_expiry BETWEEN CURRENT_DATE AND CURRENT_DATE + $2::int
+		ORDER BY i.warranty_expiry, i.name, i.id
+	`
+
+	rows, err := s.db.Query(ctx, query, homeID, withinDays)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query expiring warranties: %w", err)
+	}
+	defer rows.Close()
+
+	items := []models.Item{}
+	for rows.Next() {
+		var item models.Item
+		if err := rows.Scan(itemScanTargets(&item)...); err != nil {
+			return nil, fmt.Errorf("failed to scan item row: %w", err)
+		}
+		items = append(items, item)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after scanning item rows: %w", err)
+	}
+
+	return items, nil
+}
+
+// InsuredValue is the value of a group of items in one currency: the sum of
+// purchase price times quantity, in minor units of the currency.
+type InsuredValue struct {
+	Currency  string `json:"currency"`
+	Value     int64  `json:"value"`
+	ItemCount int    `json:"item_count"`
+}
+
+// InsuredValueGroup is the insured value of the items in one location or of
+// one item type. ID and Name are nil for items without a location or type.
+type InsuredValueGroup struct {
+	ID   *uuid.UUID `json:"id"`
+	Name *string    `json:"name"`
+	InsuredValue
+}
+
+// InsuredValueReport totals the insured value of a home per currency, and
+// breaks it down by the location items are directly in and by item type.
+type InsuredValueReport struct {
+	Totals     []InsuredValue      `json:"totals"`
+	ByLocation []InsuredValueGroup `json:"by_location"`
+	ByItemType []InsuredValueGroup `json:"by_item_type"`
+}
+
+// InsuredValueReport computes the insured value of the items of a home.
+// Items without a purchase price are left out, and items with a negative
+// quantity count as none. Values of fractional quantities are rounded to
+// whole minor units.
+func (s *InventoryService) InsuredValueReport(ctx context.Context, homeID uuid.UUID) (*InsuredValueReport, error) {
+	query := `
+		SELECT
+			GROUPING(i.location_id) = 0 AS by_location,
+			GROUPING(i.item_type_id) = 0 AS by_item_type,
+			i.currency,
+			i.location_id,
+			l.name,
+			i.item_type_id,
+			t.name,
+			ROUND(SUM(i.purchase_price * GREATEST(i.quantity, 0)))::bigint,
+			COUNT(*)
+		FROM items i
+		LEFT JOIN locations l ON l.id = i.location_id
+		LEFT JOIN item_types t ON t.id = i.item_type_id
+		WHERE i.home_id = $1 AND i.purchase_price IS NOT NULL
+		GROUP BY GROUPING SETS ((i.currency), (i.currency, i.location_id, l.name), (i.currency, i.item_type_id, t.name))
+		ORDER BY i.currency, l.name NULLS LAST, t.name NULLS LAST, i.location_id, i.item_type_id
+	`
+
+	rows, err := s.db.Query(ctx, query, homeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query insured value: %w", err)
+	}
+	defer rows.Close()
+
+	report := &InsuredValueReport{
+		Totals:     []InsuredValue{},
+		ByLocation: []InsuredValueGroup{},
+		ByItemType: []InsuredValueGroup{},
+	}
+	for rows.Next() {
+		var byLocation, byItemType bool
+		var location, itemType InsuredValueGroup
+		var value InsuredValue
+		err := rows.Scan(&byLocation, &byItemType, &value.Currency, &location.ID, &location.Name, &itemType.ID, &itemType.Name, &value.Value, &value.ItemCount)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan insured value row: %w", err)
+		}
+		switch {
+		case byLocation:
+			location.InsuredValue = value
+			report.ByLocation = append(report.ByLocation, location)
+		case byItemType:
+			itemType.InsuredValue = value
+			report.ByItemType = append(report.ByItemType, itemType)
+		default:
+			report.Totals = append(report.Totals, value)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after scanning insured value rows: %w", err)
+	}
+
+	return report, nil
+}
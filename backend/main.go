@@ -4,16 +4,27 @@ import (
 	"context"
 	"database/sql"
 	"embed" // Add embed import
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/m-cain/mnemo/backend/attachment"
+	"github.com/m-cain/mnemo/backend/audit"
 	"github.com/m-cain/mnemo/backend/auth"
+	"github.com/m-cain/mnemo/backend/enrichment"
 	"github.com/m-cain/mnemo/backend/home"
 	"github.com/m-cain/mnemo/backend/inventory"
+	"github.com/m-cain/mnemo/backend/notify"
+	"github.com/m-cain/mnemo/backend/ratelimit"
 	"github.com/m-cain/mnemo/backend/router"
+	"github.com/m-cain/mnemo/backend/storage"
 	"github.com/pressly/goose/v3"
 )
 
@@ -65,20 +76,168 @@ func main() {
 		log.Println("Database migrations applied successfully!")
 	}
 
+	// Load JWT signing keys, token lifetimes and proxy authentication settings
+	tokenConfig, err := auth.LoadTokenConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Unable to configure JWT signing: %v\n", err)
+	}
+	proxyAuthConfig, err := auth.LoadProxyAuthConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Unable to configure proxy authentication: %v\n", err)
+	}
+	if proxyAuthConfig != nil {
+		log.Println("Reverse-proxy header authentication enabled")
+	}
+	trustedProxies, err := auth.LoadTrustedProxiesFromEnv()
+	if err != nil {
+		log.Fatalf("Unable to configure trusted proxies: %v\n", err)
+	}
+
+	// Rate limiter state is kept in memory unless RATE_LIMIT_STORE=postgres,
+	// which shares quotas and lockouts between instances
+	rateLimitConfig, err := ratelimit.LoadConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Unable to configure rate limiting: %v\n", err)
+	}
+	var rateLimitStore ratelimit.Store
+	switch os.Getenv("RATE_LIMIT_STORE") {
+	case "", "memory":
+		rateLimitStore = ratelimit.NewMemoryStore()
+	case "postgres":
+		rateLimitStore = ratelimit.NewPostgresStore(dbPool)
+	default:
+		log.Fatalf("Unknown RATE_LIMIT_STORE %q\n", os.Getenv("RATE_LIMIT_STORE"))
+	}
+	defer rateLimitStore.Close()
+	limiter := ratelimit.NewLimiter(rateLimitStore, rateLimitConfig)
+
+	notifier, err := newNotifier()
+	if err != nil {
+		log.Fatalf("Unable to configure notifications: %v\n", err)
+	}
+	appBaseURL := os.Getenv("APP_BASE_URL")
+	if appBaseURL == "" {
+		appBaseURL = "http://localhost:5173" // Default Vite dev server
+	}
+	apiBaseURL := os.Getenv("API_BASE_URL")
+	if apiBaseURL == "" {
+		apiBaseURL = "http://localhost:8080"
+	}
+
 	// Initialize services
-	apiKeyService := auth.NewAPIKeyService(dbPool)            // Initialize APIKeyService
-	authService := auth.NewAuthService(dbPool, apiKeyService) // Pass dbPool and apiKeyService
-	homeService := home.NewHomeService(dbPool)                // Initialize HomeService
-	inventoryService := inventory.NewInventoryService(dbPool) // Initialize InventoryService
+	apiKeyService := auth.NewAPIKeyService(dbPool)                                                                   // Initialize APIKeyService
+	defer apiKeyService.Close()                                                                                      // Flush buffered API key usage on shutdown
+	authService := auth.NewAuthService(dbPool, apiKeyService, tokenConfig, proxyAuthConfig, trustedProxies, limiter) // Pass dbPool, apiKeyService and auth settings
+	homeService := home.NewHomeService(dbPool)                                                                       // Initialize HomeService
+	auditService := audit.NewService(dbPool)                                                                         // Initialize audit log reader
+	inventoryService := inventory.NewInventoryService(dbPool)                                                        // Initialize InventoryService
+
+	// Built-in item types are optional and shared by every home
+	builtinItemTypes, err := inventory.LoadBuiltinItemTypesFromEnv()
+	if err != nil {
+		log.Fatalf("Unable to load built-in item types: %v\n", err)
+	}
+	if len(builtinItemTypes) > 0 {
+		if err := inventoryService.SyncBuiltinItemTypes(context.Background(), builtinItemTypes); err != nil {
+			log.Fatalf("Unable to sync built-in item types: %v\n", err)
+		}
+		log.Printf("Synced %d built-in item types\n", len(builtinItemTypes))
+	}
+
+	// Product data enrichment is enabled unless ENRICHMENT_PROVIDERS is "none"
+	var enrichmentService *enrichment.Service
+	enrichmentConfig, err := enrichment.LoadConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Unable to configure enrichment: %v\n", err)
+	}
+	enrichmentRegistry, err := enrichment.NewRegistryFromConfig(enrichmentConfig)
+	if err != nil {
+		log.Fatalf("Unable to create enrichment providers: %v\n", err)
+	}
+	if enrichmentRegistry.Len() > 0 {
+		enrichmentService = enrichment.NewService(dbPool, enrichmentRegistry, enrichmentConfig)
+		log.Printf("Enrichment providers: %s\n", strings.Join(enrichmentConfig.Providers, ", "))
+	}
+
+	// Attachment contents are kept on the local filesystem unless BLOB_STORE=s3
+	blobStoreConfig, err := storage.LoadConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Unable to configure blob storage: %v\n", err)
+	}
+	blobStore, err := storage.New(blobStoreConfig)
+	if err != nil {
+		log.Fatalf("Unable to create blob store: %v\n", err)
+	}
+	attachmentConfig, err := attachment.LoadConfigFromEnv()
+	if err != nil {
+		log.Fatalf("Unable to configure attachments: %v\n", err)
+	}
+	attachmentService := attachment.NewService(dbPool, blobStore, attachmentConfig)
+	defer attachmentService.Close() // Stop removing blobs of deleted attachments on shutdown
+	log.Printf("Attachments are stored in the %s blob store\n", blobStoreConfig.Backend)
+
+	invitationService := home.NewInvitationService(dbPool, notifier, appBaseURL+"/invitations/accept")
+	passwordResetService := auth.NewPasswordResetService(dbPool, notifier, appBaseURL+"/password/reset", limiter)
+	defer passwordResetService.Close() // Finish sending reset emails on shutdown
+	emailChangeService := auth.NewEmailChangeService(dbPool, notifier, authService, appBaseURL+"/email/confirm")
+
+	// OIDC login is optional and only enabled when OIDC_ISSUER is set
+	var oidcService *auth.OIDCService
+	oidcConfig, err := auth.LoadOIDCConfigFromEnv(apiBaseURL, appBaseURL)
+	if err != nil {
+		log.Fatalf("Unable to configure OIDC: %v\n", err)
+	}
+	if oidcConfig != nil {
+		oidcService = auth.NewOIDCService(dbPool, authService, *oidcConfig)
+		log.Printf("OIDC login enabled for issuer %s\n", oidcConfig.Issuer)
+	}
 
 	// Setup router using the new router package
-	r := router.NewRouter(dbPool, apiKeyService, authService, homeService, inventoryService)
+	r := router.NewRouter(dbPool, apiKeyService, authService, passwordResetService, emailChangeService, oidcService, homeService, auditService, invitationService, inventoryService, enrichmentService, attachmentService, limiter)
 
 	// Start server
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080" // Default port
 	}
+	srv := &http.Server{Addr: ":" + port, Handler: r}
+
+	// Shut down gracefully on SIGINT/SIGTERM so deferred cleanup runs
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Server shutdown failed: %v\n", err)
+		}
+	}()
+
 	log.Printf("Server starting on port %s\n", port)
-	log.Fatal(http.ListenAndServe(":"+port, r))
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatalf("Server failed: %v\n", err)
+	}
+	log.Println("Server stopped")
+}
+
+// newNotifier configures email delivery from the environment. SMTP is used when
+// SMTP_HOST is set; otherwise messages are written to NOTIFY_FILE, or to the log.
+func newNotifier() (notify.Notifier, error) {
+	if host := os.Getenv("SMTP_HOST"); host != "" {
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		from := os.Getenv("SMTP_FROM")
+		if from == "" {
+			return nil, fmt.Errorf("SMTP_FROM must be set when SMTP_HOST is set")
+		}
+		return notify.NewSMTPNotifier(host, port, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), from), nil
+	}
+	if path := os.Getenv("NOTIFY_FILE"); path != "" {
+		return notify.NewFileNotifier(path)
+	}
+	log.Println("SMTP_HOST not set; notifications will be written to the log")
+	return notify.NewLogNotifier(), nil
 }
@@ -0,0 +1,9 @@
+-- +goose Up
+-- Roles other than the known ones were never meaningful; demote them to viewer.
+UPDATE home_users SET role = 'viewer' WHERE role NOT IN ('owner', 'admin', 'editor', 'viewer');
+
+ALTER TABLE home_users
+    ADD CONSTRAINT home_users_role_check CHECK (role IN ('owner', 'admin', 'editor', 'viewer'));
+
+-- +goose Down
+ALTER TABLE home_users DROP CONSTRAINT home_users_role_check;
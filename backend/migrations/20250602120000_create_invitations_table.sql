@@ -0,0 +1,20 @@
+-- +goose Up
+CREATE TABLE invitations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    home_id UUID NOT NULL REFERENCES homes(id) ON DELETE CASCADE,
+    email VARCHAR(255) NOT NULL,
+    role VARCHAR(50) NOT NULL CHECK (role IN ('admin', 'editor', 'viewer')),
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    invited_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'accepted', 'declined', 'revoked')),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    responded_at TIMESTAMP WITH TIME ZONE,
+    send_error TEXT, -- Set when the invitation could not be delivered
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- At most one open invitation per address and home.
+CREATE UNIQUE INDEX idx_invitations_pending_email ON invitations(home_id, LOWER(email)) WHERE status = 'pending';
+
+-- +goose Down
+DROP TABLE invitations;
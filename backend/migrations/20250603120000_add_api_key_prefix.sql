@@ -0,0 +1,19 @@
+-- +goose Up
+-- New keys have the form mnemo_<prefix>_<secret>: the prefix is stored in clear
+-- for indexed lookup and the secret as a SHA-256 hash. Existing bcrypt keys keep
+-- their hash in "key" until first use, when they are rehashed into secret_hash.
+ALTER TABLE api_keys ALTER COLUMN key DROP NOT NULL;
+ALTER TABLE api_keys ADD COLUMN prefix VARCHAR(32);
+ALTER TABLE api_keys ADD COLUMN secret_hash VARCHAR(64);
+
+CREATE UNIQUE INDEX idx_api_keys_prefix ON api_keys(prefix);
+CREATE UNIQUE INDEX idx_api_keys_secret_hash ON api_keys(secret_hash);
+
+-- +goose Down
+-- Keys issued after this migration cannot be represented without these columns.
+DELETE FROM api_keys WHERE key IS NULL;
+DROP INDEX idx_api_keys_secret_hash;
+DROP INDEX idx_api_keys_prefix;
+ALTER TABLE api_keys DROP COLUMN secret_hash;
+ALTER TABLE api_keys DROP COLUMN prefix;
+ALTER TABLE api_keys ALTER COLUMN key SET NOT NULL;
@@ -0,0 +1,14 @@
+-- +goose Up
+-- Existing keys keep the full access they had before scopes existed.
+ALTER TABLE api_keys ADD COLUMN scopes TEXT[] NOT NULL DEFAULT '{}';
+UPDATE api_keys SET scopes = ARRAY['admin'];
+
+ALTER TABLE api_keys ADD COLUMN expires_at TIMESTAMP WITH TIME ZONE;
+
+-- NULL means the key is valid for every home of its user.
+ALTER TABLE api_keys ADD COLUMN home_ids UUID[];
+
+-- +goose Down
+ALTER TABLE api_keys DROP COLUMN home_ids;
+ALTER TABLE api_keys DROP COLUMN expires_at;
+ALTER TABLE api_keys DROP COLUMN scopes;
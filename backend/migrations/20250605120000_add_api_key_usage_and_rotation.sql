@@ -0,0 +1,11 @@
+-- +goose Up
+ALTER TABLE api_keys ADD COLUMN last_used_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE api_keys ADD COLUMN last_used_ip VARCHAR(45);
+ALTER TABLE api_keys ADD COLUMN request_count BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE api_keys ADD COLUMN replaced_by UUID REFERENCES api_keys(id) ON DELETE SET NULL;
+
+-- +goose Down
+ALTER TABLE api_keys DROP COLUMN replaced_by;
+ALTER TABLE api_keys DROP COLUMN request_count;
+ALTER TABLE api_keys DROP COLUMN last_used_ip;
+ALTER TABLE api_keys DROP COLUMN last_used_at;
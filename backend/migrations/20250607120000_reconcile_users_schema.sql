@@ -0,0 +1,16 @@
+-- +goose Up
+-- The application identifies users by email and shows a display name; it never used username or role.
+ALTER TABLE users RENAME COLUMN username TO email;
+ALTER TABLE users DROP CONSTRAINT users_username_key;
+ALTER TABLE users DROP COLUMN role;
+ALTER TABLE users ADD COLUMN name VARCHAR(255) NOT NULL DEFAULT '';
+
+-- Emails are unique regardless of case.
+CREATE UNIQUE INDEX idx_users_email_lower ON users(LOWER(email));
+
+-- +goose Down
+DROP INDEX idx_users_email_lower;
+ALTER TABLE users DROP COLUMN name;
+ALTER TABLE users ADD COLUMN role VARCHAR(50) NOT NULL DEFAULT 'user';
+ALTER TABLE users RENAME COLUMN email TO username;
+ALTER TABLE users ADD CONSTRAINT users_username_key UNIQUE (username);
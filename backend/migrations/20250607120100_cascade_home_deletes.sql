@@ -0,0 +1,22 @@
+-- +goose Up
+-- Deleting a home or a user removes their memberships, and deleting a home removes its locations.
+ALTER TABLE home_users
+    DROP CONSTRAINT home_users_home_id_fkey,
+    ADD CONSTRAINT home_users_home_id_fkey FOREIGN KEY (home_id) REFERENCES homes(id) ON DELETE CASCADE,
+    DROP CONSTRAINT home_users_user_id_fkey,
+    ADD CONSTRAINT home_users_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+
+ALTER TABLE locations
+    DROP CONSTRAINT locations_home_id_fkey,
+    ADD CONSTRAINT locations_home_id_fkey FOREIGN KEY (home_id) REFERENCES homes(id) ON DELETE CASCADE;
+
+-- +goose Down
+ALTER TABLE locations
+    DROP CONSTRAINT locations_home_id_fkey,
+    ADD CONSTRAINT locations_home_id_fkey FOREIGN KEY (home_id) REFERENCES homes(id);
+
+ALTER TABLE home_users
+    DROP CONSTRAINT home_users_home_id_fkey,
+    ADD CONSTRAINT home_users_home_id_fkey FOREIGN KEY (home_id) REFERENCES homes(id),
+    DROP CONSTRAINT home_users_user_id_fkey,
+    ADD CONSTRAINT home_users_user_id_fkey FOREIGN KEY (user_id) REFERENCES users(id);
@@ -0,0 +1,34 @@
+-- +goose Up
+-- totp_secret is set on enrollment; 2FA is only enforced once totp_enabled_at is set.
+ALTER TABLE users
+    ADD COLUMN totp_secret VARCHAR(64),
+    ADD COLUMN totp_enabled_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN totp_last_used_step BIGINT; -- Rejects replays of an already used code
+
+CREATE TABLE recovery_codes (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash VARCHAR(64) NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_recovery_codes_user_id ON recovery_codes(user_id);
+
+-- Challenges issued after the password step of a login, by the ID of their
+-- token. A challenge is deleted once used or after too many wrong codes.
+CREATE TABLE mfa_challenges (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    failed_attempts INTEGER NOT NULL DEFAULT 0,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- +goose Down
+DROP TABLE mfa_challenges;
+DROP TABLE recovery_codes;
+ALTER TABLE users
+    DROP COLUMN totp_last_used_step,
+    DROP COLUMN totp_enabled_at,
+    DROP COLUMN totp_secret;
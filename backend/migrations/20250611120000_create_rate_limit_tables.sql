@@ -0,0 +1,25 @@
+-- +goose Up
+-- Token buckets for rate limiting, used when RATE_LIMIT_STORE=postgres.
+CREATE TABLE rate_limit_buckets (
+    key VARCHAR(255) PRIMARY KEY,
+    tokens DOUBLE PRECISION NOT NULL,
+    allowed BOOLEAN NOT NULL, -- Whether the last take succeeded
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+-- Consecutive authentication failures and the resulting lockouts.
+CREATE TABLE auth_failures (
+    key VARCHAR(255) PRIMARY KEY,
+    failures INTEGER NOT NULL,
+    last_failure_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    locked_until TIMESTAMP WITH TIME ZONE,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+-- Optional per-key request quota; NULL uses the server default.
+ALTER TABLE api_keys ADD COLUMN rate_limit_per_minute INTEGER CHECK (rate_limit_per_minute > 0);
+
+-- +goose Down
+ALTER TABLE api_keys DROP COLUMN rate_limit_per_minute;
+DROP TABLE auth_failures;
+DROP TABLE rate_limit_buckets;
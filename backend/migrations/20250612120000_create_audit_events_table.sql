@@ -0,0 +1,22 @@
+-- +goose Up
+-- Audit events deliberately have no foreign keys so the history of a home,
+-- user or API key outlives it.
+CREATE TABLE audit_events (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    occurred_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    home_id UUID,
+    user_id UUID,
+    api_key_id UUID,
+    action VARCHAR(50) NOT NULL,
+    entity_type VARCHAR(50) NOT NULL,
+    entity_id UUID,
+    changes JSONB NOT NULL DEFAULT '{}',
+    ip VARCHAR(45),
+    request_id VARCHAR(255)
+);
+
+CREATE INDEX idx_audit_events_home_id ON audit_events(home_id, occurred_at DESC, id DESC);
+CREATE INDEX idx_audit_events_entity ON audit_events(entity_type, entity_id);
+
+-- +goose Down
+DROP TABLE audit_events;
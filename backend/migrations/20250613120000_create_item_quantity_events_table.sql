@@ -0,0 +1,22 @@
+-- +goose Up
+CREATE TABLE item_quantity_events (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    item_id UUID NOT NULL REFERENCES items(id) ON DELETE CASCADE,
+    delta INTEGER NOT NULL,
+    quantity_after INTEGER NOT NULL,
+    reason VARCHAR(20) NOT NULL CHECK (reason IN ('purchase', 'consumed', 'adjustment', 'moved', 'expired')),
+    user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    api_key_id UUID REFERENCES api_keys(id) ON DELETE SET NULL,
+    occurred_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_item_quantity_events_item_id ON item_quantity_events(item_id, occurred_at);
+
+-- Start the ledger of existing items from their current quantity
+INSERT INTO item_quantity_events (item_id, delta, quantity_after, reason, occurred_at)
+SELECT id, quantity, quantity, 'adjustment', created_at
+FROM items
+WHERE quantity <> 0;
+
+-- +goose Down
+DROP TABLE item_quantity_events;
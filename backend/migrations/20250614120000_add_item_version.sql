@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE items ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+
+-- +goose Down
+ALTER TABLE items DROP COLUMN version;
@@ -0,0 +1,52 @@
+-- +goose Up
+ALTER TABLE items ADD COLUMN home_id UUID REFERENCES homes(id) ON DELETE CASCADE;
+
+UPDATE items i SET home_id = l.home_id FROM locations l WHERE i.location_id = l.id;
+
+-- An item without a location belongs to the home its changes were last
+-- audited in
+UPDATE items i SET home_id = (
+    SELECT e.home_id
+    FROM audit_events e
+    JOIN homes h ON h.id = e.home_id
+    WHERE e.entity_type = 'item' AND e.entity_id = i.id
+    ORDER BY e.occurred_at DESC, e.id DESC
+    LIMIT 1
+)
+WHERE i.home_id IS NULL;
+
+-- Otherwise it belongs to the home of the users who changed its quantity, if
+-- they are members of exactly one home
+UPDATE items i SET home_id = m.home_id
+FROM (
+    SELECT q.item_id, (ARRAY_AGG(DISTINCT hu.home_id))[1] AS home_id
+    FROM item_quantity_events q
+    JOIN home_users hu ON hu.user_id = q.user_id
+    GROUP BY q.item_id
+    HAVING COUNT(DISTINCT hu.home_id) = 1
+) m
+WHERE i.id = m.item_id AND i.home_id IS NULL;
+
+-- An installation with a single home keeps all its items in that home
+UPDATE items SET home_id = (SELECT id FROM homes)
+WHERE home_id IS NULL AND (SELECT COUNT(*) FROM homes) = 1;
+
+-- +goose StatementBegin
+DO $$
+DECLARE
+    orphans BIGINT;
+BEGIN
+    SELECT COUNT(*) INTO orphans FROM items WHERE home_id IS NULL;
+    IF orphans > 0 THEN
+        RAISE EXCEPTION 'cannot determine the home of % item(s) without a location', orphans
+            USING HINT = 'Give these items a location (SELECT id, name FROM items WHERE location_id IS NULL) and run the migration again.';
+    END IF;
+END;
+$$;
+-- +goose StatementEnd
+
+ALTER TABLE items ALTER COLUMN home_id SET NOT NULL;
+CREATE INDEX idx_items_home_id ON items(home_id);
+
+-- +goose Down
+ALTER TABLE items DROP COLUMN home_id;
@@ -0,0 +1,55 @@
+-- +goose Up
+-- Item types belong to a home; types without a home are built-in and shared
+-- by every home. Built-in types are only created from BUILTIN_ITEM_TYPES_FILE.
+ALTER TABLE item_types
+    DROP CONSTRAINT item_types_name_key,
+    ADD COLUMN home_id UUID REFERENCES homes(id) ON DELETE CASCADE,
+    ADD COLUMN description TEXT,
+    ADD COLUMN default_unit VARCHAR(50);
+
+-- Existing types were shared, so each home that uses a type gets its own.
+-- Every home could see a type no item uses, so each home gets one of those.
+-- The first home of a type keeps the existing row; the others get copies.
+CREATE TEMPORARY TABLE item_type_copies ON COMMIT DROP AS
+SELECT type_id, home_id,
+       CASE WHEN ROW_NUMBER() OVER (PARTITION BY type_id ORDER BY home_id) = 1
+            THEN type_id ELSE gen_random_uuid() END AS copy_id
+FROM (
+    SELECT DISTINCT item_type_id AS type_id, home_id FROM items WHERE item_type_id IS NOT NULL
+    UNION
+    SELECT t.id, h.id FROM item_types t CROSS JOIN homes h
+    WHERE NOT EXISTS (SELECT 1 FROM items i WHERE i.item_type_id = t.id)
+) uses;
+
+INSERT INTO item_types (id, home_id, name, created_at, updated_at)
+SELECT c.copy_id, c.home_id, t.name, t.created_at, t.updated_at
+FROM item_type_copies c
+JOIN item_types t ON t.id = c.type_id
+WHERE c.copy_id <> c.type_id;
+
+UPDATE item_types t SET home_id = c.home_id
+FROM item_type_copies c
+WHERE t.id = c.copy_id AND c.copy_id = c.type_id;
+
+UPDATE items i SET item_type_id = c.copy_id
+FROM item_type_copies c
+WHERE i.item_type_id = c.type_id AND i.home_id = c.home_id AND c.copy_id <> c.type_id;
+
+-- Without any home there are no items, and no one could ever use a type
+DELETE FROM item_types WHERE home_id IS NULL;
+
+CREATE UNIQUE INDEX idx_item_types_home_name ON item_types(home_id, name) WHERE home_id IS NOT NULL;
+CREATE UNIQUE INDEX idx_item_types_builtin_name ON item_types(name) WHERE home_id IS NULL;
+
+-- +goose Down
+DROP INDEX idx_item_types_builtin_name;
+DROP INDEX idx_item_types_home_name;
+
+-- Names may only be restored as globally unique once home types are gone
+DELETE FROM item_types WHERE home_id IS NOT NULL;
+
+ALTER TABLE item_types
+    DROP COLUMN default_unit,
+    DROP COLUMN description,
+    DROP COLUMN home_id,
+    ADD CONSTRAINT item_types_name_key UNIQUE (name);
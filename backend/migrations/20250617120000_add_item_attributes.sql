@@ -0,0 +1,11 @@
+-- +goose Up
+-- The attribute schema is a list of typed fields; items store their values by field name
+ALTER TABLE item_types ADD COLUMN attribute_schema JSONB NOT NULL DEFAULT '[]';
+ALTER TABLE items ADD COLUMN attributes JSONB NOT NULL DEFAULT '{}';
+
+CREATE INDEX idx_items_attributes ON items USING GIN (attributes);
+
+-- +goose Down
+DROP INDEX idx_items_attributes;
+ALTER TABLE items DROP COLUMN attributes;
+ALTER TABLE item_types DROP COLUMN attribute_schema;
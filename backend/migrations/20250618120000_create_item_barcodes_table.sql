@@ -0,0 +1,17 @@
+-- +goose Up
+-- Barcodes identify items when scanning; a code maps to at most one item per home
+CREATE TABLE item_barcodes (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    item_id UUID NOT NULL REFERENCES items(id) ON DELETE CASCADE,
+    home_id UUID NOT NULL REFERENCES homes(id) ON DELETE CASCADE,
+    code TEXT NOT NULL,
+    lookup_code TEXT NOT NULL, -- The code scans are matched on; UPC-A codes in their EAN-13 form
+    symbology VARCHAR(20) NOT NULL CHECK (symbology IN ('ean13', 'upca', 'code128', 'qr')),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX idx_item_barcodes_home_lookup_code ON item_barcodes(home_id, lookup_code);
+CREATE INDEX idx_item_barcodes_item_id ON item_barcodes(item_id);
+
+-- +goose Down
+DROP TABLE item_barcodes;
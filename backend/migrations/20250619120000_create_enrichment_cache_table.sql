@@ -0,0 +1,16 @@
+-- +goose Up
+-- Responses of product-data providers, keyed by provider and normalized code.
+-- A NULL product records that the provider has no match for the code.
+CREATE TABLE enrichment_cache (
+    provider VARCHAR(50) NOT NULL,
+    kind VARCHAR(20) NOT NULL,
+    code VARCHAR(64) NOT NULL,
+    product JSONB,
+    fetched_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (provider, kind, code)
+);
+
+CREATE INDEX idx_enrichment_cache_fetched_at ON enrichment_cache(fetched_at);
+
+-- +goose Down
+DROP TABLE enrichment_cache;
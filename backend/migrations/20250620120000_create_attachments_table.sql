@@ -0,0 +1,49 @@
+-- +goose Up
+-- Photos, receipts and manuals attached to an item or a location. The file
+-- contents live in the blob store under storage_key.
+CREATE TABLE attachments (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    home_id UUID NOT NULL REFERENCES homes(id) ON DELETE CASCADE,
+    item_id UUID REFERENCES items(id) ON DELETE CASCADE,
+    location_id UUID REFERENCES locations(id) ON DELETE CASCADE,
+    filename TEXT NOT NULL,
+    content_type VARCHAR(100) NOT NULL,
+    size_bytes BIGINT NOT NULL CHECK (size_bytes >= 0),
+    storage_key TEXT NOT NULL UNIQUE,
+    thumbnail_key TEXT UNIQUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CHECK (num_nonnulls(item_id, location_id) = 1)
+);
+
+CREATE INDEX idx_attachments_item_id ON attachments(item_id) WHERE item_id IS NOT NULL;
+CREATE INDEX idx_attachments_location_id ON attachments(location_id) WHERE location_id IS NOT NULL;
+CREATE INDEX idx_attachments_home_id ON attachments(home_id);
+
+-- Blobs of deleted attachments, removed from the blob store in the background.
+-- Attachments are mostly deleted by cascades from items, locations and homes,
+-- so a trigger queues their blobs whichever way the row goes.
+CREATE TABLE attachment_blob_deletions (
+    storage_key TEXT PRIMARY KEY,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- +goose StatementBegin
+CREATE FUNCTION queue_attachment_blob_deletion() RETURNS TRIGGER AS $$
+BEGIN
+    INSERT INTO attachment_blob_deletions (storage_key) VALUES (OLD.storage_key) ON CONFLICT DO NOTHING;
+    IF OLD.thumbnail_key IS NOT NULL THEN
+        INSERT INTO attachment_blob_deletions (storage_key) VALUES (OLD.thumbnail_key) ON CONFLICT DO NOTHING;
+    END IF;
+    RETURN OLD;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER attachments_queue_blob_deletion
+    AFTER DELETE ON attachments
+    FOR EACH ROW EXECUTE FUNCTION queue_attachment_blob_deletion();
+
+-- +goose Down
+DROP TABLE attachments;
+DROP FUNCTION queue_attachment_blob_deletion();
+DROP TABLE attachment_blob_deletions;
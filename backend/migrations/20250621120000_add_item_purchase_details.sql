@@ -0,0 +1,24 @@
+-- +goose Up
+-- Purchase and warranty details for insurance claims and returns. Prices are
+-- per unit, in minor units of the currency (e.g. cents).
+ALTER TABLE items
+    ADD COLUMN purchase_date DATE,
+    ADD COLUMN purchase_price BIGINT CHECK (purchase_price >= 0),
+    ADD COLUMN currency CHAR(3) CHECK (currency ~ '^[A-Z]{3}$'),
+    ADD COLUMN vendor TEXT,
+    ADD COLUMN serial_number TEXT,
+    ADD COLUMN warranty_expiry DATE,
+    ADD CONSTRAINT items_purchase_price_currency CHECK (purchase_price IS NULL OR currency IS NOT NULL);
+
+CREATE INDEX idx_items_warranty_expiry ON items(home_id, warranty_expiry) WHERE warranty_expiry IS NOT NULL;
+
+-- +goose Down
+DROP INDEX idx_items_warranty_expiry;
+ALTER TABLE items
+    DROP CONSTRAINT items_purchase_price_currency,
+    DROP COLUMN warranty_expiry,
+    DROP COLUMN serial_number,
+    DROP COLUMN vendor,
+    DROP COLUMN currency,
+    DROP COLUMN purchase_price,
+    DROP COLUMN purchase_date;
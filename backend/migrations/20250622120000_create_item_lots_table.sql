@@ -0,0 +1,22 @@
+-- +goose Up
+-- Lots split an item's stock by expiry date, e.g. cans bought together. The
+-- quantities of an item's lots add up to at most the item's quantity; the
+-- rest of its stock is in no lot.
+CREATE TABLE item_lots (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    item_id UUID NOT NULL REFERENCES items(id) ON DELETE CASCADE,
+    home_id UUID NOT NULL REFERENCES homes(id) ON DELETE CASCADE,
+    quantity INTEGER NOT NULL CHECK (quantity > 0),
+    expiry_date DATE,
+    opened_date DATE,
+    location_id UUID REFERENCES locations(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_item_lots_item_id ON item_lots(item_id, expiry_date);
+CREATE INDEX idx_item_lots_home_expiry ON item_lots(home_id, expiry_date) WHERE expiry_date IS NOT NULL;
+CREATE INDEX idx_item_lots_location_id ON item_lots(location_id) WHERE location_id IS NOT NULL;
+
+-- +goose Down
+DROP TABLE item_lots;
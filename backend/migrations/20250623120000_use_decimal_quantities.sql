@@ -0,0 +1,24 @@
+-- +goose Up
+-- Quantities are exact decimals with six fractional digits, e.g. 1.5 kg or
+-- 0.75 l, matching decimal.Decimal.
+ALTER TABLE items ALTER COLUMN quantity TYPE NUMERIC(18, 6);
+ALTER TABLE item_lots ALTER COLUMN quantity TYPE NUMERIC(18, 6);
+ALTER TABLE item_quantity_events
+    ALTER COLUMN delta TYPE NUMERIC(18, 6),
+    ALTER COLUMN quantity_after TYPE NUMERIC(18, 6);
+
+-- The canonical unit of an item type is the unit in which the quantities of
+-- its items are totalled and compared. Items of the type must then use a unit
+-- of the same dimension, e.g. any unit of mass for a type counted in kg.
+ALTER TABLE item_types ADD COLUMN canonical_unit VARCHAR(50);
+
+-- +goose Down
+ALTER TABLE item_types DROP COLUMN canonical_unit;
+
+-- Fractional quantities are rounded to whole units
+ALTER TABLE item_quantity_events
+    ALTER COLUMN delta TYPE INTEGER USING ROUND(delta),
+    ALTER COLUMN quantity_after TYPE INTEGER USING ROUND(quantity_after);
+UPDATE item_lots SET quantity = CEIL(quantity);
+ALTER TABLE item_lots ALTER COLUMN quantity TYPE INTEGER;
+ALTER TABLE items ALTER COLUMN quantity TYPE INTEGER USING ROUND(quantity);
@@ -0,0 +1,23 @@
+-- +goose Up
+-- Legacy keys that were never used since prefixed keys were introduced can only
+-- be validated by comparing a request against each of their bcrypt hashes. They
+-- expire in 30 days so that scan goes away. A key used before then is upgraded
+-- to an indexed SHA-256 hash and gets its original expiry back, kept meanwhile
+-- in legacy_expires_at.
+ALTER TABLE api_keys
+    ADD COLUMN legacy_expiry_forced BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN legacy_expires_at TIMESTAMP WITH TIME ZONE;
+
+UPDATE api_keys
+SET legacy_expiry_forced = TRUE,
+    legacy_expires_at = expires_at,
+    expires_at = LEAST(COALESCE(expires_at, 'infinity'::timestamptz), CURRENT_TIMESTAMP + INTERVAL '30 days'),
+    updated_at = CURRENT_TIMESTAMP
+WHERE prefix IS NULL AND secret_hash IS NULL AND is_active = TRUE;
+
+-- +goose Down
+UPDATE api_keys SET expires_at = legacy_expires_at WHERE legacy_expiry_forced;
+
+ALTER TABLE api_keys
+    DROP COLUMN legacy_expires_at,
+    DROP COLUMN legacy_expiry_forced;
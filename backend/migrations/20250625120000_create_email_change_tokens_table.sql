@@ -0,0 +1,16 @@
+-- +goose Up
+-- A changed email address only takes effect once a link sent to it is followed.
+CREATE TABLE email_change_tokens (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    new_email VARCHAR(255) NOT NULL,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_email_change_tokens_user_id ON email_change_tokens(user_id);
+
+-- +goose Down
+DROP TABLE email_change_tokens;
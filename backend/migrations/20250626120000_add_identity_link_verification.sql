@@ -0,0 +1,14 @@
+-- +goose Up
+-- An external identity is only linked to an existing account by email when the
+-- account's own address is verified; otherwise the owner links it while signed in.
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMP WITH TIME ZONE;
+
+-- Users provisioned from an identity provider got their address from it
+UPDATE users SET email_verified_at = created_at WHERE password_hash = '!external';
+
+-- Set when a signed-in user started the login to link the identity to their account
+ALTER TABLE oidc_login_states ADD COLUMN link_user_id UUID REFERENCES users(id) ON DELETE CASCADE;
+
+-- +goose Down
+ALTER TABLE oidc_login_states DROP COLUMN link_user_id;
+ALTER TABLE users DROP COLUMN email_verified_at;
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Date is a calendar date without a time of day, such as a purchase date. It
+// is encoded as "2006-01-02" in JSON and stored as a Postgres DATE.
+type Date struct {
+	time.Time
+}
+
+// String returns the date formatted as "2006-01-02".
+func (d Date) String() string {
+	return d.Format(time.DateOnly)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (d Date) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Date) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("date must be a string: %w", err)
+	}
+	t, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		return fmt.Errorf("date must have the form YYYY-MM-DD: %w", err)
+	}
+	d.Time = t
+	return nil
+}
+
+// ScanDate implements pgtype.DateScanner.
+func (d *Date) ScanDate(v pgtype.Date) error {
+	if !v.Valid {
+		return fmt.Errorf("cannot scan NULL into models.Date")
+	}
+	d.Time = v.Time
+	return nil
+}
+
+// DateValue implements pgtype.DateValuer.
+func (d Date) DateValue() (pgtype.Date, error) {
+	return pgtype.Date{Time: d.Time, Valid: true}, nil
+}
@@ -1,18 +1,23 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/m-cain/mnemo/backend/decimal"
 )
 
 // User represents a user in the system.
 type User struct {
-	ID           uuid.UUID `json:"id"`
-	Email        string    `json:"email"`
-	PasswordHash string    `json:"-"` // Exclude from JSON output
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	ID               uuid.UUID `json:"id"`
+	Email            string    `json:"email"`
+	Name             string    `json:"name"`
+	PasswordHash     string    `json:"-"` // Exclude from JSON output
+	TwoFactorEnabled bool      `json:"two_factor_enabled"`
+	EmailVerified    bool      `json:"email_verified"` // The user has proven they receive mail at Email
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
 }
 
 // Home represents a home entity.
@@ -46,31 +51,158 @@ type Location struct {
 
 // Item represents an inventory item.
 type Item struct {
-	ID         uuid.UUID  `json:"id"`
-	Name       string     `json:"name"`
-	Quantity   int        `json:"quantity"`
-	Unit       string     `json:"unit"`
-	LocationID *uuid.UUID `json:"location_id"`  // Use pointer for nullable FK
-	ItemTypeID *uuid.UUID `json:"item_type_id"` // Use pointer for nullable FK
-	CreatedAt  time.Time  `json:"created_at"`
-	UpdatedAt  time.Time  `json:"updated_at"`
+	ID         uuid.UUID       `json:"id"`
+	HomeID     uuid.UUID       `json:"home_id"`
+	Name       string          `json:"name"`
+	Quantity   decimal.Decimal `json:"quantity"`
+	Unit       string          `json:"unit"`
+	Normalized *Measure        `json:"normalized,omitempty"` // Quantity in the unit a listing was asked for
+	LocationID *uuid.UUID      `json:"location_id"`          // Use pointer for nullable FK
+	ItemTypeID *uuid.UUID      `json:"item_type_id"`         // Use pointer for nullable FK
+	Attributes map[string]any  `json:"attributes"`           // Values of the attributes defined by the item type
+	Barcodes   []Barcode       `json:"barcodes,omitempty"`   // Only loaded when reading a single item
+	Lots       []ItemLot       `json:"lots,omitempty"`       // Only loaded when reading a single item
+	// Purchase and warranty details; PurchasePrice is the price of one unit
+	// in minor units of Currency, an ISO 4217 code, e.g. 1999 for 19.99 USD.
+	PurchaseDate   *Date     `json:"purchase_date"`
+	PurchasePrice  *int64    `json:"purchase_price"`
+	Currency       *string   `json:"currency"`
+	Vendor         *string   `json:"vendor"`
+	SerialNumber   *string   `json:"serial_number"`
+	WarrantyExpiry *Date     `json:"warranty_expiry"`
+	Version        int       `json:"version"` // Incremented on every change; served as the ETag
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
 }
 
-// ItemType represents a type of item.
-type ItemType struct {
+// Measure is a quantity in a unit of measure.
+type Measure struct {
+	Quantity decimal.Decimal `json:"quantity"`
+	Unit     string          `json:"unit"`
+}
+
+// Barcode is a scannable code identifying an item within its home.
+type Barcode struct {
 	ID        uuid.UUID `json:"id"`
-	Name      string    `json:"name"`
+	ItemID    uuid.UUID `json:"item_id"`
+	Code      string    `json:"code"`
+	Symbology string    `json:"symbology"` // ean13, upca, code128 or qr
 	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Attachment is a file, such as a photo, receipt or manual, attached to an
+// item or a location. The contents are kept in the blob store.
+type Attachment struct {
+	ID           uuid.UUID  `json:"id"`
+	HomeID       uuid.UUID  `json:"home_id"`
+	ItemID       *uuid.UUID `json:"item_id"`     // Set for attachments of an item
+	LocationID   *uuid.UUID `json:"location_id"` // Set for attachments of a location
+	Filename     string     `json:"filename"`
+	ContentType  string     `json:"content_type"` // Detected from the contents, not taken from the upload
+	Size         int64      `json:"size"`
+	HasThumbnail bool       `json:"has_thumbnail"`
+	StorageKey   string     `json:"-"`
+	ThumbnailKey *string    `json:"-"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// ItemLot is part of an item's stock that shares an expiry date, such as cans
+// bought together. The lots of an item hold at most the item's quantity; the
+// rest of its stock is in no lot.
+type ItemLot struct {
+	ID         uuid.UUID       `json:"id"`
+	ItemID     uuid.UUID       `json:"item_id"`
+	Quantity   decimal.Decimal `json:"quantity"` // In the unit of the item
+	ExpiryDate *Date           `json:"expiry_date"`
+	OpenedDate *Date           `json:"opened_date"`
+	LocationID *uuid.UUID      `json:"location_id"` // Nil if the lot is wherever the item is
+	CreatedAt  time.Time       `json:"created_at"`
+	UpdatedAt  time.Time       `json:"updated_at"`
+}
+
+// ItemType represents a type of item. Types without a home are built-in and
+// available to every home.
+type ItemType struct {
+	ID              uuid.UUID        `json:"id"`
+	HomeID          *uuid.UUID       `json:"home_id"` // Nil for built-in types
+	Name            string           `json:"name"`
+	Description     *string          `json:"description"`
+	DefaultUnit     *string          `json:"default_unit"`     // Applied to new items created without a unit
+	CanonicalUnit   *string          `json:"canonical_unit"`   // Items must be in a unit convertible to it; used to total them
+	AttributeSchema []AttributeField `json:"attribute_schema"` // Custom attributes of items of this type
+	CreatedAt       time.Time        `json:"created_at"`
+	UpdatedAt       time.Time        `json:"updated_at"`
+}
+
+// AttributeField defines a custom attribute of the items of an item type.
+type AttributeField struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"` // string, number, date, enum or boolean
+	Required bool     `json:"required"`
+	Values   []string `json:"values,omitempty"` // Allowed values of an enum
 }
 
 // APIKey represents an API key for a user.
+// Only the key's public prefix is stored in clear; the secret part is never returned.
 type APIKey struct {
-	ID        uuid.UUID `json:"id"`
-	UserID    string    `json:"user_id"` // Use string for UUID to match apikey.go
-	Name      string    `json:"name"`
-	Key       string    `json:"key"` // Store hashed key
-	IsActive  bool      `json:"is_active"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID        uuid.UUID   `json:"id"`
+	UserID    string      `json:"user_id"` // Use string for UUID to match apikey.go
+	Name      string      `json:"name"`
+	Prefix    *string     `json:"prefix"` // Nil for legacy keys issued before prefixes
+	Scopes    []string    `json:"scopes"`
+	HomeIDs   []uuid.UUID `json:"home_ids"`   // Nil if the key is valid for all of the user's homes
+	ExpiresAt *time.Time  `json:"expires_at"` // Nil if the key never expires
+	IsActive  bool        `json:"is_active"`
+	// RateLimitPerMinute overrides the default request quota; nil uses the server default.
+	RateLimitPerMinute *int `json:"rate_limit_per_minute"`
+	// Usage is recorded asynchronously and may lag behind by a few seconds.
+	LastUsedAt   *time.Time `json:"last_used_at"`
+	LastUsedIP   *string    `json:"last_used_ip"`
+	RequestCount int64      `json:"request_count"`
+	ReplacedBy   *uuid.UUID `json:"replaced_by"` // Set once the key has been rotated
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// PaginatedResponse is the envelope returned by paginated list endpoints.
+// NextCursor is set when more results follow and can be passed back as the
+// cursor query parameter to fetch them.
+type PaginatedResponse[T any] struct {
+	Data       []T    `json:"data"`
+	Total      int    `json:"total"`
+	Page       int    `json:"page"`
+	PerPage    int    `json:"per_page"`
+	TotalPages int    `json:"total_pages"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// Invitation is an invitation for an email address to join a home.
+// Status is one of pending, accepted, declined, revoked or expired.
+type Invitation struct {
+	ID          uuid.UUID  `json:"id"`
+	HomeID      uuid.UUID  `json:"home_id"`
+	Email       string     `json:"email"`
+	Role        string     `json:"role"`
+	InvitedBy   *uuid.UUID `json:"invited_by"`
+	Status      string     `json:"status"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	RespondedAt *time.Time `json:"responded_at"`
+	SendError   *string    `json:"send_error"` // Set when the invitation could not be delivered
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// AuditEvent records a change made to a home or its contents. Changes maps
+// each changed field to its before and after value.
+type AuditEvent struct {
+	ID         uuid.UUID       `json:"id"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	HomeID     *uuid.UUID      `json:"home_id"`
+	UserID     *uuid.UUID      `json:"user_id"`    // Nil for changes made outside a request
+	APIKeyID   *uuid.UUID      `json:"api_key_id"` // Set when the change was made with an API key
+	Action     string          `json:"action"`
+	EntityType string          `json:"entity_type"`
+	EntityID   *uuid.UUID      `json:"entity_id"`
+	Changes    json.RawMessage `json:"changes"`
+	IP         *string         `json:"ip"`
+	RequestID  *string         `json:"request_id"`
 }
@@ -0,0 +1,98 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is an email-style notification addressed to a single recipient.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Notifier delivers messages to users.
+type Notifier interface {
+	Notify(ctx context.Context, msg Message) error
+}
+
+// SMTPNotifier sends messages as plain-text email through an SMTP server.
+type SMTPNotifier struct {
+	Host     string
+	Port     string
+	Username string // Optional; PLAIN auth is used when set
+	Password string
+	From     string
+}
+
+// NewSMTPNotifier creates a new SMTPNotifier.
+func NewSMTPNotifier(host, port, username, password, from string) *SMTPNotifier {
+	return &SMTPNotifier{Host: host, Port: port, Username: username, Password: password, From: from}
+}
+
+// Notify sends msg via SMTP.
+func (n *SMTPNotifier) Notify(ctx context.Context, msg Message) error {
+	if strings.ContainsAny(msg.To+msg.Subject, "\r\n") {
+		return fmt.Errorf("invalid message header: line breaks are not allowed")
+	}
+	var auth smtp.Auth
+	if n.Username != "" {
+		auth = smtp.PlainAuth("", n.Username, n.Password, n.Host)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", n.From)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+
+	if err := smtp.SendMail(n.Host+":"+n.Port, auth, n.From, []string{msg.To}, []byte(b.String())); err != nil {
+		return fmt.Errorf("failed to send email to %s: %w", msg.To, err)
+	}
+	return nil
+}
+
+// LogNotifier writes messages to a writer instead of delivering them. It is
+// meant for local development and self-hosted setups without an SMTP server.
+type LogNotifier struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewLogNotifier creates a LogNotifier that writes to the standard logger.
+func NewLogNotifier() *LogNotifier {
+	return &LogNotifier{w: log.Writer()}
+}
+
+// NewFileNotifier creates a LogNotifier that appends messages to the file at path.
+func NewFileNotifier(path string) (*LogNotifier, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open notification file %s: %w", path, err)
+	}
+	return &LogNotifier{w: f}, nil
+}
+
+// Notify writes msg to the underlying writer.
+func (n *LogNotifier) Notify(ctx context.Context, msg Message) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	_, err := fmt.Fprintf(n.w, "--- %s\nTo: %s\nSubject: %s\n\n%s\n\n",
+		time.Now().Format(time.RFC3339), msg.To, msg.Subject, msg.Body)
+	if err != nil {
+		return fmt.Errorf("failed to write notification: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package ratelimit
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Config sets the quotas enforced by the API.
+type Config struct {
+	// Default limits each client IP across all API routes.
+	Default Limit
+	// Routes adds stricter per-client limits for individual routes, keyed by
+	// method and chi route pattern, e.g. "POST /api/v1/register".
+	Routes map[string]Limit
+	// Login limits login attempts per client IP and per account.
+	Login Limit
+	// PasswordReset limits reset emails per account.
+	PasswordReset Limit
+	// APIKey limits each API key, unless the key sets its own quota.
+	APIKey Limit
+	// Lockout applies to failed logins and invalid API keys.
+	Lockout LockoutPolicy
+}
+
+// DefaultConfig returns the quotas used when nothing is configured.
+func DefaultConfig() Config {
+	return Config{
+		Default: PerMinute(300),
+		Routes: map[string]Limit{
+			"POST /api/v1/register":        PerMinute(5),
+			"POST /api/v1/password/forgot": PerMinute(5),
+			"POST /api/v1/password/reset":  PerMinute(10),
+			"POST /api/v1/refresh":         PerMinute(30),
+		},
+		Login:         PerMinute(10),
+		PasswordReset: Limit{Requests: 3, Period: time.Hour},
+		APIKey:        PerMinute(600),
+		Lockout:       DefaultLockoutPolicy,
+	}
+}
+
+// LoadConfigFromEnv starts from DefaultConfig and applies overrides:
+//
+//   - RATE_LIMIT_DEFAULT, RATE_LIMIT_LOGIN, RATE_LIMIT_PASSWORD_RESET,
+//     RATE_LIMIT_API_KEY: limits such as "300/m".
+//   - RATE_LIMIT_ROUTES: comma-separated "METHOD /pattern=limit" entries,
+//     e.g. "POST /api/v1/register=3/m". These are added to the default routes.
+func LoadConfigFromEnv() (Config, error) {
+	cfg := DefaultConfig()
+	for key, target := range map[string]*Limit{
+		"RATE_LIMIT_DEFAULT":        &cfg.Default,
+		"RATE_LIMIT_LOGIN":          &cfg.Login,
+		"RATE_LIMIT_PASSWORD_RESET": &cfg.PasswordReset,
+		"RATE_LIMIT_API_KEY":        &cfg.APIKey,
+	} {
+		v := os.Getenv(key)
+		if v == "" {
+			continue
+		}
+		limit, err := ParseLimit(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid %s: %w", key, err)
+		}
+		*target = limit
+	}
+
+	if routes := os.Getenv("RATE_LIMIT_ROUTES"); routes != "" {
+		for _, entry := range strings.Split(routes, ",") {
+			route, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+			if !ok {
+				return Config{}, fmt.Errorf("invalid RATE_LIMIT_ROUTES entry %q", entry)
+			}
+			limit, err := ParseLimit(value)
+			if err != nil {
+				return Config{}, fmt.Errorf("invalid RATE_LIMIT_ROUTES entry %q: %w", entry, err)
+			}
+			cfg.Routes[strings.Join(strings.Fields(route), " ")] = limit
+		}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,21 @@
+package ratelimit
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// SetHeaders reports the state of a bucket in the response headers.
+func SetHeaders(w http.ResponseWriter, limit Limit, res Result) {
+	w.Header().Set("X-RateLimit-Limit", limit.String())
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+}
+
+// Reject responds with 429 Too Many Requests and a Retry-After header.
+func Reject(w http.ResponseWriter, retryAfter time.Duration, msg string) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(max(seconds, 1)))
+	http.Error(w, msg, http.StatusTooManyRequests)
+}
@@ -0,0 +1,136 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// memorySweepInterval is how often idle state is dropped from a MemoryStore.
+const memorySweepInterval = time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	limit  Limit
+}
+
+type failureRecord struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+	window      time.Duration
+}
+
+// MemoryStore keeps state in process memory. State is lost on restart and not
+// shared between instances; use PostgresStore for that.
+type MemoryStore struct {
+	mu       sync.Mutex
+	buckets  map[string]*bucket
+	failures map[string]*failureRecord
+
+	stop chan struct{}
+	once sync.Once
+}
+
+// NewMemoryStore creates a MemoryStore that periodically drops idle state.
+func NewMemoryStore() *MemoryStore {
+	s := &MemoryStore{
+		buckets:  make(map[string]*bucket),
+		failures: make(map[string]*failureRecord),
+		stop:     make(chan struct{}),
+	}
+	go s.sweep()
+	return s
+}
+
+// Take implements Store.
+func (s *MemoryStore) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Requests), last: now}
+		s.buckets[key] = b
+	}
+	b.tokens = refill(b.tokens, b.last, now, limit)
+	b.last, b.limit = now, limit
+	if b.tokens < 1 {
+		return result(false, b.tokens, limit), nil
+	}
+	b.tokens--
+	return result(true, b.tokens, limit), nil
+}
+
+// LockedFor implements Store.
+func (s *MemoryStore) LockedFor(ctx context.Context, key string) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f, ok := s.failures[key]; ok {
+		if d := time.Until(f.lockedUntil); d > 0 {
+			return d, nil
+		}
+	}
+	return 0, nil
+}
+
+// Fail implements Store.
+func (s *MemoryStore) Fail(ctx context.Context, key string, policy LockoutPolicy) (time.Duration, error) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, ok := s.failures[key]
+	if !ok || now.Sub(f.lastFailure) > policy.Window {
+		f = &failureRecord{}
+		s.failures[key] = f
+	}
+	f.failures++
+	f.lastFailure, f.window = now, policy.Window
+	lockout := policy.lockoutFor(f.failures)
+	if lockout > 0 {
+		f.lockedUntil = now.Add(lockout)
+	}
+	return lockout, nil
+}
+
+// Reset implements Store.
+func (s *MemoryStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.failures, key)
+	return nil
+}
+
+// Close implements Store.
+func (s *MemoryStore) Close() {
+	s.once.Do(func() { close(s.stop) })
+}
+
+// sweep drops buckets that have refilled completely and failures whose window has passed.
+func (s *MemoryStore) sweep() {
+	ticker := time.NewTicker(memorySweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			now := time.Now()
+			s.mu.Lock()
+			for key, b := range s.buckets {
+				if refill(b.tokens, b.last, now, b.limit) >= float64(b.limit.Requests) {
+					delete(s.buckets, key)
+				}
+			}
+			for key, f := range s.failures {
+				if now.Sub(f.lastFailure) > f.window && now.After(f.lockedUntil) {
+					delete(s.failures, key)
+				}
+			}
+			s.mu.Unlock()
+		case <-s.stop:
+			return
+		}
+	}
+}
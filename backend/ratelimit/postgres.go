@@ -0,0 +1,145 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// postgresCleanupInterval is how often idle rows are deleted from a PostgresStore.
+const postgresCleanupInterval = 10 * time.Minute
+
+// PostgresStore keeps state in Postgres so it survives restarts and is
+// shared between instances.
+type PostgresStore struct {
+	db *pgxpool.Pool
+
+	stop chan struct{}
+	once sync.Once
+}
+
+// NewPostgresStore creates a PostgresStore that periodically deletes idle state.
+func NewPostgresStore(db *pgxpool.Pool) *PostgresStore {
+	s := &PostgresStore{db: db, stop: make(chan struct{})}
+	go s.cleanup()
+	return s
+}
+
+// Take implements Store. The refill and take happen in one statement, so
+// concurrent requests cannot overdraw a bucket.
+func (s *PostgresStore) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	query := `
+		INSERT INTO rate_limit_buckets AS b (key, tokens, allowed, updated_at)
+		VALUES ($1, $3 - 1, TRUE, CURRENT_TIMESTAMP)
+		ON CONFLICT (key) DO UPDATE SET
+			tokens = LEAST($3, b.tokens + EXTRACT(EPOCH FROM CURRENT_TIMESTAMP - b.updated_at) * $2)
+				- CASE WHEN LEAST($3, b.tokens + EXTRACT(EPOCH FROM CURRENT_TIMESTAMP - b.updated_at) * $2) >= 1 THEN 1 ELSE 0 END,
+			allowed = LEAST($3, b.tokens + EXTRACT(EPOCH FROM CURRENT_TIMESTAMP - b.updated_at) * $2) >= 1,
+			updated_at = CURRENT_TIMESTAMP
+		RETURNING tokens, allowed
+	`
+	var tokens float64
+	var allowed bool
+	if err := s.db.QueryRow(ctx, query, key, limit.rate(), float64(limit.Requests)).Scan(&tokens, &allowed); err != nil {
+		return Result{}, fmt.Errorf("failed to take rate limit token: %w", err)
+	}
+	return result(allowed, tokens, limit), nil
+}
+
+// LockedFor implements Store.
+func (s *PostgresStore) LockedFor(ctx context.Context, key string) (time.Duration, error) {
+	var lockedUntil *time.Time
+	err := s.db.QueryRow(ctx, `SELECT locked_until FROM auth_failures WHERE key = $1`, key).Scan(&lockedUntil)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to get lockout: %w", err)
+	}
+	if lockedUntil == nil {
+		return 0, nil
+	}
+	return max(time.Until(*lockedUntil), 0), nil
+}
+
+// Fail implements Store.
+func (s *PostgresStore) Fail(ctx context.Context, key string, policy LockoutPolicy) (time.Duration, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx) // Rollback if not committed
+
+	// Failures older than the window start a new count
+	query := `
+		INSERT INTO auth_failures AS f (key, failures, last_failure_at, expires_at)
+		VALUES ($1, 1, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP + $2 * INTERVAL '1 second')
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN f.last_failure_at < CURRENT_TIMESTAMP - $2 * INTERVAL '1 second' THEN 1 ELSE f.failures + 1 END,
+			last_failure_at = CURRENT_TIMESTAMP,
+			expires_at = CURRENT_TIMESTAMP + $2 * INTERVAL '1 second'
+		RETURNING failures
+	`
+	var failures int
+	if err := tx.QueryRow(ctx, query, key, policy.Window.Seconds()).Scan(&failures); err != nil {
+		return 0, fmt.Errorf("failed to record failure: %w", err)
+	}
+
+	lockout := policy.lockoutFor(failures)
+	if lockout > 0 {
+		lockQuery := `
+			UPDATE auth_failures
+			SET locked_until = CURRENT_TIMESTAMP + $2 * INTERVAL '1 second',
+				expires_at = GREATEST(expires_at, CURRENT_TIMESTAMP + $2 * INTERVAL '1 second')
+			WHERE key = $1
+		`
+		if _, err := tx.Exec(ctx, lockQuery, key, lockout.Seconds()); err != nil {
+			return 0, fmt.Errorf("failed to record lockout: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return lockout, nil
+}
+
+// Reset implements Store.
+func (s *PostgresStore) Reset(ctx context.Context, key string) error {
+	if _, err := s.db.Exec(ctx, `DELETE FROM auth_failures WHERE key = $1`, key); err != nil {
+		return fmt.Errorf("failed to reset failures: %w", err)
+	}
+	return nil
+}
+
+// Close implements Store.
+func (s *PostgresStore) Close() {
+	s.once.Do(func() { close(s.stop) })
+}
+
+// cleanup deletes full buckets and forgotten failures.
+func (s *PostgresStore) cleanup() {
+	ticker := time.NewTicker(postgresCleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			// A bucket idle for an hour has refilled for any limit with a period of up to an hour
+			if _, err := s.db.Exec(ctx, `DELETE FROM rate_limit_buckets WHERE updated_at < CURRENT_TIMESTAMP - INTERVAL '1 hour'`); err != nil {
+				log.Printf("Error cleaning up rate limit buckets: %v", err)
+			}
+			if _, err := s.db.Exec(ctx, `DELETE FROM auth_failures WHERE expires_at < CURRENT_TIMESTAMP`); err != nil {
+				log.Printf("Error cleaning up auth failures: %v", err)
+			}
+			cancel()
+		case <-s.stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,144 @@
+// Package ratelimit provides token-bucket rate limiting and exponential
+// lockout after repeated failures, with state kept in memory or in Postgres.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit allows Requests per Period on average, with bursts of up to Requests.
+type Limit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// PerMinute returns a Limit of n requests per minute.
+func PerMinute(n int) Limit {
+	return Limit{Requests: n, Period: time.Minute}
+}
+
+// rate returns the refill rate in tokens per second.
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+// String formats the limit as accepted by ParseLimit.
+func (l Limit) String() string {
+	switch l.Period {
+	case time.Second:
+		return fmt.Sprintf("%d/s", l.Requests)
+	case time.Minute:
+		return fmt.Sprintf("%d/m", l.Requests)
+	case time.Hour:
+		return fmt.Sprintf("%d/h", l.Requests)
+	}
+	return fmt.Sprintf("%d/%s", l.Requests, l.Period)
+}
+
+// ParseLimit parses limits such as "10/m", "100/h", "5/s" or "30/10m".
+func ParseLimit(s string) (Limit, error) {
+	count, period, ok := strings.Cut(strings.TrimSpace(s), "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("invalid rate limit %q: expected <requests>/<period>", s)
+	}
+	n, err := strconv.Atoi(count)
+	if err != nil || n <= 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q: requests must be a positive integer", s)
+	}
+	var d time.Duration
+	switch period {
+	case "s":
+		d = time.Second
+	case "m":
+		d = time.Minute
+	case "h":
+		d = time.Hour
+	default:
+		if d, err = time.ParseDuration(period); err != nil || d <= 0 {
+			return Limit{}, fmt.Errorf("invalid rate limit %q: unknown period %q", s, period)
+		}
+	}
+	return Limit{Requests: n, Period: d}, nil
+}
+
+// Result is the outcome of taking a token from a bucket.
+type Result struct {
+	Allowed    bool
+	Remaining  int           // Whole tokens left in the bucket
+	RetryAfter time.Duration // When the next token is available, if not allowed
+}
+
+// LockoutPolicy locks a key out after repeated failures. The first lockout
+// lasts BaseDelay and each further failure doubles it, up to MaxDelay.
+// Failures are forgotten after Window without any.
+type LockoutPolicy struct {
+	Threshold int // Failures allowed before the first lockout
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	Window    time.Duration
+}
+
+// DefaultLockoutPolicy locks out after 5 failures, for 30s doubling up to an hour.
+var DefaultLockoutPolicy = LockoutPolicy{Threshold: 5, BaseDelay: 30 * time.Second, MaxDelay: time.Hour, Window: 24 * time.Hour}
+
+// lockoutFor returns how long to lock a key out after its nth consecutive failure.
+func (p LockoutPolicy) lockoutFor(failures int) time.Duration {
+	if failures < p.Threshold {
+		return 0
+	}
+	exp := failures - p.Threshold
+	if exp > 30 {
+		return p.MaxDelay
+	}
+	d := time.Duration(float64(p.BaseDelay) * math.Pow(2, float64(exp)))
+	if d > p.MaxDelay || d <= 0 {
+		return p.MaxDelay
+	}
+	return d
+}
+
+// Store keeps rate limiter and lockout state. Implementations must be safe for
+// concurrent use.
+type Store interface {
+	// Take removes a token from the bucket for key, refilling it at limit's rate.
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+	// LockedFor returns how much longer key is locked out, or zero.
+	LockedFor(ctx context.Context, key string) (time.Duration, error)
+	// Fail records a failure for key and returns the lockout it triggered, or zero.
+	Fail(ctx context.Context, key string, policy LockoutPolicy) (time.Duration, error)
+	// Reset forgets the failures recorded for key.
+	Reset(ctx context.Context, key string) error
+	// Close stops background cleanup.
+	Close()
+}
+
+// refill returns the tokens in a bucket that held tokens at last, at now.
+func refill(tokens float64, last, now time.Time, limit Limit) float64 {
+	tokens += now.Sub(last).Seconds() * limit.rate()
+	return math.Min(tokens, float64(limit.Requests))
+}
+
+// result builds the Result for a bucket holding tokens after a take attempt.
+func result(allowed bool, tokens float64, limit Limit) Result {
+	r := Result{Allowed: allowed, Remaining: int(math.Max(tokens, 0))}
+	if !allowed {
+		r.RetryAfter = time.Duration((1 - tokens) / limit.rate() * float64(time.Second))
+	}
+	return r
+}
+
+// Limiter enforces a Config using a Store.
+type Limiter struct {
+	Store  Store
+	Config Config
+}
+
+// NewLimiter creates a new Limiter.
+func NewLimiter(store Store, cfg Config) *Limiter {
+	return &Limiter{Store: store, Config: cfg}
+}
@@ -2,20 +2,26 @@ package router
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/m-cain/mnemo/backend/apperrors"
 	"github.com/m-cain/mnemo/backend/auth"
 	"github.com/m-cain/mnemo/backend/contextkey"
 	"github.com/m-cain/mnemo/backend/inventory"
+	"github.com/m-cain/mnemo/backend/models"
 )
 
 // RegisterAPIKeyRoutes registers the API key related routes.
 func RegisterAPIKeyRoutes(r chi.Router, apiKeyService *auth.APIKeyService, authService *auth.AuthService, inventoryService *inventory.InventoryService) {
 	r.Route("/api-keys", func(r chi.Router) {
-		r.Use(authService.AuthMiddleware) // Protect API key routes
+		r.Use(authService.AuthMiddleware)    // Protect API key routes
+		r.Use(RequireScope(auth.ScopeAdmin)) // API keys may only manage keys when granted admin
 
 		r.Get("/", func(w http.ResponseWriter, r *http.Request) {
 			userID := r.Context().Value(contextkey.UserIDKey).(string) // Use contextkey.UserIDKey
@@ -31,20 +37,81 @@ func RegisterAPIKeyRoutes(r chi.Router, apiKeyService *auth.APIKeyService, authS
 		r.Post("/", func(w http.ResponseWriter, r *http.Request) {
 			userID := r.Context().Value(contextkey.UserIDKey).(string) // Use contextkey.UserIDKey
 			var req struct {
-				Name string `json:"name"`
+				Name      string      `json:"name"`
+				Scopes    []string    `json:"scopes"`
+				HomeIDs   []uuid.UUID `json:"home_ids"`
+				ExpiresAt *time.Time  `json:"expires_at"`
+				// Optional per-key quota overriding the server default
+				RateLimitPerMinute *int `json:"rate_limit_per_minute"`
 			}
 			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 				http.Error(w, "Invalid request body", http.StatusBadRequest)
 				return
 			}
-			_, rawKey, err := apiKeyService.GenerateAPIKey(r.Context(), userID, req.Name) // Use _ for unused apiKey
+			opts := auth.APIKeyOptions{Scopes: req.Scopes, HomeIDs: req.HomeIDs, ExpiresAt: req.ExpiresAt, RateLimitPerMinute: req.RateLimitPerMinute}
+			apiKey, rawKey, err := apiKeyService.GenerateAPIKey(r.Context(), userID, req.Name, opts)
 			if err != nil {
+				if errors.Is(err, apperrors.ErrInvalidInput) {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				if errors.Is(err, auth.ErrExceedsGrants) {
+					http.Error(w, err.Error(), http.StatusForbidden)
+					return
+				}
 				http.Error(w, "Failed to generate API key", http.StatusInternalServerError)
 				log.Printf("Error generating API key: %v", err)
 				return
 			}
-			// Return the generated raw key (only once)
-			json.NewEncoder(w).Encode(map[string]string{"key": rawKey})
+			// Return the generated raw key (only once) along with the key's details
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(struct {
+				*models.APIKey
+				Key string `json:"key"`
+			}{apiKey, rawKey})
+		})
+
+		r.Post("/{apiKeyID}/rotate", func(w http.ResponseWriter, r *http.Request) {
+			userID := r.Context().Value(contextkey.UserIDKey).(string) // Use contextkey.UserIDKey
+			apiKeyID := chi.URLParam(r, "apiKeyID")
+			var req struct {
+				GracePeriod string `json:"grace_period"` // Go duration such as "24h"; defaults to auth.DefaultRotationGracePeriod
+			}
+			if r.ContentLength != 0 {
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					http.Error(w, "Invalid request body", http.StatusBadRequest)
+					return
+				}
+			}
+			grace := auth.DefaultRotationGracePeriod
+			if req.GracePeriod != "" {
+				var err error
+				if grace, err = time.ParseDuration(req.GracePeriod); err != nil {
+					http.Error(w, "Invalid grace_period", http.StatusBadRequest)
+					return
+				}
+			}
+
+			apiKey, rawKey, err := apiKeyService.RotateAPIKey(r.Context(), apiKeyID, userID, grace)
+			if err != nil {
+				if errors.Is(err, apperrors.ErrInvalidInput) {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+				} else if errors.Is(err, auth.ErrExceedsGrants) {
+					http.Error(w, err.Error(), http.StatusForbidden)
+				} else if err == pgx.ErrNoRows {
+					http.Error(w, "API key not found or does not belong to user", http.StatusNotFound)
+				} else {
+					http.Error(w, "Failed to rotate API key", http.StatusInternalServerError)
+					log.Printf("Error rotating API key: %v", err)
+				}
+				return
+			}
+			// Return the replacement raw key (only once) along with the key's details
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(struct {
+				*models.APIKey
+				Key string `json:"key"`
+			}{apiKey, rawKey})
 		})
 
 		r.Delete("/{apiKeyID}", func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,249 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/m-cain/mnemo/backend/apperrors"
+	"github.com/m-cain/mnemo/backend/attachment"
+	"github.com/m-cain/mnemo/backend/home"
+)
+
+// multipartOverhead is allowed on top of the maximum attachment size for the
+// multipart framing and other form fields of an upload.
+const multipartOverhead = 1 << 20
+
+// attachmentRoutes serves the attachments of one type of owner. The owner ID
+// is read from the ownerParam URL parameter.
+type attachmentRoutes struct {
+	attachmentService *attachment.Service
+	ownerType         string
+	ownerParam        string
+}
+
+// registerAttachmentRoutes registers the attachment routes of an owner type
+// below /{ownerParam}/attachments. Reading attachments requires readPerm and
+// changing them writePerm.
+func registerAttachmentRoutes(r chi.Router, attachmentService *attachment.Service, ownerType, ownerParam string, readPerm, writePerm home.Permission) {
+	a := &attachmentRoutes{attachmentService: attachmentService, ownerType: ownerType, ownerParam: ownerParam}
+	r.Route("/{"+ownerParam+"}/attachments", func(r chi.Router) {
+		r.With(RequirePermission(readPerm)).Get("/", a.listAttachmentsHandler)
+		r.With(RequirePermission(writePerm)).Post("/", a.uploadAttachmentHandler)
+		r.With(RequirePermission(readPerm)).Get("/{attachmentID}", a.getAttachmentHandler)
+		r.With(RequirePermission(readPerm)).Get("/{attachmentID}/content", a.attachmentContentHandler(false))
+		r.With(RequirePermission(readPerm)).Get("/{attachmentID}/thumbnail", a.attachmentContentHandler(true))
+		r.With(RequirePermission(writePerm)).Delete("/{attachmentID}", a.deleteAttachmentHandler)
+	})
+}
+
+// params parses the home, owner and, if withAttachment is set, attachment
+// IDs of a request. On failure it writes an error response and returns false.
+func (a *attachmentRoutes) params(w http.ResponseWriter, r *http.Request, withAttachment bool) (homeID uuid.UUID, owner attachment.Owner, attachmentID uuid.UUID, ok bool) {
+	homeID, err := homeIDParam(r)
+	if err != nil {
+		http.Error(w, "Invalid home ID", http.StatusBadRequest)
+		return homeID, owner, attachmentID, false
+	}
+	ownerID, err := uuid.Parse(chi.URLParam(r, a.ownerParam))
+	if err != nil {
+		http.Error(w, "Invalid "+a.ownerType+" ID format", http.StatusBadRequest)
+		return homeID, owner, attachmentID, false
+	}
+	owner = attachment.Owner{Type: a.ownerType, ID: ownerID}
+	if withAttachment {
+		attachmentID, err = uuid.Parse(chi.URLParam(r, "attachmentID"))
+		if err != nil {
+			http.Error(w, "Invalid attachment ID format", http.StatusBadRequest)
+			return homeID, owner, attachmentID, false
+		}
+	}
+	return homeID, owner, attachmentID, true
+}
+
+// notFoundMessage describes a missing owner or attachment.
+func (a *attachmentRoutes) notFoundMessage(withAttachment bool) string {
+	if withAttachment {
+		return "Attachment not found"
+	}
+	return strings.ToUpper(a.ownerType[:1]) + a.ownerType[1:] + " not found"
+}
+
+// listAttachmentsHandler lists the attachments of an owner.
+func (a *attachmentRoutes) listAttachmentsHandler(w http.ResponseWriter, r *http.Request) {
+	homeID, owner, _, ok := a.params(w, r, false)
+	if !ok {
+		return
+	}
+
+	attachments, err := a.attachmentService.List(r.Context(), homeID, owner)
+	if err != nil {
+		if errors.Is(err, apperrors.ErrNotFound) {
+			http.Error(w, a.notFoundMessage(false), http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to list attachments", http.StatusInternalServerError)
+		log.Printf("Error listing attachments: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(attachments)
+}
+
+// uploadAttachmentHandler attaches the file in the "file" field of a
+// multipart/form-data request to an owner. The file is streamed to the
+// attachment service rather than parsed into memory.
+func (a *attachmentRoutes) uploadAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	homeID, owner, _, ok := a.params(w, r, false)
+	if !ok {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, a.attachmentService.MaxSize()+multipartOverhead)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "Request must be multipart/form-data", http.StatusBadRequest)
+		return
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			http.Error(w, "Missing file field", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			writeAttachmentUploadError(w, err)
+			return
+		}
+		if part.FormName() != "file" {
+			part.Close()
+			continue
+		}
+
+		created, err := a.attachmentService.Upload(r.Context(), homeID, owner, part.FileName(), part)
+		if err != nil {
+			if errors.Is(err, apperrors.ErrNotFound) {
+				http.Error(w, a.notFoundMessage(false), http.StatusNotFound)
+				return
+			}
+			writeAttachmentUploadError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(created)
+		return
+	}
+}
+
+// writeAttachmentUploadError maps an upload error to a response.
+func writeAttachmentUploadError(w http.ResponseWriter, err error) {
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, attachment.ErrTooLarge), errors.As(err, &maxBytesErr):
+		http.Error(w, "Attachment is too large", http.StatusRequestEntityTooLarge)
+	case errors.Is(err, attachment.ErrUnsupportedType):
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+	case errors.Is(err, apperrors.ErrInvalidInput):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "Failed to upload attachment", http.StatusInternalServerError)
+		log.Printf("Error uploading attachment: %v", err)
+	}
+}
+
+// getAttachmentHandler returns the metadata of an attachment.
+func (a *attachmentRoutes) getAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	homeID, owner, attachmentID, ok := a.params(w, r, true)
+	if !ok {
+		return
+	}
+
+	found, err := a.attachmentService.Get(r.Context(), homeID, owner, attachmentID)
+	if err != nil {
+		if errors.Is(err, apperrors.ErrNotFound) {
+			http.Error(w, a.notFoundMessage(true), http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to get attachment", http.StatusInternalServerError)
+		log.Printf("Error getting attachment: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(found)
+}
+
+// attachmentContentHandler returns a http.HandlerFunc that serves the
+// contents of an attachment, or its thumbnail if thumbnail is set. Images are
+// shown inline; other files are downloaded.
+func (a *attachmentRoutes) attachmentContentHandler(thumbnail bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		homeID, owner, attachmentID, ok := a.params(w, r, true)
+		if !ok {
+			return
+		}
+
+		found, contents, err := a.attachmentService.Open(r.Context(), homeID, owner, attachmentID, thumbnail)
+		if err != nil {
+			if errors.Is(err, apperrors.ErrNotFound) {
+				http.Error(w, a.notFoundMessage(true), http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Failed to get attachment", http.StatusInternalServerError)
+			log.Printf("Error opening attachment: %v", err)
+			return
+		}
+		defer contents.Close()
+
+		contentType, disposition := found.ContentType, "attachment"
+		if thumbnail {
+			contentType = "image/jpeg"
+		}
+		if strings.HasPrefix(contentType, "image/") {
+			disposition = "inline"
+		}
+		if !thumbnail {
+			w.Header().Set("Content-Length", strconv.FormatInt(found.Size, 10))
+		}
+		w.Header().Set("Content-Type", contentType)
+		if cd := mime.FormatMediaType(disposition, map[string]string{"filename": found.Filename}); cd != "" {
+			w.Header().Set("Content-Disposition", cd)
+		}
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Cache-Control", "private, max-age=86400") // Contents never change
+		if _, err := io.Copy(w, contents); err != nil {
+			log.Printf("Error serving attachment %s: %v", attachmentID, err)
+		}
+	}
+}
+
+// deleteAttachmentHandler deletes an attachment.
+func (a *attachmentRoutes) deleteAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	homeID, owner, attachmentID, ok := a.params(w, r, true)
+	if !ok {
+		return
+	}
+
+	if err := a.attachmentService.Delete(r.Context(), homeID, owner, attachmentID); err != nil {
+		if errors.Is(err, apperrors.ErrNotFound) {
+			http.Error(w, a.notFoundMessage(true), http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to delete attachment", http.StatusInternalServerError)
+		log.Printf("Error deleting attachment: %v", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
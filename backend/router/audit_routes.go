@@ -0,0 +1,103 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/m-cain/mnemo/backend/apperrors"
+	"github.com/m-cain/mnemo/backend/audit"
+	"github.com/m-cain/mnemo/backend/models"
+)
+
+// listAuditEventsHandler returns a http.HandlerFunc that lists the audit log of a home.
+// Filters and pagination are taken from the query string; see parseAuditQuery.
+func listAuditEventsHandler(auditService *audit.Service) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		homeID, err := uuid.Parse(chi.URLParam(r, "homeID"))
+		if err != nil {
+			http.Error(w, "Invalid Home ID format", http.StatusBadRequest)
+			return
+		}
+
+		query, err := parseAuditQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		page, err := auditService.List(r.Context(), homeID, query)
+		if err != nil {
+			if errors.Is(err, apperrors.ErrInvalidInput) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			http.Error(w, "Failed to list audit events", http.StatusInternalServerError)
+			log.Printf("Error listing audit events: %v", err)
+			return
+		}
+
+		resp := models.PaginatedResponse[models.AuditEvent]{
+			Data:       page.Events,
+			Total:      page.Total,
+			Page:       page.Page,
+			PerPage:    page.PerPage,
+			TotalPages: (page.Total + page.PerPage - 1) / page.PerPage,
+		}
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// parseAuditQuery builds an audit.Query from the request's query string.
+// Supported parameters: user_id, api_key_id, action, entity_type, entity_id,
+// since and until (RFC 3339), page and per_page.
+func parseAuditQuery(r *http.Request) (audit.Query, error) {
+	params := r.URL.Query()
+	query := audit.Query{
+		Action:     params.Get("action"),
+		EntityType: params.Get("entity_type"),
+	}
+
+	var err error
+	if query.UserID, err = parseOptionalUUID(params.Get("user_id")); err != nil {
+		return query, fmt.Errorf("invalid user_id: %w", err)
+	}
+	if query.APIKeyID, err = parseOptionalUUID(params.Get("api_key_id")); err != nil {
+		return query, fmt.Errorf("invalid api_key_id: %w", err)
+	}
+	if query.EntityID, err = parseOptionalUUID(params.Get("entity_id")); err != nil {
+		return query, fmt.Errorf("invalid entity_id: %w", err)
+	}
+	if v := params.Get("since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return query, fmt.Errorf("invalid since: %w", err)
+		}
+		query.Since = &t
+	}
+	if v := params.Get("until"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return query, fmt.Errorf("invalid until: %w", err)
+		}
+		query.Until = &t
+	}
+	if v := params.Get("page"); v != "" {
+		if query.Page, err = strconv.Atoi(v); err != nil || query.Page < 1 {
+			return query, fmt.Errorf("invalid page: %q", v)
+		}
+	}
+	if v := params.Get("per_page"); v != "" {
+		if query.PerPage, err = strconv.Atoi(v); err != nil || query.PerPage < 1 {
+			return query, fmt.Errorf("invalid per_page: %q", v)
+		}
+	}
+
+	return query, nil
+}
@@ -0,0 +1,258 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/m-cain/mnemo/backend/apperrors"
+	"github.com/m-cain/mnemo/backend/decimal"
+	"github.com/m-cain/mnemo/backend/home"
+	"github.com/m-cain/mnemo/backend/inventory"
+	"github.com/m-cain/mnemo/backend/models"
+)
+
+// RegisterBarcodeRoutes registers the barcode lookup routes of a home. It is
+// mounted under /homes/{homeID}, after homeIDMiddleware. The code is passed in
+// the code query parameter, or escaped in the path of a lookup as
+// /barcodes/{code}. The query form is preferred, since clients may resolve
+// dot segments of codes such as "a/../b" before sending them.
+func RegisterBarcodeRoutes(r chi.Router, inventoryService *inventory.InventoryService) {
+	r.With(RequirePermission(home.PermItemsRead)).Get("/barcodes", lookupBarcodeHandler(inventoryService))
+	r.With(RequirePermission(home.PermItemsRead)).Get("/barcodes/*", lookupBarcodeHandler(inventoryService))
+	r.With(RequirePermission(home.PermItemsWrite)).Post("/barcodes/scan", scanBarcodeHandler(inventoryService))
+}
+
+// barcodeLookupResponse is returned by barcode lookups. When no item has the
+// barcode, Suggestion is "create" and Barcode holds the validated code to
+// attach to the new item.
+type barcodeLookupResponse struct {
+	Found      bool            `json:"found"`
+	Item       *models.Item    `json:"item,omitempty"`
+	Suggestion string          `json:"suggestion,omitempty"`
+	Barcode    *models.Barcode `json:"barcode,omitempty"`
+}
+
+// writeBarcodeNotFound responds that no item has the barcode and suggests
+// creating one. An invalid code is rejected instead.
+func writeBarcodeNotFound(w http.ResponseWriter, code, symbology string) {
+	code, symbology, err := inventory.NormalizeBarcode(code, symbology)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNotFound)
+	json.NewEncoder(w).Encode(barcodeLookupResponse{
+		Suggestion: "create",
+		Barcode:    &models.Barcode{Code: code, Symbology: symbology},
+	})
+}
+
+// pathBarcode returns the code escaped in the path after /barcodes/, or an
+// empty string if there is none. The code is read from the escaped request
+// path rather than the route wildcard, since the router decodes escaped
+// slashes and URLFormat strips an extension such as ".json" from the route.
+func pathBarcode(r *http.Request) (string, error) {
+	path := r.URL.EscapedPath()
+	i := strings.Index(path, "/barcodes/")
+	if i < 0 {
+		return "", nil
+	}
+	return url.PathUnescape(path[i+len("/barcodes/"):])
+}
+
+// lookupBarcodeHandler returns a http.HandlerFunc that finds the item a
+// barcode is attached to, given in the code query parameter or the path. The
+// optional symbology query parameter is used when suggesting a new item.
+func lookupBarcodeHandler(inventoryService *inventory.InventoryService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		homeID, err := homeIDParam(r)
+		if err != nil {
+			http.Error(w, "Invalid home ID", http.StatusBadRequest)
+			return
+		}
+		code := r.URL.Query().Get("code")
+		if code == "" {
+			if code, err = pathBarcode(r); err != nil {
+				http.Error(w, "Invalid barcode", http.StatusBadRequest)
+				return
+			}
+		}
+		if code == "" {
+			http.Error(w, "Missing barcode", http.StatusBadRequest)
+			return
+		}
+
+		item, err := inventoryService.FindItemByBarcode(r.Context(), homeID, code)
+		if err != nil {
+			if errors.Is(err, apperrors.ErrNotFound) {
+				writeBarcodeNotFound(w, code, r.URL.Query().Get("symbology"))
+				return
+			}
+			http.Error(w, "Failed to look up barcode", http.StatusInternalServerError)
+			log.Printf("Error looking up barcode: %v", err)
+			return
+		}
+
+		w.Header().Set("ETag", itemETag(item))
+		json.NewEncoder(w).Encode(barcodeLookupResponse{Found: true, Item: item})
+	}
+}
+
+// scanBarcodeHandler returns a http.HandlerFunc that changes the quantity of
+// the item a barcode is attached to. The body is optional: delta defaults to
+// 1 and reason to purchase, so a plain POST restocks one unit. An empty body
+// is accepted whether or not its length is known, as with chunked encoding.
+func scanBarcodeHandler(inventoryService *inventory.InventoryService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		homeID, err := homeIDParam(r)
+		if err != nil {
+			http.Error(w, "Invalid home ID", http.StatusBadRequest)
+			return
+		}
+		code := r.URL.Query().Get("code")
+		if code == "" {
+			http.Error(w, "Missing barcode", http.StatusBadRequest)
+			return
+		}
+
+		req := struct {
+			Delta         decimal.Decimal `json:"delta"`
+			Reason        string          `json:"reason"`
+			AllowNegative bool            `json:"allow_negative"`
+			Symbology     string          `json:"symbology"`
+		}{Delta: decimal.FromInt(1), Reason: inventory.ReasonPurchase}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		change := inventory.QuantityChange{Delta: &req.Delta, Reason: req.Reason, AllowNegative: req.AllowNegative}
+		item, err := inventoryService.ScanBarcode(r.Context(), homeID, code, change)
+		if err != nil {
+			if errors.Is(err, apperrors.ErrNotFound) {
+				writeBarcodeNotFound(w, code, req.Symbology)
+				return
+			}
+			writeItemQuantityError(w, err)
+			return
+		}
+
+		w.Header().Set("ETag", itemETag(item))
+		json.NewEncoder(w).Encode(barcodeLookupResponse{Found: true, Item: item})
+	}
+}
+
+// listItemBarcodesHandler returns a http.HandlerFunc that lists the barcodes of an item.
+func listItemBarcodesHandler(inventoryService *inventory.InventoryService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		homeID, err := homeIDParam(r)
+		if err != nil {
+			http.Error(w, "Invalid home ID", http.StatusBadRequest)
+			return
+		}
+		itemID, err := uuid.Parse(chi.URLParam(r, "itemID"))
+		if err != nil {
+			http.Error(w, "Invalid item ID format", http.StatusBadRequest)
+			return
+		}
+
+		barcodes, err := inventoryService.ListItemBarcodes(r.Context(), homeID, itemID)
+		if err != nil {
+			if errors.Is(err, apperrors.ErrNotFound) {
+				http.Error(w, "Item not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Failed to list barcodes", http.StatusInternalServerError)
+			log.Printf("Error listing barcodes: %v", err)
+			return
+		}
+
+		json.NewEncoder(w).Encode(barcodes)
+	}
+}
+
+// addItemBarcodeHandler returns a http.HandlerFunc that attaches a barcode to
+// an item. The symbology is detected from the code when omitted.
+func addItemBarcodeHandler(inventoryService *inventory.InventoryService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		homeID, err := homeIDParam(r)
+		if err != nil {
+			http.Error(w, "Invalid home ID", http.StatusBadRequest)
+			return
+		}
+		itemID, err := uuid.Parse(chi.URLParam(r, "itemID"))
+		if err != nil {
+			http.Error(w, "Invalid item ID format", http.StatusBadRequest)
+			return
+		}
+
+		var req struct {
+			Code      string `json:"code"`
+			Symbology string `json:"symbology"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		barcode, err := inventoryService.AddItemBarcode(r.Context(), homeID, itemID, req.Code, req.Symbology)
+		if err != nil {
+			switch {
+			case errors.Is(err, apperrors.ErrNotFound):
+				http.Error(w, "Item not found", http.StatusNotFound)
+			case errors.Is(err, inventory.ErrDuplicateBarcode):
+				http.Error(w, err.Error(), http.StatusConflict)
+			case errors.Is(err, apperrors.ErrInvalidInput):
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			default:
+				http.Error(w, "Failed to add barcode", http.StatusInternalServerError)
+				log.Printf("Error adding barcode: %v", err)
+			}
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(barcode)
+	}
+}
+
+// deleteItemBarcodeHandler returns a http.HandlerFunc that removes a barcode from an item.
+func deleteItemBarcodeHandler(inventoryService *inventory.InventoryService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		homeID, err := homeIDParam(r)
+		if err != nil {
+			http.Error(w, "Invalid home ID", http.StatusBadRequest)
+			return
+		}
+		itemID, err := uuid.Parse(chi.URLParam(r, "itemID"))
+		if err != nil {
+			http.Error(w, "Invalid item ID format", http.StatusBadRequest)
+			return
+		}
+		barcodeID, err := uuid.Parse(chi.URLParam(r, "barcodeID"))
+		if err != nil {
+			http.Error(w, "Invalid barcode ID format", http.StatusBadRequest)
+			return
+		}
+
+		if err := inventoryService.DeleteItemBarcode(r.Context(), homeID, itemID, barcodeID); err != nil {
+			if errors.Is(err, apperrors.ErrNotFound) {
+				http.Error(w, "Barcode not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Failed to delete barcode", http.StatusInternalServerError)
+			log.Printf("Error deleting barcode: %v", err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
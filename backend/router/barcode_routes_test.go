@@ -0,0 +1,164 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/m-cain/mnemo/backend/contextkey"
+	"github.com/m-cain/mnemo/backend/decimal"
+	"github.com/m-cain/mnemo/backend/home"
+	"github.com/m-cain/mnemo/backend/inventory"
+	"github.com/m-cain/mnemo/backend/models"
+	"github.com/m-cain/mnemo/backend/testdb"
+)
+
+// newBarcodeTestRouter mounts the barcode routes as NewRouter does, for a
+// member of every home with the owner role.
+func newBarcodeTestRouter(inventoryService *inventory.InventoryService) http.Handler {
+	r := chi.NewRouter()
+	r.Use(middleware.URLFormat)
+	r.Route("/api/v1/homes/{homeID}", func(r chi.Router) {
+		r.Use(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextkey.UserRoleKey, home.RoleOwner)))
+			})
+		})
+		RegisterBarcodeRoutes(r, inventoryService)
+	})
+	return r
+}
+
+// seedBarcodeHome creates a home and returns it with an item of the home.
+func seedBarcodeHome(t *testing.T, db *pgxpool.Pool, inventoryService *inventory.InventoryService) (uuid.UUID, *models.Item) {
+	t.Helper()
+	ctx := context.Background()
+	userID, homeID := uuid.New(), uuid.New()
+	if _, err := db.Exec(ctx, `INSERT INTO users (id, email, name, password_hash) VALUES ($1, $2, 'Test', 'x')`,
+		userID, fmt.Sprintf("%s@example.com", userID)); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	if _, err := db.Exec(ctx, `INSERT INTO homes (id, name, owner_id) VALUES ($1, 'Test', $2)`, homeID, userID); err != nil {
+		t.Fatalf("failed to create home: %v", err)
+	}
+	item, err := inventoryService.CreateItem(ctx, homeID, models.Item{Name: "Flour", Quantity: decimal.FromInt(1)})
+	if err != nil {
+		t.Fatalf("CreateItem: %v", err)
+	}
+	return homeID, item
+}
+
+func TestBarcodeRoutesAcceptDotsAndSlashes(t *testing.T) {
+	db := testdb.Open(t)
+	inventoryService := inventory.NewInventoryService(db)
+	handler := newBarcodeTestRouter(inventoryService)
+	homeID, item := seedBarcodeHome(t, db, inventoryService)
+
+	codes := []string{
+		"LOT-1.5.json",
+		"https://example.com/items/42?ref=a%2Fb",
+		"a/b/../c.",
+	}
+	for _, code := range codes {
+		if _, err := inventoryService.AddItemBarcode(context.Background(), homeID, item.ID, code, ""); err != nil {
+			t.Fatalf("AddItemBarcode %q: %v", code, err)
+		}
+	}
+
+	for i, code := range codes {
+		t.Run(code, func(t *testing.T) {
+			targets := []string{
+				fmt.Sprintf("/api/v1/homes/%s/barcodes?code=%s", homeID, url.QueryEscape(code)),
+				fmt.Sprintf("/api/v1/homes/%s/barcodes/%s", homeID, url.PathEscape(code)),
+			}
+			for _, target := range targets {
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+				if rec.Code != http.StatusOK {
+					t.Fatalf("lookup %s status = %d, want 200: %s", target, rec.Code, rec.Body)
+				}
+				var lookup barcodeLookupResponse
+				if err := json.NewDecoder(rec.Body).Decode(&lookup); err != nil {
+					t.Fatalf("failed to decode lookup: %v", err)
+				}
+				if !lookup.Found || lookup.Item.ID != item.ID {
+					t.Fatalf("lookup %s = %+v, want item %s", target, lookup, item.ID)
+				}
+			}
+
+			target := fmt.Sprintf("/api/v1/homes/%s/barcodes/scan?code=%s", homeID, url.QueryEscape(code))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, target, nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("scan status = %d, want 200: %s", rec.Code, rec.Body)
+			}
+			var scan barcodeLookupResponse
+			if err := json.NewDecoder(rec.Body).Decode(&scan); err != nil {
+				t.Fatalf("failed to decode scan: %v", err)
+			}
+			if want := decimal.FromInt(int64(i + 2)); scan.Item.Quantity.Cmp(want) != 0 {
+				t.Fatalf("quantity after scan = %s, want %s", scan.Item.Quantity, want)
+			}
+		})
+	}
+}
+
+func TestBarcodeLookupSuggestsUnknownCodeUnchanged(t *testing.T) {
+	db := testdb.Open(t)
+	inventoryService := inventory.NewInventoryService(db)
+	handler := newBarcodeTestRouter(inventoryService)
+	homeID, _ := seedBarcodeHome(t, db, inventoryService)
+
+	code := "https://example.com/new/item.html"
+	target := fmt.Sprintf("/api/v1/homes/%s/barcodes?code=%s", homeID, url.QueryEscape(code))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want 404: %s", rec.Code, rec.Body)
+	}
+	var lookup barcodeLookupResponse
+	if err := json.NewDecoder(rec.Body).Decode(&lookup); err != nil {
+		t.Fatalf("failed to decode lookup: %v", err)
+	}
+	if lookup.Suggestion != "create" || lookup.Barcode == nil || lookup.Barcode.Code != code {
+		t.Fatalf("lookup = %+v, want a suggestion to create %q", lookup, code)
+	}
+}
+
+func TestBarcodeScanAcceptsEmptyChunkedBody(t *testing.T) {
+	db := testdb.Open(t)
+	inventoryService := inventory.NewInventoryService(db)
+	handler := newBarcodeTestRouter(inventoryService)
+	homeID, item := seedBarcodeHome(t, db, inventoryService)
+
+	code := "4006381333931"
+	if _, err := inventoryService.AddItemBarcode(context.Background(), homeID, item.ID, code, ""); err != nil {
+		t.Fatalf("AddItemBarcode: %v", err)
+	}
+
+	target := fmt.Sprintf("/api/v1/homes/%s/barcodes/scan?code=%s", homeID, code)
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(""))
+	req.ContentLength = -1
+	req.TransferEncoding = []string{"chunked"}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", rec.Code, rec.Body)
+	}
+	var scan barcodeLookupResponse
+	if err := json.NewDecoder(rec.Body).Decode(&scan); err != nil {
+		t.Fatalf("failed to decode scan: %v", err)
+	}
+	if want := decimal.FromInt(2); scan.Item.Quantity.Cmp(want) != 0 {
+		t.Fatalf("quantity after scan = %s, want %s", scan.Item.Quantity, want)
+	}
+}
@@ -0,0 +1,81 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/m-cain/mnemo/backend/apperrors"
+	"github.com/m-cain/mnemo/backend/enrichment"
+	"github.com/m-cain/mnemo/backend/inventory"
+)
+
+// enrichItemResponse is returned by enrichItemHandler. ItemTypeID is the
+// home's item type matching the product's suggested type, if there is one.
+type enrichItemResponse struct {
+	Query      enrichment.Query    `json:"query"`
+	Product    *enrichment.Product `json:"product"`
+	ItemTypeID *uuid.UUID          `json:"item_type_id"`
+}
+
+// enrichItemHandler returns a http.HandlerFunc that looks up product data for
+// a barcode or ISBN to prefill a new item. The body holds the code and an
+// optional kind ("barcode" or "isbn"), which is detected when omitted.
+func enrichItemHandler(enrichmentService *enrichment.Service, inventoryService *inventory.InventoryService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		homeID, err := homeIDParam(r)
+		if err != nil {
+			http.Error(w, "Invalid home ID", http.StatusBadRequest)
+			return
+		}
+
+		var req struct {
+			Code string `json:"code"`
+			Kind string `json:"kind"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		query, err := enrichment.ParseQuery(req.Code, req.Kind)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		product, err := enrichmentService.Lookup(r.Context(), query)
+		if err != nil {
+			switch {
+			case errors.Is(err, apperrors.ErrNotFound):
+				http.Error(w, "No product data found", http.StatusNotFound)
+			case errors.Is(err, enrichment.ErrProvidersUnavailable):
+				http.Error(w, err.Error(), http.StatusBadGateway)
+			default:
+				http.Error(w, "Failed to look up product data", http.StatusInternalServerError)
+				log.Printf("Error enriching item: %v", err)
+			}
+			return
+		}
+
+		resp := enrichItemResponse{Query: query, Product: product}
+		if product.SuggestedItemType != "" {
+			itemTypes, err := inventoryService.ListItemTypes(r.Context(), homeID)
+			if err != nil {
+				http.Error(w, "Failed to list item types", http.StatusInternalServerError)
+				log.Printf("Error listing item types: %v", err)
+				return
+			}
+			for _, itemType := range itemTypes {
+				if strings.EqualFold(itemType.Name, product.SuggestedItemType) {
+					resp.ItemTypeID = &itemType.ID
+					break // The home's own types are listed before built-in ones of the same name
+				}
+			}
+		}
+
+		json.NewEncoder(w).Encode(resp)
+	}
+}
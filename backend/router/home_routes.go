@@ -1,63 +1,62 @@
 package router
 
 import (
-	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"slices"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/m-cain/mnemo/backend/attachment"
+	"github.com/m-cain/mnemo/backend/audit"
 	"github.com/m-cain/mnemo/backend/auth"
 	"github.com/m-cain/mnemo/backend/contextkey"
+	"github.com/m-cain/mnemo/backend/enrichment"
 	"github.com/m-cain/mnemo/backend/home"
 	"github.com/m-cain/mnemo/backend/inventory"
+	"github.com/m-cain/mnemo/backend/models"
 )
 
 // RegisterHomeRoutes registers the home related routes.
-func RegisterHomeRoutes(r chi.Router, homeService *home.HomeService, authService *auth.AuthService, inventoryService *inventory.InventoryService) {
+func RegisterHomeRoutes(r chi.Router, homeService *home.HomeService, auditService *audit.Service, invitationService *home.InvitationService, authService *auth.AuthService, inventoryService *inventory.InventoryService, enrichmentService *enrichment.Service, attachmentService *attachment.Service) {
 	r.Route("/homes", func(r chi.Router) {
 		r.Use(authService.AuthMiddleware) // Protect home routes
 
-		// Middleware to check home membership and set homeID in context for /homes/{homeID} routes
-		r.Use(func(next http.Handler) http.Handler {
-			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-				homeID := chi.URLParam(r, "homeID")
-				if homeID != "" {
-					userID := r.Context().Value(contextkey.UserIDKey).(string) // Use contextkey.UserIDKey
-					role, err := homeService.CheckHomeMembership(r.Context(), homeID, userID)
-					if err != nil {
-						http.Error(w, "Failed to check home membership", http.StatusInternalServerError)
-						log.Printf("Error checking home membership: %v", err)
-						return
-					}
-					if role == "" {
-						http.Error(w, "Forbidden", http.StatusForbidden) // User is not a member
-						return
-					}
-					ctx := context.WithValue(r.Context(), contextkey.HomeIDKey, homeID) // Use contextkey.HomeIDKey
-					ctx = context.WithValue(ctx, contextkey.UserRoleKey, role)          // Use contextkey.UserRoleKey
-					next.ServeHTTP(w, r.WithContext(ctx))
-					return
-				}
-				next.ServeHTTP(w, r) // Proceed if homeID is not in URL (e.g., POST /homes)
-			})
-		})
-
-		r.Post("/", createHomeHandler(homeService))
-		r.Get("/", listHomesHandler(homeService))
+		r.With(RequireScope(auth.ScopeHomesWrite)).Post("/", createHomeHandler(homeService))
+		r.With(RequireScope(auth.ScopeHomesRead)).Get("/", listHomesHandler(homeService))
 
 		r.Route("/{homeID}", func(r chi.Router) {
-			r.Get("/", getHomeByIDHandler(homeService))
-			r.Put("/", updateHomeHandler(homeService))
-			r.Delete("/", deleteHomeHandler(homeService))
+			r.Use(homeIDMiddleware(homeService)) // Check home membership and set homeID and role in context
+
+			r.With(RequirePermission(home.PermHomeRead)).Get("/", getHomeByIDHandler(homeService))
+			r.With(RequirePermission(home.PermHomeUpdate)).Put("/", updateHomeHandler(homeService))
+			r.With(RequirePermission(home.PermHomeDelete)).Delete("/", deleteHomeHandler(homeService))
 
 			// Home User Management Routes
 			r.Route("/users", func(r chi.Router) {
-				r.Get("/", listHomeUsersHandler(homeService))
-				r.Post("/invite", inviteUserToHomeHandler(homeService))
-				r.Put("/{userID}", updateHomeUserRoleHandler(homeService))
-				r.Delete("/{userID}", removeUserFromHomeHandler(homeService))
+				r.With(RequirePermission(home.PermMembersRead)).Get("/", listHomeUsersHandler(homeService))
+				r.With(RequirePermission(home.PermMembersManage)).Put("/{userID}", updateHomeUserRoleHandler(homeService))
+				r.With(RequirePermissionOrSelf(home.PermMembersManage, "userID")).Delete("/{userID}", removeUserFromHomeHandler(homeService))
+			})
+
+			r.With(RequirePermission(home.PermAuditRead)).Get("/audit", listAuditEventsHandler(auditService))
+
+			// Inventory Routes
+			RegisterInventoryItemRoutes(r, inventoryService, enrichmentService, attachmentService)
+			RegisterInventoryItemTypeRoutes(r, inventoryService)
+			RegisterBarcodeRoutes(r, inventoryService)
+			RegisterReportRoutes(r, inventoryService)
+			r.Route("/locations", NewLocationRouter(inventoryService, attachmentService).RegisterRoutes)
+
+			// Invitation Management Routes
+			r.Route("/invitations", func(r chi.Router) {
+				r.Use(RequirePermission(home.PermMembersInvite))
+				r.Get("/", listInvitationsHandler(invitationService))
+				r.Post("/", createInvitationHandler(invitationService))
+				r.Delete("/{invitationID}", revokeInvitationHandler(invitationService))
 			})
 		})
 	})
@@ -95,6 +94,10 @@ func listHomesHandler(homeService *home.HomeService) http.HandlerFunc {
 			log.Printf("Error listing homes: %v", err)
 			return
 		}
+		// Hide homes an API key is not allowed to access
+		homes = slices.DeleteFunc(homes, func(h models.Home) bool {
+			return !auth.CanAccessHome(r.Context(), h.ID.String())
+		})
 		json.NewEncoder(w).Encode(homes)
 	}
 }
@@ -174,33 +177,15 @@ func listHomeUsersHandler(homeService *home.HomeService) http.HandlerFunc {
 	}
 }
 
-// inviteUserToHomeHandler returns a http.HandlerFunc that invites a user to a home.
-func inviteUserToHomeHandler(homeService *home.HomeService) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		homeID := chi.URLParam(r, "homeID")
-		var req struct {
-			UserID string `json:"user_id"` // User ID to invite
-			Role   string `json:"role"`
-		}
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
-			return
-		}
-		err := homeService.InviteUserToHome(r.Context(), homeID, req.UserID, req.Role)
-		if err != nil {
-			http.Error(w, "Failed to invite user", http.StatusInternalServerError)
-			log.Printf("Error inviting user: %v", err)
-			return
-		}
-		w.WriteHeader(http.StatusNoContent)
-	}
-}
-
 // updateHomeUserRoleHandler returns a http.HandlerFunc that updates a user's role in a home.
 func updateHomeUserRoleHandler(homeService *home.HomeService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		homeID := chi.URLParam(r, "homeID")
 		userID := chi.URLParam(r, "userID")
+		if _, err := uuid.Parse(userID); err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
 		var req struct {
 			Role string `json:"role"`
 		}
@@ -208,9 +193,12 @@ func updateHomeUserRoleHandler(homeService *home.HomeService) http.HandlerFunc {
 			http.Error(w, "Invalid request body", http.StatusBadRequest)
 			return
 		}
-		err := homeService.UpdateHomeUserRole(r.Context(), homeID, userID, req.Role)
+		actorRole := r.Context().Value(contextkey.UserRoleKey).(string)
+		err := homeService.UpdateHomeUserRole(r.Context(), homeID, userID, req.Role, actorRole)
 		if err != nil {
-			if err == pgx.ErrNoRows {
+			if isRoleError(err) {
+				writeRoleError(w, err)
+			} else if err == pgx.ErrNoRows {
 				http.Error(w, "Home user not found", http.StatusNotFound)
 			} else {
 				http.Error(w, "Failed to update home user role", http.StatusInternalServerError)
@@ -222,14 +210,25 @@ func updateHomeUserRoleHandler(homeService *home.HomeService) http.HandlerFunc {
 	}
 }
 
-// removeUserFromHomeHandler returns a http.HandlerFunc that removes a user from a home.
+// removeUserFromHomeHandler returns a http.HandlerFunc that removes a user
+// from a home. Members other than the owner may remove themselves to leave it.
 func removeUserFromHomeHandler(homeService *home.HomeService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		homeID := chi.URLParam(r, "homeID")
 		userID := chi.URLParam(r, "userID")
-		err := homeService.RemoveUserFromHome(r.Context(), homeID, userID)
+		if _, err := uuid.Parse(userID); err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+		actorRole := "" // Members may leave a home whatever their role
+		if !isSelf(r, "userID") {
+			actorRole = r.Context().Value(contextkey.UserRoleKey).(string)
+		}
+		err := homeService.RemoveUserFromHome(r.Context(), homeID, userID, actorRole)
 		if err != nil {
-			if err == pgx.ErrNoRows {
+			if isRoleError(err) {
+				writeRoleError(w, err)
+			} else if err == pgx.ErrNoRows {
 				http.Error(w, "Home user not found", http.StatusNotFound)
 			} else {
 				http.Error(w, "Failed to remove user from home", http.StatusInternalServerError)
@@ -240,3 +239,17 @@ func removeUserFromHomeHandler(homeService *home.HomeService) http.HandlerFunc {
 		w.WriteHeader(http.StatusNoContent)
 	}
 }
+
+// isRoleError reports whether err is one of the home package's role errors.
+func isRoleError(err error) bool {
+	return errors.Is(err, home.ErrInvalidRole) || errors.Is(err, home.ErrOwnerRole) || errors.Is(err, home.ErrInsufficientRole)
+}
+
+// writeRoleError maps a home role error to an HTTP response.
+func writeRoleError(w http.ResponseWriter, err error) {
+	if errors.Is(err, home.ErrInvalidRole) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusForbidden)
+}
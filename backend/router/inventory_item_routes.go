@@ -3,109 +3,328 @@ package router
 import (
 	"encoding/json"
 	"errors" // Import the errors package
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
-	"github.com/jackc/pgx/v5"
 	"github.com/m-cain/mnemo/backend/apperrors" // Import the apperrors package
-	"github.com/m-cain/mnemo/backend/auth"
-	"github.com/m-cain/mnemo/backend/contextkey"
+	"github.com/m-cain/mnemo/backend/attachment"
+	"github.com/m-cain/mnemo/backend/decimal"
+	"github.com/m-cain/mnemo/backend/enrichment"
 	"github.com/m-cain/mnemo/backend/home"
 	"github.com/m-cain/mnemo/backend/inventory"
 	"github.com/m-cain/mnemo/backend/models"
 )
 
-// RegisterInventoryItemRoutes registers the inventory item related routes.
-func RegisterInventoryItemRoutes(r chi.Router, inventoryService *inventory.InventoryService, authService *auth.AuthService, homeService *home.HomeService) {
+// RegisterInventoryItemRoutes registers the inventory item related routes of a
+// home. It is mounted under /homes/{homeID}, after homeIDMiddleware.
+// Product data enrichment is only available if enrichmentService is not nil.
+func RegisterInventoryItemRoutes(r chi.Router, inventoryService *inventory.InventoryService, enrichmentService *enrichment.Service, attachmentService *attachment.Service) {
 	r.Route("/items", func(r chi.Router) {
-		r.Use(authService.AuthMiddleware)    // Protect inventory routes
-		r.Use(homeIDMiddleware(homeService)) // Middleware to check home membership and set homeID in context
-
-		r.Get("/", listItemsHandler(inventoryService))
-		r.Post("/", createItemHandler(inventoryService))
-		r.Get("/{itemID}", getItemByIDHandler(inventoryService))
-		r.Put("/{itemID}", updateItemHandler(inventoryService))
-		r.Delete("/{itemID}", deleteItemHandler(inventoryService))
-		r.Put("/{itemID}/quantity", updateItemQuantityHandler(inventoryService))
+		if enrichmentService != nil {
+			r.With(RequirePermission(home.PermItemsWrite)).Post("/enrich", enrichItemHandler(enrichmentService, inventoryService))
+		}
+		r.With(RequirePermission(home.PermItemsRead)).Get("/", listItemsHandler(inventoryService))
+		r.With(RequirePermission(home.PermItemsWrite)).Post("/", createItemHandler(inventoryService))
+		r.With(RequirePermission(home.PermItemsRead)).Get("/{itemID}", getItemByIDHandler(inventoryService))
+		r.With(RequirePermission(home.PermItemsWrite)).Put("/{itemID}", updateItemHandler(inventoryService))
+		r.With(RequirePermission(home.PermItemsWrite)).Delete("/{itemID}", deleteItemHandler(inventoryService))
+		r.With(RequirePermission(home.PermItemsWrite)).Put("/{itemID}/quantity", updateItemQuantityHandler(inventoryService))
+		r.With(RequirePermission(home.PermItemsWrite)).Post("/{itemID}/adjust", adjustItemQuantityHandler(inventoryService))
+		r.With(RequirePermission(home.PermItemsRead)).Get("/{itemID}/history", itemHistoryHandler(inventoryService))
+		r.With(RequirePermission(home.PermItemsRead)).Get("/{itemID}/barcodes", listItemBarcodesHandler(inventoryService))
+		r.With(RequirePermission(home.PermItemsWrite)).Post("/{itemID}/barcodes", addItemBarcodeHandler(inventoryService))
+		r.With(RequirePermission(home.PermItemsWrite)).Delete("/{itemID}/barcodes/{barcodeID}", deleteItemBarcodeHandler(inventoryService))
+		r.With(RequirePermission(home.PermItemsRead)).Get("/{itemID}/lots", listItemLotsHandler(inventoryService))
+		r.With(RequirePermission(home.PermItemsWrite)).Post("/{itemID}/lots", createItemLotHandler(inventoryService))
+		r.With(RequirePermission(home.PermItemsWrite)).Put("/{itemID}/lots/{lotID}", updateItemLotHandler(inventoryService))
+		r.With(RequirePermission(home.PermItemsWrite)).Delete("/{itemID}/lots/{lotID}", deleteItemLotHandler(inventoryService))
+		registerAttachmentRoutes(r, attachmentService, attachment.OwnerItem, "itemID", home.PermItemsRead, home.PermItemsWrite)
 	})
 }
 
 // updateItemQuantityHandler returns a http.HandlerFunc that updates the quantity of an existing item.
 func updateItemQuantityHandler(inventoryService *inventory.InventoryService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		itemIDStr := chi.URLParam(r, "itemID")
-		itemID, err := uuid.Parse(itemIDStr)
+		homeID, err := homeIDParam(r)
+		if err != nil {
+			http.Error(w, "Invalid home ID", http.StatusBadRequest)
+			return
+		}
+		itemID, err := uuid.Parse(chi.URLParam(r, "itemID"))
 		if err != nil {
 			http.Error(w, "Invalid item ID format", http.StatusBadRequest)
 			return
 		}
 
+		// Either an absolute quantity or a relative delta, with an optional
+		// reason and unit if not the item's own
 		var req struct {
-			Quantity int `json:"quantity"`
+			Quantity      *decimal.Decimal `json:"quantity"`
+			Delta         *decimal.Decimal `json:"delta"`
+			Unit          string           `json:"unit"`
+			Reason        string           `json:"reason"`
+			AllowNegative bool             `json:"allow_negative"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			http.Error(w, "Invalid request body", http.StatusBadRequest)
 			return
 		}
 
-		err = inventoryService.UpdateItemQuantity(r.Context(), itemID, req.Quantity)
+		change := inventory.QuantityChange{Quantity: req.Quantity, Delta: req.Delta, Unit: req.Unit, Reason: req.Reason, AllowNegative: req.AllowNegative}
+		if _, err := inventoryService.UpdateItemQuantity(r.Context(), homeID, itemID, change); err != nil {
+			writeItemQuantityError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{"message": "Item quantity updated successfully"})
+	}
+}
+
+// adjustItemQuantityHandler returns a http.HandlerFunc that changes the
+// quantity of an item by a relative delta, optionally in another unit than the
+// item's, and returns the updated item.
+func adjustItemQuantityHandler(inventoryService *inventory.InventoryService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		homeID, err := homeIDParam(r)
+		if err != nil {
+			http.Error(w, "Invalid home ID", http.StatusBadRequest)
+			return
+		}
+		itemID, err := uuid.Parse(chi.URLParam(r, "itemID"))
+		if err != nil {
+			http.Error(w, "Invalid item ID format", http.StatusBadRequest)
+			return
+		}
+
+		var req struct {
+			Delta         *decimal.Decimal `json:"delta"`
+			Unit          string           `json:"unit"`
+			Reason        string           `json:"reason"`
+			AllowNegative bool             `json:"allow_negative"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if req.Delta == nil {
+			http.Error(w, "delta is required", http.StatusBadRequest)
+			return
+		}
+
+		change := inventory.QuantityChange{Delta: req.Delta, Unit: req.Unit, Reason: req.Reason, AllowNegative: req.AllowNegative}
+		item, err := inventoryService.UpdateItemQuantity(r.Context(), homeID, itemID, change)
+		if err != nil {
+			writeItemQuantityError(w, err)
+			return
+		}
+
+		w.Header().Set("ETag", itemETag(item))
+		json.NewEncoder(w).Encode(item)
+	}
+}
+
+// writeItemQuantityError writes the response for an error from UpdateItemQuantity.
+func writeItemQuantityError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, apperrors.ErrNotFound):
+		http.Error(w, "Item not found", http.StatusNotFound)
+	case errors.Is(err, inventory.ErrNegativeQuantity):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, apperrors.ErrInvalidInput):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "Failed to update item quantity", http.StatusInternalServerError)
+		log.Printf("Error updating item quantity: %v", err)
+	}
+}
+
+// itemETag returns the entity tag of an item, derived from its version.
+func itemETag(item *models.Item) string {
+	return `"` + strconv.Itoa(item.Version) + `"`
+}
+
+// parseIfMatch returns the item version required by the If-Match header, or
+// nil if the header is absent or "*". A tag that is not an item version can
+// never match, so ok is false.
+func parseIfMatch(r *http.Request) (version *int, ok bool) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" || header == "*" {
+		return nil, true
+	}
+	n, err := strconv.Atoi(strings.Trim(header, `"`))
+	if err != nil {
+		return nil, false
+	}
+	return &n, true
+}
+
+// itemHistoryHandler returns a http.HandlerFunc that aggregates an item's
+// quantity changes into time buckets. Supported query parameters: bucket
+// (hour, day, week or month), since and until (RFC 3339), and unit (a unit
+// or "canonical" to report quantities in).
+func itemHistoryHandler(inventoryService *inventory.InventoryService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		homeID, err := homeIDParam(r)
 		if err != nil {
-			if err == pgx.ErrNoRows {
+			http.Error(w, "Invalid home ID", http.StatusBadRequest)
+			return
+		}
+		itemID, err := uuid.Parse(chi.URLParam(r, "itemID"))
+		if err != nil {
+			http.Error(w, "Invalid item ID format", http.StatusBadRequest)
+			return
+		}
+
+		params := r.URL.Query()
+		query := inventory.HistoryQuery{Bucket: params.Get("bucket"), Unit: params.Get("unit")}
+		if v := params.Get("since"); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				http.Error(w, "Invalid since: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			query.Since = &t
+		}
+		if v := params.Get("until"); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				http.Error(w, "Invalid until: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			query.Until = &t
+		}
+
+		buckets, err := inventoryService.ItemHistory(r.Context(), homeID, itemID, query)
+		if err != nil {
+			if errors.Is(err, apperrors.ErrNotFound) {
 				http.Error(w, "Item not found", http.StatusNotFound)
 				return
 			}
-			http.Error(w, "Failed to update item quantity", http.StatusInternalServerError)
-			log.Printf("Error updating item quantity: %v", err)
+			if errors.Is(err, apperrors.ErrInvalidInput) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			http.Error(w, "Failed to get item history", http.StatusInternalServerError)
+			log.Printf("Error getting item history: %v", err)
 			return
 		}
 
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]string{"message": "Item quantity updated successfully"})
+		json.NewEncoder(w).Encode(buckets)
 	}
 }
 
 // listItemsHandler returns a http.HandlerFunc that lists items for a given home.
+// Filters, sorting and pagination are taken from the query string; see parseItemQuery.
 func listItemsHandler(inventoryService *inventory.InventoryService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		homeIDStr, ok := r.Context().Value(contextkey.HomeIDKey).(string)
-		if !ok {
-			http.Error(w, "Home ID not found in context", http.StatusInternalServerError)
+		homeID, err := homeIDParam(r)
+		if err != nil {
+			http.Error(w, "Invalid home ID", http.StatusBadRequest)
 			return
 		}
 
-		homeID, err := uuid.Parse(homeIDStr) // Correctly parse and assign homeID
+		query, err := parseItemQuery(r)
 		if err != nil {
-			http.Error(w, "Invalid Home ID format", http.StatusBadRequest)
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		items, err := inventoryService.ListItems(r.Context(), homeID)
+		page, err := inventoryService.ListItems(r.Context(), homeID, query)
 		if err != nil {
+			if errors.Is(err, apperrors.ErrInvalidInput) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
 			http.Error(w, "Failed to list items", http.StatusInternalServerError)
 			log.Printf("Error listing items: %v", err)
 			return
 		}
 
-		json.NewEncoder(w).Encode(items)
+		resp := models.PaginatedResponse[models.Item]{
+			Data:       page.Items,
+			Total:      page.Total,
+			Page:       page.Page,
+			PerPage:    page.PerPage,
+			TotalPages: (page.Total + page.PerPage - 1) / page.PerPage,
+			NextCursor: page.NextCursor,
+		}
+		json.NewEncoder(w).Encode(resp)
 	}
 }
 
+// parseItemQuery builds an inventory.ItemQuery from the request's query string.
+// Supported parameters: name, item_type_id, location_id, min_quantity,
+// max_quantity, updated_since (RFC 3339), attr.<name> (attribute value),
+// unit (a unit or "canonical" to normalize quantities to), sort (e.g.
+// "name,-updated_at"), page, per_page and cursor.
+func parseItemQuery(r *http.Request) (inventory.ItemQuery, error) {
+	params := r.URL.Query()
+	query := inventory.ItemQuery{
+		Name:   params.Get("name"),
+		Unit:   params.Get("unit"),
+		Cursor: params.Get("cursor"),
+	}
+
+	var err error
+	if query.ItemTypeID, err = parseOptionalUUID(params.Get("item_type_id")); err != nil {
+		return query, fmt.Errorf("invalid item_type_id: %w", err)
+	}
+	if query.LocationID, err = parseOptionalUUID(params.Get("location_id")); err != nil {
+		return query, fmt.Errorf("invalid location_id: %w", err)
+	}
+	if query.MinQuantity, err = parseOptionalDecimal(params.Get("min_quantity")); err != nil {
+		return query, fmt.Errorf("invalid min_quantity: %w", err)
+	}
+	if query.MaxQuantity, err = parseOptionalDecimal(params.Get("max_quantity")); err != nil {
+		return query, fmt.Errorf("invalid max_quantity: %w", err)
+	}
+	if v := params.Get("updated_since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return query, fmt.Errorf("invalid updated_since: %w", err)
+		}
+		query.UpdatedSince = &t
+	}
+	for key, values := range params {
+		if name, ok := strings.CutPrefix(key, "attr."); ok {
+			if query.Attributes == nil {
+				query.Attributes = make(map[string]string)
+			}
+			query.Attributes[name] = values[0]
+		}
+	}
+	if query.Sort, err = inventory.ParseItemSort(params.Get("sort")); err != nil {
+		return query, err
+	}
+	if v := params.Get("page"); v != "" {
+		if query.Page, err = strconv.Atoi(v); err != nil || query.Page < 1 {
+			return query, fmt.Errorf("invalid page: %q", v)
+		}
+	}
+	if v := params.Get("per_page"); v != "" {
+		if query.PerPage, err = strconv.Atoi(v); err != nil || query.PerPage < 1 {
+			return query, fmt.Errorf("invalid per_page: %q", v)
+		}
+	}
+
+	return query, nil
+}
+
 // createItemHandler returns a http.HandlerFunc that creates a new item.
 func createItemHandler(inventoryService *inventory.InventoryService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// homeIDStr, ok := r.Context().Value(contextkey.HomeIDKey).(string) // homeIDStr is not used in this handler currently
-		// if !ok {
-		// 	http.Error(w, "Home ID not found in context", http.StatusInternalServerError)
-		// 	return
-		// }
-
-		// homeID, err := uuid.Parse(homeIDStr) // homeID is not used directly in this handler currently
-		// if err != nil {
-		// 	http.Error(w, "Invalid Home ID format", http.StatusBadRequest)
-		// 	return
-		// }
+		homeID, err := homeIDParam(r)
+		if err != nil {
+			http.Error(w, "Invalid home ID", http.StatusBadRequest)
+			return
+		}
 
 		var req models.Item
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -113,14 +332,16 @@ func createItemHandler(inventoryService *inventory.InventoryService) http.Handle
 			return
 		}
 
-		// Ensure the item is associated with the home from the context
-		// req.HomeID = homeID // Items table does not have home_id directly, it's linked via location
-
-		// For now, we'll assume location_id is provided in the request body
-		// TODO: Implement proper location handling and validation
-
-		createdItem, err := inventoryService.CreateItem(r.Context(), req)
+		createdItem, err := inventoryService.CreateItem(r.Context(), homeID, req)
 		if err != nil {
+			if errors.Is(err, apperrors.ErrInvalidInput) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if errors.Is(err, inventory.ErrDuplicateBarcode) {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
 			http.Error(w, "Failed to create item", http.StatusInternalServerError)
 			log.Printf("Error creating item: %v", err)
 			return
@@ -131,9 +352,16 @@ func createItemHandler(inventoryService *inventory.InventoryService) http.Handle
 	}
 }
 
-// getItemByIDHandler returns a http.HandlerFunc that retrieves an item by its ID.
+// getItemByIDHandler returns a http.HandlerFunc that retrieves an item by its
+// ID. The optional unit query parameter, a unit or "canonical", adds the
+// item's quantity normalized to that unit.
 func getItemByIDHandler(inventoryService *inventory.InventoryService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		homeID, err := homeIDParam(r)
+		if err != nil {
+			http.Error(w, "Invalid home ID", http.StatusBadRequest)
+			return
+		}
 		itemIDStr := chi.URLParam(r, "itemID")
 		itemID, err := uuid.Parse(itemIDStr)
 		if err != nil {
@@ -141,7 +369,7 @@ func getItemByIDHandler(inventoryService *inventory.InventoryService) http.Handl
 			return
 		}
 
-		item, err := inventoryService.GetItemByID(r.Context(), itemID)
+		item, err := inventoryService.GetItemByID(r.Context(), homeID, itemID)
 		if err != nil {
 			if errors.Is(err, apperrors.ErrNotFound) {
 				http.Error(w, "Item not found", http.StatusNotFound)
@@ -151,7 +379,21 @@ func getItemByIDHandler(inventoryService *inventory.InventoryService) http.Handl
 			log.Printf("Error getting item by ID: %v", err)
 			return
 		}
+		if unit := r.URL.Query().Get("unit"); unit != "" {
+			items := []models.Item{*item}
+			if err := inventoryService.NormalizeQuantities(r.Context(), homeID, unit, items); err != nil {
+				if errors.Is(err, apperrors.ErrInvalidInput) {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				http.Error(w, "Failed to get item", http.StatusInternalServerError)
+				log.Printf("Error normalizing item quantity: %v", err)
+				return
+			}
+			item = &items[0]
+		}
 
+		w.Header().Set("ETag", itemETag(item))
 		json.NewEncoder(w).Encode(item)
 	}
 }
@@ -159,6 +401,11 @@ func getItemByIDHandler(inventoryService *inventory.InventoryService) http.Handl
 // updateItemHandler returns a http.HandlerFunc that updates an existing item.
 func updateItemHandler(inventoryService *inventory.InventoryService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		homeID, err := homeIDParam(r)
+		if err != nil {
+			http.Error(w, "Invalid home ID", http.StatusBadRequest)
+			return
+		}
 		itemIDStr := chi.URLParam(r, "itemID")
 		itemID, err := uuid.Parse(itemIDStr)
 		if err != nil {
@@ -172,18 +419,33 @@ func updateItemHandler(inventoryService *inventory.InventoryService) http.Handle
 			return
 		}
 
-		updatedItem, err := inventoryService.UpdateItem(r.Context(), itemID, req)
-		if err != nil {
-			http.Error(w, "Failed to update item", http.StatusInternalServerError)
-			log.Printf("Error updating item: %v", err)
+		// With If-Match, the update only applies to the version the client last read
+		ifVersion, ok := parseIfMatch(r)
+		if !ok {
+			http.Error(w, "Item has been modified", http.StatusPreconditionFailed)
 			return
 		}
 
-		if updatedItem == nil {
-			http.Error(w, "Item not found", http.StatusNotFound)
+		updatedItem, err := inventoryService.UpdateItem(r.Context(), homeID, itemID, req, ifVersion)
+		if err != nil {
+			if errors.Is(err, apperrors.ErrNotFound) {
+				http.Error(w, "Item not found", http.StatusNotFound)
+				return
+			}
+			if errors.Is(err, inventory.ErrVersionMismatch) {
+				http.Error(w, err.Error(), http.StatusPreconditionFailed)
+				return
+			}
+			if errors.Is(err, apperrors.ErrInvalidInput) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			http.Error(w, "Failed to update item", http.StatusInternalServerError)
+			log.Printf("Error updating item: %v", err)
 			return
 		}
 
+		w.Header().Set("ETag", itemETag(updatedItem))
 		json.NewEncoder(w).Encode(updatedItem)
 	}
 }
@@ -191,6 +453,11 @@ func updateItemHandler(inventoryService *inventory.InventoryService) http.Handle
 // deleteItemHandler returns a http.HandlerFunc that deletes an item by its ID.
 func deleteItemHandler(inventoryService *inventory.InventoryService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		homeID, err := homeIDParam(r)
+		if err != nil {
+			http.Error(w, "Invalid home ID", http.StatusBadRequest)
+			return
+		}
 		itemIDStr := chi.URLParam(r, "itemID")
 		itemID, err := uuid.Parse(itemIDStr)
 		if err != nil {
@@ -198,9 +465,9 @@ func deleteItemHandler(inventoryService *inventory.InventoryService) http.Handle
 			return
 		}
 
-		err = inventoryService.DeleteItem(r.Context(), itemID)
+		err = inventoryService.DeleteItem(r.Context(), homeID, itemID)
 		if err != nil {
-			if err == pgx.ErrNoRows {
+			if errors.Is(err, apperrors.ErrNotFound) {
 				http.Error(w, "Item not found", http.StatusNotFound)
 				return
 			}
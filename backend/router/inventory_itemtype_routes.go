@@ -2,32 +2,81 @@ package router
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
-	"github.com/jackc/pgx/v5"
-	"github.com/m-cain/mnemo/backend/auth"
+	"github.com/m-cain/mnemo/backend/apperrors"
+	"github.com/m-cain/mnemo/backend/home"
 	"github.com/m-cain/mnemo/backend/inventory"
+	"github.com/m-cain/mnemo/backend/models"
 )
 
-// RegisterInventoryItemTypeRoutes registers the inventory item type related routes.
-func RegisterInventoryItemTypeRoutes(r chi.Router, inventoryService *inventory.InventoryService, authService *auth.AuthService) {
+// RegisterInventoryItemTypeRoutes registers the item type related routes of a
+// home. It is mounted under /homes/{homeID}, after homeIDMiddleware. Listing
+// and reading also return the built-in types shared by every home.
+func RegisterInventoryItemTypeRoutes(r chi.Router, inventoryService *inventory.InventoryService) {
 	r.Route("/item-types", func(r chi.Router) {
-		r.Use(authService.AuthMiddleware) // Protect item type routes
-		r.Get("/", listItemTypesHandler(inventoryService))
-		r.Post("/", createItemTypeHandler(inventoryService))
-		r.Get("/{itemTypeID}", getItemTypeHandler(inventoryService))
-		r.Put("/{itemTypeID}", updateItemTypeHandler(inventoryService))
-		r.Delete("/{itemTypeID}", deleteItemTypeHandler(inventoryService))
+		r.With(RequirePermission(home.PermItemsRead)).Get("/", listItemTypesHandler(inventoryService))
+		r.With(RequirePermission(home.PermItemsWrite)).Post("/", createItemTypeHandler(inventoryService))
+		r.With(RequirePermission(home.PermItemsRead)).Get("/{itemTypeID}", getItemTypeHandler(inventoryService))
+		r.With(RequirePermission(home.PermItemsWrite)).Put("/{itemTypeID}", updateItemTypeHandler(inventoryService))
+		r.With(RequirePermission(home.PermItemsWrite)).Delete("/{itemTypeID}", deleteItemTypeHandler(inventoryService))
 	})
 }
 
-// listItemTypesHandler returns a http.HandlerFunc that lists all item types.
+// itemTypeRequest is the request body for creating or updating an item type.
+type itemTypeRequest struct {
+	Name            string                  `json:"name"`
+	Description     *string                 `json:"description"`
+	DefaultUnit     *string                 `json:"default_unit"`
+	CanonicalUnit   *string                 `json:"canonical_unit"`
+	AttributeSchema []models.AttributeField `json:"attribute_schema"`
+}
+
+// decodeItemTypeRequest decodes and validates an item type request body.
+func decodeItemTypeRequest(w http.ResponseWriter, r *http.Request) (models.ItemType, bool) {
+	var req itemTypeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return models.ItemType{}, false
+	}
+	if req.Name == "" {
+		http.Error(w, "Name is required", http.StatusBadRequest)
+		return models.ItemType{}, false
+	}
+	return models.ItemType{Name: req.Name, Description: req.Description, DefaultUnit: req.DefaultUnit, CanonicalUnit: req.CanonicalUnit, AttributeSchema: req.AttributeSchema}, true
+}
+
+// writeItemTypeError writes the response for an error from an item type mutation.
+func writeItemTypeError(w http.ResponseWriter, err error, action string) {
+	switch {
+	case errors.Is(err, apperrors.ErrNotFound):
+		http.Error(w, "Item type not found", http.StatusNotFound)
+	case errors.Is(err, inventory.ErrBuiltinItemType):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, inventory.ErrDuplicateItemType):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, apperrors.ErrInvalidInput):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "Failed to "+action+" item type", http.StatusInternalServerError)
+		log.Printf("Error trying to %s item type: %v", action, err)
+	}
+}
+
+// listItemTypesHandler returns a http.HandlerFunc that lists the item types available to a home.
 func listItemTypesHandler(inventoryService *inventory.InventoryService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		itemTypes, err := inventoryService.ListItemTypes(r.Context())
+		homeID, err := homeIDParam(r)
+		if err != nil {
+			http.Error(w, "Invalid home ID", http.StatusBadRequest)
+			return
+		}
+
+		itemTypes, err := inventoryService.ListItemTypes(r.Context(), homeID)
 		if err != nil {
 			http.Error(w, "Failed to list item types", http.StatusInternalServerError)
 			log.Printf("Error listing item types: %v", err)
@@ -41,18 +90,20 @@ func listItemTypesHandler(inventoryService *inventory.InventoryService) http.Han
 // createItemTypeHandler returns a http.HandlerFunc that creates a new item type.
 func createItemTypeHandler(inventoryService *inventory.InventoryService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var req struct {
-			Name string `json:"name"`
+		homeID, err := homeIDParam(r)
+		if err != nil {
+			http.Error(w, "Invalid home ID", http.StatusBadRequest)
+			return
 		}
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+
+		req, ok := decodeItemTypeRequest(w, r)
+		if !ok {
 			return
 		}
 
-		itemType, err := inventoryService.CreateItemType(r.Context(), req.Name)
+		itemType, err := inventoryService.CreateItemType(r.Context(), homeID, req)
 		if err != nil {
-			http.Error(w, "Failed to create item type", http.StatusInternalServerError)
-			log.Printf("Error creating item type: %v", err)
+			writeItemTypeError(w, err, "create")
 			return
 		}
 
@@ -64,6 +115,12 @@ func createItemTypeHandler(inventoryService *inventory.InventoryService) http.Ha
 // getItemTypeHandler returns a http.HandlerFunc that retrieves an item type by ID.
 func getItemTypeHandler(inventoryService *inventory.InventoryService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		homeID, err := homeIDParam(r)
+		if err != nil {
+			http.Error(w, "Invalid home ID", http.StatusBadRequest)
+			return
+		}
+
 		itemTypeIDStr := chi.URLParam(r, "itemTypeID")
 		itemTypeID, err := uuid.Parse(itemTypeIDStr)
 		if err != nil {
@@ -71,19 +128,14 @@ func getItemTypeHandler(inventoryService *inventory.InventoryService) http.Handl
 			return
 		}
 
-		itemType, err := inventoryService.GetItemTypeByID(r.Context(), itemTypeID)
+		itemType, err := inventoryService.GetItemTypeByID(r.Context(), homeID, itemTypeID)
 		if err != nil {
-			if err == pgx.ErrNoRows {
+			if errors.Is(err, apperrors.ErrNotFound) {
 				http.Error(w, "Item type not found", http.StatusNotFound)
-			} else {
-				http.Error(w, "Failed to get item type", http.StatusInternalServerError)
-				log.Printf("Error getting item type: %v", err)
+				return
 			}
-			return
-		}
-
-		if itemType == nil {
-			http.Error(w, "Item type not found", http.StatusNotFound)
+			http.Error(w, "Failed to get item type", http.StatusInternalServerError)
+			log.Printf("Error getting item type: %v", err)
 			return
 		}
 
@@ -94,6 +146,12 @@ func getItemTypeHandler(inventoryService *inventory.InventoryService) http.Handl
 // updateItemTypeHandler returns a http.HandlerFunc that updates an existing item type.
 func updateItemTypeHandler(inventoryService *inventory.InventoryService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		homeID, err := homeIDParam(r)
+		if err != nil {
+			http.Error(w, "Invalid home ID", http.StatusBadRequest)
+			return
+		}
+
 		itemTypeIDStr := chi.URLParam(r, "itemTypeID")
 		itemTypeID, err := uuid.Parse(itemTypeIDStr)
 		if err != nil {
@@ -101,27 +159,14 @@ func updateItemTypeHandler(inventoryService *inventory.InventoryService) http.Ha
 			return
 		}
 
-		var req struct {
-			Name string `json:"name"`
-		}
-		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+		req, ok := decodeItemTypeRequest(w, r)
+		if !ok {
 			return
 		}
 
-		itemType, err := inventoryService.UpdateItemType(r.Context(), itemTypeID, req.Name)
+		itemType, err := inventoryService.UpdateItemType(r.Context(), homeID, itemTypeID, req)
 		if err != nil {
-			if err == pgx.ErrNoRows {
-				http.Error(w, "Item type not found", http.StatusNotFound)
-			} else {
-				http.Error(w, "Failed to update item type", http.StatusInternalServerError)
-				log.Printf("Error updating item type: %v", err)
-			}
-			return
-		}
-
-		if itemType == nil {
-			http.Error(w, "Item type not found", http.StatusNotFound)
+			writeItemTypeError(w, err, "update")
 			return
 		}
 
@@ -132,6 +177,12 @@ func updateItemTypeHandler(inventoryService *inventory.InventoryService) http.Ha
 // deleteItemTypeHandler returns a http.HandlerFunc that deletes an item type by ID.
 func deleteItemTypeHandler(inventoryService *inventory.InventoryService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		homeID, err := homeIDParam(r)
+		if err != nil {
+			http.Error(w, "Invalid home ID", http.StatusBadRequest)
+			return
+		}
+
 		itemTypeIDStr := chi.URLParam(r, "itemTypeID")
 		itemTypeID, err := uuid.Parse(itemTypeIDStr)
 		if err != nil {
@@ -139,14 +190,9 @@ func deleteItemTypeHandler(inventoryService *inventory.InventoryService) http.Ha
 			return
 		}
 
-		err = inventoryService.DeleteItemType(r.Context(), itemTypeID)
+		err = inventoryService.DeleteItemType(r.Context(), homeID, itemTypeID)
 		if err != nil {
-			if err == pgx.ErrNoRows {
-				http.Error(w, "Item type not found", http.StatusNotFound)
-			} else {
-				http.Error(w, "Failed to delete item type", http.StatusInternalServerError)
-				log.Printf("Error deleting item type: %v", err)
-			}
+			writeItemTypeError(w, err, "delete")
 			return
 		}
 
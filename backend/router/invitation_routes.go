@@ -0,0 +1,193 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/m-cain/mnemo/backend/apperrors"
+	"github.com/m-cain/mnemo/backend/auth"
+	"github.com/m-cain/mnemo/backend/contextkey"
+	"github.com/m-cain/mnemo/backend/home"
+)
+
+// RegisterInvitationRoutes registers the routes used by invitation recipients.
+// Routes for managing a home's invitations are registered by RegisterHomeRoutes.
+func RegisterInvitationRoutes(r chi.Router, invitationService *home.InvitationService, authService *auth.AuthService) {
+	r.Route("/invitations", func(r chi.Router) {
+		r.With(authService.AuthMiddleware, RequireScope(auth.ScopeAdmin)).Post("/accept", acceptInvitationHandler(invitationService))
+		r.Post("/decline", declineInvitationHandler(invitationService)) // The token alone authorizes declining
+	})
+}
+
+// invitationTokenRequest is the request body for accepting or declining an invitation.
+type invitationTokenRequest struct {
+	Token string `json:"token"`
+}
+
+// writeInvitationError maps invitation service errors to HTTP responses.
+// It returns false if err is not a known invitation error.
+func writeInvitationError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, apperrors.ErrNotFound):
+		http.Error(w, "Invitation not found", http.StatusNotFound)
+	case errors.Is(err, apperrors.ErrInvalidInput):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, home.ErrInvitationClosed):
+		http.Error(w, err.Error(), http.StatusGone)
+	case errors.Is(err, home.ErrInvitationEmailMismatch):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	case errors.Is(err, home.ErrAlreadyMember):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case isRoleError(err):
+		writeRoleError(w, err)
+	default:
+		return false
+	}
+	return true
+}
+
+// createInvitationHandler returns a http.HandlerFunc that invites an email address to a home.
+func createInvitationHandler(invitationService *home.InvitationService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		homeID, err := uuid.Parse(chi.URLParam(r, "homeID"))
+		if err != nil {
+			http.Error(w, "Invalid home ID", http.StatusBadRequest)
+			return
+		}
+		inviterID, err := uuid.Parse(r.Context().Value(contextkey.UserIDKey).(string))
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+		inviterRole := r.Context().Value(contextkey.UserRoleKey).(string)
+
+		var req struct {
+			Email string `json:"email"`
+			Role  string `json:"role"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		invitation, err := invitationService.CreateInvitation(r.Context(), homeID, inviterID, inviterRole, req.Email, req.Role)
+		if errors.Is(err, home.ErrInvitationNotSent) {
+			// The invitation exists and lists the failure; inviting again resends it
+			log.Printf("Error sending invitation %s: %v", invitation.ID, err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadGateway)
+			json.NewEncoder(w).Encode(invitation)
+			return
+		}
+		if err != nil {
+			if writeInvitationError(w, err) {
+				return
+			}
+			http.Error(w, "Failed to create invitation", http.StatusInternalServerError)
+			log.Printf("Error creating invitation: %v", err)
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(invitation)
+	}
+}
+
+// listInvitationsHandler returns a http.HandlerFunc that lists a home's invitations.
+func listInvitationsHandler(invitationService *home.InvitationService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		homeID, err := uuid.Parse(chi.URLParam(r, "homeID"))
+		if err != nil {
+			http.Error(w, "Invalid home ID", http.StatusBadRequest)
+			return
+		}
+
+		invitations, err := invitationService.ListInvitations(r.Context(), homeID)
+		if err != nil {
+			http.Error(w, "Failed to list invitations", http.StatusInternalServerError)
+			log.Printf("Error listing invitations: %v", err)
+			return
+		}
+		json.NewEncoder(w).Encode(invitations)
+	}
+}
+
+// revokeInvitationHandler returns a http.HandlerFunc that revokes a pending invitation.
+func revokeInvitationHandler(invitationService *home.InvitationService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		homeID, err := uuid.Parse(chi.URLParam(r, "homeID"))
+		if err != nil {
+			http.Error(w, "Invalid home ID", http.StatusBadRequest)
+			return
+		}
+		invitationID, err := uuid.Parse(chi.URLParam(r, "invitationID"))
+		if err != nil {
+			http.Error(w, "Invalid invitation ID", http.StatusBadRequest)
+			return
+		}
+
+		err = invitationService.RevokeInvitation(r.Context(), homeID, invitationID)
+		if err != nil {
+			if writeInvitationError(w, err) {
+				return
+			}
+			http.Error(w, "Failed to revoke invitation", http.StatusInternalServerError)
+			log.Printf("Error revoking invitation: %v", err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// acceptInvitationHandler returns a http.HandlerFunc that accepts an invitation for the authenticated user.
+func acceptInvitationHandler(invitationService *home.InvitationService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, err := uuid.Parse(r.Context().Value(contextkey.UserIDKey).(string))
+		if err != nil {
+			http.Error(w, "Invalid user ID", http.StatusBadRequest)
+			return
+		}
+
+		var req invitationTokenRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		invitation, err := invitationService.AcceptInvitation(r.Context(), req.Token, userID)
+		if err != nil {
+			if writeInvitationError(w, err) {
+				return
+			}
+			http.Error(w, "Failed to accept invitation", http.StatusInternalServerError)
+			log.Printf("Error accepting invitation: %v", err)
+			return
+		}
+		json.NewEncoder(w).Encode(invitation)
+	}
+}
+
+// declineInvitationHandler returns a http.HandlerFunc that declines an invitation.
+func declineInvitationHandler(invitationService *home.InvitationService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req invitationTokenRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		if err := invitationService.DeclineInvitation(r.Context(), req.Token); err != nil {
+			if writeInvitationError(w, err) {
+				return
+			}
+			http.Error(w, "Failed to decline invitation", http.StatusInternalServerError)
+			log.Printf("Error declining invitation: %v", err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
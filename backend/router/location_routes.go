@@ -3,38 +3,48 @@ package router
 import (
 	"encoding/json"
 	"errors"
+	"log"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
-	"github.com/jackc/pgx/v5"
 	"github.com/m-cain/mnemo/backend/apperrors"
-	"github.com/m-cain/mnemo/backend/contextkey"
+	"github.com/m-cain/mnemo/backend/attachment"
+	"github.com/m-cain/mnemo/backend/home"
 	"github.com/m-cain/mnemo/backend/inventory"
 )
 
 // LocationRouter provides routing for location-related requests.
 type LocationRouter struct {
-	inventoryService *inventory.InventoryService
+	inventoryService  *inventory.InventoryService
+	attachmentService *attachment.Service
 }
 
 // NewLocationRouter creates a new instance of LocationRouter.
-func NewLocationRouter(inventoryService *inventory.InventoryService) *LocationRouter {
-	return &LocationRouter{inventoryService: inventoryService}
+func NewLocationRouter(inventoryService *inventory.InventoryService, attachmentService *attachment.Service) *LocationRouter {
+	return &LocationRouter{inventoryService: inventoryService, attachmentService: attachmentService}
 }
 
-// RegisterRoutes registers the location routes with the provided router.
+// RegisterRoutes registers the location routes of a home with the provided
+// router. It is mounted under /homes/{homeID}/locations, after homeIDMiddleware.
 func (r *LocationRouter) RegisterRoutes(router chi.Router) {
-	router.Post("/", r.createLocationHandler)
-	router.Get("/{locationID}", r.getLocationByIDHandler)
-	router.Put("/{locationID}", r.updateLocationHandler)
-	router.Delete("/{locationID}", r.deleteLocationHandler)
-	router.Get("/home/{homeID}", r.listLocationsByHomeHandler)
-	router.Get("/parent/{parentLocationID}", r.listLocationsByParentHandler)
+	router.With(RequirePermission(home.PermLocationsRead)).Get("/", r.listLocationsByHomeHandler)
+	router.With(RequirePermission(home.PermLocationsWrite)).Post("/", r.createLocationHandler)
+	router.With(RequirePermission(home.PermLocationsRead)).Get("/{locationID}", r.getLocationByIDHandler)
+	router.With(RequirePermission(home.PermLocationsWrite)).Put("/{locationID}", r.updateLocationHandler)
+	router.With(RequirePermission(home.PermLocationsWrite)).Delete("/{locationID}", r.deleteLocationHandler)
+	router.With(RequirePermission(home.PermLocationsRead)).Get("/{locationID}/children", r.listLocationsByParentHandler)
+	registerAttachmentRoutes(router, r.attachmentService, attachment.OwnerLocation, "locationID", home.PermLocationsRead, home.PermLocationsWrite)
 }
 
 // createLocationHandler handles requests to create a new location.
 func (r *LocationRouter) createLocationHandler(w http.ResponseWriter, req *http.Request) {
+	homeID, err := homeIDParam(req)
+	if err != nil {
+		http.Error(w, "Invalid home ID", http.StatusBadRequest)
+		return
+	}
+
 	var input struct {
 		Name             string     `json:"name"`
 		ParentLocationID *uuid.UUID `json:"parent_location_id"`
@@ -50,15 +60,14 @@ func (r *LocationRouter) createLocationHandler(w http.ResponseWriter, req *http.
 		return
 	}
 
-	homeID, ok := req.Context().Value(contextkey.HomeIDKey).(uuid.UUID)
-	if !ok {
-		http.Error(w, "Home ID not found in context", http.StatusInternalServerError)
-		return
-	}
-
-	location, err := r.inventoryService.CreateLocation(req.Context(), input.Name, input.ParentLocationID, homeID)
+	location, err := r.inventoryService.CreateLocation(req.Context(), homeID, input.Name, input.ParentLocationID)
 	if err != nil {
+		if errors.Is(err, apperrors.ErrInvalidInput) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		http.Error(w, "Failed to create location", http.StatusInternalServerError)
+		log.Printf("Error creating location: %v", err)
 		return
 	}
 
@@ -69,6 +78,12 @@ func (r *LocationRouter) createLocationHandler(w http.ResponseWriter, req *http.
 
 // getLocationByIDHandler handles requests to get a location by its ID.
 func (r *LocationRouter) getLocationByIDHandler(w http.ResponseWriter, req *http.Request) {
+	homeID, err := homeIDParam(req)
+	if err != nil {
+		http.Error(w, "Invalid home ID", http.StatusBadRequest)
+		return
+	}
+
 	locationIDStr := chi.URLParam(req, "locationID")
 	locationID, err := uuid.Parse(locationIDStr)
 	if err != nil {
@@ -76,7 +91,7 @@ func (r *LocationRouter) getLocationByIDHandler(w http.ResponseWriter, req *http
 		return
 	}
 
-	location, err := r.inventoryService.GetLocationByID(req.Context(), locationID)
+	location, err := r.inventoryService.GetLocationByID(req.Context(), homeID, locationID)
 	if err != nil {
 		if errors.Is(err, apperrors.ErrNotFound) {
 			http.Error(w, "Location not found", http.StatusNotFound)
@@ -92,6 +107,12 @@ func (r *LocationRouter) getLocationByIDHandler(w http.ResponseWriter, req *http
 
 // updateLocationHandler handles requests to update an existing location.
 func (r *LocationRouter) updateLocationHandler(w http.ResponseWriter, req *http.Request) {
+	homeID, err := homeIDParam(req)
+	if err != nil {
+		http.Error(w, "Invalid home ID", http.StatusBadRequest)
+		return
+	}
+
 	locationIDStr := chi.URLParam(req, "locationID")
 	locationID, err := uuid.Parse(locationIDStr)
 	if err != nil {
@@ -116,7 +137,7 @@ func (r *LocationRouter) updateLocationHandler(w http.ResponseWriter, req *http.
 	}
 
 	// Get the existing location to apply updates
-	existingLocation, err := r.inventoryService.GetLocationByID(req.Context(), locationID)
+	existingLocation, err := r.inventoryService.GetLocationByID(req.Context(), homeID, locationID)
 	if err != nil {
 		if errors.Is(err, apperrors.ErrNotFound) {
 			http.Error(w, "Location not found", http.StatusNotFound)
@@ -146,13 +167,18 @@ func (r *LocationRouter) updateLocationHandler(w http.ResponseWriter, req *http.
 		existingLocation.ParentLocationID = input.ParentLocationID
 	}
 
-	updatedLocation, err := r.inventoryService.UpdateLocation(req.Context(), locationID, existingLocation.Name, existingLocation.ParentLocationID)
+	updatedLocation, err := r.inventoryService.UpdateLocation(req.Context(), homeID, locationID, existingLocation.Name, existingLocation.ParentLocationID)
 	if err != nil {
 		if errors.Is(err, apperrors.ErrNotFound) {
 			http.Error(w, "Location not found after update attempt", http.StatusNotFound)
 			return
 		}
+		if errors.Is(err, apperrors.ErrInvalidInput) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		http.Error(w, "Failed to update location", http.StatusInternalServerError)
+		log.Printf("Error updating location: %v", err)
 		return
 	}
 
@@ -162,6 +188,12 @@ func (r *LocationRouter) updateLocationHandler(w http.ResponseWriter, req *http.
 
 // deleteLocationHandler handles requests to delete a location by its ID.
 func (r *LocationRouter) deleteLocationHandler(w http.ResponseWriter, req *http.Request) {
+	homeID, err := homeIDParam(req)
+	if err != nil {
+		http.Error(w, "Invalid home ID", http.StatusBadRequest)
+		return
+	}
+
 	locationIDStr := chi.URLParam(req, "locationID")
 	locationID, err := uuid.Parse(locationIDStr)
 	if err != nil {
@@ -169,18 +201,18 @@ func (r *LocationRouter) deleteLocationHandler(w http.ResponseWriter, req *http.
 		return
 	}
 
-	err = r.inventoryService.DeleteLocation(req.Context(), locationID)
+	err = r.inventoryService.DeleteLocation(req.Context(), homeID, locationID)
 	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) { // Use pgx.ErrNoRows for not found from DeleteLocation
+		if errors.Is(err, apperrors.ErrNotFound) {
 			http.Error(w, "Location not found", http.StatusNotFound)
 			return
 		}
-		// Check for specific error messages from DeleteLocation for business logic errors
-		if err.Error() == "location has child locations and cannot be deleted" || err.Error() == "location contains items and cannot be deleted" {
+		if errors.Is(err, inventory.ErrLocationHasChildren) || errors.Is(err, inventory.ErrLocationNotEmpty) {
 			http.Error(w, err.Error(), http.StatusConflict) // Use 409 Conflict for business rule violation
 			return
 		}
 		http.Error(w, "Failed to delete location", http.StatusInternalServerError)
+		log.Printf("Error deleting location: %v", err)
 		return
 	}
 
@@ -189,8 +221,7 @@ func (r *LocationRouter) deleteLocationHandler(w http.ResponseWriter, req *http.
 
 // listLocationsByHomeHandler handles requests to list top-level locations for a home.
 func (r *LocationRouter) listLocationsByHomeHandler(w http.ResponseWriter, req *http.Request) {
-	homeIDStr := chi.URLParam(req, "homeID")
-	homeID, err := uuid.Parse(homeIDStr)
+	homeID, err := homeIDParam(req)
 	if err != nil {
 		http.Error(w, "Invalid home ID", http.StatusBadRequest)
 		return
@@ -208,14 +239,20 @@ func (r *LocationRouter) listLocationsByHomeHandler(w http.ResponseWriter, req *
 
 // listLocationsByParentHandler handles requests to list child locations for a parent.
 func (r *LocationRouter) listLocationsByParentHandler(w http.ResponseWriter, req *http.Request) {
-	parentLocationIDStr := chi.URLParam(req, "parentLocationID")
+	homeID, err := homeIDParam(req)
+	if err != nil {
+		http.Error(w, "Invalid home ID", http.StatusBadRequest)
+		return
+	}
+
+	parentLocationIDStr := chi.URLParam(req, "locationID")
 	parentLocationID, err := uuid.Parse(parentLocationIDStr)
 	if err != nil {
 		http.Error(w, "Invalid parent location ID", http.StatusBadRequest)
 		return
 	}
 
-	locations, err := r.inventoryService.ListLocationsByParent(req.Context(), parentLocationID)
+	locations, err := r.inventoryService.ListLocationsByParent(req.Context(), homeID, parentLocationID)
 	if err != nil {
 		http.Error(w, "Failed to get child locations", http.StatusInternalServerError)
 		return
@@ -0,0 +1,215 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/m-cain/mnemo/backend/apperrors"
+	"github.com/m-cain/mnemo/backend/decimal"
+	"github.com/m-cain/mnemo/backend/inventory"
+	"github.com/m-cain/mnemo/backend/models"
+)
+
+// defaultExpiringWindow is the expiring_within used for lots when none is given.
+const defaultExpiringWindow = "7d"
+
+// lotRequest is the body of requests creating or updating an item lot.
+type lotRequest struct {
+	Quantity   decimal.Decimal `json:"quantity"` // In the unit of the item
+	ExpiryDate *models.Date    `json:"expiry_date"`
+	OpenedDate *models.Date    `json:"opened_date"`
+	LocationID *uuid.UUID      `json:"location_id"`
+}
+
+func (req lotRequest) lot() models.ItemLot {
+	return models.ItemLot{Quantity: req.Quantity, ExpiryDate: req.ExpiryDate, OpenedDate: req.OpenedDate, LocationID: req.LocationID}
+}
+
+// itemAndLotParams parses the itemID and, if withLot is set, lotID URL
+// parameters. On failure it writes an error response and returns false.
+func itemAndLotParams(w http.ResponseWriter, r *http.Request, withLot bool) (itemID, lotID uuid.UUID, ok bool) {
+	itemID, err := uuid.Parse(chi.URLParam(r, "itemID"))
+	if err != nil {
+		http.Error(w, "Invalid item ID format", http.StatusBadRequest)
+		return itemID, lotID, false
+	}
+	if withLot {
+		if lotID, err = uuid.Parse(chi.URLParam(r, "lotID")); err != nil {
+			http.Error(w, "Invalid lot ID format", http.StatusBadRequest)
+			return itemID, lotID, false
+		}
+	}
+	return itemID, lotID, true
+}
+
+// writeLotError writes the response for an error from the item lot methods of
+// the inventory service.
+func writeLotError(w http.ResponseWriter, err error, notFound string) {
+	switch {
+	case errors.Is(err, apperrors.ErrNotFound):
+		http.Error(w, notFound, http.StatusNotFound)
+	case errors.Is(err, apperrors.ErrInvalidInput):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "Failed to change item lot", http.StatusInternalServerError)
+		log.Printf("Error changing item lot: %v", err)
+	}
+}
+
+// listItemLotsHandler returns a http.HandlerFunc that lists the lots of an
+// item in the order they are consumed.
+func listItemLotsHandler(inventoryService *inventory.InventoryService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		homeID, err := homeIDParam(r)
+		if err != nil {
+			http.Error(w, "Invalid home ID", http.StatusBadRequest)
+			return
+		}
+		itemID, _, ok := itemAndLotParams(w, r, false)
+		if !ok {
+			return
+		}
+
+		lots, err := inventoryService.ListItemLots(r.Context(), homeID, itemID)
+		if err != nil {
+			if errors.Is(err, apperrors.ErrNotFound) {
+				http.Error(w, "Item not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Failed to list item lots", http.StatusInternalServerError)
+			log.Printf("Error listing item lots: %v", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(lots)
+	}
+}
+
+// createItemLotHandler returns a http.HandlerFunc that adds a lot to an item.
+func createItemLotHandler(inventoryService *inventory.InventoryService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		homeID, err := homeIDParam(r)
+		if err != nil {
+			http.Error(w, "Invalid home ID", http.StatusBadRequest)
+			return
+		}
+		itemID, _, ok := itemAndLotParams(w, r, false)
+		if !ok {
+			return
+		}
+
+		var req lotRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		lot, err := inventoryService.CreateItemLot(r.Context(), homeID, itemID, req.lot())
+		if err != nil {
+			writeLotError(w, err, "Item not found")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(lot)
+	}
+}
+
+// updateItemLotHandler returns a http.HandlerFunc that replaces the quantity,
+// dates and location of an item lot.
+func updateItemLotHandler(inventoryService *inventory.InventoryService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		homeID, err := homeIDParam(r)
+		if err != nil {
+			http.Error(w, "Invalid home ID", http.StatusBadRequest)
+			return
+		}
+		itemID, lotID, ok := itemAndLotParams(w, r, true)
+		if !ok {
+			return
+		}
+
+		var req lotRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		lot, err := inventoryService.UpdateItemLot(r.Context(), homeID, itemID, lotID, req.lot())
+		if err != nil {
+			writeLotError(w, err, "Item lot not found")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(lot)
+	}
+}
+
+// deleteItemLotHandler returns a http.HandlerFunc that removes an item lot.
+// The optional reason query parameter, e.g. "expired", is recorded with the
+// quantity change.
+func deleteItemLotHandler(inventoryService *inventory.InventoryService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		homeID, err := homeIDParam(r)
+		if err != nil {
+			http.Error(w, "Invalid home ID", http.StatusBadRequest)
+			return
+		}
+		itemID, lotID, ok := itemAndLotParams(w, r, true)
+		if !ok {
+			return
+		}
+
+		if err := inventoryService.DeleteItemLot(r.Context(), homeID, itemID, lotID, r.URL.Query().Get("reason")); err != nil {
+			writeLotError(w, err, "Item lot not found")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// listExpiringLotsHandler returns a http.HandlerFunc that lists the item lots
+// expiring within the expiring_within query parameter, 7 days by default,
+// including lots that have already expired. The optional unit query
+// parameter, a unit or "canonical", adds lot quantities normalized to it.
+func listExpiringLotsHandler(inventoryService *inventory.InventoryService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		homeID, err := homeIDParam(r)
+		if err != nil {
+			http.Error(w, "Invalid home ID", http.StatusBadRequest)
+			return
+		}
+
+		within := r.URL.Query().Get("expiring_within")
+		if within == "" {
+			within = defaultExpiringWindow
+		}
+		days, err := parseDays(within)
+		if err != nil {
+			http.Error(w, "Invalid expiring_within: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		lots, err := inventoryService.ListExpiringLots(r.Context(), homeID, days, r.URL.Query().Get("unit"))
+		if err != nil {
+			if errors.Is(err, apperrors.ErrInvalidInput) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			http.Error(w, "Failed to list expiring lots", http.StatusInternalServerError)
+			log.Printf("Error listing expiring lots: %v", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(lots)
+	}
+}
@@ -6,8 +6,11 @@ import (
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/m-cain/mnemo/backend/auth"
 	"github.com/m-cain/mnemo/backend/contextkey"
 	"github.com/m-cain/mnemo/backend/home"
+	"github.com/m-cain/mnemo/backend/ratelimit"
 )
 
 // homeIDMiddleware is a middleware that extracts the homeID from the URL
@@ -18,11 +21,8 @@ func homeIDMiddleware(homeService *home.HomeService) func(next http.Handler) htt
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			homeIDStr := chi.URLParam(r, "homeID")
 			if homeIDStr == "" {
-				// If homeID is not in the URL, proceed without adding to context
-				// This is important for routes like POST /api/v1/items where homeID might not be a URL param
-				// but expected in context from a higher-level middleware or derived differently.
-				// However, for routes specifically under /homes/{homeID}/items, this middleware implies homeIDStr should exist.
-				// The current logic seems to handle cases where it's optional at this middleware layer.
+				// If homeID is not in the URL, proceed without adding to context;
+				// RequirePermission rejects the request if it needs a home.
 				next.ServeHTTP(w, r)
 				return
 			}
@@ -34,6 +34,12 @@ func homeIDMiddleware(homeService *home.HomeService) func(next http.Handler) htt
 				return
 			}
 
+			// API keys may be restricted to specific homes
+			if !auth.CanAccessHome(r.Context(), homeIDStr) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
 			// Check if the user is a member of the home
 			role, err := homeService.CheckHomeMembership(r.Context(), homeIDStr, userID)
 			if err != nil {
@@ -55,3 +61,136 @@ func homeIDMiddleware(homeService *home.HomeService) func(next http.Handler) htt
 		})
 	}
 }
+
+// permissionScopes maps home permissions to the API key scope required to use them.
+var permissionScopes = map[home.Permission]string{
+	home.PermHomeRead:       auth.ScopeHomesRead,
+	home.PermHomeUpdate:     auth.ScopeHomesWrite,
+	home.PermHomeDelete:     auth.ScopeAdmin,
+	home.PermMembersRead:    auth.ScopeMembersRead,
+	home.PermMembersInvite:  auth.ScopeMembersWrite,
+	home.PermMembersManage:  auth.ScopeMembersWrite,
+	home.PermItemsRead:      auth.ScopeItemsRead,
+	home.PermItemsWrite:     auth.ScopeItemsWrite,
+	home.PermLocationsRead:  auth.ScopeLocationsRead,
+	home.PermLocationsWrite: auth.ScopeLocationsWrite,
+	home.PermAuditRead:      auth.ScopeAuditRead,
+}
+
+// RequirePermission is a middleware that only lets the request through if the
+// user's role in the current home grants perm and, for API keys, the key holds
+// the matching scope. It must run after homeIDMiddleware; requests without a
+// home role in the context are rejected.
+func RequirePermission(perm home.Permission) func(next http.Handler) http.Handler {
+	scope, ok := permissionScopes[perm]
+	if !ok {
+		scope = auth.ScopeAdmin // Permissions without a dedicated scope need full access
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			role, ok := r.Context().Value(contextkey.UserRoleKey).(string)
+			if !ok || !home.HasPermission(role, perm) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			if !auth.HasScope(r.Context(), scope) {
+				http.Error(w, "API key lacks the required scope", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequirePermissionOrSelf is RequirePermission for routes acting on the home
+// member named by the userParam URL parameter. Members may act on themselves,
+// such as leaving the home, without perm; API keys still need perm's scope.
+func RequirePermissionOrSelf(perm home.Permission, userParam string) func(next http.Handler) http.Handler {
+	scope, ok := permissionScopes[perm]
+	if !ok {
+		scope = auth.ScopeAdmin // Permissions without a dedicated scope need full access
+	}
+	requirePermission := RequirePermission(perm)
+	return func(next http.Handler) http.Handler {
+		withPermission := requirePermission(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !isSelf(r, userParam) {
+				withPermission.ServeHTTP(w, r)
+				return
+			}
+			if !auth.HasScope(r.Context(), scope) {
+				http.Error(w, "API key lacks the required scope", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// isSelf reports whether the userParam URL parameter names the requesting user.
+func isSelf(r *http.Request, userParam string) bool {
+	userID, ok := r.Context().Value(contextkey.UserIDKey).(string)
+	if !ok {
+		return false
+	}
+	actor, err := uuid.Parse(userID)
+	if err != nil {
+		return false
+	}
+	target, err := uuid.Parse(chi.URLParam(r, userParam))
+	return err == nil && target == actor
+}
+
+// RequireScope is a middleware for routes outside a home that rejects API keys
+// lacking scope. Requests authenticated as a user session are always let through.
+func RequireScope(scope string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !auth.HasScope(r.Context(), scope) {
+				http.Error(w, "API key lacks the required scope", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rateLimitMiddleware applies the default per-IP quota to every request, plus
+// any stricter quota configured for the matched route. Routes are looked up
+// on mux so quotas are keyed by pattern rather than by concrete URL. Limiter
+// errors fail open so a broken store does not take the API down.
+func rateLimitMiddleware(mux *chi.Mux, limiter *ratelimit.Limiter) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := auth.ClientIP(r)
+
+			res, err := limiter.Store.Take(r.Context(), "ip:"+ip, limiter.Config.Default)
+			if err != nil {
+				log.Printf("Error taking rate limit token: %v", err)
+				next.ServeHTTP(w, r)
+				return
+			}
+			ratelimit.SetHeaders(w, limiter.Config.Default, res)
+			if !res.Allowed {
+				ratelimit.Reject(w, res.RetryAfter, "Rate limit exceeded")
+				return
+			}
+
+			route := r.Method + " " + mux.Find(chi.NewRouteContext(), r.Method, r.URL.Path)
+			if limit, ok := limiter.Config.Routes[route]; ok {
+				res, err := limiter.Store.Take(r.Context(), "route:"+route+":"+ip, limit)
+				if err != nil {
+					log.Printf("Error taking rate limit token for %s: %v", route, err)
+				} else {
+					ratelimit.SetHeaders(w, limit, res)
+					if !res.Allowed {
+						ratelimit.Reject(w, res.RetryAfter, "Rate limit exceeded")
+						return
+					}
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
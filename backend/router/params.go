@@ -0,0 +1,38 @@
+package router
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/m-cain/mnemo/backend/decimal"
+)
+
+// parseOptionalUUID parses s as a UUID, returning nil when s is empty.
+func parseOptionalUUID(s string) (*uuid.UUID, error) {
+	if s == "" {
+		return nil, nil
+	}
+	id, err := uuid.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
+
+// parseOptionalDecimal parses s as a decimal number, returning nil when s is empty.
+func parseOptionalDecimal(s string) (*decimal.Decimal, error) {
+	if s == "" {
+		return nil, nil
+	}
+	d, err := decimal.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// homeIDParam parses the homeID URL parameter of a route nested under /homes/{homeID}.
+func homeIDParam(r *http.Request) (uuid.UUID, error) {
+	return uuid.Parse(chi.URLParam(r, "homeID"))
+}
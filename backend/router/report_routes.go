@@ -0,0 +1,131 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/m-cain/mnemo/backend/apperrors"
+	"github.com/m-cain/mnemo/backend/home"
+	"github.com/m-cain/mnemo/backend/inventory"
+)
+
+// defaultWarrantyWindow is the expiring_within used for warranties when none is given.
+const defaultWarrantyWindow = "30d"
+
+// RegisterReportRoutes registers the expiry, warranty, insured value and
+// quantity reports of a home. It is mounted under /homes/{homeID}, after homeIDMiddleware.
+func RegisterReportRoutes(r chi.Router, inventoryService *inventory.InventoryService) {
+	r.With(RequirePermission(home.PermItemsRead)).Get("/expiring", listExpiringLotsHandler(inventoryService))
+	r.With(RequirePermission(home.PermItemsRead)).Get("/warranties", listExpiringWarrantiesHandler(inventoryService))
+	r.With(RequirePermission(home.PermItemsRead)).Get("/reports/insured-value", insuredValueReportHandler(inventoryService))
+	r.With(RequirePermission(home.PermItemsRead)).Get("/reports/quantities", quantityReportHandler(inventoryService))
+}
+
+// parseDays parses a number of days such as "30d" or "4w". A bare number is
+// a number of days.
+func parseDays(s string) (int, error) {
+	multiplier := 1
+	switch {
+	case strings.HasSuffix(s, "d"):
+		s = strings.TrimSuffix(s, "d")
+	case strings.HasSuffix(s, "w"):
+		s, multiplier = strings.TrimSuffix(s, "w"), 7
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n > 100*365 {
+		return 0, fmt.Errorf("must be a number of days such as 30d or weeks such as 4w")
+	}
+	return n * multiplier, nil
+}
+
+// listExpiringWarrantiesHandler returns a http.HandlerFunc that lists the items
+// whose warranty expires within the expiring_within query parameter, 30 days
+// by default.
+func listExpiringWarrantiesHandler(inventoryService *inventory.InventoryService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		homeID, err := homeIDParam(r)
+		if err != nil {
+			http.Error(w, "Invalid home ID", http.StatusBadRequest)
+			return
+		}
+
+		within := r.URL.Query().Get("expiring_within")
+		if within == "" {
+			within = defaultWarrantyWindow
+		}
+		days, err := parseDays(within)
+		if err != nil {
+			http.Error(w, "Invalid expiring_within: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		items, err := inventoryService.ListExpiringWarranties(r.Context(), homeID, days)
+		if err != nil {
+			if errors.Is(err, apperrors.ErrInvalidInput) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			http.Error(w, "Failed to list warranties", http.StatusInternalServerError)
+			log.Printf("Error listing expiring warranties: %v", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(items)
+	}
+}
+
+// insuredValueReportHandler returns a http.HandlerFunc that reports the
+// insured value of a home by location and item type.
+func insuredValueReportHandler(inventoryService *inventory.InventoryService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		homeID, err := homeIDParam(r)
+		if err != nil {
+			http.Error(w, "Invalid home ID", http.StatusBadRequest)
+			return
+		}
+
+		report, err := inventoryService.InsuredValueReport(r.Context(), homeID)
+		if err != nil {
+			http.Error(w, "Failed to compute insured value", http.StatusInternalServerError)
+			log.Printf("Error computing insured value report: %v", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}
+}
+
+// quantityReportHandler returns a http.HandlerFunc that totals the quantities
+// of the items of a home by item type. The optional unit query parameter, a
+// unit or "canonical", is the unit quantities are converted to and totalled in.
+func quantityReportHandler(inventoryService *inventory.InventoryService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		homeID, err := homeIDParam(r)
+		if err != nil {
+			http.Error(w, "Invalid home ID", http.StatusBadRequest)
+			return
+		}
+
+		totals, err := inventoryService.QuantityTotals(r.Context(), homeID, r.URL.Query().Get("unit"))
+		if err != nil {
+			if errors.Is(err, apperrors.ErrInvalidInput) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			http.Error(w, "Failed to total quantities", http.StatusInternalServerError)
+			log.Printf("Error computing quantity report: %v", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(totals)
+	}
+}
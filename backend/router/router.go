@@ -6,13 +6,17 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/m-cain/mnemo/backend/attachment"
+	"github.com/m-cain/mnemo/backend/audit"
 	"github.com/m-cain/mnemo/backend/auth"
+	"github.com/m-cain/mnemo/backend/enrichment"
 	"github.com/m-cain/mnemo/backend/home"
 	"github.com/m-cain/mnemo/backend/inventory"
+	"github.com/m-cain/mnemo/backend/ratelimit"
 )
 
 // NewRouter initializes and configures the main Chi router.
-func NewRouter(dbPool *pgxpool.Pool, apiKeyService *auth.APIKeyService, authService *auth.AuthService, homeService *home.HomeService, inventoryService *inventory.InventoryService) http.Handler {
+func NewRouter(dbPool *pgxpool.Pool, apiKeyService *auth.APIKeyService, authService *auth.AuthService, passwordResetService *auth.PasswordResetService, emailChangeService *auth.EmailChangeService, oidcService *auth.OIDCService, homeService *home.HomeService, auditService *audit.Service, invitationService *home.InvitationService, inventoryService *inventory.InventoryService, enrichmentService *enrichment.Service, attachmentService *attachment.Service, limiter *ratelimit.Limiter) http.Handler {
 	r := chi.NewRouter()
 
 	// Global Middleware
@@ -20,13 +24,15 @@ func NewRouter(dbPool *pgxpool.Pool, apiKeyService *auth.APIKeyService, authServ
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.URLFormat)
+	r.Use(authService.ClientIPMiddleware)
 
 	// CORS middleware (basic example, configure properly for production)
 	r.Use(func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			w.Header().Set("Access-Control-Allow-Origin", "*") // TODO: Restrict this in production
 			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
+			w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, If-Match")
+			w.Header().Set("Access-Control-Expose-Headers", "ETag")
 			if r.Method == "OPTIONS" {
 				return
 			}
@@ -34,20 +40,26 @@ func NewRouter(dbPool *pgxpool.Pool, apiKeyService *auth.APIKeyService, authServ
 		})
 	})
 
+	// Per-IP and per-route quotas; nil disables rate limiting
+	if limiter != nil {
+		r.Use(rateLimitMiddleware(r, limiter))
+	}
+
 	// Register API routes
 	r.Route("/api/v1", func(r chi.Router) {
 		// Register authentication routes (handled within auth package's RegisterRoutes)
 		authService.RegisterRoutes(r)
+		passwordResetService.RegisterRoutes(r)
+		emailChangeService.RegisterRoutes(r)
+		if oidcService != nil { // OIDC login is optional
+			oidcService.RegisterRoutes(r)
+		}
 
 		// Register other route groups
-		RegisterAPIKeyRoutes(r, apiKeyService, authService, inventoryService) // Added inventoryService
-		RegisterInventoryItemRoutes(r, inventoryService, authService, homeService)
-		RegisterInventoryItemTypeRoutes(r, inventoryService, authService)
-		RegisterHomeRoutes(r, homeService, authService, inventoryService) // Added inventoryService
-
-		// Register location routes
-		locationRouter := NewLocationRouter(inventoryService)
-		r.Route("/locations", locationRouter.RegisterRoutes)
+		RegisterAPIKeyRoutes(r, apiKeyService, authService, inventoryService)                                                                    // Added inventoryService
+		RegisterHomeRoutes(r, homeService, auditService, invitationService, authService, inventoryService, enrichmentService, attachmentService) // Added inventoryService
+		RegisterInvitationRoutes(r, invitationService, authService)
+		RegisterUnitRoutes(r)
 	})
 
 	return r
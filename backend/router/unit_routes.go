@@ -0,0 +1,36 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/m-cain/mnemo/backend/units"
+)
+
+// unitResponse describes a unit of measure. Factor is its size in the base
+// unit of its dimension: g, ml, pcs or m.
+type unitResponse struct {
+	units.Unit
+	Factor string `json:"factor"`
+}
+
+// RegisterUnitRoutes registers the listing of the units of measure quantities
+// can be converted between.
+func RegisterUnitRoutes(r chi.Router) {
+	r.Get("/units", listUnitsHandler())
+}
+
+// listUnitsHandler returns a http.HandlerFunc that lists the known units of
+// measure, grouped by dimension and smallest first.
+func listUnitsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := []unitResponse{}
+		for _, u := range units.All() {
+			resp = append(resp, unitResponse{Unit: u, Factor: u.Factor()})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
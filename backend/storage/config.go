@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// DefaultLocalDir is where the local store keeps blobs unless configured otherwise.
+const DefaultLocalDir = "data/blobs"
+
+// Config selects and configures a BlobStore.
+type Config struct {
+	Backend  string // "local" or "s3"
+	LocalDir string
+	S3       S3Config
+}
+
+// LoadConfigFromEnv reads the blob store configuration:
+//
+//   - BLOB_STORE: "local" (the default) or "s3".
+//   - BLOB_STORE_DIR: directory of the local store, data/blobs by default.
+//   - S3_ENDPOINT, S3_REGION, S3_BUCKET: the bucket of the s3 store. S3_REGION
+//     defaults to us-east-1.
+//   - S3_ACCESS_KEY_ID, S3_SECRET_ACCESS_KEY: credentials of the s3 store.
+//   - S3_PATH_STYLE: "true" to address the bucket as a path of the endpoint,
+//     as MinIO and most other S3-compatible services expect.
+func LoadConfigFromEnv() (Config, error) {
+	cfg := Config{
+		Backend:  os.Getenv("BLOB_STORE"),
+		LocalDir: os.Getenv("BLOB_STORE_DIR"),
+		S3: S3Config{
+			Endpoint:        os.Getenv("S3_ENDPOINT"),
+			Region:          os.Getenv("S3_REGION"),
+			Bucket:          os.Getenv("S3_BUCKET"),
+			AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+		},
+	}
+	if cfg.Backend == "" {
+		cfg.Backend = "local"
+	}
+	if cfg.LocalDir == "" {
+		cfg.LocalDir = DefaultLocalDir
+	}
+	if cfg.S3.Region == "" {
+		cfg.S3.Region = "us-east-1"
+	}
+	if v := os.Getenv("S3_PATH_STYLE"); v != "" {
+		pathStyle, err := strconv.ParseBool(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid S3_PATH_STYLE: %w", err)
+		}
+		cfg.S3.PathStyle = pathStyle
+	}
+	return cfg, nil
+}
+
+// New creates the BlobStore selected by cfg.
+func New(cfg Config) (BlobStore, error) {
+	switch cfg.Backend {
+	case "local":
+		return NewLocalStore(cfg.LocalDir)
+	case "s3":
+		return NewS3Store(cfg.S3)
+	default:
+		return nil, fmt.Errorf("unknown blob store %q", cfg.Backend)
+	}
+}
@@ -0,0 +1,36 @@
+// Package storage stores file contents, such as attachments, outside the
+// database. Blobs are addressed by slash-separated keys and are written once;
+// a key is never overwritten with different contents.
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// BlobStore stores blobs by key. Implementations must be safe for concurrent use.
+type BlobStore interface {
+	// Put stores size bytes read from r under key.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get opens the blob stored under key. It returns apperrors.ErrNotFound
+	// if there is none.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the blob stored under key. Deleting a missing blob is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// validateKey rejects keys that could escape the store, such as absolute keys
+// or keys with "." or ".." segments.
+func validateKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.HasSuffix(key, "/") {
+		return fmt.Errorf("invalid blob key %q", key)
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." || strings.ContainsAny(segment, "\\\x00") {
+			return fmt.Errorf("invalid blob key %q", key)
+		}
+	}
+	return nil
+}
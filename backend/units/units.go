@@ -0,0 +1,154 @@
+// Package units defines the units of measure items are counted in and the
+// conversions between units of the same dimension, such as g, kg and lb.
+package units
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/m-cain/mnemo/backend/decimal"
+)
+
+// Dimension is the kind of quantity a unit measures.
+type Dimension string
+
+// Dimensions of the known units.
+const (
+	Mass   Dimension = "mass"
+	Volume Dimension = "volume"
+	Count  Dimension = "count"
+	Length Dimension = "length"
+)
+
+// ErrIncompatible is returned when converting between units of different dimensions.
+var ErrIncompatible = errors.New("units measure different dimensions")
+
+// Unit is a unit of measure of one dimension. Its size is kept relative to
+// the base unit of the dimension: grams, millilitres, pieces or metres.
+type Unit struct {
+	Symbol    string    `json:"symbol"`
+	Name      string    `json:"name"`
+	Dimension Dimension `json:"dimension"`
+	factor    *big.Rat
+	aliases   []string
+}
+
+// Factor returns the size of u in the base unit of its dimension, e.g.
+// "1000" for kg or "0.001" for mg.
+func (u Unit) Factor() string {
+	s := strings.TrimRight(u.factor.FloatString(12), "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+// known lists the known units by dimension, smallest first. Imperial volumes
+// are US customary measures.
+var known = []Unit{
+	{Symbol: "mg", Name: "milligram", Dimension: Mass, factor: rat("0.001"), aliases: []string{"milligrams"}},
+	{Symbol: "g", Name: "gram", Dimension: Mass, factor: rat("1"), aliases: []string{"grams"}},
+	{Symbol: "oz", Name: "ounce", Dimension: Mass, factor: rat("28.349523125"), aliases: []string{"ounces"}},
+	{Symbol: "lb", Name: "pound", Dimension: Mass, factor: rat("453.59237"), aliases: []string{"lbs", "pounds"}},
+	{Symbol: "kg", Name: "kilogram", Dimension: Mass, factor: rat("1000"), aliases: []string{"kilograms", "kilo", "kilos"}},
+
+	{Symbol: "ml", Name: "millilitre", Dimension: Volume, factor: rat("1"), aliases: []string{"milliliter", "milliliters", "millilitres"}},
+	{Symbol: "tsp", Name: "teaspoon", Dimension: Volume, factor: rat("4.92892159375"), aliases: []string{"teaspoons"}},
+	{Symbol: "cl", Name: "centilitre", Dimension: Volume, factor: rat("10"), aliases: []string{"centiliter", "centiliters", "centilitres"}},
+	{Symbol: "tbsp", Name: "tablespoon", Dimension: Volume, factor: rat("14.78676478125"), aliases: []string{"tablespoons"}},
+	{Symbol: "fl_oz", Name: "fluid ounce", Dimension: Volume, factor: rat("29.5735295625"), aliases: []string{"fl oz", "floz", "fluid ounces"}},
+	{Symbol: "dl", Name: "decilitre", Dimension: Volume, factor: rat("100"), aliases: []string{"deciliter", "deciliters", "decilitres"}},
+	{Symbol: "cup", Name: "cup", Dimension: Volume, factor: rat("236.5882365"), aliases: []string{"cups"}},
+	{Symbol: "pt", Name: "pint", Dimension: Volume, factor: rat("473.176473"), aliases: []string{"pints"}},
+	{Symbol: "qt", Name: "quart", Dimension: Volume, factor: rat("946.352946"), aliases: []string{"quarts"}},
+	{Symbol: "l", Name: "litre", Dimension: Volume, factor: rat("1000"), aliases: []string{"liter", "liters", "litres"}},
+	{Symbol: "gal", Name: "gallon", Dimension: Volume, factor: rat("3785.411784"), aliases: []string{"gallons"}},
+
+	{Symbol: "pcs", Name: "piece", Dimension: Count, factor: rat("1"), aliases: []string{"pc", "pieces", "each", "ea"}},
+	{Symbol: "pair", Name: "pair", Dimension: Count, factor: rat("2"), aliases: []string{"pairs"}},
+	{Symbol: "dozen", Name: "dozen", Dimension: Count, factor: rat("12"), aliases: []string{"dozens", "doz"}},
+
+	{Symbol: "mm", Name: "millimetre", Dimension: Length, factor: rat("0.001"), aliases: []string{"millimeter", "millimeters", "millimetres"}},
+	{Symbol: "cm", Name: "centimetre", Dimension: Length, factor: rat("0.01"), aliases: []string{"centimeter", "centimeters", "centimetres"}},
+	{Symbol: "in", Name: "inch", Dimension: Length, factor: rat("0.0254"), aliases: []string{"inches"}},
+	{Symbol: "ft", Name: "foot", Dimension: Length, factor: rat("0.3048"), aliases: []string{"feet"}},
+	{Symbol: "yd", Name: "yard", Dimension: Length, factor: rat("0.9144"), aliases: []string{"yards"}},
+	{Symbol: "m", Name: "metre", Dimension: Length, factor: rat("1"), aliases: []string{"meter", "meters", "metres"}},
+	{Symbol: "km", Name: "kilometre", Dimension: Length, factor: rat("1000"), aliases: []string{"kilometer", "kilometers", "kilometres"}},
+}
+
+// byName maps the lower-cased symbols, names and aliases of the known units to them.
+var byName = func() map[string]Unit {
+	m := make(map[string]Unit)
+	for _, u := range known {
+		for _, name := range append([]string{u.Symbol, u.Name}, u.aliases...) {
+			if other, dup := m[name]; dup && other.Symbol != u.Symbol {
+				panic("units: duplicate unit name " + name)
+			}
+			m[name] = u
+		}
+	}
+	return m
+}()
+
+func rat(s string) *big.Rat {
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		panic("units: invalid factor " + s)
+	}
+	return r
+}
+
+// All returns the known units, grouped by dimension and smallest first.
+func All() []Unit {
+	return append([]Unit(nil), known...)
+}
+
+// Lookup returns the unit with the given symbol, name or alias, ignoring case
+// and surrounding whitespace.
+func Lookup(s string) (Unit, bool) {
+	u, ok := byName[strings.ToLower(strings.TrimSpace(s))]
+	return u, ok
+}
+
+// Normalize returns the symbol of a known unit, e.g. "kg" for "Kilograms".
+// Other units are returned with surrounding whitespace removed.
+func Normalize(s string) string {
+	if u, ok := Lookup(s); ok {
+		return u.Symbol
+	}
+	return strings.TrimSpace(s)
+}
+
+// Convert converts a quantity in unit from to unit to, rounded to the
+// precision of a decimal.Decimal. It returns ErrIncompatible if the units
+// measure different dimensions.
+func Convert(q decimal.Decimal, from, to Unit) (decimal.Decimal, error) {
+	if from.Dimension != to.Dimension {
+		return decimal.Zero, fmt.Errorf("%w: cannot convert %s to %s", ErrIncompatible, from.Symbol, to.Symbol)
+	}
+	if from.Symbol == to.Symbol {
+		return q, nil
+	}
+	r := new(big.Rat).Mul(q.Rat(), from.factor)
+	r.Quo(r, to.factor)
+	converted, err := decimal.FromRat(r)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("cannot convert %s %s to %s: %w", q, from.Symbol, to.Symbol, err)
+	}
+	return converted, nil
+}
+
+// ConvertNamed converts a quantity between two units given by symbol, name
+// or alias. Units that are not known can only be "converted" to themselves,
+// ignoring case.
+func ConvertNamed(q decimal.Decimal, from, to string) (decimal.Decimal, error) {
+	fromUnit, fromOK := Lookup(from)
+	toUnit, toOK := Lookup(to)
+	if !fromOK || !toOK {
+		if strings.EqualFold(Normalize(from), Normalize(to)) {
+			return q, nil
+		}
+		return decimal.Zero, fmt.Errorf("%w: cannot convert %q to %q", ErrIncompatible, from, to)
+	}
+	return Convert(q, fromUnit, toUnit)
+}
@@ -0,0 +1,85 @@
+package units
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/m-cain/mnemo/backend/decimal"
+)
+
+func mustLookup(t *testing.T, name string) Unit {
+	t.Helper()
+	u, ok := Lookup(name)
+	if !ok {
+		t.Fatalf("Lookup(%q) found no unit", name)
+	}
+	return u
+}
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		q, from, to, want string
+	}{
+		{q: "1", from: "kg", to: "g", want: "1000"},
+		{q: "1500", from: "g", to: "kg", want: "1.5"},
+		{q: "1", from: "lb", to: "g", want: "453.59237"},
+		{q: "1", from: "lb", to: "kg", want: "0.453592"},
+		{q: "1", from: "kg", to: "lb", want: "2.204623"},
+		{q: "2", from: "lb", to: "lb", want: "2"},
+		{q: "1", from: "l", to: "ml", want: "1000"},
+		{q: "250", from: "ml", to: "l", want: "0.25"},
+		{q: "1", from: "cup", to: "ml", want: "236.588237"},
+		{q: "4", from: "cup", to: "l", want: "0.946353"},
+		{q: "1", from: "l", to: "cup", want: "4.226753"},
+		{q: "-2", from: "cup", to: "ml", want: "-473.176473"},
+	}
+	for _, tt := range tests {
+		q, err := decimal.Parse(tt.q)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.q, err)
+		}
+		got, err := Convert(q, mustLookup(t, tt.from), mustLookup(t, tt.to))
+		if err != nil {
+			t.Fatalf("Convert(%s %s to %s): %v", tt.q, tt.from, tt.to, err)
+		}
+		if got.String() != tt.want {
+			t.Errorf("Convert(%s %s to %s) = %s, want %s", tt.q, tt.from, tt.to, got, tt.want)
+		}
+	}
+
+	if _, err := Convert(decimal.FromInt(1), mustLookup(t, "g"), mustLookup(t, "ml")); !errors.Is(err, ErrIncompatible) {
+		t.Errorf("Convert(g to ml) error = %v, want ErrIncompatible", err)
+	}
+	if _, err := Convert(decimal.FromInt(1_000_000_000), mustLookup(t, "kg"), mustLookup(t, "mg")); !errors.Is(err, decimal.ErrOutOfRange) {
+		t.Errorf("Convert(10^9 kg to mg) error = %v, want ErrOutOfRange", err)
+	}
+}
+
+func TestConvertNamed(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     string
+		err      error
+	}{
+		{from: "Kilograms", to: "lbs", want: "4.409245"},
+		{from: "litres", to: "cups", want: "8.453506"},
+		{from: "box", to: " Box ", want: "2"},
+		{from: "box", to: "kg", err: ErrIncompatible},
+		{from: "kg", to: "l", err: ErrIncompatible},
+	}
+	for _, tt := range tests {
+		got, err := ConvertNamed(decimal.FromInt(2), tt.from, tt.to)
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("ConvertNamed(2 %s to %s) error = %v, want %v", tt.from, tt.to, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("ConvertNamed(2 %s to %s): %v", tt.from, tt.to, err)
+		}
+		if got.String() != tt.want {
+			t.Errorf("ConvertNamed(2 %s to %s) = %s, want %s", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
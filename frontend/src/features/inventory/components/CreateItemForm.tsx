@@ -84,12 +84,13 @@ export default function CreateItemForm() {
       return;
     }
 
+    const { barcode, ...values } = data;
     try {
       await createMutation.mutateAsync({
-        ...data,
+        ...values,
         // Convert undefined to null for backend compatibility
         description: data.description || null,
-        barcode: data.barcode || null,
+        barcodes: barcode ? [{ code: barcode }] : [],
         quantity_unit: data.quantity_unit || null,
         location_id: data.location_id || null,
         home_id: selectedHomeId,
@@ -1,4 +1,9 @@
-import { Link, useNavigate, useParams } from "@tanstack/react-router";
+import {
+  Link,
+  useNavigate,
+  useParams,
+  useSearch,
+} from "@tanstack/react-router";
 import {
   AlertTriangle,
   ArrowLeft,
@@ -28,6 +33,7 @@ import { QuantityAdjustmentDialog } from "./QuantityAdjustmentDialog.zod";
  */
 export default function ItemDetailPage() {
   const { itemId } = useParams({ from: "/inventory/$itemId" });
+  const { homeId } = useSearch({ from: "/inventory/$itemId" });
   const navigate = useNavigate();
 
   // Fetch the item details
@@ -36,13 +42,13 @@ export default function ItemDetailPage() {
     isLoading,
     isError,
     error,
-  } = useItemQuery(itemId);
+  } = useItemQuery(homeId, itemId);
 
   // Delete item mutation
-  const deleteMutation = useDeleteItemMutation();
+  const deleteMutation = useDeleteItemMutation(homeId);
 
   // Quantity adjustment mutation
-  const quantityMutation = useUpdateItemQuantityMutation(itemId);
+  const quantityMutation = useUpdateItemQuantityMutation(homeId, itemId);
 
   // Handle item deletion
   const handleDeleteItem = async () => {
@@ -174,8 +180,14 @@ export default function ItemDetailPage() {
                   </p>
                 </div>
                 <div>
-                  <h3 className="text-sm font-medium text-gray-500">Barcode</h3>
-                  <p className="text-base">{item.barcode || "N/A"}</p>
+                  <h3 className="text-sm font-medium text-gray-500">
+                    Barcodes
+                  </h3>
+                  <p className="text-base">
+                    {item.barcodes?.length
+                      ? item.barcodes.map((barcode) => barcode.code).join(", ")
+                      : "N/A"}
+                  </p>
                 </div>
               </div>
               <div className="space-y-4">
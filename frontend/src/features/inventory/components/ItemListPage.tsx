@@ -62,7 +62,7 @@ export default function ItemListPage() {
   const { data: itemTypesResponse } = useItemTypesQuery(selectedHomeId || "");
 
   // Delete item mutation
-  const deleteMutation = useDeleteItemMutation(selectedHomeId || undefined);
+  const deleteMutation = useDeleteItemMutation(selectedHomeId || "");
 
   // Get unique item types
   const itemTypes = useMemo(() => {
@@ -479,7 +479,13 @@ export default function ItemListPage() {
                     <Button
                       variant="ghost"
                       size="sm"
-                      onClick={() => navigate({ to: `/inventory/${item.id}` })}
+                      onClick={() =>
+                        navigate({
+                          to: "/inventory/$itemId",
+                          params: { itemId: item.id },
+                          search: { homeId: item.home_id },
+                        })
+                      }
                       className="text-blue-600 hover:text-blue-900 mr-1"
                     >
                       View
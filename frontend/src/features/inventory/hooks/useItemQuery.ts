@@ -3,12 +3,12 @@ import { getItem } from "../../../lib/apiClient";
 import type { ApiResponse, Item } from "../../../types/models";
 
 /**
- * Hook to fetch a single item by ID
+ * Hook to fetch a single item of a home by ID
  */
-export const useItemQuery = (itemId: string) => {
+export const useItemQuery = (homeId: string, itemId: string) => {
   return useQuery<ApiResponse<Item>>({
     queryKey: ["item", itemId],
-    queryFn: () => getItem(itemId),
-    enabled: !!itemId,
+    queryFn: () => getItem(homeId, itemId),
+    enabled: !!homeId && !!itemId,
   });
 };
@@ -3,9 +3,9 @@ import { updateItem } from "../../../lib/apiClient";
 import type { Item } from "../../../types/models";
 
 /**
- * Hook to update an existing inventory item
+ * Hook to update an existing inventory item of a home
  */
-export const useUpdateItemMutation = () => {
+export const useUpdateItemMutation = (homeId: string) => {
   const queryClient = useQueryClient();
 
   return useMutation({
@@ -15,17 +15,13 @@ export const useUpdateItemMutation = () => {
     }: {
       itemId: string;
       itemData: Partial<Item>;
-    }) => updateItem(itemId, itemData),
-    onSuccess: (data) => {
+    }) => updateItem(homeId, itemId, itemData),
+    onSuccess: (_, { itemId }) => {
       // Invalidate the specific item query
-      queryClient.invalidateQueries({ queryKey: ["item", data.data.id] });
+      queryClient.invalidateQueries({ queryKey: ["item", itemId] });
 
       // Invalidate the items list for the home
-      if (data.data.home_id) {
-        queryClient.invalidateQueries({
-          queryKey: ["items", data.data.home_id],
-        });
-      }
+      queryClient.invalidateQueries({ queryKey: ["items", homeId] });
     },
   });
 };
@@ -5,21 +5,20 @@ import { updateItemQuantity } from "../../../lib/apiClient";
  * Hook to update just the quantity of an inventory item
  */
 export const useUpdateItemQuantityMutation = (
-  itemId: string,
-  homeId?: string
+  homeId: string,
+  itemId: string
 ) => {
   const queryClient = useQueryClient();
 
   return useMutation({
-    mutationFn: (quantity: number) => updateItemQuantity(itemId, quantity),
+    mutationFn: (quantity: number) =>
+      updateItemQuantity(homeId, itemId, quantity),
     onSuccess: () => {
       // Invalidate the specific item query
       queryClient.invalidateQueries({ queryKey: ["item", itemId] });
 
-      // Invalidate the items list for the home if homeId is provided
-      if (homeId) {
-        queryClient.invalidateQueries({ queryKey: ["items", homeId] });
-      }
+      // Invalidate the items list for the home
+      queryClient.invalidateQueries({ queryKey: ["items", homeId] });
     },
   });
 };
@@ -146,20 +146,17 @@ export const getItems = async (homeId: string) => {
   );
 };
 
-// Get a single item by ID
-export const getItem = async (itemId: string) => {
+// Get a single item of a home by ID
+export const getItem = async (homeId: string, itemId: string) => {
   return apiClient.get<{ data: import("../types/models").Item }>(
-    `/items/${itemId}`
+    `/homes/${homeId}/items/${itemId}`
   );
 };
 
 // Create a new item
 export const createItem = async (
   homeId: string,
-  itemData: Omit<
-    import("../types/models").Item,
-    "id" | "created_at" | "updated_at"
-  >
+  itemData: import("../types/models").NewItem
 ) => {
   return apiClient.post<{ data: import("../types/models").Item }>(
     `/homes/${homeId}/items`,
@@ -167,28 +164,35 @@ export const createItem = async (
   );
 };
 
-// Update an existing item
+// Update an existing item of a home
 export const updateItem = async (
+  homeId: string,
   itemId: string,
   itemData: Partial<import("../types/models").Item>
 ) => {
   return apiClient.put<{ data: import("../types/models").Item }>(
-    `/items/${itemId}`,
+    `/homes/${homeId}/items/${itemId}`,
     itemData
   );
 };
 
 // Update item quantity
-export const updateItemQuantity = async (itemId: string, quantity: number) => {
+export const updateItemQuantity = async (
+  homeId: string,
+  itemId: string,
+  quantity: number
+) => {
   return apiClient.put<{ data: import("../types/models").Item }>(
-    `/items/${itemId}/quantity`,
+    `/homes/${homeId}/items/${itemId}/quantity`,
     { quantity }
   );
 };
 
-// Delete an item
-export const deleteItem = async (itemId: string) => {
-  return apiClient.delete<{ success: boolean }>(`/items/${itemId}`);
+// Delete an item of a home
+export const deleteItem = async (homeId: string, itemId: string) => {
+  return apiClient.delete<{ success: boolean }>(
+    `/homes/${homeId}/items/${itemId}`
+  );
 };
 
 /**
@@ -202,10 +206,10 @@ export const getItemTypes = async (homeId: string) => {
   );
 };
 
-// Get a single item type by ID
-export const getItemType = async (itemTypeId: string) => {
+// Get a single item type available to a home by ID
+export const getItemType = async (homeId: string, itemTypeId: string) => {
   return apiClient.get<{ data: import("../types/models").ItemType }>(
-    `/item-types/${itemTypeId}`
+    `/homes/${homeId}/item-types/${itemTypeId}`
   );
 };
 
@@ -223,18 +227,21 @@ export const createItemType = async (
   );
 };
 
-// Update an existing item type
+// Update an existing item type of a home
 export const updateItemType = async (
+  homeId: string,
   itemTypeId: string,
   itemTypeData: Partial<import("../types/models").ItemType>
 ) => {
   return apiClient.put<{ data: import("../types/models").ItemType }>(
-    `/item-types/${itemTypeId}`,
+    `/homes/${homeId}/item-types/${itemTypeId}`,
     itemTypeData
   );
 };
 
-// Delete an item type
-export const deleteItemType = async (itemTypeId: string) => {
-  return apiClient.delete<{ success: boolean }>(`/item-types/${itemTypeId}`);
+// Delete an item type of a home
+export const deleteItemType = async (homeId: string, itemTypeId: string) => {
+  return apiClient.delete<{ success: boolean }>(
+    `/homes/${homeId}/item-types/${itemTypeId}`
+  );
 };
@@ -78,9 +78,13 @@ const inventoryRoute = createRoute({
   component: ItemList,
 });
 
+// Items belong to a home, which is passed as ?homeId=
 const inventoryDetailRoute = createRoute({
   getParentRoute: () => appLayoutRoute,
   path: "/inventory/$itemId",
+  validateSearch: (search: Record<string, unknown>): { homeId: string } => ({
+    homeId: typeof search.homeId === "string" ? search.homeId : "",
+  }),
   component: ItemDetail,
 });
 
@@ -24,7 +24,7 @@ export interface Home {
 export interface HomeUser {
   home_id: string;
   user_id: string;
-  role: string; // 'owner', 'admin', 'member'
+  role: string; // 'owner', 'admin', 'editor', 'viewer'
   created_at: string;
   updated_at: string;
   // Include user information when listing home users
@@ -51,10 +51,20 @@ export interface ItemType {
   name: string;
   description: string | null;
   default_unit: string | null;
+  canonical_unit: string | null;
+  attribute_schema: AttributeField[];
   created_at: string;
   updated_at: string;
 }
 
+// AttributeField defines a custom attribute of the items of an item type
+export interface AttributeField {
+  name: string;
+  type: "string" | "number" | "date" | "enum" | "boolean";
+  required: boolean;
+  values?: string[];
+}
+
 // Item
 export interface Item {
   id: string;
@@ -62,15 +72,108 @@ export interface Item {
   type_id: string;
   name: string;
   description: string | null;
-  barcode: string | null;
   location_id: string | null;
   quantity: number;
   quantity_unit: string | null;
+  attributes: Record<string, string | number | boolean>;
+  // Purchase and warranty details. Dates are YYYY-MM-DD; purchase_price is the
+  // price of one unit in minor units of currency, e.g. 1999 for 19.99 USD
+  purchase_date: string | null;
+  purchase_price: number | null;
+  currency: string | null; // ISO 4217 code
+  vendor: string | null;
+  serial_number: string | null;
+  warranty_expiry: string | null;
   created_at: string;
   updated_at: string;
   // Include related entities when retrieving an item
   item_type?: ItemType;
   location?: Location;
+  barcodes?: Barcode[];
+  lots?: ItemLot[];
+}
+
+// Part of an item's stock that shares an expiry date. The lots of an item hold
+// at most its quantity; the rest of its stock is in no lot
+export interface ItemLot {
+  id: string;
+  item_id: string;
+  quantity: number; // In the unit of the item
+  expiry_date: string | null; // YYYY-MM-DD
+  opened_date: string | null; // YYYY-MM-DD
+  location_id: string | null; // null when the lot is wherever the item is
+  created_at: string;
+  updated_at: string;
+}
+
+// Lot listed by the expiring report, with the name and unit of its item
+export interface ExpiringLot extends ItemLot {
+  item_name: string;
+  unit: string;
+  normalized?: Measure; // Quantity in the unit the report was asked for
+  expired: boolean;
+}
+
+// Quantity in a unit of measure
+export interface Measure {
+  quantity: number;
+  unit: string;
+}
+
+// Barcode attached to an item; UPC-A and EAN-13 forms of a code match
+export interface Barcode {
+  id: string;
+  item_id: string;
+  code: string;
+  symbology: "ean13" | "upca" | "code128" | "qr";
+  created_at: string;
+}
+
+// Purchase and warranty details of an item, which may be left out when
+// creating it
+type ItemPurchaseFields =
+  | "purchase_date"
+  | "purchase_price"
+  | "currency"
+  | "vendor"
+  | "serial_number"
+  | "warranty_expiry";
+
+// Body of an item creation request. Barcodes are given by code; the server
+// detects the symbology when it is omitted
+export type NewItem = Omit<
+  Item,
+  | "id"
+  | "created_at"
+  | "updated_at"
+  | "barcodes"
+  | "lots"
+  | ItemPurchaseFields
+> &
+  Partial<Pick<Item, ItemPurchaseFields>> & {
+    barcodes?: (Pick<Barcode, "code"> &
+      Partial<Pick<Barcode, "symbology">>)[];
+  };
+
+// Insured value of a group of items in one currency, in minor units
+export interface InsuredValue {
+  currency: string;
+  value: number;
+  item_count: number;
+}
+
+// Insured value of the items in a location or of an item type; id and name
+// are null for items without a location or type
+export interface InsuredValueGroup extends InsuredValue {
+  id: string | null;
+  name: string | null;
+}
+
+// Insured value of a home per currency, by location and by item type
+export interface InsuredValueReport {
+  totals: InsuredValue[];
+  by_location: InsuredValueGroup[];
+  by_item_type: InsuredValueGroup[];
 }
 
 // API Key
@@ -78,8 +181,16 @@ export interface ApiKey {
   id: string;
   name: string;
   key: string; // Only included when creating a new key
+  prefix: string | null; // Public part of the key, null for legacy keys
   user_id: string;
+  scopes: string[];
+  home_ids: string[] | null; // null when the key is valid for all of the user's homes
+  rate_limit_per_minute: number | null; // null when the server default quota applies
   expires_at: string | null;
+  last_used_at: string | null;
+  last_used_ip: string | null;
+  request_count: number;
+  replaced_by: string | null; // Set once the key has been rotated
   created_at: string;
   updated_at: string;
 }
@@ -98,6 +209,9 @@ export interface RegistrationData {
 
 export interface AuthResponse {
   token: string;
+  refresh_token: string;
+  token_type: string;
+  expires_in: number; // Access token lifetime in seconds
   user: User;
 }
 